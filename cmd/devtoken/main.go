@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"rr-backend/internal/auth"
+)
+
+const usage = `usage: devtoken [-email address] [-name name] [-picture url] [-ttl 24h] <user-id>
+
+Mints an ID token for user-id that an API started with AUTH_PROVIDER=local
+accepts, and prints it. The token is signed with the same AUTH_LOCAL_SECRET
+or AUTH_LOCAL_RSA_KEY as the API uses. Send it as
+"Authorization: Bearer <token>".
+
+flags:
+`
+
+func main() {
+	email := flag.String("email", "", "email claim")
+	name := flag.String("name", "", "name claim")
+	picture := flag.String("picture", "", "picture claim, the URL of the profile photo")
+	ttl := flag.Duration("ttl", 24*time.Hour, "how long the token is valid")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || flag.Arg(0) == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	issuer, err := auth.NewLocalIssuerFromEnv()
+	if err != nil {
+		log.Fatalf("devtoken: %v", err)
+	}
+	token, err := issuer.Mint(auth.Identity{UserID: flag.Arg(0), Email: *email, Name: *name, PhotoURL: *picture}, *ttl)
+	if err != nil {
+		log.Fatalf("devtoken: %v", err)
+	}
+	fmt.Println(token)
+}
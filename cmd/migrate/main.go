@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/maintenance"
+)
+
+const usage = `usage: migrate [-dry-run] <migration>
+
+migrations:
+  object-keys      move song audio and thumbnails to song_id/content-hash keys
+  playlist-order   move playlist contents into the ordered playlist_tracks table
+`
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would change without writing anything")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	db := database.NewScylla()
+	store := database.NewMinIO()
+
+	var (
+		report interface{}
+		err    error
+	)
+	switch flag.Arg(0) {
+	case "object-keys":
+		report, err = maintenance.MigrateObjectKeys(ctx, db, store, *dryRun)
+	case "playlist-order":
+		report, err = maintenance.MigratePlaylistOrder(db, *dryRun)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("migration %s failed: %v", flag.Arg(0), err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/maintenance"
+)
+
+const usage = `usage: reconcile [-fix] [-min-age duration]
+
+Compares the music bucket with the songs table and prints the drift as JSON:
+objects under songs/, thumbnails/ and hls/ that no song references, staging
+objects under uploads/ whose upload is gone, and songs whose objects are
+missing.
+
+flags:
+`
+
+func main() {
+	fix := flag.Bool("fix", false, "remove orphan objects and mark songs without audio unavailable")
+	minAge := flag.Duration("min-age", time.Hour, "ignore objects written more recently than this")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	report, err := maintenance.Reconcile(context.Background(), database.NewScylla(), database.NewMinIO(), maintenance.ReconcileOptions{
+		Fix:    *fix,
+		MinAge: *minAge,
+	})
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/search"
+)
+
+const usage = `usage: reindex [-index path]
+
+Rebuilds the search index from ScyllaDB and prints how many songs, artists
+and playlists were indexed. The new index replaces the old one only once it
+is complete; restart the API afterwards so it picks the new index up.
+
+flags:
+`
+
+func main() {
+	path := flag.String("index", search.DefaultPath(), "search index directory")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	report, err := search.RebuildAt(context.Background(), database.NewScylla(), *path)
+	if err != nil {
+		log.Fatalf("reindex failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
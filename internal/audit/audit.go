@@ -0,0 +1,72 @@
+// Package audit records security-relevant actions in the append-only audit
+// log: who did what to which target, from which request and address, and how
+// the target looked before and after.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// Entry is what a handler knows about the action it just took. Before and
+// After are snapshots of the target, encoded as JSON; leave one nil when the
+// target did not exist on that side of the action.
+type Entry struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+	Reason     string
+}
+
+// Record appends entry to the audit log as done by the signed-in user of c.
+// The action has already happened, so a failure is only logged.
+func Record(c echo.Context, dbService database.ScyllaService, entry Entry) {
+	now := time.Now()
+	actorID, _ := c.Get("userID").(string)
+	event := models.AuditEvent{
+		EventID:    gocql.UUIDFromTime(now),
+		At:         now,
+		ActorID:    actorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     snapshot(entry.Before, entry.Action),
+		After:      snapshot(entry.After, entry.Action),
+		Reason:     entry.Reason,
+		RequestID:  requestID(c),
+		IP:         c.RealIP(),
+	}
+	if err := dbService.RecordAuditEvent(event); err != nil {
+		log.Printf("Failed to record %s on %s %s by %s: %v", event.Action, event.TargetType, event.TargetID, event.ActorID, err)
+	}
+}
+
+// requestID is the ID the RequestID middleware gave the request, or the one
+// the client sent when it is not installed.
+func requestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+func snapshot(v interface{}, action string) string {
+	if v == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode snapshot for %s: %v", action, err)
+		return ""
+	}
+	return string(encoded)
+}
@@ -2,25 +2,43 @@ package auth
 
 import (
 	"context"
-	"log"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/auth"
 	"google.golang.org/api/option"
 )
 
-var AuthClient *auth.Client
+// FirebaseVerifier verifies Firebase Auth ID tokens.
+type FirebaseVerifier struct {
+	client *auth.Client
+}
 
-func InitFirebase() {
-	ctx := context.Background()
-	opt := option.WithCredentialsFile("./credentials/rr-auth-467b4-firebase-adminsdk-1ortf-cc80f01084.json")
-	app, err := firebase.NewApp(ctx, nil, opt)
+// NewFirebaseVerifier connects to Firebase with the service account in
+// credentialsFile, or with the application default credentials when it is
+// empty.
+func NewFirebaseVerifier(ctx context.Context, credentialsFile string) (*FirebaseVerifier, error) {
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	app, err := firebase.NewApp(ctx, nil, opts...)
 	if err != nil {
-		log.Fatalf("error initializing firebase app: %v", err)
+		return nil, err
 	}
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &FirebaseVerifier{client: client}, nil
+}
 
-	AuthClient, err = app.Auth(ctx)
+func (v *FirebaseVerifier) VerifyIDToken(ctx context.Context, idToken string) (*Identity, error) {
+	token, err := v.client.VerifyIDToken(ctx, idToken)
 	if err != nil {
-		log.Fatalf("error getting Auth client: %v", err)
+		return nil, ErrInvalidToken
 	}
+	email, _ := token.Claims["email"].(string)
+	name, _ := token.Claims["name"].(string)
+	picture, _ := token.Claims["picture"].(string)
+	return &Identity{UserID: token.UID, Email: email, Name: name, PhotoURL: picture}, nil
 }
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksMaxAge is how long fetched keys are trusted before they are
+	// fetched again. Keys are still used past it while the provider cannot
+	// be reached.
+	jwksMaxAge = time.Hour
+	// jwksMinInterval stops tokens with unknown key IDs, and an unreachable
+	// provider, from making us fetch the key set on every request.
+	jwksMinInterval = time.Minute
+)
+
+// OIDCVerifier verifies RS256 ID tokens of an OpenID Connect provider
+// against the keys it publishes as a JWKS. Keys are cached and fetched again
+// when a token names a key we do not know, which is how providers rotate.
+type OIDCVerifier struct {
+	issuer   string
+	audience string
+	jwksURL  string
+	client   *http.Client
+
+	// fetches lets concurrent requests share one fetch of the key set.
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey // by kid
+	fetched   time.Time                 // last successful fetch
+	attempted time.Time                 // last fetch, successful or not
+	failed    error                     // why the last fetch failed
+}
+
+// NewOIDCVerifier returns a verifier for tokens issued by issuer to
+// audience. Without jwksURL the key set is found through the issuer's
+// discovery document.
+func NewOIDCVerifier(issuer, audience, jwksURL string) (*OIDCVerifier, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("OIDC needs an issuer and an audience")
+	}
+	return &OIDCVerifier{
+		issuer:   issuer,
+		audience: audience,
+		jwksURL:  jwksURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (v *OIDCVerifier) VerifyIDToken(ctx context.Context, idToken string) (*Identity, error) {
+	return verifyJWT(idToken, []string{jwt.SigningMethodRS256.Alg()}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}, v.issuer, v.audience)
+}
+
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	key, known := v.keys[kid]
+	fresh := time.Since(v.fetched) < jwksMaxAge
+	v.mu.Unlock()
+	if known && fresh {
+		return key, nil
+	}
+
+	// The fetch runs outside the lock, so requests with cached keys are not
+	// held up, and is shared by every request that waits for it. Its outcome
+	// is shared too, so it must not end with the context of whichever
+	// request started it.
+	_, err, _ := v.fetches.Do("jwks", func() (interface{}, error) {
+		return nil, v.refresh(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		if known {
+			log.Printf("Failed to refresh OIDC keys, using the cached ones: %v", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh fetches the key set and replaces the cached keys with it. Every
+// attempt counts towards jwksMinInterval, so an unreachable provider is not
+// asked again on every request: until the interval is over the outcome of
+// the last attempt stands. A failed attempt keeps the keys we have.
+func (v *OIDCVerifier) refresh(ctx context.Context) error {
+	v.mu.Lock()
+	if time.Since(v.attempted) < jwksMinInterval {
+		err := v.failed
+		v.mu.Unlock()
+		return err
+	}
+	v.attempted = time.Now()
+	v.mu.Unlock()
+
+	keys, err := v.fetchKeys(ctx)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.failed = err
+	if err != nil {
+		return err
+	}
+	v.keys, v.fetched = keys, time.Now()
+	return nil
+}
+
+func (v *OIDCVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	if v.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("OIDC discovery document has no jwks_uri")
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrInvalidToken is returned for any token that does not verify: bad
+// signature, wrong issuer or audience, expired or malformed.
+var ErrInvalidToken = errors.New("invalid or expired ID token")
+
+// Identity is who a verified ID token belongs to.
+type Identity struct {
+	UserID   string
+	Email    string
+	Name     string
+	PhotoURL string
+}
+
+// TokenVerifier checks the ID tokens clients send as bearer tokens.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*Identity, error)
+}
+
+// NewVerifierFromEnv builds the verifier chosen by AUTH_PROVIDER:
+//
+//   - firebase (default): Firebase Auth, with the service account in
+//     FIREBASE_CREDENTIALS.
+//   - oidc: any OpenID Connect provider, from OIDC_ISSUER, OIDC_AUDIENCE and
+//     optionally OIDC_JWKS_URL.
+//   - local: tokens minted by cmd/devtoken, signed with AUTH_LOCAL_SECRET
+//     (HS256) or the RSA key in AUTH_LOCAL_RSA_KEY (RS256).
+func NewVerifierFromEnv(ctx context.Context) (TokenVerifier, error) {
+	switch provider := os.Getenv("AUTH_PROVIDER"); provider {
+	case "", "firebase":
+		return NewFirebaseVerifier(ctx, os.Getenv("FIREBASE_CREDENTIALS"))
+	case "oidc":
+		return NewOIDCVerifier(os.Getenv("OIDC_ISSUER"), os.Getenv("OIDC_AUDIENCE"), os.Getenv("OIDC_JWKS_URL"))
+	case "local":
+		return NewLocalIssuerFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown AUTH_PROVIDER %q", provider)
+	}
+}
+
+// verifyJWT checks the signature of idToken with the key keyFunc picks, then
+// that it is current, names a subject and was issued by issuer for audience.
+func verifyJWT(idToken string, methods []string, keyFunc jwt.Keyfunc, issuer, audience string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: methods}
+	if _, err := parser.ParseWithClaims(idToken, claims, keyFunc); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) ||
+		!claims.VerifyIssuer(issuer, true) ||
+		!claims.VerifyAudience(audience, true) {
+		return nil, ErrInvalidToken
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, ErrInvalidToken
+	}
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	picture, _ := claims["picture"].(string)
+	return &Identity{UserID: sub, Email: email, Name: name, PhotoURL: picture}, nil
+}
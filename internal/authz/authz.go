@@ -0,0 +1,119 @@
+// Package authz decides who may do what to playlists, songs and artist
+// profiles. Policies are plain functions of the acting user, the resource
+// and the action, so they can be tested without a database;
+// middleware.Authorize loads the resource for a request and applies one.
+package authz
+
+import "rr-backend/internal/models"
+
+// Action is what a request does to a resource, from least to most
+// privileged.
+type Action int
+
+const (
+	// View reads the resource.
+	View Action = iota
+	// ListMembers reads who a playlist is shared with.
+	ListMembers
+	// Edit changes the contents: the tracks of a playlist, the details of
+	// a profile.
+	Edit
+	// Manage renames, deletes or hands out access to the resource.
+	Manage
+)
+
+func (a Action) String() string {
+	switch a {
+	case View:
+		return "view"
+	case ListMembers:
+		return "list the members of"
+	case Edit:
+		return "edit"
+	default:
+		return "manage"
+	}
+}
+
+// Resource kinds, used in error messages.
+const (
+	KindPlaylist = "playlist"
+	KindSong     = "song"
+	KindArtist   = "artist profile"
+)
+
+// Resource is what a policy needs to know about the target of a request.
+type Resource struct {
+	Kind    string
+	ID      string
+	OwnerID string
+	// Collaborators may edit, but not manage, the resource.
+	Collaborators []string
+	// Viewers may view the resource whatever its visibility.
+	Viewers []string
+	// Visibility is that of a playlist.
+	Visibility string
+	// LinkShared is set when the request carries a valid share link for
+	// the resource.
+	LinkShared bool
+}
+
+// Policy reports whether user may perform action on resource. user is nil
+// for anonymous requests.
+type Policy func(user *models.User, resource Resource, action Action) bool
+
+// Playlist lets only its owner rename, delete or share a playlist, its
+// collaborators edit its tracks and its viewers listen; members of either
+// kind may see who else it is shared with. Anyone else may view it while it
+// is public, or unlisted and reached through a share link.
+func Playlist(user *models.User, resource Resource, action Action) bool {
+	switch {
+	case isAdmin(user) || isOwner(user, resource):
+		return true
+	case action == Edit:
+		return isCollaborator(user, resource)
+	case action == ListMembers:
+		return isCollaborator(user, resource) || isListed(user, resource.Viewers)
+	case action == View:
+		return isCollaborator(user, resource) || isListed(user, resource.Viewers) ||
+			resource.Visibility == models.PlaylistPublic ||
+			resource.Visibility == models.PlaylistUnlisted && resource.LinkShared
+	}
+	return false
+}
+
+// Song lets anyone view a song and only the artist who uploaded it change
+// or remove it. Moderators may remove any song.
+func Song(user *models.User, resource Resource, action Action) bool {
+	return action == View || UserCan(user, ModerateContent) || isOwner(user, resource)
+}
+
+// ArtistProfile lets anyone view an artist and only the artist edit their
+// own profile.
+func ArtistProfile(user *models.User, resource Resource, action Action) bool {
+	return action == View || isAdmin(user) || isOwner(user, resource)
+}
+
+func isAdmin(user *models.User) bool {
+	return user != nil && user.Role == models.RoleAdmin
+}
+
+func isOwner(user *models.User, resource Resource) bool {
+	return user != nil && resource.OwnerID != "" && user.UserID == resource.OwnerID
+}
+
+func isCollaborator(user *models.User, resource Resource) bool {
+	return isListed(user, resource.Collaborators)
+}
+
+func isListed(user *models.User, userIDs []string) bool {
+	if user == nil {
+		return false
+	}
+	for _, id := range userIDs {
+		if id == user.UserID {
+			return true
+		}
+	}
+	return false
+}
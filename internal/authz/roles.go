@@ -0,0 +1,55 @@
+package authz
+
+import "rr-backend/internal/models"
+
+// Permission is what a role lets a user do across the whole service, as
+// opposed to a Policy, which decides about one resource. Routes declare the
+// permission they need with middleware.Require.
+type Permission string
+
+const (
+	// UploadMusic allows uploading songs.
+	UploadMusic Permission = "music:upload"
+	// ApplyForArtist allows asking to become an artist.
+	ApplyForArtist Permission = "artists:apply"
+	// ReviewArtists allows approving and rejecting artist applications.
+	ReviewArtists Permission = "artists:review"
+	// ModerateContent allows taking down any song and deleting any
+	// playlist.
+	ModerateContent Permission = "content:moderate"
+	// ManageUsers allows listing users, changing their role and suspending
+	// or banning them.
+	ManageUsers Permission = "users:manage"
+	// ManageCatalog allows reviewing storage use and refreshing derived
+	// counts.
+	ManageCatalog Permission = "catalog:manage"
+	// ReadAuditLog allows reading the audit log.
+	ReadAuditLog Permission = "audit:read"
+)
+
+// rolePermissions lists what each role may do on top of what every signed
+// in user may: listen, like, follow and keep playlists. Admins may do
+// everything.
+var rolePermissions = map[string][]Permission{
+	models.RoleListener:  {ApplyForArtist},
+	models.RoleArtist:    {UploadMusic},
+	models.RoleModerator: {ModerateContent},
+}
+
+// Can reports whether role grants permission.
+func Can(role string, permission Permission) bool {
+	if role == models.RoleAdmin {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// UserCan is Can for a user, who may be nil when nobody is signed in.
+func UserCan(user *models.User, permission Permission) bool {
+	return user != nil && Can(user.Role, permission)
+}
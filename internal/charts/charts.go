@@ -0,0 +1,262 @@
+// Package charts computes the song and artist rankings served under
+// /charts. Computing them means reading every song, so a background job does
+// it periodically and stores the results as snapshots that the endpoints
+// read directly.
+package charts
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+)
+
+// Size is the number of entries kept in every chart.
+const Size = 100
+
+const (
+	TopSongs           = "top-songs"
+	TopArtistsPlays    = "top-artists:plays"
+	TopArtistsFollowed = "top-artists:followers"
+)
+
+// newFollowersWindow is how far back new followers are counted.
+const newFollowersWindow = 7 * 24 * time.Hour
+
+// minTrendingPlays keeps songs with a handful of plays from topping the
+// trending charts on ratio alone.
+const minTrendingPlays = 5
+
+// TrendingWindows are the windows over which play velocity is compared.
+var TrendingWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// GenreChart is the name of the top songs chart of a genre.
+func GenreChart(genre string) string {
+	return TopSongs + ":genre:" + genre
+}
+
+// TrendingChart is the name of the trending chart over a window.
+func TrendingChart(window string) string {
+	return "trending:" + window
+}
+
+// Refresh recomputes every chart as of now and saves the snapshots. A chart
+// that fails is logged and skipped so the others still get refreshed; the
+// first error is returned.
+func Refresh(ctx context.Context, dbService database.ScyllaService, now time.Time) error {
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return err
+	}
+	var available []models.Song
+	for _, song := range songs {
+		if song.Status == "" {
+			available = append(available, song)
+		}
+	}
+
+	artists, err := database.All(dbService.GetAllArtists)
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, artist := range artists {
+		names[artist.UserID] = artist.Username
+	}
+
+	var firstErr error
+	save := func(name string, entries []models.ChartEntry) {
+		chart := models.Chart{Name: name, ComputedAt: now, Entries: entries}
+		if err := dbService.SaveChart(chart); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	save(TopSongs, topSongs(available, names))
+
+	byGenre := map[string][]models.Song{}
+	for _, song := range available {
+		if song.Genre != "" {
+			byGenre[song.Genre] = append(byGenre[song.Genre], song)
+		}
+	}
+	for genre, genreSongs := range byGenre {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		save(GenreChart(genre), topSongs(genreSongs, names))
+	}
+
+	save(TopArtistsPlays, topArtistsByPlays(available, names))
+
+	followed, err := topArtistsByFollowers(dbService, artists, now.Add(-newFollowersWindow))
+	if err != nil {
+		log.Printf("Failed to count new followers: %v", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	} else {
+		save(TopArtistsFollowed, followed)
+	}
+
+	if err := refreshTrending(ctx, dbService, available, names, now, save); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+func topSongs(songs []models.Song, names map[string]string) []models.ChartEntry {
+	var entries []models.ChartEntry
+	for _, song := range songs {
+		if song.Status != "" {
+			continue
+		}
+		entries = append(entries, songEntry(song, names, int64(song.PlayCount)))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Plays > entries[j].Plays
+	})
+	return rank(entries)
+}
+
+func topArtistsByPlays(songs []models.Song, names map[string]string) []models.ChartEntry {
+	plays := map[string]int64{}
+	for _, song := range songs {
+		plays[song.UserID] += int64(song.PlayCount)
+	}
+	var entries []models.ChartEntry
+	for artistID, n := range plays {
+		entries = append(entries, models.ChartEntry{ArtistID: artistID, Artist: names[artistID], Plays: n})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Plays != entries[j].Plays {
+			return entries[i].Plays > entries[j].Plays
+		}
+		return entries[i].ArtistID < entries[j].ArtistID
+	})
+	return rank(entries)
+}
+
+// topArtistsByFollowers ranks artists by the followers gained since since,
+// breaking ties on the total follower count.
+func topArtistsByFollowers(dbService database.ScyllaService, artists []models.Artist, since time.Time) ([]models.ChartEntry, error) {
+	newFollowers, err := dbService.CountNewFollowers(since)
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.ChartEntry
+	for _, artist := range artists {
+		gained := newFollowers[artist.UserID]
+		if gained == 0 {
+			continue
+		}
+		entries = append(entries, models.ChartEntry{
+			ArtistID:  artist.UserID,
+			Artist:    artist.Username,
+			Followers: artist.Followers,
+			Score:     float64(gained),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Followers > entries[j].Followers
+	})
+	return rank(entries), nil
+}
+
+// refreshTrending ranks songs by how much faster they were played in the
+// latest window than in the one before it. The hourly counters of the longest
+// window pair are read once and shared by all windows.
+func refreshTrending(ctx context.Context, dbService database.ScyllaService, songs []models.Song, names map[string]string, now time.Time, save func(string, []models.ChartEntry)) error {
+	var longest time.Duration
+	for _, window := range TrendingWindows {
+		longest = max(longest, window)
+	}
+
+	// hours[i] holds the plays of the i-th complete hour before now.
+	end := now.UTC().Truncate(time.Hour)
+	hours := make([]map[string]int64, 2*int(longest/time.Hour))
+	for i := range hours {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		plays, err := dbService.GetHourlyPlays(end.Add(-time.Duration(i+1) * time.Hour))
+		if err != nil {
+			return err
+		}
+		hours[i] = plays
+	}
+
+	byID := map[string]models.Song{}
+	for _, song := range songs {
+		byID[song.SongID] = song
+	}
+
+	for name, window := range TrendingWindows {
+		n := int(window / time.Hour)
+		current, previous := map[string]int64{}, map[string]int64{}
+		for i := 0; i < n; i++ {
+			for songID, plays := range hours[i] {
+				current[songID] += plays
+			}
+			for songID, plays := range hours[n+i] {
+				previous[songID] += plays
+			}
+		}
+		save(TrendingChart(name), trending(byID, names, current, previous))
+	}
+	return nil
+}
+
+func trending(songs map[string]models.Song, names map[string]string, current, previous map[string]int64) []models.ChartEntry {
+	var entries []models.ChartEntry
+	for songID, plays := range current {
+		song, ok := songs[songID]
+		if !ok || plays < minTrendingPlays {
+			continue
+		}
+		entry := songEntry(song, names, plays)
+		entry.Score = float64(plays) / float64(previous[songID]+1)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		if entries[i].Plays != entries[j].Plays {
+			return entries[i].Plays > entries[j].Plays
+		}
+		return entries[i].SongID < entries[j].SongID
+	})
+	return rank(entries)
+}
+
+func songEntry(song models.Song, names map[string]string, plays int64) models.ChartEntry {
+	return models.ChartEntry{
+		SongID:   song.SongID,
+		Title:    song.Title,
+		Genre:    song.Genre,
+		ArtistID: song.UserID,
+		Artist:   names[song.UserID],
+		Plays:    plays,
+	}
+}
+
+// rank trims sorted entries to Size and numbers them.
+func rank(entries []models.ChartEntry) []models.ChartEntry {
+	if len(entries) > Size {
+		entries = entries[:Size]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
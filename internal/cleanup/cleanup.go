@@ -0,0 +1,108 @@
+// Package cleanup removes storage objects that no longer belong to any row.
+// Removals that fail are recorded in ScyllaDB and retried by a background
+// worker, so a MinIO hiccup never leaves an object orphaned for good.
+package cleanup
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+)
+
+const (
+	firstRetryDelay = time.Minute
+	maxRetryDelay   = 6 * time.Hour
+)
+
+// RemoveObjects deletes the named objects from bucketName. Names ending in
+// "/" remove everything under that prefix. Failures are queued for retry
+// rather than returned; empty names are skipped.
+func RemoveObjects(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, bucketName, reason string, objectNames ...string) {
+	for _, objectName := range objectNames {
+		if objectName == "" {
+			continue
+		}
+		if err := remove(ctx, minioService, bucketName, objectName); err != nil {
+			log.Printf("Failed to remove %s (%s), will retry: %v", objectName, reason, err)
+			now := time.Now()
+			err = dbService.AddPendingDeletion(models.PendingDeletion{
+				Bucket:        bucketName,
+				ObjectName:    objectName,
+				Reason:        reason,
+				Attempts:      1,
+				LastError:     err.Error(),
+				CreatedAt:     now,
+				NextAttemptAt: now.Add(firstRetryDelay),
+			})
+			if err != nil {
+				log.Printf("Object %s is orphaned: failed to queue its removal: %v", objectName, err)
+			}
+		}
+	}
+}
+
+// RetryPending retries every queued removal in bucketName that is due at
+// now, backing off exponentially on repeated failures. It returns how many
+// objects were removed.
+func RetryPending(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, bucketName string, now time.Time) (int, error) {
+	pending, err := dbService.GetPendingDeletions(bucketName)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, d := range pending {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+		if d.NextAttemptAt.After(now) {
+			continue
+		}
+		if err := remove(ctx, minioService, d.Bucket, d.ObjectName); err != nil {
+			d.Attempts++
+			d.LastError = err.Error()
+			d.NextAttemptAt = now.Add(retryDelay(d.Attempts))
+			log.Printf("Retry %d of removing %s failed: %v", d.Attempts, d.ObjectName, err)
+			if err := dbService.AddPendingDeletion(d); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err := dbService.RemovePendingDeletion(d.Bucket, d.ObjectName); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func remove(ctx context.Context, minioService database.MinIOService, bucketName, objectName string) error {
+	if !strings.HasSuffix(objectName, "/") {
+		return minioService.RemoveObject(bucketName, objectName)
+	}
+	objects, err := minioService.ListObjects(ctx, bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := minioService.RemoveObject(bucketName, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
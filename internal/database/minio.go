@@ -4,6 +4,9 @@ import (
 	"context"
 	"io"
 	"log"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/minio/minio-go/v7"
@@ -16,6 +19,19 @@ type MinIOService interface {
 	UploadObject(bucketName, objectName string, reader io.Reader, objectSize int64, contentType string) (*minio.UploadInfo, error)
 	GetObject(bucketName, objectName string) (*minio.Object, error)
 	RemoveObject(bucketName, objectName string) error
+	StatObject(ctx context.Context, bucketName, objectName string) (minio.ObjectInfo, error)
+	GetObjectRange(ctx context.Context, bucketName, objectName string, start, end int64) (io.ReadCloser, error)
+	CopyObject(ctx context.Context, bucketName, srcObjectName, dstObjectName, contentType string) error
+	ListObjects(ctx context.Context, bucketName, prefix string) ([]minio.ObjectInfo, error)
+
+	NewMultipartUpload(ctx context.Context, bucketName, objectName, contentType string) (string, error)
+	PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts map[int]string) error
+	AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error
+	ListIncompleteUploads(ctx context.Context, bucketName, prefix string) ([]minio.ObjectMultipartInfo, error)
+
+	PresignedPostObject(ctx context.Context, bucketName, objectName string, size int64, expiry time.Duration) (*url.URL, map[string]string, error)
+	PresignedGetObject(ctx context.Context, bucketName, objectName string, expiry time.Duration, reqParams url.Values) (*url.URL, error)
 }
 
 type minIOService struct {
@@ -88,3 +104,132 @@ func (s *minIOService) RemoveObject(bucketName, objectName string) error {
 	}
 	return nil
 }
+
+func (s *minIOService) StatObject(ctx context.Context, bucketName, objectName string) (minio.ObjectInfo, error) {
+	return s.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+}
+
+// GetObjectRange fetches the inclusive byte range [start, end] of an object,
+// so callers never pull more of the object over the network than they serve.
+func (s *minIOService) GetObjectRange(ctx context.Context, bucketName, objectName string, start, end int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(start, end); err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, bucketName, objectName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// CopyObject duplicates an object inside the bucket without routing the data
+// through this process. A non-empty contentType replaces the one stored with
+// the source.
+func (s *minIOService) CopyObject(ctx context.Context, bucketName, srcObjectName, dstObjectName, contentType string) error {
+	dst := minio.CopyDestOptions{Bucket: bucketName, Object: dstObjectName}
+	if contentType != "" {
+		dst.ReplaceMetadata = true
+		dst.UserMetadata = map[string]string{"Content-Type": contentType}
+	}
+	src := minio.CopySrcOptions{Bucket: bucketName, Object: srcObjectName}
+	_, err := s.client.CopyObject(ctx, dst, src)
+	return err
+}
+
+// ListObjects returns every object under prefix, recursively.
+func (s *minIOService) ListObjects(ctx context.Context, bucketName, prefix string) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+	for object := range s.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (s *minIOService) NewMultipartUpload(ctx context.Context, bucketName, objectName, contentType string) (string, error) {
+	core := minio.Core{Client: s.client}
+	return core.NewMultipartUpload(ctx, bucketName, objectName, minio.PutObjectOptions{ContentType: contentType})
+}
+
+// PutObjectPart uploads one part of a multipart upload and returns its ETag.
+// Every part but the last must be at least 5 MiB.
+func (s *minIOService) PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	core := minio.Core{Client: s.client}
+	part, err := core.PutObjectPart(ctx, bucketName, objectName, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+// CompleteMultipartUpload assembles the parts, given as part number to ETag.
+func (s *minIOService) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts map[int]string) error {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for number, etag := range parts {
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: number, ETag: etag})
+	}
+	sort.Slice(completeParts, func(i, j int) bool {
+		return completeParts[i].PartNumber < completeParts[j].PartNumber
+	})
+
+	core := minio.Core{Client: s.client}
+	_, err := core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, completeParts, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *minIOService) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	core := minio.Core{Client: s.client}
+	return core.AbortMultipartUpload(ctx, bucketName, objectName, uploadID)
+}
+
+// ListIncompleteUploads returns the multipart uploads under prefix that were
+// neither completed nor aborted.
+func (s *minIOService) ListIncompleteUploads(ctx context.Context, bucketName, prefix string) ([]minio.ObjectMultipartInfo, error) {
+	var uploads []minio.ObjectMultipartInfo
+	for upload := range s.client.ListIncompleteUploads(ctx, bucketName, prefix, true) {
+		if upload.Err != nil {
+			return nil, upload.Err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// PresignedPostObject returns a URL and the form fields the client can POST
+// the object body to directly, without going through this process. MinIO
+// refuses a body that is not exactly size bytes.
+func (s *minIOService) PresignedPostObject(ctx context.Context, bucketName, objectName string, size int64, expiry time.Duration) (*url.URL, map[string]string, error) {
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucketName); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetExpires(time.Now().Add(expiry)); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.SetContentLengthRange(size, size); err != nil {
+		return nil, nil, err
+	}
+	return s.client.PresignedPostPolicy(ctx, policy)
+}
+
+// PresignedGetObject returns a URL that reads the object directly from
+// MinIO. reqParams may override response headers such as
+// response-content-type.
+func (s *minIOService) PresignedGetObject(ctx context.Context, bucketName, objectName string, expiry time.Duration, reqParams url.Values) (*url.URL, error) {
+	return s.client.PresignedGetObject(ctx, bucketName, objectName, expiry, reqParams)
+}
+
+// IsObjectNotFound reports whether err means the object or bucket does not exist.
+func IsObjectNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return true
+	}
+	return false
+}
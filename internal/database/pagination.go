@@ -0,0 +1,43 @@
+package database
+
+import "github.com/gocql/gocql"
+
+// scanPageSize is the page size All reads with.
+const scanPageSize = 1000
+
+// pageRows calls scan for each row of the page iter was opened on, and
+// returns the paging state of the next page, or nil on the last one.
+//
+// Scanning stops at the end of the page: the driver would otherwise fetch
+// the next page on its own, and its rows would be lost to the caller. Pages
+// of filtering queries may be short or even empty while more follow.
+func pageRows(iter *gocql.Iter, scan func() bool) ([]byte, error) {
+	for n := iter.NumRows(); n > 0 && scan(); n-- {
+	}
+	next := iter.PageState()
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if len(next) == 0 {
+		return nil, nil
+	}
+	return next, nil
+}
+
+// All collects every page of a paginated listing. It is meant for
+// background jobs and commands; request handlers serve one page at a time.
+func All[T any](list func(limit int, pageState []byte) ([]T, []byte, error)) ([]T, error) {
+	var all []T
+	var pageState []byte
+	for {
+		items, next, err := list(scanPageSize, pageState)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if len(next) == 0 {
+			return all, nil
+		}
+		pageState = next
+	}
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"encoding/json"
+	"hash/fnv"
 	"log"
 	"os"
 	"rr-backend/internal/models"
+	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -12,37 +15,95 @@ import (
 type ScyllaService interface {
 	Health() map[string]string
 	UpsertUser(userID, username, email, role string) error
+	SignInUser(identity models.User, seenAt time.Time) (*models.User, error)
 	GetUserByID(userID string) (*models.User, error)
+	GetUsersByIDs(userIDs []string) ([]models.User, error)
 	UpdateUserRole(userID, role string) error
-
-	InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string) error
+	UpdateUsername(userID, username string) error
+	GetUsers(limit int, pageState []byte) ([]models.User, []byte, error)
+	SetUserStatus(userID, status string, suspendedUntil *time.Time) error
+	RecordAuditEvent(event models.AuditEvent) error
+	GetAuditEvents(filter models.AuditFilter, limit int) ([]models.AuditEvent, error)
+
+	SaveArtistApplication(application models.ArtistApplication) error
+	GetArtistApplication(userID string) (*models.ArtistApplication, error)
+	GetArtistApplications(status string, limit int, pageState []byte) ([]models.ArtistApplication, []byte, error)
+	ReviewArtistApplication(userID, status, reviewedBy, note string, reviewedAt time.Time) (bool, error)
+
+	InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string, audio models.AudioInfo) error
 	RemoveSong(songID gocql.UUID) error
-	GetSongsByUserID(userID string) ([]models.Song, error)
-	GetAllSongs() ([]models.Song, error)
+	GetSongsByUserID(userID string, limit int, pageState []byte) ([]models.Song, []byte, error)
+	GetAllSongs(limit int, pageState []byte) ([]models.Song, []byte, error)
+	GetSongByID(songID gocql.UUID) (*models.Song, error)
+	GetSongsByIDs(songIDs []gocql.UUID) ([]models.Song, error)
+	GetSongsByGenre(genre string, limit int, pageState []byte) ([]models.Song, []byte, error)
 	GetObjectNameBySongID(songID string) (string, error)
 	GetSongThumbnailBySongID(songID string) (string, error)
-	SearchSongs(query string, limit, offset int) ([]models.Song, error)
-
-	AddPlaylist(playlistID gocql.UUID, userID, name, description string) error
-	UpdatePlaylist(playlistID gocql.UUID, name, description string) error
-	AddSongToPlaylist(playlistID gocql.UUID, userID string, songID gocql.UUID, addedAt time.Time) error
-	RemoveSongFromPlaylist(playlistID gocql.UUID, songID gocql.UUID) error
-	GetSongsInPlaylist(playlistID gocql.UUID) ([]models.Song, error)
-	FetchPlaylists(userID string) ([]models.Playlist, error)
+	GetSongManifestBySongID(songID string) (string, error)
+	GetSongStatus(songID string) (string, error)
+	UpdateSongObjects(songID gocql.UUID, songURL, thumbnailURL string) error
+	UpdateSongManifest(songID gocql.UUID, manifestURL string) error
+	SetSongStatus(songID gocql.UUID, status string) error
+
+	CreateUpload(upload models.Upload) error
+	GetUpload(uploadID gocql.UUID) (*models.Upload, error)
+	ClaimUploadPart(upload models.Upload) (int, bool, error)
+	AdvanceUpload(upload models.Upload, fromOffset int64) (bool, error)
+	ClaimUploadFinish(upload models.Upload, claimedAt time.Time, lease time.Duration) (bool, error)
+	ReleaseUploadFinish(upload models.Upload) error
+	CompleteUpload(upload models.Upload, songID gocql.UUID) (bool, error)
+	RemoveUpload(uploadID gocql.UUID) error
+
+	ClaimPlay(userID string, songID gocql.UUID, window time.Duration) (bool, error)
+	RecordPlay(userID string, songID gocql.UUID, playedAt time.Time, listenedSeconds float64) error
+	GetListeningHistory(userID string, limit int, pageState []byte) ([]models.Play, []byte, error)
+	GetHourlyPlays(hour time.Time) (map[string]int64, error)
+
+	SaveChart(chart models.Chart) error
+	GetChart(name string) (*models.Chart, error)
+
+	AddPendingDeletion(deletion models.PendingDeletion) error
+	GetPendingDeletions(bucketName string) ([]models.PendingDeletion, error)
+	RemovePendingDeletion(bucketName, objectName string) error
+
+	RecordMediaFailure(failure models.MediaFailure) error
+	GetMediaFailures(job string) ([]models.MediaFailure, error)
+	RemoveMediaFailure(job, songID string) error
+
+	AddPlaylist(playlistID gocql.UUID, userID, name, description, visibility string) error
+	UpdatePlaylist(playlistID gocql.UUID, name, description, visibility string) error
+	GetPlaylistTracks(playlistID gocql.UUID, limit int, pageState []byte) ([]models.PlaylistTrack, []byte, error)
+	GetPlaylistTracksVersion(playlistID gocql.UUID) (int, error)
+	UpdatePlaylistTracks(playlistID gocql.UUID, version int, remove, add []models.PlaylistTrack) (bool, error)
+	GetLegacyPlaylistSongs(playlistID gocql.UUID) ([]models.PlaylistTrack, error)
+	RemoveLegacyPlaylistSongs(playlistID gocql.UUID) error
+	GetPlaylist(playlistID gocql.UUID) (*models.Playlist, error)
+	FetchPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error)
+	GetSharedPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error)
+	GetAllPlaylists(limit int, pageState []byte) ([]models.Playlist, []byte, error)
 	RemovePlaylist(playlistID gocql.UUID) error
 
+	GetPlaylistInvitations(userID string, limit int, pageState []byte) ([]models.PlaylistInvitation, []byte, error)
+	InvitePlaylistMember(member models.PlaylistMember) (bool, error)
+	SetPlaylistMemberRole(playlistID gocql.UUID, userID, role string) (bool, error)
+	GetPlaylistMember(playlistID gocql.UUID, userID string) (*models.PlaylistMember, error)
+	GetPlaylistMembers(playlistID gocql.UUID) ([]models.PlaylistMember, error)
+	AcceptPlaylistInvite(playlistID gocql.UUID, userID string, acceptedAt time.Time) (bool, error)
+	RemovePlaylistMember(playlistID gocql.UUID, userID string) error
+
 	LikeSong(userID string, songID gocql.UUID) error
 	UnlikeSong(userID string, songID gocql.UUID) error
-	GetLikedSongsByUser(userID string) ([]models.Song, error)
+	GetLikedSongsByUser(userID string, limit int, pageState []byte) ([]models.Song, []byte, error)
 
 	GetSongUserID(songID gocql.UUID) (string, error)
 
-	GetAllArtists() ([]models.Artist, error)
-	GetArtistWithSongs(artistID string) (*models.ArtistWithSongs, error)
+	GetAllArtists(limit int, pageState []byte) ([]models.Artist, []byte, error)
+	GetArtistWithSongs(artistID string, limit int, pageState []byte) (*models.ArtistWithSongs, []byte, error)
 	FollowArtist(artistID string, followerID string) error
 	UnfollowArtist(artistID string, followerID string) error
-	GetFollowedArtists(userID string) ([]models.Artist, error)
+	GetFollowedArtists(userID string, limit int, pageState []byte) ([]models.Artist, []byte, error)
 	GetArtistFollowersCount(artistID string) (int, error)
+	CountNewFollowers(since time.Time) (map[string]int, error)
 }
 
 type scyllaService struct {
@@ -90,9 +151,49 @@ func (s *scyllaService) UpsertUser(userID, username, email, role string) error {
 	return nil
 }
 
-func (s *scyllaService) InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string) error {
-	query := `INSERT INTO songs (song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0)`
-	if err := s.session.Query(query, songID, title, userID, album, releaseDate, genre, songURL, thumbnailURL).Exec(); err != nil {
+// SignInUser records a sign-in of the user identity describes. A user who
+// signs in for the first time is created with models.DefaultRole and the
+// username from their token. Later sign-ins refresh the email and photo and
+// keep the username and role, which are changed through the API. Rows
+// written before sign-ins were tracked get their first_seen, and a missing
+// username or role, filled in.
+func (s *scyllaService) SignInUser(identity models.User, seenAt time.Time) (*models.User, error) {
+	insert := `INSERT INTO users (user_id, username, email, photo_url, role, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+	existing := map[string]interface{}{}
+	applied, err := s.session.Query(insert, identity.UserID, identity.Username, identity.Email, identity.PhotoURL,
+		models.DefaultRole, seenAt, seenAt).MapScanCAS(existing)
+	if err != nil {
+		log.Printf("Failed to sign in user: %v", err)
+		return nil, err
+	}
+
+	if !applied {
+		assignments := []string{"email = ?", "photo_url = ?", "last_seen = ?"}
+		values := []interface{}{identity.Email, identity.PhotoURL, seenAt}
+		if firstSeen, _ := existing["first_seen"].(time.Time); firstSeen.IsZero() {
+			assignments = append(assignments, "first_seen = ?")
+			values = append(values, seenAt)
+		}
+		if username, _ := existing["username"].(string); username == "" && identity.Username != "" {
+			assignments = append(assignments, "username = ?")
+			values = append(values, identity.Username)
+		}
+		if role, _ := existing["role"].(string); role == "" {
+			assignments = append(assignments, "role = ?")
+			values = append(values, models.DefaultRole)
+		}
+		update := `UPDATE users SET ` + strings.Join(assignments, ", ") + ` WHERE user_id = ?`
+		if err := s.session.Query(update, append(values, identity.UserID)...).Exec(); err != nil {
+			log.Printf("Failed to sign in user: %v", err)
+			return nil, err
+		}
+	}
+	return s.GetUserByID(identity.UserID)
+}
+
+func (s *scyllaService) InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string, audio models.AudioInfo) error {
+	query := `INSERT INTO songs (song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count, duration, bitrate, sample_rate, channels) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?)`
+	if err := s.session.Query(query, songID, title, userID, album, releaseDate, genre, songURL, thumbnailURL, audio.Duration, audio.Bitrate, audio.SampleRate, audio.Channels).Exec(); err != nil {
 		log.Printf("Failed to insert song: %v", err)
 		return err
 	}
@@ -108,13 +209,18 @@ func (s *scyllaService) RemoveSong(songID gocql.UUID) error {
 	return nil
 }
 
-func (s *scyllaService) GetSongsByUserID(userID string) ([]models.Song, error) {
-	query := `SELECT song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count FROM songs WHERE user_id = ?`
-	iter := s.session.Query(query, userID).Iter()
+// songColumns is the column list scanned by scanSongs.
+const songColumns = `song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count, status, duration, bitrate, sample_rate, channels, hls_manifest_url`
+
+func scanSong(iter *gocql.Iter, song *models.Song) bool {
+	return iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL, &song.PlayCount, &song.Status,
+		&song.Duration, &song.Bitrate, &song.SampleRate, &song.Channels, &song.HLSManifest)
+}
 
+func scanSongs(iter *gocql.Iter) ([]models.Song, error) {
 	var songs []models.Song
 	var song models.Song
-	for iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL, &song.PlayCount) {
+	for scanSong(iter, &song) {
 		songs = append(songs, song)
 	}
 
@@ -125,23 +231,94 @@ func (s *scyllaService) GetSongsByUserID(userID string) ([]models.Song, error) {
 	return songs, nil
 }
 
-func (s *scyllaService) GetAllSongs() ([]models.Song, error) {
-	query := `SELECT song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count FROM songs`
-	iter := s.session.Query(query).Iter()
-
+// scanSongPage reads one page of songs; see pageRows.
+func scanSongPage(iter *gocql.Iter) ([]models.Song, []byte, error) {
 	var songs []models.Song
 	var song models.Song
-	for iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL, &song.PlayCount) {
+	next, err := pageRows(iter, func() bool {
+		if !scanSong(iter, &song) {
+			return false
+		}
 		songs = append(songs, song)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return songs, next, nil
+}
 
-	if err := iter.Close(); err != nil {
-		return nil, err
+// maxInKeys is how many partition keys one IN query asks for; longer lists
+// are split so no single query fans out to too many partitions.
+const maxInKeys = 100
+
+// GetSongsByIDs loads songs in the order of songIDs, skipping any that no
+// longer exist.
+func (s *scyllaService) GetSongsByIDs(songIDs []gocql.UUID) ([]models.Song, error) {
+	var songs []models.Song
+	for start := 0; start < len(songIDs); start += maxInKeys {
+		found, err := s.songsByIDs(songIDs[start:min(start+maxInKeys, len(songIDs))])
+		if err != nil {
+			log.Printf("Failed to get songs: %v", err)
+			return nil, err
+		}
+		songs = append(songs, found...)
 	}
+	return songs, nil
+}
 
+// songsByIDs loads songs in the order of songIDs, skipping any that no
+// longer exist.
+func (s *scyllaService) songsByIDs(songIDs []gocql.UUID) ([]models.Song, error) {
+	if len(songIDs) == 0 {
+		return nil, nil
+	}
+	query := `SELECT ` + songColumns + ` FROM songs WHERE song_id IN ?`
+	found, err := scanSongs(s.session.Query(query, songIDs).Iter())
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Song, len(found))
+	for _, song := range found {
+		byID[song.SongID] = song
+	}
+	songs := make([]models.Song, 0, len(songIDs))
+	for _, id := range songIDs {
+		if song, ok := byID[id.String()]; ok {
+			songs = append(songs, song)
+		}
+	}
 	return songs, nil
 }
 
+func (s *scyllaService) GetSongsByUserID(userID string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	query := `SELECT ` + songColumns + ` FROM songs WHERE user_id = ?`
+	return scanSongPage(s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter())
+}
+
+func (s *scyllaService) GetAllSongs(limit int, pageState []byte) ([]models.Song, []byte, error) {
+	query := `SELECT ` + songColumns + ` FROM songs`
+	return scanSongPage(s.session.Query(query).PageSize(limit).PageState(pageState).Iter())
+}
+
+func (s *scyllaService) GetSongByID(songID gocql.UUID) (*models.Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs WHERE song_id = ?`
+	songs, err := scanSongs(s.session.Query(query, songID).Iter())
+	if err != nil {
+		return nil, err
+	}
+	if len(songs) == 0 {
+		return nil, nil
+	}
+	return &songs[0], nil
+}
+
+// GetSongsByGenre looks songs up through songs_genre_idx.
+func (s *scyllaService) GetSongsByGenre(genre string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	query := `SELECT ` + songColumns + ` FROM songs WHERE genre = ?`
+	return scanSongPage(s.session.Query(query, genre).PageSize(limit).PageState(pageState).Iter())
+}
+
 func (s *scyllaService) GetObjectNameBySongID(songID string) (string, error) {
 	var objectName string
 	query := `SELECT song_url FROM songs WHERE song_id = ? LIMIT 1`
@@ -160,122 +337,681 @@ func (s *scyllaService) GetSongThumbnailBySongID(songID string) (string, error)
 	return thumbnailURL, nil
 }
 
-func (s *scyllaService) SearchSongs(query string, limit, offset int) ([]models.Song, error) {
-	var songs []models.Song
-	cqlQuery := "SELECT song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url FROM songs WHERE title LIKE ? ALLOW FILTERING"
-
-	iter := s.session.Query(cqlQuery, "%"+query+"%").PageSize(limit).PageState(nil).Iter() // create an iterator that go through the select results
+func (s *scyllaService) UpdateSongObjects(songID gocql.UUID, songURL, thumbnailURL string) error {
+	query := `UPDATE songs SET song_url = ?, thumbnail_url = ? WHERE song_id = ?`
+	if err := s.session.Query(query, songURL, thumbnailURL, songID).Exec(); err != nil {
+		log.Printf("Failed to update song objects: %v", err)
+		return err
+	}
+	return nil
+}
 
-	var song models.Song
-	for iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL) {
-		songs = append(songs, song)
+// SetSongStatus changes the availability of a song; an empty status makes it
+// available again.
+func (s *scyllaService) SetSongStatus(songID gocql.UUID, status string) error {
+	query := `UPDATE songs SET status = ? WHERE song_id = ? IF EXISTS`
+	applied, err := s.session.Query(query, status, songID).ScanCAS()
+	if err != nil {
+		log.Printf("Failed to update song status: %v", err)
+		return err
+	}
+	if !applied {
+		return gocql.ErrNotFound
 	}
+	return nil
+}
 
-	if err := iter.Close(); err != nil {
-		return nil, err
+// GetSongStatus returns the status of a song, which is empty when the song
+// is available or does not exist.
+func (s *scyllaService) GetSongStatus(songID string) (string, error) {
+	var status string
+	query := `SELECT status FROM songs WHERE song_id = ? LIMIT 1`
+	if err := s.session.Query(query, songID).Scan(&status); err != nil {
+		if err == gocql.ErrNotFound {
+			return "", nil
+		}
+		return "", err
 	}
+	return status, nil
+}
 
-	return songs, nil
+func (s *scyllaService) GetSongManifestBySongID(songID string) (string, error) {
+	var manifestURL string
+	query := `SELECT hls_manifest_url FROM songs WHERE song_id = ? LIMIT 1`
+	if err := s.session.Query(query, songID).Scan(&manifestURL); err != nil {
+		return "", err
+	}
+	return manifestURL, nil
 }
 
-func (s *scyllaService) AddPlaylist(playlistID gocql.UUID, userID, name, description string) error {
-	query := `INSERT INTO playlists (playlist_id, user_id, name, description) VALUES (?, ?, ?, ?)`
-	if err := s.session.Query(query, playlistID, userID, name, description).Exec(); err != nil {
+// UpdateSongManifest records the HLS manifest of a song. It returns
+// gocql.ErrNotFound when the song was removed while it was being packaged,
+// rather than recreating a partial row.
+func (s *scyllaService) UpdateSongManifest(songID gocql.UUID, manifestURL string) error {
+	query := `UPDATE songs SET hls_manifest_url = ? WHERE song_id = ? IF EXISTS`
+	applied, err := s.session.Query(query, manifestURL, songID).ScanCAS()
+	if err != nil {
+		log.Printf("Failed to update song manifest: %v", err)
 		return err
 	}
+	if !applied {
+		return gocql.ErrNotFound
+	}
 	return nil
 }
 
-func (s *scyllaService) UpdatePlaylist(playlistID gocql.UUID, name, description string) error {
-	query := `UPDATE playlists SET name = ?, description = ? WHERE playlist_id = ?`
-	if err := s.session.Query(query, name, description, playlistID).Exec(); err != nil {
-		log.Printf("Failed to update playlist: %v", err)
+// uploadTTL is how long an upload row lives after its last write. Every write
+// sets it on all the columns it needs, so the cells written when the upload
+// was created do not expire while chunks are still arriving.
+const uploadTTL = 7 * 24 * time.Hour
+
+func (s *scyllaService) CreateUpload(upload models.Upload) error {
+	query := `INSERT INTO uploads (upload_id, user_id, object_name, multipart_id, thumbnail_object_name, upload_length, upload_offset, pending_size, next_part, metadata, created_at) VALUES (?, ?, ?, ?, ?, ?, 0, 0, 1, ?, ?) USING TTL ?`
+	if err := s.session.Query(query, upload.UploadID, upload.UserID, upload.ObjectName, upload.MultipartID, upload.ThumbnailObject, upload.Length, upload.Metadata, upload.CreatedAt,
+		int(uploadTTL.Seconds())).Exec(); err != nil {
+		log.Printf("Failed to create upload: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (s *scyllaService) AddSongToPlaylist(playlistID gocql.UUID, userID string, songID gocql.UUID, addedAt time.Time) error {
-	query := `INSERT INTO playlist_songs (playlist_id, added_at, song_id) VALUES (?, ?, ?)`
-	if err := s.session.Query(query, playlistID, addedAt, songID).Exec(); err != nil {
-		log.Printf("Failed to add song to playlist: %v", err)
-		return err
+func (s *scyllaService) GetUpload(uploadID gocql.UUID) (*models.Upload, error) {
+	var upload models.Upload
+	query := `SELECT upload_id, user_id, object_name, multipart_id, thumbnail_object_name, upload_length, upload_offset, parts, next_part, pending_object, pending_size, metadata, song_id, finalizing_at, created_at FROM uploads WHERE upload_id = ?`
+	err := s.session.Query(query, uploadID).Scan(&upload.UploadID, &upload.UserID, &upload.ObjectName, &upload.MultipartID, &upload.ThumbnailObject, &upload.Length, &upload.Offset,
+		&upload.Parts, &upload.NextPart, &upload.PendingObject, &upload.PendingSize, &upload.Metadata, &upload.SongID, &upload.FinalizingAt, &upload.CreatedAt)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
 	}
-	return nil
+	return &upload, nil
+}
+
+// ClaimUploadPart hands out the next part number of an upload still at
+// upload.Offset. Every number is handed out once, so a request that loses the
+// race to advance the upload can never overwrite the part or pending object
+// of the one that wins. It returns false when another request got there
+// first.
+func (s *scyllaService) ClaimUploadPart(upload models.Upload) (int, bool, error) {
+	part := upload.NextPart
+	var current interface{} = part
+	if part == 0 {
+		// Uploads started before next_part existed count on from their parts.
+		current = nil
+		for n := range upload.Parts {
+			part = max(part, n)
+		}
+		part++
+	}
+
+	query := `UPDATE uploads USING TTL ? SET next_part = ? WHERE upload_id = ? IF upload_offset = ? AND next_part = ?`
+	var currentOffset int64
+	var currentPart *int
+	applied, err := s.session.Query(query, int(uploadTTL.Seconds()), part+1, upload.UploadID, upload.Offset, current).ScanCAS(&currentOffset, &currentPart)
+	if err != nil {
+		log.Printf("Failed to claim upload part: %v", err)
+		return 0, false, err
+	}
+	return part, applied, nil
+}
+
+// AdvanceUpload records the progress in upload, which must still be at
+// fromOffset. The whole row is rewritten under a fresh TTL. It returns false
+// when another request advanced the upload first.
+func (s *scyllaService) AdvanceUpload(upload models.Upload, fromOffset int64) (bool, error) {
+	query := `UPDATE uploads USING TTL ? SET user_id = ?, object_name = ?, multipart_id = ?, thumbnail_object_name = ?, upload_length = ?, metadata = ?, created_at = ?,
+		upload_offset = ?, parts = ?, pending_object = ?, pending_size = ? WHERE upload_id = ? IF upload_offset = ?`
+	var currentOffset int64
+	applied, err := s.session.Query(query, int(uploadTTL.Seconds()), upload.UserID, upload.ObjectName, upload.MultipartID, upload.ThumbnailObject, upload.Length, upload.Metadata, upload.CreatedAt,
+		upload.Offset, upload.Parts, upload.PendingObject, upload.PendingSize, upload.UploadID, fromOffset).ScanCAS(&currentOffset)
+	if err != nil {
+		log.Printf("Failed to advance upload: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+// ClaimUploadFinish makes the caller the only one turning upload into a song,
+// as of claimedAt. A claim older than lease is taken to be left behind by a
+// request that died and may be taken over. It returns false when the upload
+// is finished already or another request holds a live claim.
+func (s *scyllaService) ClaimUploadFinish(upload models.Upload, claimedAt time.Time, lease time.Duration) (bool, error) {
+	if !upload.FinalizingAt.IsZero() && claimedAt.Sub(upload.FinalizingAt) < lease {
+		return false, nil
+	}
+
+	query := `UPDATE uploads USING TTL ? SET finalizing_at = ? WHERE upload_id = ? IF song_id = null AND finalizing_at = ?`
+	applied, err := s.session.Query(query, int(uploadTTL.Seconds()), claimedAt, upload.UploadID, finalizingClaim(upload)).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Printf("Failed to claim upload finish: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+// ReleaseUploadFinish gives up the claim recorded in upload.FinalizingAt so
+// the upload can be finished by a retry. A claim taken over in the meantime
+// is left alone.
+func (s *scyllaService) ReleaseUploadFinish(upload models.Upload) error {
+	query := `DELETE finalizing_at FROM uploads WHERE upload_id = ? IF finalizing_at = ?`
+	_, err := s.session.Query(query, upload.UploadID, finalizingClaim(upload)).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// CompleteUpload records the song an upload became, keeping the row around
+// for another TTL so clients can still look the song up. It returns false
+// when the claim recorded in upload.FinalizingAt was taken over, in which
+// case the song belongs to nobody.
+func (s *scyllaService) CompleteUpload(upload models.Upload, songID gocql.UUID) (bool, error) {
+	query := `UPDATE uploads USING TTL ? SET user_id = ?, object_name = ?, multipart_id = ?, thumbnail_object_name = ?, upload_length = ?, metadata = ?, created_at = ?,
+		upload_offset = ?, song_id = ? WHERE upload_id = ? IF finalizing_at = ?`
+	applied, err := s.session.Query(query, int(uploadTTL.Seconds()), upload.UserID, upload.ObjectName, upload.MultipartID, upload.ThumbnailObject, upload.Length, upload.Metadata, upload.CreatedAt,
+		upload.Offset, songID, upload.UploadID, finalizingClaim(upload)).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Printf("Failed to complete upload: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+// finalizingClaim is upload.FinalizingAt as a query value; a zero time stands
+// for no claim at all.
+func finalizingClaim(upload models.Upload) interface{} {
+	if upload.FinalizingAt.IsZero() {
+		return nil
+	}
+	return upload.FinalizingAt
+}
+
+func (s *scyllaService) RemoveUpload(uploadID gocql.UUID) error {
+	query := `DELETE FROM uploads WHERE upload_id = ?`
+	return s.session.Query(query, uploadID).Exec()
+}
+
+// ClaimPlay reserves the right to count a play of songID by userID. It
+// returns false if another play of the same song by the same user was
+// claimed within window, which is how rapid replays are de-duplicated.
+func (s *scyllaService) ClaimPlay(userID string, songID gocql.UUID, window time.Duration) (bool, error) {
+	query := `INSERT INTO recent_plays (user_id, song_id) VALUES (?, ?) IF NOT EXISTS USING TTL ?`
+	var existingUserID string
+	var existingSongID gocql.UUID
+	applied, err := s.session.Query(query, userID, songID, int(window.Seconds())).ScanCAS(&existingUserID, &existingSongID)
+	if err != nil {
+		log.Printf("Failed to claim play: %v", err)
+		return false, err
+	}
+	return applied, nil
 }
 
-func (s *scyllaService) RemoveSongFromPlaylist(playlistID gocql.UUID, songID gocql.UUID) error {
-	query := `DELETE FROM playlist_songs WHERE playlist_id = ? AND song_id = ?`
-	if err := s.session.Query(query, playlistID, songID).Exec(); err != nil {
-		log.Printf("Failed to remove song from playlist: %v", err)
+// RecordPlay counts a qualified play and appends it to the user's listening
+// history. songs.play_count mirrors the counter so song listings need no
+// extra reads.
+func (s *scyllaService) RecordPlay(userID string, songID gocql.UUID, playedAt time.Time, listenedSeconds float64) error {
+	if err := s.session.Query(`UPDATE song_play_counts SET play_count = play_count + 1 WHERE song_id = ?`, songID).Exec(); err != nil {
+		log.Printf("Failed to count play: %v", err)
+		return err
+	}
+
+	hour := playedAt.UTC().Truncate(time.Hour)
+	query := `UPDATE hourly_song_plays SET plays = plays + 1 WHERE hour = ? AND bucket = ? AND song_id = ?`
+	if err := s.session.Query(query, hour, playBucket(songID), songID).Exec(); err != nil {
+		log.Printf("Failed to count hourly play: %v", err)
+		return err
+	}
+
+	query = `INSERT INTO listening_history (user_id, played_at, song_id, listened_seconds) VALUES (?, ?, ?, ?)`
+	if err := s.session.Query(query, userID, gocql.UUIDFromTime(playedAt), songID, listenedSeconds).Exec(); err != nil {
+		log.Printf("Failed to record listening history: %v", err)
+		return err
+	}
+
+	var playCount int64
+	if err := s.session.Query(`SELECT play_count FROM song_play_counts WHERE song_id = ?`, songID).Scan(&playCount); err != nil {
+		log.Printf("Failed to read play count: %v", err)
+		return err
+	}
+	// Concurrent plays read the counter in any order, so the mirror only
+	// moves forward. The condition also fails for a removed song, which keeps
+	// a play racing the removal from recreating the row.
+	query = `UPDATE songs SET play_count = ? WHERE song_id = ? IF play_count < ?`
+	if _, err := s.session.Query(query, int(playCount), songID, int(playCount)).MapScanCAS(map[string]interface{}{}); err != nil {
+		log.Printf("Failed to update song play count: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (s *scyllaService) GetSongsInPlaylist(playlistID gocql.UUID) ([]models.Song, error) {
-	query := `SELECT song_id FROM playlist_songs WHERE playlist_id = ?`
-	iter := s.session.Query(query, playlistID).Iter()
+// GetListeningHistory returns a user's plays, most recent first, one page at
+// a time. The returned page state is nil on the last page.
+func (s *scyllaService) GetListeningHistory(userID string, limit int, pageState []byte) ([]models.Play, []byte, error) {
+	query := `SELECT song_id, played_at, listened_seconds FROM listening_history WHERE user_id = ?`
+	iter := s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter()
+
+	var plays []models.Play
+	var songID, playedAt gocql.UUID
+	var listened float64
+	nextPageState, err := pageRows(iter, func() bool {
+		if !iter.Scan(&songID, &playedAt, &listened) {
+			return false
+		}
+		plays = append(plays, models.Play{SongID: songID.String(), PlayedAt: playedAt.Time(), ListenedSeconds: listened})
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return plays, nextPageState, nil
+}
 
-	var songIDs []gocql.UUID
-	var songID gocql.UUID
-	for iter.Scan(&songID) {
-		songIDs = append(songIDs, songID)
+// playBuckets is how many partitions the plays of one hour are spread over,
+// so a busy hour does not make a single partition hot and large.
+const playBuckets = 16
+
+var allPlayBuckets = func() []int {
+	buckets := make([]int, playBuckets)
+	for i := range buckets {
+		buckets[i] = i
 	}
+	return buckets
+}()
+
+// playBucket picks the partition the plays of songID are counted in. Time
+// UUIDs made on one host share their last bytes, so all of them are hashed.
+func playBucket(songID gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(songID[:])
+	return int(h.Sum32() % playBuckets)
+}
 
+// GetHourlyPlays returns the plays per song ID counted in the hour starting
+// at hour, read from every bucket of that hour.
+func (s *scyllaService) GetHourlyPlays(hour time.Time) (map[string]int64, error) {
+	query := `SELECT song_id, plays FROM hourly_song_plays WHERE hour = ? AND bucket IN ?`
+	iter := s.session.Query(query, hour.UTC().Truncate(time.Hour), allPlayBuckets).Iter()
+
+	plays := map[string]int64{}
+	var songID gocql.UUID
+	var count int64
+	for iter.Scan(&songID, &count) {
+		plays[songID.String()] = count
+	}
 	if err := iter.Close(); err != nil {
 		return nil, err
 	}
+	return plays, nil
+}
 
-	if len(songIDs) == 0 {
-		return []models.Song{}, nil
+func (s *scyllaService) SaveChart(chart models.Chart) error {
+	entries, err := json.Marshal(chart.Entries)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO chart_snapshots (chart, computed_at, entries) VALUES (?, ?, ?)`
+	if err := s.session.Query(query, chart.Name, chart.ComputedAt, string(entries)).Exec(); err != nil {
+		log.Printf("Failed to save chart %s: %v", chart.Name, err)
+		return err
 	}
+	return nil
+}
 
-	query = `SELECT song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count FROM songs WHERE song_id IN ?`
-	iter = s.session.Query(query, songIDs).Iter()
+// GetChart returns the latest snapshot of a chart, or nil if it has not been
+// computed yet.
+func (s *scyllaService) GetChart(name string) (*models.Chart, error) {
+	chart := models.Chart{Name: name}
+	var entries string
+	query := `SELECT computed_at, entries FROM chart_snapshots WHERE chart = ?`
+	if err := s.session.Query(query, name).Scan(&chart.ComputedAt, &entries); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(entries), &chart.Entries); err != nil {
+		return nil, err
+	}
+	return &chart, nil
+}
 
-	var songs []models.Song
-	var song models.Song
-	for iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL, &song.PlayCount) {
-		songs = append(songs, song)
+// AddPendingDeletion records an object whose removal failed, or updates the
+// attempt count and next attempt time of one that is already recorded.
+func (s *scyllaService) AddPendingDeletion(deletion models.PendingDeletion) error {
+	query := `INSERT INTO pending_object_deletions (bucket, object_name, reason, attempts, last_error, created_at, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	if err := s.session.Query(query, deletion.Bucket, deletion.ObjectName, deletion.Reason, deletion.Attempts, deletion.LastError, deletion.CreatedAt, deletion.NextAttemptAt).Exec(); err != nil {
+		log.Printf("Failed to record pending deletion: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *scyllaService) GetPendingDeletions(bucketName string) ([]models.PendingDeletion, error) {
+	var deletions []models.PendingDeletion
+	var d models.PendingDeletion
+	query := `SELECT bucket, object_name, reason, attempts, last_error, created_at, next_attempt_at FROM pending_object_deletions WHERE bucket = ?`
+	iter := s.session.Query(query, bucketName).Iter()
+	for iter.Scan(&d.Bucket, &d.ObjectName, &d.Reason, &d.Attempts, &d.LastError, &d.CreatedAt, &d.NextAttemptAt) {
+		deletions = append(deletions, d)
 	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+func (s *scyllaService) RemovePendingDeletion(bucketName, objectName string) error {
+	query := `DELETE FROM pending_object_deletions WHERE bucket = ? AND object_name = ?`
+	return s.session.Query(query, bucketName, objectName).Exec()
+}
+
+// RecordMediaFailure records a media job that failed for a song, or updates
+// the attempt count and next attempt time of one that is already recorded.
+func (s *scyllaService) RecordMediaFailure(failure models.MediaFailure) error {
+	query := `INSERT INTO media_failures (job, song_id, attempts, last_error, next_attempt_at) VALUES (?, ?, ?, ?, ?)`
+	return s.session.Query(query, failure.Job, failure.SongID, failure.Attempts, failure.LastError, failure.NextAttemptAt).Exec()
+}
 
+func (s *scyllaService) GetMediaFailures(job string) ([]models.MediaFailure, error) {
+	var failures []models.MediaFailure
+	var f models.MediaFailure
+	query := `SELECT job, song_id, attempts, last_error, next_attempt_at FROM media_failures WHERE job = ?`
+	iter := s.session.Query(query, job).Iter()
+	for iter.Scan(&f.Job, &f.SongID, &f.Attempts, &f.LastError, &f.NextAttemptAt) {
+		failures = append(failures, f)
+	}
 	if err := iter.Close(); err != nil {
 		return nil, err
 	}
+	return failures, nil
+}
 
-	return songs, nil
+func (s *scyllaService) RemoveMediaFailure(job, songID string) error {
+	query := `DELETE FROM media_failures WHERE job = ? AND song_id = ?`
+	return s.session.Query(query, job, songID).Exec()
 }
 
-func (s *scyllaService) FetchPlaylists(userID string) ([]models.Playlist, error) {
-	query := `SELECT playlist_id, name, description FROM playlists WHERE user_id = ?`
-	iter := s.session.Query(query, userID).Iter()
+func (s *scyllaService) AddPlaylist(playlistID gocql.UUID, userID, name, description, visibility string) error {
+	query := `INSERT INTO playlists (playlist_id, user_id, name, description, visibility) VALUES (?, ?, ?, ?, ?)`
+	if err := s.session.Query(query, playlistID, userID, name, description, visibility).Exec(); err != nil {
+		return err
+	}
+	return nil
+}
 
-	var playlists []models.Playlist
-	var playlistID gocql.UUID
-	var name string
-	var description string
+func (s *scyllaService) UpdatePlaylist(playlistID gocql.UUID, name, description, visibility string) error {
+	query := `UPDATE playlists SET name = ?, description = ?, visibility = ? WHERE playlist_id = ?`
+	if err := s.session.Query(query, name, description, visibility, playlistID).Exec(); err != nil {
+		log.Printf("Failed to update playlist: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetPlaylistTracks returns one page of a playlist in order, with the songs
+// attached.
+func (s *scyllaService) GetPlaylistTracks(playlistID gocql.UUID, limit int, pageState []byte) ([]models.PlaylistTrack, []byte, error) {
+	query := `SELECT position, entry_id, song_id, added_at, added_by FROM playlist_tracks WHERE playlist_id = ?`
+	iter := s.session.Query(query, playlistID).PageSize(limit).PageState(pageState).Iter()
+
+	var tracks []models.PlaylistTrack
+	track := models.PlaylistTrack{PlaylistID: playlistID}
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&track.Position, &track.EntryID, &track.SongID, &track.AddedAt, &track.AddedBy) {
+			return false
+		}
+		tracks = append(tracks, track)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	songIDs := make([]gocql.UUID, 0, len(tracks))
+	for _, t := range tracks {
+		songIDs = append(songIDs, t.SongID)
+	}
+	songs, err := s.songsByIDs(songIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[gocql.UUID]*models.Song, len(songs))
+	for i := range songs {
+		id, _ := gocql.ParseUUID(songs[i].SongID)
+		byID[id] = &songs[i]
+	}
+	for i := range tracks {
+		tracks[i].Song = byID[tracks[i].SongID]
+	}
+	return tracks, next, nil
+}
 
-	for iter.Scan(&playlistID, &name, &description) {
-		playlists = append(playlists, models.Playlist{
-			PlaylistID:  playlistID,
-			UserID:      userID,
-			Name:        name,
-			Description: description,
-		})
+// GetPlaylistTracksVersion returns the version every change to the tracks of
+// a playlist is written against; 0 before the first change.
+func (s *scyllaService) GetPlaylistTracksVersion(playlistID gocql.UUID) (int, error) {
+	var version int
+	query := `SELECT version FROM playlist_tracks WHERE playlist_id = ? LIMIT 1`
+	if err := s.session.Query(query, playlistID).Scan(&version); err != nil {
+		if err == gocql.ErrNotFound {
+			return 0, nil
+		}
+		log.Printf("Failed to get playlist version: %v", err)
+		return 0, err
 	}
+	return version, nil
+}
 
+// UpdatePlaylistTracks deletes the remove rows and writes the add rows of a
+// playlist in one conditional batch, provided the playlist is still at
+// version, and moves it to version+1. The position is part of the primary
+// key, so moving a track is removing it and adding it back; a key must not
+// be in both lists. It reports false when another change got in first.
+func (s *scyllaService) UpdatePlaylistTracks(playlistID gocql.UUID, version int, remove, add []models.PlaylistTrack) (bool, error) {
+	// A playlist that was never changed has no version yet.
+	var current interface{}
+	if version > 0 {
+		current = version
+	}
+	batch := s.session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`UPDATE playlist_tracks SET version = ? WHERE playlist_id = ? IF version = ?`, version+1, playlistID, current)
+	for _, t := range remove {
+		batch.Query(`DELETE FROM playlist_tracks WHERE playlist_id = ? AND position = ? AND entry_id = ?`, playlistID, t.Position, t.EntryID)
+	}
+	for _, t := range add {
+		batch.Query(`INSERT INTO playlist_tracks (playlist_id, position, entry_id, song_id, added_at, added_by) VALUES (?, ?, ?, ?, ?, ?)`,
+			playlistID, t.Position, t.EntryID, t.SongID, t.AddedAt, t.AddedBy)
+	}
+	applied, _, err := s.session.MapExecuteBatchCAS(batch, map[string]interface{}{})
+	if err != nil {
+		log.Printf("Failed to update playlist tracks: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+// GetLegacyPlaylistSongs reads a playlist from the unordered playlist_songs
+// table, for the playlist-order migration.
+func (s *scyllaService) GetLegacyPlaylistSongs(playlistID gocql.UUID) ([]models.PlaylistTrack, error) {
+	query := `SELECT song_id, added_at FROM playlist_songs WHERE playlist_id = ?`
+	iter := s.session.Query(query, playlistID).Iter()
+
+	var tracks []models.PlaylistTrack
+	track := models.PlaylistTrack{PlaylistID: playlistID}
+	for iter.Scan(&track.SongID, &track.AddedAt) {
+		tracks = append(tracks, track)
+	}
 	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// RemoveLegacyPlaylistSongs deletes a playlist's playlist_songs rows once
+// the playlist-order migration has copied them.
+func (s *scyllaService) RemoveLegacyPlaylistSongs(playlistID gocql.UUID) error {
+	if err := s.session.Query(`DELETE FROM playlist_songs WHERE playlist_id = ?`, playlistID).Exec(); err != nil {
+		log.Printf("Failed to remove legacy playlist songs: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *scyllaService) FetchPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	query := `SELECT ` + playlistColumns + ` FROM playlists WHERE user_id = ?`
+	playlists, next, err := scanPlaylistPage(s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter())
+	if err != nil {
 		log.Printf("Failed to fetch playlists: %v", err)
+		return nil, nil, err
+	}
+	return playlists, next, nil
+}
+
+func (s *scyllaService) GetPlaylist(playlistID gocql.UUID) (*models.Playlist, error) {
+	playlist := models.Playlist{PlaylistID: playlistID}
+	query := `SELECT user_id, name, description, visibility FROM playlists WHERE playlist_id = ?`
+	if err := s.session.Query(query, playlistID).Scan(&playlist.UserID, &playlist.Name, &playlist.Description, &playlist.Visibility); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		log.Printf("Failed to get playlist: %v", err)
 		return nil, err
 	}
+	defaultVisibility(&playlist)
+	return &playlist, nil
+}
+
+// GetSharedPlaylists lists the playlists userID has accepted an invitation
+// to, with MemberRole set. Pending invitations are skipped, so a page may
+// come back short while more follow.
+func (s *scyllaService) GetSharedPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	query := `SELECT playlist_id, role, status FROM playlist_members WHERE user_id = ?`
+	iter := s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter()
+
+	var ids []gocql.UUID
+	roles := map[gocql.UUID]string{}
+	var playlistID gocql.UUID
+	var role, status string
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&playlistID, &role, &status) {
+			return false
+		}
+		if status == models.MemberAccepted {
+			ids = append(ids, playlistID)
+			roles[playlistID] = role
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to fetch shared playlists: %v", err)
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, next, nil
+	}
+
+	found, _, err := scanPlaylistPage(s.session.Query(`SELECT `+playlistColumns+` FROM playlists WHERE playlist_id IN ?`, ids).Iter())
+	if err != nil {
+		log.Printf("Failed to fetch shared playlists: %v", err)
+		return nil, nil, err
+	}
+	for i := range found {
+		found[i].MemberRole = roles[found[i].PlaylistID]
+	}
+	return found, next, nil
+}
+
+// GetPlaylistInvitations lists the invitations userID has not answered yet,
+// with their playlists. Accepted memberships are skipped, so a page may come
+// back short while more follow.
+func (s *scyllaService) GetPlaylistInvitations(userID string, limit int, pageState []byte) ([]models.PlaylistInvitation, []byte, error) {
+	query := `SELECT playlist_id, role, status, invited_by, invited_at FROM playlist_members WHERE user_id = ?`
+	iter := s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter()
+
+	var ids []gocql.UUID
+	var pending []models.PlaylistInvitation
+	var invitation models.PlaylistInvitation
+	var status string
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&invitation.Playlist.PlaylistID, &invitation.Role, &status, &invitation.InvitedBy, &invitation.InvitedAt) {
+			return false
+		}
+		if status == models.MemberInvited {
+			ids = append(ids, invitation.Playlist.PlaylistID)
+			pending = append(pending, invitation)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to fetch playlist invitations: %v", err)
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, next, nil
+	}
 
-	return playlists, nil
+	found, _, err := scanPlaylistPage(s.session.Query(`SELECT `+playlistColumns+` FROM playlists WHERE playlist_id IN ?`, ids).Iter())
+	if err != nil {
+		log.Printf("Failed to fetch playlist invitations: %v", err)
+		return nil, nil, err
+	}
+	byID := make(map[gocql.UUID]models.Playlist, len(found))
+	for _, playlist := range found {
+		byID[playlist.PlaylistID] = playlist
+	}
+	var invitations []models.PlaylistInvitation
+	for _, invitation := range pending {
+		// Invitations to playlists deleted since are dropped.
+		if playlist, ok := byID[invitation.Playlist.PlaylistID]; ok {
+			invitation.Playlist = playlist
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, next, nil
+}
+
+const playlistColumns = `playlist_id, user_id, name, description, visibility`
+
+func scanPlaylistPage(iter *gocql.Iter) ([]models.Playlist, []byte, error) {
+	var playlists []models.Playlist
+	var playlist models.Playlist
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&playlist.PlaylistID, &playlist.UserID, &playlist.Name, &playlist.Description, &playlist.Visibility) {
+			return false
+		}
+		defaultVisibility(&playlist)
+		playlists = append(playlists, playlist)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return playlists, next, nil
+}
+
+// defaultVisibility makes playlists created before visibility existed
+// public, which is how they were served.
+func defaultVisibility(playlist *models.Playlist) {
+	if playlist.Visibility == "" {
+		playlist.Visibility = models.PlaylistPublic
+	}
+}
+
+// GetAllPlaylists pages through every playlist; it is meant for maintenance
+// jobs.
+func (s *scyllaService) GetAllPlaylists(limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	query := `SELECT ` + playlistColumns + ` FROM playlists`
+	playlists, next, err := scanPlaylistPage(s.session.Query(query).PageSize(limit).PageState(pageState).Iter())
+	if err != nil {
+		log.Printf("Failed to fetch playlists: %v", err)
+		return nil, nil, err
+	}
+	return playlists, next, nil
 }
 
 func (s scyllaService) RemovePlaylist(playlistID gocql.UUID) error {
 	batch := s.session.NewBatch(gocql.LoggedBatch)
 	batch.Query(`DELETE FROM playlists WHERE playlist_id = ?`, playlistID)
+	batch.Query(`DELETE FROM playlist_tracks WHERE playlist_id = ?`, playlistID)
+	batch.Query(`DELETE FROM playlist_members WHERE playlist_id = ?`, playlistID)
 	batch.Query(`DELETE FROM playlist_songs WHERE playlist_id = ?`, playlistID)
 	if err := s.session.ExecuteBatch(batch); err != nil {
 		log.Printf("Failed to remove playlists: %v", err)
@@ -284,6 +1020,86 @@ func (s scyllaService) RemovePlaylist(playlistID gocql.UUID) error {
 	return nil
 }
 
+// InvitePlaylistMember stores a new invitation. It returns false when the
+// user is already invited or a member, so a concurrent accept is never
+// overwritten.
+func (s *scyllaService) InvitePlaylistMember(member models.PlaylistMember) (bool, error) {
+	query := `INSERT INTO playlist_members (playlist_id, user_id, role, status, invited_by, invited_at) VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+	applied, err := s.session.Query(query, member.PlaylistID, member.UserID, member.Role, member.Status, member.InvitedBy, member.InvitedAt).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Printf("Failed to invite playlist member: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+// SetPlaylistMemberRole changes the role of an invited or accepted member
+// and nothing else. It returns false when the membership is gone.
+func (s *scyllaService) SetPlaylistMemberRole(playlistID gocql.UUID, userID, role string) (bool, error) {
+	query := `UPDATE playlist_members SET role = ? WHERE playlist_id = ? AND user_id = ? IF EXISTS`
+	applied, err := s.session.Query(query, role, playlistID, userID).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Printf("Failed to change playlist member role: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+func (s *scyllaService) GetPlaylistMember(playlistID gocql.UUID, userID string) (*models.PlaylistMember, error) {
+	member := models.PlaylistMember{PlaylistID: playlistID, UserID: userID}
+	query := `SELECT role, status, invited_by, invited_at, accepted_at FROM playlist_members WHERE playlist_id = ? AND user_id = ?`
+	if err := s.session.Query(query, playlistID, userID).Scan(&member.Role, &member.Status, &member.InvitedBy, &member.InvitedAt, &member.AcceptedAt); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		log.Printf("Failed to get playlist member: %v", err)
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetPlaylistMembers returns every member and pending invitation of a
+// playlist. Invitations are capped, so the list is short.
+func (s *scyllaService) GetPlaylistMembers(playlistID gocql.UUID) ([]models.PlaylistMember, error) {
+	query := `SELECT user_id, role, status, invited_by, invited_at, accepted_at FROM playlist_members WHERE playlist_id = ?`
+	iter := s.session.Query(query, playlistID).Iter()
+
+	var members []models.PlaylistMember
+	member := models.PlaylistMember{PlaylistID: playlistID}
+	for iter.Scan(&member.UserID, &member.Role, &member.Status, &member.InvitedBy, &member.InvitedAt, &member.AcceptedAt) {
+		members = append(members, member)
+		member.AcceptedAt = nil
+	}
+	if err := iter.Close(); err != nil {
+		log.Printf("Failed to get playlist members: %v", err)
+		return nil, err
+	}
+	return members, nil
+}
+
+// AcceptPlaylistInvite turns a pending invitation into a membership. It
+// returns false when there is no pending invitation, including when it was
+// revoked concurrently.
+func (s *scyllaService) AcceptPlaylistInvite(playlistID gocql.UUID, userID string, acceptedAt time.Time) (bool, error) {
+	query := `UPDATE playlist_members SET status = ?, accepted_at = ? WHERE playlist_id = ? AND user_id = ? IF status = ?`
+	var status string
+	applied, err := s.session.Query(query, models.MemberAccepted, acceptedAt, playlistID, userID, models.MemberInvited).ScanCAS(&status)
+	if err != nil {
+		log.Printf("Failed to accept playlist invitation: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+func (s *scyllaService) RemovePlaylistMember(playlistID gocql.UUID, userID string) error {
+	query := `DELETE FROM playlist_members WHERE playlist_id = ? AND user_id = ?`
+	if err := s.session.Query(query, playlistID, userID).Exec(); err != nil {
+		log.Printf("Failed to remove playlist member: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (s *scyllaService) LikeSong(userID string, songID gocql.UUID) error {
 	query := `INSERT INTO song_likes (user_id, song_id, liked_at) VALUES (?, ?, ?)`
 	if err := s.session.Query(query, userID, songID, time.Now()).Exec(); err != nil {
@@ -302,40 +1118,41 @@ func (s *scyllaService) UnlikeSong(userID string, songID gocql.UUID) error {
 	return nil
 }
 
-func (s *scyllaService) GetLikedSongsByUser(userID string) ([]models.Song, error) {
+func (s *scyllaService) GetLikedSongsByUser(userID string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	query := `SELECT song_id FROM song_likes WHERE user_id = ?`
+	iter := s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter()
+
 	var songIDs []gocql.UUID
 	var songID gocql.UUID
-	query := `SELECT song_id FROM song_likes WHERE user_id = ?`
-	iter := s.session.Query(query, userID).Iter()
-	for iter.Scan(&songID) {
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&songID) {
+			return false
+		}
 		songIDs = append(songIDs, songID)
-	}
-	if err := iter.Close(); err != nil {
-		return nil, err
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if len(songIDs) == 0 {
-		return []models.Song{}, nil
+	songs, err := s.songsByIDs(songIDs)
+	if err != nil {
+		return nil, nil, err
 	}
+	return songs, next, nil
+}
 
-	var likedSongs []models.Song
-	var song models.Song
-	query = `SELECT song_id, title, user_id, album, release_date, genre, song_url, thumbnail_url, play_count FROM songs WHERE song_id IN ?`
-	iter = s.session.Query(query, songIDs).Iter()
-	for iter.Scan(&song.SongID, &song.Title, &song.UserID, &song.Album, &song.ReleaseDate, &song.Genre, &song.SongURL, &song.ThumbnailURL, &song.PlayCount) {
-		likedSongs = append(likedSongs, song)
-	}
-	if err := iter.Close(); err != nil {
-		return nil, err
-	}
+const userColumns = `user_id, username, email, photo_url, role, first_seen, last_seen, status, suspended_until`
 
-	return likedSongs, nil
+// userFields are the scan destinations of userColumns.
+func userFields(u *models.User) []interface{} {
+	return []interface{}{&u.UserID, &u.Username, &u.Email, &u.PhotoURL, &u.Role, &u.FirstSeen, &u.LastSeen, &u.Status, &u.SuspendedUntil}
 }
 
 func (s *scyllaService) GetUserByID(userID string) (*models.User, error) {
 	var user models.User
-	query := `SELECT user_id, username, email, role FROM users WHERE user_id = ? LIMIT 1`
-	if err := s.session.Query(query, userID).Scan(&user.UserID, &user.Username, &user.Email, &user.Role); err != nil {
+	query := `SELECT ` + userColumns + ` FROM users WHERE user_id = ? LIMIT 1`
+	if err := s.session.Query(query, userID).Scan(userFields(&user)...); err != nil {
 		if err == gocql.ErrNotFound {
 			return nil, nil
 		}
@@ -364,83 +1181,338 @@ func (s *scyllaService) UpdateUserRole(userID, role string) error {
 	return nil
 }
 
-func (s *scyllaService) GetAllArtists() ([]models.Artist, error) {
+// UpdateUsername changes only the username, so it cannot undo a concurrent
+// change of the user's role or status.
+func (s *scyllaService) UpdateUsername(userID, username string) error {
+	query := "UPDATE users SET username = ? WHERE user_id = ?"
+	if err := s.session.Query(query, username, userID).Exec(); err != nil {
+		log.Printf("Failed to update username: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetUsersByIDs loads the users with the given IDs, in no particular order,
+// skipping any that do not exist.
+func (s *scyllaService) GetUsersByIDs(userIDs []string) ([]models.User, error) {
+	var users []models.User
+	for start := 0; start < len(userIDs); start += maxInKeys {
+		query := `SELECT ` + userColumns + ` FROM users WHERE user_id IN ?`
+		iter := s.session.Query(query, userIDs[start:min(start+maxInKeys, len(userIDs))]).Iter()
+		var user models.User
+		for iter.Scan(userFields(&user)...) {
+			users = append(users, user)
+			user = models.User{}
+		}
+		if err := iter.Close(); err != nil {
+			log.Printf("Failed to get users: %v", err)
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// GetUsers lists one page of all users, in token order.
+func (s *scyllaService) GetUsers(limit int, pageState []byte) ([]models.User, []byte, error) {
+	query := `SELECT ` + userColumns + ` FROM users`
+	iter := s.session.Query(query).PageSize(limit).PageState(pageState).Iter()
+
+	var users []models.User
+	next, err := pageRows(iter, func() bool {
+		var user models.User
+		if !iter.Scan(userFields(&user)...) {
+			return false
+		}
+		users = append(users, user)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to fetch users: %v", err)
+		return nil, nil, err
+	}
+	return users, next, nil
+}
+
+// SetUserStatus suspends, bans or, with an empty status, reinstates a user.
+func (s *scyllaService) SetUserStatus(userID, status string, suspendedUntil *time.Time) error {
+	query := `UPDATE users SET status = ?, suspended_until = ? WHERE user_id = ?`
+	if err := s.session.Query(query, status, suspendedUntil, userID).Exec(); err != nil {
+		log.Printf("Failed to update user status: %v", err)
+		return err
+	}
+	return nil
+}
+
+// RecordAuditEvent appends an event to the audit log: to the partition of
+// the day it happened, and to the partitions of its actor and its target so
+// either can be looked up without a scan.
+func (s *scyllaService) RecordAuditEvent(event models.AuditEvent) error {
+	day := event.At.UTC().Truncate(24 * time.Hour)
+	batch := s.session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO audit_log (day, event_id, actor_id, action, target_type, target_id, before, after, reason, request_id, ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		day, event.EventID, event.ActorID, event.Action, event.TargetType, event.TargetID, event.Before, event.After, event.Reason, event.RequestID, event.IP)
+	if event.ActorID != "" {
+		batch.Query(`INSERT INTO audit_log_by_actor (actor_id, event_id, action, target_type, target_id, before, after, reason, request_id, ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.ActorID, event.EventID, event.Action, event.TargetType, event.TargetID, event.Before, event.After, event.Reason, event.RequestID, event.IP)
+	}
+	if event.TargetType != "" && event.TargetID != "" {
+		batch.Query(`INSERT INTO audit_log_by_target (target_type, target_id, event_id, actor_id, action, before, after, reason, request_id, ip) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			event.TargetType, event.TargetID, event.EventID, event.ActorID, event.Action, event.Before, event.After, event.Reason, event.RequestID, event.IP)
+	}
+	if err := s.session.ExecuteBatch(batch); err != nil {
+		log.Printf("Failed to record audit event: %v", err)
+		return err
+	}
+	return nil
+}
+
+const auditColumns = `event_id, actor_id, action, target_type, target_id, before, after, reason, request_id, ip`
+
+// GetAuditEvents lists up to limit events matching filter, newest first.
+// With an actor or a target it reads their partition; otherwise it walks the
+// day partitions back from filter.To to filter.From.
+func (s *scyllaService) GetAuditEvents(filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	var where []string
+	var args []interface{}
+	table, filtering := "audit_log", false
+	switch {
+	case filter.ActorID != "":
+		table = "audit_log_by_actor"
+		where, args = append(where, "actor_id = ?"), append(args, filter.ActorID)
+		if filter.TargetType != "" && filter.TargetID != "" {
+			// Filtered within the one partition of the actor
+			where, args = append(where, "target_type = ?", "target_id = ?"), append(args, filter.TargetType, filter.TargetID)
+			filtering = true
+		}
+	case filter.TargetType != "" && filter.TargetID != "":
+		table = "audit_log_by_target"
+		where, args = append(where, "target_type = ?", "target_id = ?"), append(args, filter.TargetType, filter.TargetID)
+	default:
+		where = append(where, "day = ?")
+		args = append(args, nil) // set per day below
+	}
+	where, args = append(where, "event_id >= minTimeuuid(?)", "event_id <= maxTimeuuid(?)"), append(args, filter.From, filter.To)
+	if filter.Before != nil {
+		where, args = append(where, "event_id < ?"), append(args, *filter.Before)
+	}
+	query := `SELECT ` + auditColumns + ` FROM ` + table + ` WHERE ` + strings.Join(where, " AND ") + ` LIMIT ?`
+	if filtering {
+		query += ` ALLOW FILTERING`
+	}
+
+	var events []models.AuditEvent
+	read := func(args []interface{}) error {
+		iter := s.session.Query(query, append(args, limit-len(events))...).Iter()
+		var event models.AuditEvent
+		for iter.Scan(&event.EventID, &event.ActorID, &event.Action, &event.TargetType, &event.TargetID,
+			&event.Before, &event.After, &event.Reason, &event.RequestID, &event.IP) {
+			event.At = event.EventID.Time()
+			events = append(events, event)
+		}
+		return iter.Close()
+	}
+
+	if table != "audit_log" {
+		if err := read(args); err != nil {
+			log.Printf("Failed to fetch audit events: %v", err)
+			return nil, err
+		}
+		return events, nil
+	}
+	first := filter.From.UTC().Truncate(24 * time.Hour)
+	for day := filter.To.UTC().Truncate(24 * time.Hour); !day.Before(first) && len(events) < limit; day = day.Add(-24 * time.Hour) {
+		args[0] = day
+		if err := read(args); err != nil {
+			log.Printf("Failed to fetch audit events of %s: %v", day.Format(time.DateOnly), err)
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// SaveArtistApplication creates or replaces the application of a user.
+func (s *scyllaService) SaveArtistApplication(application models.ArtistApplication) error {
+	query := `INSERT INTO artist_applications (user_id, artist_name, message, status, submitted_at, reviewed_by, reviewed_at, review_note) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if err := s.session.Query(query, application.UserID, application.ArtistName, application.Message, application.Status,
+		application.SubmittedAt, application.ReviewedBy, application.ReviewedAt, application.ReviewNote).Exec(); err != nil {
+		log.Printf("Failed to save artist application: %v", err)
+		return err
+	}
+	return nil
+}
+
+const artistApplicationColumns = `user_id, artist_name, message, status, submitted_at, reviewed_by, reviewed_at, review_note`
+
+func scanArtistApplication(scan func(dest ...interface{}) bool, a *models.ArtistApplication) bool {
+	return scan(&a.UserID, &a.ArtistName, &a.Message, &a.Status, &a.SubmittedAt, &a.ReviewedBy, &a.ReviewedAt, &a.ReviewNote)
+}
+
+func (s *scyllaService) GetArtistApplication(userID string) (*models.ArtistApplication, error) {
+	var application models.ArtistApplication
+	query := `SELECT ` + artistApplicationColumns + ` FROM artist_applications WHERE user_id = ?`
+	iter := s.session.Query(query, userID).Iter()
+	found := scanArtistApplication(iter.Scan, &application)
+	if err := iter.Close(); err != nil {
+		log.Printf("Failed to get artist application: %v", err)
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &application, nil
+}
+
+// GetArtistApplications lists one page of the applications with status.
+func (s *scyllaService) GetArtistApplications(status string, limit int, pageState []byte) ([]models.ArtistApplication, []byte, error) {
+	query := `SELECT ` + artistApplicationColumns + ` FROM artist_applications WHERE status = ?`
+	iter := s.session.Query(query, status).PageSize(limit).PageState(pageState).Iter()
+
+	var applications []models.ArtistApplication
+	var application models.ArtistApplication
+	next, err := pageRows(iter, func() bool {
+		if !scanArtistApplication(iter.Scan, &application) {
+			return false
+		}
+		applications = append(applications, application)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to fetch artist applications: %v", err)
+		return nil, nil, err
+	}
+	return applications, next, nil
+}
+
+// ReviewArtistApplication approves or rejects a pending application. It
+// returns false when the application is not pending, including when another
+// admin reviewed it first.
+func (s *scyllaService) ReviewArtistApplication(userID, status, reviewedBy, note string, reviewedAt time.Time) (bool, error) {
+	query := `UPDATE artist_applications SET status = ?, reviewed_by = ?, reviewed_at = ?, review_note = ? WHERE user_id = ? IF status = ?`
+	var current string
+	applied, err := s.session.Query(query, status, reviewedBy, reviewedAt, note, userID, models.ApplicationPending).ScanCAS(&current)
+	if err != nil {
+		log.Printf("Failed to review artist application: %v", err)
+		return false, err
+	}
+	return applied, nil
+}
+
+func (s *scyllaService) GetAllArtists(limit int, pageState []byte) ([]models.Artist, []byte, error) {
 	query := `SELECT user_id, username, email, role FROM users WHERE role = 'artist' ALLOW FILTERING`
-	iter := s.session.Query(query).Iter()
+	iter := s.session.Query(query).PageSize(limit).PageState(pageState).Iter()
 
 	var artists []models.Artist
 	var artist models.Artist
-	for iter.Scan(&artist.UserID, &artist.Username, &artist.Email, &artist.Role) {
-		// Get followers count for each artist
-		followers, err := s.GetArtistFollowersCount(artist.UserID)
-		if err != nil {
-			return nil, err
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&artist.UserID, &artist.Username, &artist.Email, &artist.Role) {
+			return false
 		}
-		artist.Followers = followers
 		artists = append(artists, artist)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if err := iter.Close(); err != nil {
-		return nil, err
+	// Get followers count for each artist
+	for i := range artists {
+		followers, err := s.GetArtistFollowersCount(artists[i].UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		artists[i].Followers = followers
 	}
 
-	return artists, nil
+	return artists, next, nil
 }
 
-func (s *scyllaService) GetArtistWithSongs(artistID string) (*models.ArtistWithSongs, error) {
+// GetArtistWithSongs returns an artist with one page of their songs.
+func (s *scyllaService) GetArtistWithSongs(artistID string, limit int, pageState []byte) (*models.ArtistWithSongs, []byte, error) {
 	// Get artist info
 	var artist models.Artist
 	query := `SELECT user_id, username, email, role FROM users WHERE user_id = ? LIMIT 1`
 	if err := s.session.Query(query, artistID).Scan(&artist.UserID, &artist.Username, &artist.Email, &artist.Role); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Get followers count
 	followers, err := s.GetArtistFollowersCount(artistID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	artist.Followers = followers
 
 	// Get artist's songs
-	songs, err := s.GetSongsByUserID(artistID)
+	songs, next, err := s.GetSongsByUserID(artistID, limit, pageState)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	return &models.ArtistWithSongs{
 		Artist: artist,
 		Songs:  songs,
-	}, nil
+	}, next, nil
 }
 
+// FollowArtist records the follow in artist_followers and in the day bucket
+// of follows_by_day. Following again moves the follow to today's bucket.
 func (s *scyllaService) FollowArtist(artistID string, followerID string) error {
+	if err := s.forgetFollowDay(artistID, followerID); err != nil {
+		return err
+	}
+	now := time.Now()
 	query := `INSERT INTO artist_followers (artist_id, follower_id, followed_at) VALUES (?, ?, ?)`
-	return s.session.Query(query, artistID, followerID, time.Now()).Exec()
+	if err := s.session.Query(query, artistID, followerID, now).Exec(); err != nil {
+		return err
+	}
+	query = `INSERT INTO follows_by_day (day, artist_id, follower_id, followed_at) VALUES (?, ?, ?, ?)`
+	return s.session.Query(query, followDay(now), artistID, followerID, now).Exec()
 }
 
 func (s *scyllaService) UnfollowArtist(artistID string, followerID string) error {
+	if err := s.forgetFollowDay(artistID, followerID); err != nil {
+		return err
+	}
 	query := `DELETE FROM artist_followers WHERE artist_id = ? AND follower_id = ?`
 	return s.session.Query(query, artistID, followerID).Exec()
 }
 
-func (s *scyllaService) GetFollowedArtists(userID string) ([]models.Artist, error) {
-	// First get all artist IDs that the user follows
+// forgetFollowDay removes an existing follow from its follows_by_day bucket.
+func (s *scyllaService) forgetFollowDay(artistID, followerID string) error {
+	var followedAt time.Time
+	query := `SELECT followed_at FROM artist_followers WHERE artist_id = ? AND follower_id = ?`
+	err := s.session.Query(query, artistID, followerID).Scan(&followedAt)
+	if err == gocql.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	query = `DELETE FROM follows_by_day WHERE day = ? AND artist_id = ? AND follower_id = ?`
+	return s.session.Query(query, followDay(followedAt), artistID, followerID).Exec()
+}
+
+// followDay is the follows_by_day bucket of a follow made at t.
+func followDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func (s *scyllaService) GetFollowedArtists(userID string, limit int, pageState []byte) ([]models.Artist, []byte, error) {
+	// First get a page of the artist IDs that the user follows
 	query := `SELECT artist_id FROM artist_followers WHERE follower_id = ?`
-	iter := s.session.Query(query, userID).Iter()
+	iter := s.session.Query(query, userID).PageSize(limit).PageState(pageState).Iter()
 
 	var artistIDs []string
 	var artistID string
-	for iter.Scan(&artistID) {
+	next, err := pageRows(iter, func() bool {
+		if !iter.Scan(&artistID) {
+			return false
+		}
 		artistIDs = append(artistIDs, artistID)
-	}
-
-	if err := iter.Close(); err != nil {
-		return nil, err
-	}
-
-	if len(artistIDs) == 0 {
-		return []models.Artist{}, nil
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Then get artist details for each ID
@@ -462,7 +1534,28 @@ func (s *scyllaService) GetFollowedArtists(userID string) ([]models.Artist, erro
 		artists = append(artists, artist)
 	}
 
-	return artists, nil
+	return artists, next, nil
+}
+
+// CountNewFollowers counts the followers every artist gained since the given
+// time, reading only the day buckets of follows_by_day that cover it.
+func (s *scyllaService) CountNewFollowers(since time.Time) (map[string]int, error) {
+	counts := map[string]int{}
+	query := `SELECT artist_id, followed_at FROM follows_by_day WHERE day = ?`
+	for day := followDay(since); !day.After(time.Now()); day = day.Add(24 * time.Hour) {
+		iter := s.session.Query(query, day).Iter()
+		var artistID string
+		var followedAt time.Time
+		for iter.Scan(&artistID, &followedAt) {
+			if followedAt.After(since) {
+				counts[artistID]++
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
 }
 
 func (s *scyllaService) GetArtistFollowersCount(artistID string) (int, error) {
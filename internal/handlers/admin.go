@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"rr-backend/internal/audit"
+	"rr-backend/internal/database"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/search"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// maxUserScanPages bounds how many pages of users one filtered listing reads
+// before it answers with what it found and a cursor to carry on from.
+const maxUserScanPages = 20
+
+// ListUsersHandler lists users a page at a time. ?q= keeps those whose name
+// or email contains it, or whose ID is exactly it; ?role= and ?status= keep
+// those with that role or account status (active, suspended or banned).
+// Users have no index to filter on, so a filtered page may hold fewer users
+// than ?limit= while more follow.
+func ListUsersHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		query := c.QueryParam("q")
+		folded := search.Fold(query)
+		role, status := c.QueryParam("role"), c.QueryParam("status")
+		if role != "" && !models.ValidRole(role) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid role")
+		}
+		if status != "" && status != accountActive && status != models.UserSuspended && status != models.UserBanned {
+			return echo.NewHTTPError(http.StatusBadRequest, "Status must be active, suspended or banned")
+		}
+		now := time.Now()
+		matches := func(u models.User) bool {
+			if role != "" && u.Role != role {
+				return false
+			}
+			if status != "" && accountStatus(u, now) != status {
+				return false
+			}
+			return query == "" || u.UserID == query ||
+				folded != "" && (strings.Contains(search.Fold(u.Username), folded) || strings.Contains(search.Fold(u.Email), folded))
+		}
+
+		var users []models.User
+		for pages := 0; pages < maxUserScanPages; pages++ {
+			var batch []models.User
+			batch, pageState, err = dbService.GetUsers(limit, pageState)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get users")
+			}
+			for _, u := range batch {
+				if matches(u) {
+					users = append(users, u)
+				}
+			}
+			if len(users) >= limit || len(pageState) == 0 {
+				break
+			}
+		}
+		return c.JSON(http.StatusOK, newPage(users, pageState))
+	}
+}
+
+// accountActive is the status filter for users who are neither banned nor
+// suspended.
+const accountActive = "active"
+
+// accountStatus is the status of u at now, counting an expired suspension as
+// active.
+func accountStatus(u models.User, now time.Time) string {
+	if !u.Blocked(now) {
+		return accountActive
+	}
+	return u.Status
+}
+
+// ChangeUserRoleHandler gives :user_id the role in {"role": ...}. Admins
+// cannot change their own role, so the last admin cannot lock everyone out.
+func ChangeUserRoleHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind role")
+		}
+		if !models.ValidRole(body.Role) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Role must be listener, artist, moderator or admin")
+		}
+		user, err := adminTargetUser(c, dbService)
+		if err != nil {
+			return err
+		}
+
+		if err := dbService.UpdateUserRole(user.UserID, body.Role); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to change role")
+		}
+
+		before := *user
+		user.Role = body.Role
+		audit.Record(c, dbService, audit.Entry{
+			Action: models.ActionChangeRole, TargetType: models.TargetUser, TargetID: user.UserID, Before: before, After: user,
+		})
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+// SuspendUserHandler suspends :user_id until the time in {"until": ...}, or
+// until they are reinstated when it is omitted. {"reason": ...} goes to the
+// audit log.
+func SuspendUserHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Until  *time.Time `json:"until"`
+			Reason string     `json:"reason"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind suspension")
+		}
+		if body.Until != nil && !body.Until.After(time.Now()) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Suspension must end in the future")
+		}
+		return setUserStatus(c, dbService, models.UserSuspended, body.Until, models.ActionSuspendUser, body.Reason)
+	}
+}
+
+// BanUserHandler bans :user_id for good, giving {"reason": ...} to the audit
+// log.
+func BanUserHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind ban")
+		}
+		return setUserStatus(c, dbService, models.UserBanned, nil, models.ActionBanUser, body.Reason)
+	}
+}
+
+// ReinstateUserHandler lifts the suspension or ban of :user_id.
+func ReinstateUserHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind reinstatement")
+		}
+		return setUserStatus(c, dbService, "", nil, models.ActionReinstateUser, body.Reason)
+	}
+}
+
+func setUserStatus(c echo.Context, dbService database.ScyllaService, status string, until *time.Time, action, reason string) error {
+	user, err := adminTargetUser(c, dbService)
+	if err != nil {
+		return err
+	}
+
+	if err := dbService.SetUserStatus(user.UserID, status, until); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user status")
+	}
+
+	before := *user
+	user.Status, user.SuspendedUntil = status, until
+	audit.Record(c, dbService, audit.Entry{
+		Action: action, TargetType: models.TargetUser, TargetID: user.UserID, Before: before, After: user, Reason: reason,
+	})
+	return c.JSON(http.StatusOK, user)
+}
+
+// adminTargetUser loads :user_id, refusing admins who act on themselves.
+func adminTargetUser(c echo.Context, dbService database.ScyllaService) (*models.User, error) {
+	userID := c.Param("user_id")
+	if userID == c.Get("userID").(string) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Admins cannot change their own account")
+	}
+	user, err := dbService.GetUserByID(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	if user == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// TakeDownSongHandler stops :song_id from being streamed, played or found
+// without deleting it, giving {"reason": ...} to the audit log.
+func TakeDownSongHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind takedown")
+		}
+		song, err := adminTargetSong(c, dbService)
+		if err != nil {
+			return err
+		}
+		if song.Status == models.SongStatusTakenDown {
+			return echo.NewHTTPError(http.StatusConflict, "Song is already taken down")
+		}
+		return setSongStatus(c, dbService, song, models.SongStatusTakenDown, models.ActionTakeDownSong, body.Reason)
+	}
+}
+
+// RestoreSongHandler makes a song that was taken down available again.
+func RestoreSongHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind restore")
+		}
+		song, err := adminTargetSong(c, dbService)
+		if err != nil {
+			return err
+		}
+		if song.Status != models.SongStatusTakenDown {
+			return echo.NewHTTPError(http.StatusConflict, "Song is not taken down")
+		}
+		return setSongStatus(c, dbService, song, "", models.ActionRestoreSong, body.Reason)
+	}
+}
+
+func adminTargetSong(c echo.Context, dbService database.ScyllaService) (*models.Song, error) {
+	songUUID, err := gocql.ParseUUID(c.Param("song_id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
+	}
+	song, err := dbService.GetSongByID(songUUID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+	}
+	if song == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Song not found")
+	}
+	return song, nil
+}
+
+func setSongStatus(c echo.Context, dbService database.ScyllaService, song *models.Song, status, action, reason string) error {
+	songUUID, _ := gocql.ParseUUID(song.SongID)
+	if err := dbService.SetSongStatus(songUUID, status); err != nil {
+		if err == gocql.ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update song status")
+	}
+
+	before := *song
+	song.Status = status
+	audit.Record(c, dbService, audit.Entry{
+		Action: action, TargetType: models.TargetSong, TargetID: song.SongID, Before: before, After: song, Reason: reason,
+	})
+	return c.JSON(http.StatusOK, song)
+}
+
+// AdminRemovePlaylistHandler deletes any playlist, whoever owns it. ?reason=
+// goes to the audit log.
+func AdminRemovePlaylistHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		playlist, err := dbService.GetPlaylist(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+
+		if err := dbService.RemovePlaylist(playlistUUID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove playlist")
+		}
+		audit.Record(c, dbService, audit.Entry{
+			Action: models.ActionRemovePlaylist, TargetType: models.TargetPlaylist, TargetID: playlistUUID.String(),
+			Before: playlist, Reason: c.QueryParam("reason"),
+		})
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Playlist removed successfully",
+		})
+	}
+}
+
+// GetStorageUsageHandler reports how much storage the songs of each artist
+// take. It lists the whole music bucket, so it is slow on large catalogs.
+func GetStorageUsageHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report, err := maintenance.StorageUsage(c.Request().Context(), dbService, minioService)
+		if err != nil {
+			log.Printf("Failed to compute storage usage: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to compute storage usage")
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// RefreshFollowerCountsHandler queues refresh, which recomputes everything
+// derived from follower counts, instead of waiting for its next scheduled
+// run.
+func RefreshFollowerCountsHandler(dbService database.ScyllaService, jobQueue *jobs.Queue, refresh func(ctx context.Context) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !jobQueue.Enqueue("refresh follower counts", refresh) {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "Too much background work queued, try again later")
+		}
+		audit.Record(c, dbService, audit.Entry{Action: models.ActionRefreshFollowers, TargetType: models.TargetArtists})
+
+		return c.JSON(http.StatusAccepted, echo.Map{
+			"message": "Follower counts are being refreshed",
+		})
+	}
+}
+
+// defaultAuditRange and maxAuditRange are how far back the audit log is read
+// without ?from=, and the longest ?from= to ?to= range one listing may walk.
+const (
+	defaultAuditRange = 30 * 24 * time.Hour
+	maxAuditRange     = 366 * 24 * time.Hour
+)
+
+// GetAuditLogHandler lists audit events newest first. ?actor= and
+// ?target_type= with ?target_id= narrow it to one actor or target; ?from=
+// and ?to= (RFC 3339) to a time range, by default the last 30 days.
+func GetAuditLogHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		filter := models.AuditFilter{
+			ActorID:    c.QueryParam("actor"),
+			TargetType: c.QueryParam("target_type"),
+			TargetID:   c.QueryParam("target_id"),
+			To:         time.Now(),
+		}
+		if (filter.TargetType == "") != (filter.TargetID == "") {
+			return echo.NewHTTPError(http.StatusBadRequest, "Both target_type and target_id are needed to filter by target")
+		}
+		if s := c.QueryParam("to"); s != "" {
+			if filter.To, err = time.Parse(time.RFC3339, s); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid to time")
+			}
+		}
+		filter.From = filter.To.Add(-defaultAuditRange)
+		if s := c.QueryParam("from"); s != "" {
+			if filter.From, err = time.Parse(time.RFC3339, s); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid from time")
+			}
+		}
+		if filter.From.After(filter.To) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Time range ends before it starts")
+		}
+		if filter.To.Sub(filter.From) > maxAuditRange {
+			return echo.NewHTTPError(http.StatusBadRequest, "Time range must be at most a year")
+		}
+		// The cursor is the ID of the last event of the previous page.
+		if len(pageState) > 0 {
+			before, err := gocql.UUIDFromBytes(pageState)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+			}
+			filter.Before = &before
+		}
+
+		events, err := dbService.GetAuditEvents(filter, limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get audit log")
+		}
+		var next []byte
+		if len(events) == limit {
+			next = events[len(events)-1].EventID.Bytes()
+		}
+		return c.JSON(http.StatusOK, newPage(events, next))
+	}
+}
@@ -2,30 +2,45 @@ package handlers
 
 import (
     "net/http"
+    "rr-backend/internal/audit"
     "rr-backend/internal/database"
+    "rr-backend/internal/models"
+    "strings"
     "github.com/labstack/echo/v4"
 )
 
 func GetAllArtistsHandler(dbService database.ScyllaService) echo.HandlerFunc {
     return func(c echo.Context) error {
-        artists, err := dbService.GetAllArtists()
+        limit, pageState, err := pageParams(c)
+        if err != nil {
+            return err
+        }
+        artists, next, err := dbService.GetAllArtists(limit, pageState)
         if err != nil {
             return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artists")
         }
-        return c.JSON(http.StatusOK, artists)
+        return c.JSON(http.StatusOK, newPage(artists, next))
     }
 }
 
 func GetArtistWithSongsHandler(dbService database.ScyllaService) echo.HandlerFunc {
     return func(c echo.Context) error {
         artistID := c.Param("artist_id")
-        
-        artistWithSongs, err := dbService.GetArtistWithSongs(artistID)
+        limit, pageState, err := pageParams(c)
+        if err != nil {
+            return err
+        }
+
+        // The cursor pages through the artist's songs.
+        artistWithSongs, next, err := dbService.GetArtistWithSongs(artistID, limit, pageState)
         if err != nil {
             return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist data")
         }
-        
-        return c.JSON(http.StatusOK, artistWithSongs)
+
+        return c.JSON(http.StatusOK, echo.Map{
+            "artist": artistWithSongs.Artist,
+            "songs":  newPage(availableSongs(artistWithSongs.Songs), next),
+        })
     }
 }
 
@@ -38,6 +53,7 @@ func FollowArtistHandler(dbService database.ScyllaService) echo.HandlerFunc {
         if err != nil {
             return echo.NewHTTPError(http.StatusInternalServerError, "Failed to follow artist")
         }
+        audit.Record(c, dbService, audit.Entry{Action: models.ActionFollowArtist, TargetType: models.TargetArtist, TargetID: artistID})
 
         return c.JSON(http.StatusOK, echo.Map{
             "message": "Successfully followed artist",
@@ -54,6 +70,7 @@ func UnfollowArtistHandler(dbService database.ScyllaService) echo.HandlerFunc {
         if err != nil {
             return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unfollow artist")
         }
+        audit.Record(c, dbService, audit.Entry{Action: models.ActionUnfollowArtist, TargetType: models.TargetArtist, TargetID: artistID})
 
         return c.JSON(http.StatusOK, echo.Map{
             "message": "Successfully unfollowed artist",
@@ -64,12 +81,51 @@ func UnfollowArtistHandler(dbService database.ScyllaService) echo.HandlerFunc {
 func GetFollowedArtistsHandler(dbService database.ScyllaService) echo.HandlerFunc {
     return func(c echo.Context) error {
         userID := c.Get("userID").(string)
-        
-        artists, err := dbService.GetFollowedArtists(userID)
+        limit, pageState, err := pageParams(c)
+        if err != nil {
+            return err
+        }
+
+        artists, next, err := dbService.GetFollowedArtists(userID, limit, pageState)
         if err != nil {
             return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get followed artists")
         }
-        
-        return c.JSON(http.StatusOK, artists)
+
+        return c.JSON(http.StatusOK, newPage(artists, next))
     }
-} 
\ No newline at end of file
+}
+
+// UpdateArtistProfileHandler changes an artist's display name. The route
+// only lets the artist themselves or an admin through.
+func UpdateArtistProfileHandler(dbService database.ScyllaService) echo.HandlerFunc {
+    return func(c echo.Context) error {
+        artistID := c.Param("artist_id")
+
+        var body struct {
+            Username string `json:"username"`
+        }
+        if err := c.Bind(&body); err != nil {
+            return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind profile")
+        }
+        username := strings.TrimSpace(body.Username)
+        if username == "" {
+            return echo.NewHTTPError(http.StatusBadRequest, "Username is required")
+        }
+
+        artist, err := dbService.GetUserByID(artistID)
+        if err != nil {
+            return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist")
+        }
+        if artist == nil {
+            return echo.NewHTTPError(http.StatusNotFound, "Artist not found")
+        }
+
+        if err := dbService.UpdateUsername(artistID, username); err != nil {
+            return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update artist profile")
+        }
+
+        return c.JSON(http.StatusOK, echo.Map{
+            "message": "Artist profile updated successfully",
+        })
+    }
+}
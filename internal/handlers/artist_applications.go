@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"rr-backend/internal/audit"
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ApplyForArtistHandler files an artist application for the signed-in
+// listener from {"artist_name": ..., "message": ...}. A rejected listener may
+// apply again; a pending application must be reviewed first.
+func ApplyForArtistHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var body struct {
+			ArtistName string `json:"artist_name"`
+			Message    string `json:"message"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind application")
+		}
+		body.ArtistName = strings.TrimSpace(body.ArtistName)
+		if body.ArtistName == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Artist name is required")
+		}
+
+		existing, err := dbService.GetArtistApplication(userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist application")
+		}
+		if existing != nil && existing.Status == models.ApplicationPending {
+			return echo.NewHTTPError(http.StatusConflict, "An application is already pending")
+		}
+
+		application := models.ArtistApplication{
+			UserID:      userID,
+			ArtistName:  body.ArtistName,
+			Message:     body.Message,
+			Status:      models.ApplicationPending,
+			SubmittedAt: time.Now(),
+		}
+		if err := dbService.SaveArtistApplication(application); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save artist application")
+		}
+		return c.JSON(http.StatusCreated, application)
+	}
+}
+
+// GetMyArtistApplicationHandler returns the application of the signed-in
+// user, so they can follow its review.
+func GetMyArtistApplicationHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+
+		application, err := dbService.GetArtistApplication(userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist application")
+		}
+		if application == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "No artist application")
+		}
+		return c.JSON(http.StatusOK, application)
+	}
+}
+
+// GetArtistApplicationsHandler lists one page of applications, the pending
+// ones unless ?status= asks for others.
+func GetArtistApplicationsHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		status := c.QueryParam("status")
+		if status == "" {
+			status = models.ApplicationPending
+		}
+		if status != models.ApplicationPending && status != models.ApplicationApproved && status != models.ApplicationRejected {
+			return echo.NewHTTPError(http.StatusBadRequest, "Status must be pending, approved or rejected")
+		}
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		applications, next, err := dbService.GetArtistApplications(status, limit, pageState)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist applications")
+		}
+		return c.JSON(http.StatusOK, newPage(applications, next))
+	}
+}
+
+// ApproveArtistApplicationHandler approves the pending application of
+// :user_id and makes them an artist under the name they applied with.
+func ApproveArtistApplicationHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return reviewArtistApplication(dbService, models.ApplicationApproved)
+}
+
+// RejectArtistApplicationHandler rejects the pending application of
+// :user_id, optionally explaining why in {"note": ...}.
+func RejectArtistApplicationHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return reviewArtistApplication(dbService, models.ApplicationRejected)
+}
+
+func reviewArtistApplication(dbService database.ScyllaService, status string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reviewerID := c.Get("userID").(string)
+		applicantID := c.Param("user_id")
+		var body struct {
+			Note string `json:"note"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind review")
+		}
+
+		application, err := dbService.GetArtistApplication(applicantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist application")
+		}
+		if application == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Artist application not found")
+		}
+		applicant, err := dbService.GetUserByID(applicantID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+		}
+		if applicant == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+
+		reviewedAt := time.Now()
+		applied, err := dbService.ReviewArtistApplication(applicantID, status, reviewerID, body.Note, reviewedAt)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to review artist application")
+		}
+		if !applied {
+			return echo.NewHTTPError(http.StatusConflict, "Artist application is not pending")
+		}
+
+		// Admins and moderators who applied keep their role.
+		before := *applicant
+		if status == models.ApplicationApproved && applicant.Role == models.RoleListener {
+			if err := dbService.UpdateUsername(applicantID, application.ArtistName); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to promote listener to artist")
+			}
+			if err := dbService.UpdateUserRole(applicantID, models.RoleArtist); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to promote listener to artist")
+			}
+			applicant.Username, applicant.Role = application.ArtistName, models.RoleArtist
+		}
+
+		action := models.ActionRejectArtist
+		if status == models.ApplicationApproved {
+			action = models.ActionApproveArtist
+		}
+		audit.Record(c, dbService, audit.Entry{
+			Action: action, TargetType: models.TargetUser, TargetID: applicantID, Before: before, After: applicant, Reason: body.Note,
+		})
+
+		application.Status = status
+		application.ReviewedBy = reviewerID
+		application.ReviewedAt = &reviewedAt
+		application.ReviewNote = body.Note
+		return c.JSON(http.StatusOK, application)
+	}
+}
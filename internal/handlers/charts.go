@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"rr-backend/internal/charts"
+	"rr-backend/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Charts are served from the snapshots written by the charts job; none of
+// these handlers reads the songs table.
+
+// defaultChartLength is how many entries a chart lists without ?limit=.
+const defaultChartLength = 20
+
+func GetTopSongsHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return serveChart(c, dbService, charts.TopSongs)
+	}
+}
+
+func GetTopSongsByGenreHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return serveChart(c, dbService, charts.GenreChart(c.Param("genre")))
+	}
+}
+
+// GetTopArtistsHandler ranks artists by plays (default) or, with
+// ?by=followers, by followers gained in the last week.
+func GetTopArtistsHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.QueryParam("by") {
+		case "", "plays":
+			return serveChart(c, dbService, charts.TopArtistsPlays)
+		case "followers":
+			return serveChart(c, dbService, charts.TopArtistsFollowed)
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "by must be plays or followers")
+		}
+	}
+}
+
+// GetTrendingHandler serves the trending chart of ?window=24h (default) or 7d.
+func GetTrendingHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		window := c.QueryParam("window")
+		if window == "" {
+			window = "24h"
+		}
+		if _, ok := charts.TrendingWindows[window]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "window must be 24h or 7d")
+		}
+		return serveChart(c, dbService, charts.TrendingChart(window))
+	}
+}
+
+func serveChart(c echo.Context, dbService database.ScyllaService, name string) error {
+	limit := defaultChartLength
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid limit")
+		}
+		limit = min(n, charts.Size)
+	}
+
+	chart, err := dbService.GetChart(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get chart")
+	}
+	if chart == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Chart not available yet")
+	}
+	if len(chart.Entries) > limit {
+		chart.Entries = chart.Entries[:limit]
+	}
+	return c.JSON(http.StatusOK, chart)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// page is the envelope of every paginated response. NextCursor is omitted on
+// the last page.
+type page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// pageParams reads ?limit= and ?cursor=. Cursors are URL-safe base64 of the
+// driver's paging state and mean nothing to clients.
+func pageParams(c echo.Context) (int, []byte, error) {
+	limit := defaultPageSize
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid limit")
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	var pageState []byte
+	if s := c.QueryParam("cursor"); s != "" {
+		var err error
+		pageState, err = base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return 0, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+	}
+	return limit, pageState, nil
+}
+
+// newPage wraps one page of items; an empty page still lists its items as [].
+func newPage[T any](items []T, nextPageState []byte) page {
+	if items == nil {
+		items = []T{}
+	}
+	p := page{Items: items}
+	if len(nextPageState) > 0 {
+		p.NextCursor = base64.RawURLEncoding.EncodeToString(nextPageState)
+	}
+	return p
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// qualifiedPlaySeconds is how long a song must be listened to for the
+	// play to count. Songs shorter than twice that count at half their length.
+	qualifiedPlaySeconds = 30
+	// minReplayWindow is the shortest time between two counted plays of the
+	// same song by the same user.
+	minReplayWindow = qualifiedPlaySeconds * time.Second
+)
+
+type playEvent struct {
+	ListenedSeconds float64 `json:"listened_seconds"`
+}
+
+// RecordPlayHandler receives a play event from the player once playback of a
+// song stops or ends.
+func RecordPlayHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		songID, err := gocql.ParseUUID(c.Param("song_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
+		}
+
+		var event playEvent
+		if err := c.Bind(&event); err != nil || event.ListenedSeconds < 0 || math.IsNaN(event.ListenedSeconds) || math.IsInf(event.ListenedSeconds, 0) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid play event")
+		}
+
+		song, err := dbService.GetSongByID(songID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+		}
+		if song == nil || song.Status != "" {
+			return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+		}
+
+		listened := event.ListenedSeconds
+		if song.Duration > 0 && listened > song.Duration {
+			listened = song.Duration
+		}
+		if listened < qualifyingSeconds(song.Duration) {
+			return c.JSON(http.StatusOK, echo.Map{"message": "Play not counted", "counted": false, "reason": "too_short"})
+		}
+
+		// Nobody can listen for longer than wall-clock time allows, so a play
+		// of the same song that arrives sooner than that is a replayed event.
+		window := time.Duration(listened * float64(time.Second))
+		if window < minReplayWindow {
+			window = minReplayWindow
+		}
+		claimed, err := dbService.ClaimPlay(userID, songID, window)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record play")
+		}
+		if !claimed {
+			return c.JSON(http.StatusOK, echo.Map{"message": "Play not counted", "counted": false, "reason": "duplicate"})
+		}
+
+		if err := dbService.RecordPlay(userID, songID, time.Now(), listened); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record play")
+		}
+		return c.JSON(http.StatusOK, echo.Map{"message": "Play recorded", "counted": true})
+	}
+}
+
+func qualifyingSeconds(duration float64) float64 {
+	if duration > 0 && duration < 2*qualifiedPlaySeconds {
+		return duration / 2
+	}
+	return qualifiedPlaySeconds
+}
+
+// GetListeningHistoryHandler lists the signed-in user's plays, most recent
+// first, with the songs they refer to.
+func GetListeningHistoryHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		plays, nextPageState, err := dbService.GetListeningHistory(userID, limit, pageState)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get listening history")
+		}
+
+		var songIDs []gocql.UUID
+		seen := map[string]bool{}
+		for _, play := range plays {
+			if songID, err := gocql.ParseUUID(play.SongID); err == nil && !seen[play.SongID] {
+				seen[play.SongID] = true
+				songIDs = append(songIDs, songID)
+			}
+		}
+		// Plays are listed without their songs rather than not at all.
+		songs, err := dbService.GetSongsByIDs(songIDs)
+		if err != nil {
+			log.Printf("Failed to get songs for history: %v", err)
+		}
+		byID := make(map[string]*models.Song, len(songs))
+		for i := range songs {
+			byID[songs[i].SongID] = &songs[i]
+		}
+		for i := range plays {
+			plays[i].Song = byID[plays[i].SongID]
+		}
+		return c.JSON(http.StatusOK, newPage(plays, nextPageState))
+	}
+}
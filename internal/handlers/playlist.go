@@ -1,28 +1,163 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"rr-backend/internal/audit"
+	"rr-backend/internal/authz"
 	"rr-backend/internal/database"
+	mdw "rr-backend/internal/middleware"
 	"rr-backend/internal/models"
+	"rr-backend/internal/playlists"
+	"strconv"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/labstack/echo/v4"
 )
 
+// The playlists of a user are listed owned ones first, then the ones shared
+// with them. The first byte of the cursor says which listing it continues.
+const (
+	ownedPlaylistsCursor  byte = 'o'
+	sharedPlaylistsCursor byte = 's'
+)
+
+// FetchPlaylistsHandler lists the playlists a user owns followed by the
+// ones shared with them, which carry the member_role they were given.
+// Everyone else, signed in or not, only sees the user's public playlists;
+// those pages may come back short while more follow.
 func FetchPlaylistsHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID := c.Param("user_id")
 		if userID == "" {
 			return echo.NewHTTPError(http.StatusBadRequest, "User ID is missing?")
 		}
+		self, err := viewsOwnPlaylists(c, scyllaService, userID)
+		if err != nil {
+			return err
+		}
+
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		phase := ownedPlaylistsCursor
+		if len(pageState) > 0 {
+			phase, pageState = pageState[0], pageState[1:]
+			if phase != ownedPlaylistsCursor && phase != sharedPlaylistsCursor {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+			}
+		}
 
-		playlists, err := scyllaService.FetchPlaylists(userID)
+		var playlists []models.Playlist
+		if phase == ownedPlaylistsCursor {
+			owned, next, err := scyllaService.FetchPlaylists(userID, limit, pageState)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch playlists")
+			}
+			if next != nil {
+				next = append([]byte{ownedPlaylistsCursor}, next...)
+			}
+			if !self {
+				return c.JSON(http.StatusOK, newPage(publicPlaylists(owned), next))
+			}
+			if next != nil {
+				return c.JSON(http.StatusOK, newPage(owned, next))
+			}
+			playlists, pageState = owned, nil
+			if limit -= len(owned); limit <= 0 {
+				return c.JSON(http.StatusOK, newPage(playlists, []byte{sharedPlaylistsCursor}))
+			}
+		}
+
+		if !self {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+		}
+		shared, next, err := scyllaService.GetSharedPlaylists(userID, limit, pageState)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch playlists")
 		}
+		playlists = append(playlists, shared...)
+		if next != nil {
+			next = append([]byte{sharedPlaylistsCursor}, next...)
+		}
+		return c.JSON(http.StatusOK, newPage(playlists, next))
+	}
+}
 
-		return c.JSON(http.StatusOK, playlists)
+// viewsOwnPlaylists reports whether the signed-in user, if any, may see all
+// of userID's playlists: they are userID or an admin.
+func viewsOwnPlaylists(c echo.Context, dbService database.ScyllaService, userID string) (bool, error) {
+	viewerID, _ := c.Get("userID").(string)
+	if viewerID == "" {
+		return false, nil
+	}
+	if viewerID == userID {
+		return true, nil
+	}
+	viewer, err := mdw.CurrentUser(c, dbService)
+	if err != nil {
+		return false, err
+	}
+	return viewer != nil && viewer.Role == models.RoleAdmin, nil
+}
+
+func publicPlaylists(all []models.Playlist) []models.Playlist {
+	var public []models.Playlist
+	for _, playlist := range all {
+		if playlist.Visibility == models.PlaylistPublic {
+			public = append(public, playlist)
+		}
+	}
+	return public
+}
+
+// GetPlaylistHandler returns a playlist. The route decides who may see it,
+// so public playlists can be viewed without signing in.
+func GetPlaylistHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		playlist, err := dbService.GetPlaylist(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+		return c.JSON(http.StatusOK, playlist)
+	}
+}
+
+// defaultShareDays and maxShareDays bound how long a share link lasts.
+const (
+	defaultShareDays = 30
+	maxShareDays     = 365
+)
+
+// SharePlaylistHandler issues a share link token, valid for ?days= days.
+// Passing it as ?share= lets anyone view the playlist while it is unlisted.
+func SharePlaylistHandler(shareLinks *authz.ShareLinks) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		days := defaultShareDays
+		if raw := c.QueryParam("days"); raw != "" {
+			if days, err = strconv.Atoi(raw); err != nil || days <= 0 || days > maxShareDays {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid days")
+			}
+		}
+
+		expires := time.Now().Add(time.Duration(days) * 24 * time.Hour).Truncate(time.Second)
+		return c.JSON(http.StatusCreated, echo.Map{
+			"token":      shareLinks.Token(playlistUUID, expires),
+			"expires_at": expires,
+		})
 	}
 }
 
@@ -33,9 +168,16 @@ func AddPlaylistHandler(dbService database.ScyllaService) echo.HandlerFunc {
 		if err := c.Bind(playlist); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind playlist")
 		}
+		if playlist.Visibility == "" {
+			playlist.Visibility = models.PlaylistPublic
+		}
+		if !models.ValidVisibility(playlist.Visibility) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Visibility must be public, private or unlisted")
+		}
 		playlist.PlaylistID = gocql.TimeUUID()
 		playlist.UserID = userID
-		err := dbService.AddPlaylist(playlist.PlaylistID, playlist.UserID, playlist.Name, playlist.Description)
+		playlist.MemberRole = ""
+		err := dbService.AddPlaylist(playlist.PlaylistID, playlist.UserID, playlist.Name, playlist.Description, playlist.Visibility)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add playlist")
 		}
@@ -56,8 +198,18 @@ func UpdatePlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFun
 		if err := c.Bind(&updateData); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 		}
+		if updateData.Visibility == "" {
+			current, err := scyllaService.GetPlaylist(playlistUUID)
+			if err != nil || current == nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+			}
+			updateData.Visibility = current.Visibility
+		}
+		if !models.ValidVisibility(updateData.Visibility) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Visibility must be public, private or unlisted")
+		}
 
-		err = scyllaService.UpdatePlaylist(playlistUUID, updateData.Name, updateData.Description)
+		err = scyllaService.UpdatePlaylist(playlistUUID, updateData.Name, updateData.Description, updateData.Visibility)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update playlist")
 		}
@@ -68,11 +220,13 @@ func UpdatePlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFun
 	}
 }
 
+// AddSongToPlaylistHandler adds a song to a playlist, at the end or at the
+// index given by ?position=. A song may be added more than once; the
+// response carries the entry ID of the new track.
 func AddSongToPlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		playlistID := c.Param("playlist_id")
 		songID := c.Param("song_id")
-		userID := c.Get("userID").(string)
 
 		playlistUUID, err := gocql.ParseUUID(playlistID)
 		if err != nil {
@@ -84,17 +238,43 @@ func AddSongToPlaylistHandler(scyllaService database.ScyllaService) echo.Handler
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
 		}
 
-		err = scyllaService.AddSongToPlaylist(playlistUUID, userID, songUUID, time.Now()) // use current time stamp for now.
+		index := -1
+		if raw := c.QueryParam("position"); raw != "" {
+			if index, err = strconv.Atoi(raw); err != nil || index < 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid position")
+			}
+		}
+
+		song, err := scyllaService.GetSongByID(songUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+		}
+		if song == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+		}
+		if err := songStatusError(song.Status); err != nil {
+			return err
+		}
+
+		track, err := playlists.Insert(scyllaService, playlistUUID, songUUID, c.Get("userID").(string), index, time.Now())
+		if errors.Is(err, playlists.ErrConflict) {
+			return errPlaylistBusy
+		}
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add song to playlist")
 		}
+		track.Song = song
 
-		return c.JSON(http.StatusCreated, echo.Map{
-			"message": "Song added to playlist successfully",
-		})
+		return c.JSON(http.StatusCreated, track)
 	}
 }
 
+// errPlaylistBusy answers a track change that kept losing to concurrent
+// changes of the same playlist.
+var errPlaylistBusy = echo.NewHTTPError(http.StatusConflict, "Playlist is being changed, try again")
+
+// RemoveSongFromPlaylistHandler removes every occurrence of a song from a
+// playlist. RemovePlaylistTrackHandler removes a single one.
 func RemoveSongFromPlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		playlistID := c.Param("playlist_id")
@@ -108,7 +288,13 @@ func RemoveSongFromPlaylistHandler(scyllaService database.ScyllaService) echo.Ha
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
 		}
-		err = scyllaService.RemoveSongFromPlaylist(playlistUUID, songUUID)
+		_, err = playlists.RemoveSong(scyllaService, playlistUUID, songUUID)
+		if errors.Is(err, playlists.ErrTrackNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Song not in playlist")
+		}
+		if errors.Is(err, playlists.ErrConflict) {
+			return errPlaylistBusy
+		}
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove song from playlist")
 		}
@@ -119,6 +305,103 @@ func RemoveSongFromPlaylistHandler(scyllaService database.ScyllaService) echo.Ha
 	}
 }
 
+func RemovePlaylistTrackHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, entryUUID, err := trackParams(c)
+		if err != nil {
+			return err
+		}
+		err = playlists.Remove(scyllaService, playlistUUID, entryUUID)
+		if errors.Is(err, playlists.ErrTrackNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Track not found")
+		}
+		if errors.Is(err, playlists.ErrConflict) {
+			return errPlaylistBusy
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove track from playlist")
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Track removed from playlist successfully",
+		})
+	}
+}
+
+// MovePlaylistTrackHandler moves one track to the index given as
+// {"position": n}.
+func MovePlaylistTrackHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, entryUUID, err := trackParams(c)
+		if err != nil {
+			return err
+		}
+		var body struct {
+			Position *int `json:"position"`
+		}
+		if err := c.Bind(&body); err != nil || body.Position == nil || *body.Position < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid position")
+		}
+
+		track, err := playlists.Move(scyllaService, playlistUUID, entryUUID, *body.Position)
+		if errors.Is(err, playlists.ErrTrackNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Track not found")
+		}
+		if errors.Is(err, playlists.ErrConflict) {
+			return errPlaylistBusy
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to move track")
+		}
+
+		return c.JSON(http.StatusOK, track)
+	}
+}
+
+// ReorderPlaylistHandler rewrites the order of a whole playlist from
+// {"entry_ids": [...]}. The list must hold every track of the playlist once;
+// otherwise the client is working from a stale copy and gets a 409.
+func ReorderPlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		var body struct {
+			EntryIDs []string `json:"entry_ids"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind order")
+		}
+		entryIDs := make([]gocql.UUID, 0, len(body.EntryIDs))
+		for _, raw := range body.EntryIDs {
+			id, err := gocql.ParseUUID(raw)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid entry ID")
+			}
+			entryIDs = append(entryIDs, id)
+		}
+
+		tracks, err := playlists.Reorder(scyllaService, playlistUUID, entryIDs)
+		if errors.Is(err, playlists.ErrOrderMismatch) {
+			return echo.NewHTTPError(http.StatusConflict, "Order does not match the playlist")
+		}
+		if errors.Is(err, playlists.ErrConflict) {
+			return errPlaylistBusy
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to reorder playlist")
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message":   "Playlist reordered successfully",
+			"entry_ids": entryIDsOf(tracks),
+		})
+	}
+}
+
+// GetSongsInPlaylistHandler lists a playlist in order, one page of tracks at
+// a time.
 func GetSongsInPlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		playlistID := c.Param("playlist_id")
@@ -128,13 +411,45 @@ func GetSongsInPlaylistHandler(scyllaService database.ScyllaService) echo.Handle
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
 		}
 
-		playllistSongs, err := scyllaService.GetSongsInPlaylist(playlistUUID)
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		tracks, next, err := scyllaService.GetPlaylistTracks(playlistUUID, limit, pageState)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get liked songs")
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist songs")
 		}
+		// Tracks of songs that are not available stay in place, so entry
+		// IDs keep working, but show up like removed songs.
+		for i := range tracks {
+			if tracks[i].Song != nil && tracks[i].Song.Status != "" {
+				tracks[i].Song = nil
+			}
+		}
+
+		return c.JSON(http.StatusOK, newPage(tracks, next))
+	}
+}
 
-		return c.JSON(http.StatusOK, playllistSongs)
+func trackParams(c echo.Context) (playlistID, entryID gocql.UUID, err error) {
+	playlistID, err = gocql.ParseUUID(c.Param("playlist_id"))
+	if err != nil {
+		return playlistID, entryID, echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
 	}
+	entryID, err = gocql.ParseUUID(c.Param("entry_id"))
+	if err != nil {
+		return playlistID, entryID, echo.NewHTTPError(http.StatusBadRequest, "Invalid entry ID")
+	}
+	return playlistID, entryID, nil
+}
+
+func entryIDsOf(tracks []models.PlaylistTrack) []gocql.UUID {
+	ids := make([]gocql.UUID, 0, len(tracks))
+	for _, track := range tracks {
+		ids = append(ids, track.EntryID)
+	}
+	return ids
 }
 
 func RemovePlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
@@ -145,10 +460,21 @@ func RemovePlaylistHandler(scyllaService database.ScyllaService) echo.HandlerFun
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
 		}
 
+		playlist, err := scyllaService.GetPlaylist(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+
 		err = scyllaService.RemovePlaylist(playlistUUID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove playlist")
 		}
+		audit.Record(c, scyllaService, audit.Entry{
+			Action: models.ActionRemovePlaylist, TargetType: models.TargetPlaylist, TargetID: playlistID, Before: playlist,
+		})
 
 		return c.JSON(http.StatusOK, echo.Map{
 			"message": "Playlist removed successfully",
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"rr-backend/internal/playlistfile"
+	"rr-backend/internal/playlists"
+	"rr-backend/internal/search"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxImportSize = 1 << 20
+	// maxImportTracks keeps matching an import well inside the write
+	// timeout.
+	maxImportTracks = 250
+)
+
+// ExportPlaylistHandler writes a playlist as an M3U8, XSPF or JSPF file,
+// chosen with ?format= (m3u8 by default). Tracks point at our stream URLs.
+func ExportPlaylistHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		format := playlistfile.M3U8
+		if raw := c.QueryParam("format"); raw != "" {
+			var ok bool
+			if format, ok = playlistfile.ParseFormat(raw); !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, "Format must be m3u8, xspf or jspf")
+			}
+		}
+
+		playlist, err := dbService.GetPlaylist(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+		tracks, err := playlists.Tracks(dbService, playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist songs")
+		}
+
+		baseURL := c.Scheme() + "://" + c.Request().Host
+		artists := playlistfile.NewArtistNames(dbService)
+		file := playlistfile.File{Title: playlist.Name, Description: playlist.Description}
+		for _, track := range tracks {
+			if track.Song == nil || track.Song.Status != "" {
+				continue
+			}
+			artist, err := artists.Name(track.Song.UserID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist")
+			}
+			file.Entries = append(file.Entries, playlistfile.Entry{
+				Title:    track.Song.Title,
+				Artist:   artist,
+				Album:    track.Song.Album,
+				Duration: track.Song.Duration,
+				Location: playlistfile.StreamURL(baseURL, track.Song.SongID),
+			})
+		}
+
+		var buf bytes.Buffer
+		if err := playlistfile.Write(&buf, format, file); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export playlist")
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			mime.FormatMediaType("attachment", map[string]string{"filename": exportFilename(playlist.Name) + format.Ext()}))
+		return c.Blob(http.StatusOK, format.ContentType(), buf.Bytes())
+	}
+}
+
+// exportFilename makes a playlist name safe to use as a file name.
+func exportFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return -1
+		}
+		return r
+	}, name)
+	if name = strings.TrimSpace(name); name == "" {
+		return "playlist"
+	}
+	return name
+}
+
+// UnmatchedEntry is an imported entry no song was found for; Index is its
+// place in the file.
+type UnmatchedEntry struct {
+	Index int `json:"index"`
+	playlistfile.Entry
+}
+
+// ImportPlaylistHandler creates a playlist from an uploaded M3U8, XSPF or
+// JSPF file, sent as the multipart field "file". The format is taken from
+// the "format" field, the file name or the content. Entries are matched
+// against the catalogue by title, artist, album and duration; the ones
+// nothing matched are reported instead of added.
+//
+// The optional fields "name" and "visibility" override the title in the
+// file and the public default.
+func ImportPlaylistHandler(dbService database.ScyllaService, searchIndex *search.Index) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Playlist file is required")
+		}
+		if fh.Size > maxImportSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Playlist file is too large")
+		}
+		src, err := fh.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read playlist file")
+		}
+		data, err := io.ReadAll(io.LimitReader(src, maxImportSize+1))
+		src.Close()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read playlist file")
+		}
+		if len(data) > maxImportSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Playlist file is too large")
+		}
+
+		format := playlistfile.Detect(fh.Filename, data)
+		if raw := c.FormValue("format"); raw != "" {
+			var ok bool
+			if format, ok = playlistfile.ParseFormat(raw); !ok {
+				return echo.NewHTTPError(http.StatusBadRequest, "Format must be m3u8, xspf or jspf")
+			}
+		}
+		file, err := playlistfile.Read(bytes.NewReader(data), format)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to parse playlist file")
+		}
+		if len(file.Entries) > maxImportTracks {
+			return echo.NewHTTPError(http.StatusBadRequest, "Playlist file has too many tracks")
+		}
+
+		playlist := models.Playlist{
+			PlaylistID:  gocql.TimeUUID(),
+			UserID:      userID,
+			Name:        firstNonEmpty(strings.TrimSpace(c.FormValue("name")), file.Title, strings.TrimSuffix(path.Base(fh.Filename), path.Ext(fh.Filename)), "Imported playlist"),
+			Description: file.Description,
+			Visibility:  firstNonEmpty(c.FormValue("visibility"), models.PlaylistPublic),
+		}
+		if !models.ValidVisibility(playlist.Visibility) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Visibility must be public, private or unlisted")
+		}
+
+		matches, err := playlistfile.NewMatcher(dbService, searchIndex).MatchAll(file.Entries)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to match playlist tracks")
+		}
+		var songs []*models.Song
+		unmatched := []UnmatchedEntry{}
+		for i, song := range matches {
+			if song == nil || song.Status != "" {
+				unmatched = append(unmatched, UnmatchedEntry{Index: i, Entry: file.Entries[i]})
+				continue
+			}
+			songs = append(songs, song)
+		}
+
+		// The playlist is only created once everything is matched, and
+		// removed again if its tracks cannot be written.
+		err = dbService.AddPlaylist(playlist.PlaylistID, playlist.UserID, playlist.Name, playlist.Description, playlist.Visibility)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add playlist")
+		}
+		now := time.Now()
+		tracks := make([]models.PlaylistTrack, len(songs))
+		for i, song := range songs {
+			songUUID, _ := gocql.ParseUUID(song.SongID)
+			tracks[i] = models.PlaylistTrack{
+				EntryID: gocql.UUIDFromTime(now),
+				SongID:  songUUID,
+				AddedAt: now,
+				AddedBy: userID,
+			}
+		}
+		if err := playlists.Append(dbService, playlist.PlaylistID, tracks); err != nil {
+			if err := dbService.RemovePlaylist(playlist.PlaylistID); err != nil {
+				log.Printf("Failed to remove half-imported playlist %s: %v", playlist.PlaylistID, err)
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add song to playlist")
+		}
+
+		return c.JSON(http.StatusCreated, echo.Map{
+			"playlist":  playlist,
+			"matched":   len(songs),
+			"unmatched": unmatched,
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// maxPlaylistMembers bounds members and pending invitations per playlist;
+// every authorization check on the playlist reads all of them.
+const maxPlaylistMembers = 50
+
+// GetPlaylistMembersHandler lists the members of a playlist and the
+// invitations still pending. The route lets only the owner and members in.
+func GetPlaylistMembersHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+
+		members, err := dbService.GetPlaylistMembers(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist members")
+		}
+		if members == nil {
+			members = []models.PlaylistMember{}
+		}
+		return c.JSON(http.StatusOK, members)
+	}
+}
+
+// InvitePlaylistMemberHandler invites a user as an editor or viewer from
+// {"user_id": ..., "role": ...}. Inviting an existing member changes their
+// role and keeps their membership.
+func InvitePlaylistMemberHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		var body struct {
+			UserID string `json:"user_id"`
+			Role   string `json:"role"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to bind invitation")
+		}
+		if body.Role == "" {
+			body.Role = models.PlaylistEditor
+		}
+		if body.Role != models.PlaylistEditor && body.Role != models.PlaylistViewer {
+			return echo.NewHTTPError(http.StatusBadRequest, "Role must be editor or viewer")
+		}
+
+		playlist, err := dbService.GetPlaylist(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+		if body.UserID == playlist.UserID {
+			return echo.NewHTTPError(http.StatusBadRequest, "The owner cannot be invited")
+		}
+		invitee, err := dbService.GetUserByID(body.UserID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+		}
+		if invitee == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+
+		members, err := dbService.GetPlaylistMembers(playlistUUID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist members")
+		}
+		isMember := false
+		for _, existing := range members {
+			isMember = isMember || existing.UserID == body.UserID
+		}
+		if !isMember {
+			if len(members) >= maxPlaylistMembers {
+				return echo.NewHTTPError(http.StatusConflict, "Playlist has too many members")
+			}
+			member := models.PlaylistMember{
+				PlaylistID: playlistUUID,
+				UserID:     body.UserID,
+				Role:       body.Role,
+				Status:     models.MemberInvited,
+				InvitedBy:  c.Get("userID").(string),
+				InvitedAt:  time.Now(),
+			}
+			invited, err := dbService.InvitePlaylistMember(member)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to invite member")
+			}
+			if invited {
+				return c.JSON(http.StatusCreated, member)
+			}
+			// Invited concurrently; change the role of that invitation.
+		}
+
+		// Only the role changes, so an invitation accepted meanwhile stays
+		// accepted.
+		updated, err := dbService.SetPlaylistMemberRole(playlistUUID, body.UserID, body.Role)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to invite member")
+		}
+		if !updated {
+			return echo.NewHTTPError(http.StatusConflict, "Membership was revoked concurrently")
+		}
+		member, err := dbService.GetPlaylistMember(playlistUUID, body.UserID)
+		if err != nil || member == nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist member")
+		}
+		return c.JSON(http.StatusOK, member)
+	}
+}
+
+// GetPlaylistInvitationsHandler lists the signed-in user's pending
+// invitations, each with its playlist.
+func GetPlaylistInvitationsHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+		invitations, next, err := dbService.GetPlaylistInvitations(c.Get("userID").(string), limit, pageState)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist invitations")
+		}
+		return c.JSON(http.StatusOK, newPage(invitations, next))
+	}
+}
+
+// AcceptPlaylistInviteHandler lets the signed-in user accept their pending
+// invitation to a playlist.
+func AcceptPlaylistInviteHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		userID := c.Get("userID").(string)
+
+		accepted, err := dbService.AcceptPlaylistInvite(playlistUUID, userID, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to accept invitation")
+		}
+		if !accepted {
+			return echo.NewHTTPError(http.StatusNotFound, "No pending invitation")
+		}
+
+		member, err := dbService.GetPlaylistMember(playlistUUID, userID)
+		if err != nil || member == nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist member")
+		}
+		return c.JSON(http.StatusOK, member)
+	}
+}
+
+// LeavePlaylistHandler lets the signed-in user decline an invitation or
+// leave a playlist shared with them.
+func LeavePlaylistHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return removeMember(c, dbService, c.Get("userID").(string))
+	}
+}
+
+// RevokePlaylistMemberHandler removes a member or withdraws an invitation.
+func RevokePlaylistMemberHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return removeMember(c, dbService, c.Param("user_id"))
+	}
+}
+
+func removeMember(c echo.Context, dbService database.ScyllaService, userID string) error {
+	playlistUUID, err := gocql.ParseUUID(c.Param("playlist_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+	}
+
+	member, err := dbService.GetPlaylistMember(playlistUUID, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist member")
+	}
+	if member == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Not a member of this playlist")
+	}
+	if err := dbService.RemovePlaylistMember(playlistUUID, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove member")
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message": "Member removed successfully",
+	})
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/database"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// Two-phase uploads: the client asks for presigned POST forms, sends the files
+// straight to MinIO and then reports the upload complete. The backend reads
+// the few bytes it needs to validate the song and streams it once to hash it,
+// but never stores it locally.
+
+const presignedUploadExpiry = time.Hour
+
+type presignedFile struct {
+	FileName string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+type presignedUploadRequest struct {
+	Song        presignedFile  `json:"song"`
+	Thumbnail   *presignedFile `json:"thumbnail"`
+	Title       string         `json:"title"`
+	Album       string         `json:"album"`
+	Genre       string         `json:"genre"`
+	ReleaseDate string         `json:"releaseDate"`
+}
+
+// presignedTarget is a form upload: the client POSTs Fields and then the
+// file, as multipart/form-data, to URL. The form only accepts a file of the
+// size the client announced.
+type presignedTarget struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func CreateUploadHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+
+		limits, err := uploadLimits(c, dbService)
+		if err != nil {
+			return err
+		}
+
+		var req presignedUploadRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		var fieldErrors []*media.FieldError
+		if fe := media.CheckSize("song", req.Song.Size, limits.Audio); fe != nil {
+			fieldErrors = append(fieldErrors, fe)
+		}
+		if req.Thumbnail != nil {
+			if fe := media.CheckSize("thumbnail", req.Thumbnail.Size, limits.Image); fe != nil {
+				fieldErrors = append(fieldErrors, fe)
+			}
+		}
+		if len(fieldErrors) > 0 {
+			return rejectUpload(c, fieldErrors)
+		}
+
+		uploadID := gocql.TimeUUID()
+		upload := models.Upload{
+			UploadID:   uploadID,
+			UserID:     userID,
+			ObjectName: media.StagingObjectName(uploadID.String()),
+			Length:     req.Song.Size,
+			Metadata: map[string]string{
+				"filename":    req.Song.FileName,
+				"title":       req.Title,
+				"album":       req.Album,
+				"genre":       req.Genre,
+				"releaseDate": req.ReleaseDate,
+			},
+			CreatedAt: time.Now(),
+		}
+		if req.Thumbnail != nil {
+			upload.ThumbnailObject = upload.ObjectName + ".thumbnail"
+		}
+
+		ctx := c.Request().Context()
+		songURL, songFields, err := minioService.PresignedPostObject(ctx, "music", upload.ObjectName, req.Song.Size, presignedUploadExpiry)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create upload URL")
+		}
+		resp := echo.Map{
+			"upload_id":  uploadID.String(),
+			"expires_at": upload.CreatedAt.Add(presignedUploadExpiry).UTC(),
+			"song":       presignedTarget{Method: http.MethodPost, URL: songURL.String(), Fields: songFields},
+		}
+		if upload.ThumbnailObject != "" {
+			thumbnailURL, thumbnailFields, err := minioService.PresignedPostObject(ctx, "music", upload.ThumbnailObject, req.Thumbnail.Size, presignedUploadExpiry)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create upload URL")
+			}
+			resp["thumbnail"] = presignedTarget{Method: http.MethodPost, URL: thumbnailURL.String(), Fields: thumbnailFields}
+		}
+
+		if err := dbService.CreateUpload(upload); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start upload")
+		}
+		return c.JSON(http.StatusCreated, resp)
+	}
+}
+
+func CompleteUploadHandler(dbService database.ScyllaService, minioService database.MinIOService, jobQueue *jobs.Queue) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		upload, err := loadUpload(c, dbService, false)
+		if err != nil {
+			return err
+		}
+		if upload.SongID != (gocql.UUID{}) {
+			return c.JSON(http.StatusOK, echo.Map{
+				"message": "Music uploaded successfully",
+				"song_id": upload.SongID.String(),
+			})
+		}
+
+		if err := claimUploadFinish(dbService, upload); err != nil {
+			return err
+		}
+		// Hand the upload back on failure; a rejected one is discarded instead.
+		claimed := true
+		defer func() {
+			if claimed {
+				releaseUploadFinish(dbService, upload)
+			}
+		}()
+
+		limits, err := uploadLimits(c, dbService)
+		if err != nil {
+			return err
+		}
+
+		// The client said how large the files would be; hold it to that.
+		ctx := c.Request().Context()
+		songInfo, err := minioService.StatObject(ctx, "music", upload.ObjectName)
+		if database.IsObjectNotFound(err) {
+			return echo.NewHTTPError(http.StatusConflict, "Song file has not been uploaded yet")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check uploaded song")
+		}
+		var fieldErrors []*media.FieldError
+		if songInfo.Size != upload.Length {
+			fieldErrors = append(fieldErrors, &media.FieldError{Field: "song", Code: "size_mismatch", Message: "Uploaded song does not match the announced size", Status: http.StatusUnprocessableEntity})
+		}
+		songFormat, fe := media.ValidateAudio("song", media.ObjectReaderAt(ctx, minioService, "music", upload.ObjectName, songInfo.Size), songInfo.Size, limits.Audio)
+		if fe != nil {
+			fieldErrors = append(fieldErrors, fe)
+		}
+
+		song := newSong{
+			userID:           upload.UserID,
+			fields:           upload.Metadata,
+			fileName:         upload.Metadata["filename"],
+			audioFormat:      songFormat,
+			stagedAudio:      upload.ObjectName,
+			stagedAudioSize:  songInfo.Size,
+			requireThumbnail: true,
+			imageLimit:       limits.Image,
+		}
+		if upload.ThumbnailObject != "" {
+			thumbnail, format, fe, err := stagedThumbnail(c, minioService, upload, limits.Image)
+			if err != nil {
+				return err
+			}
+			if fe != nil {
+				fieldErrors = append(fieldErrors, fe)
+			} else {
+				song.thumbnail = bytes.NewReader(thumbnail)
+				song.thumbnailSize = int64(len(thumbnail))
+				song.thumbnailFormat = format
+			}
+		}
+		if len(fieldErrors) > 0 {
+			claimed = false
+			discardPresignedUpload(c, dbService, minioService, upload)
+			return rejectUpload(c, fieldErrors)
+		}
+
+		songID, fieldErrors, err := storeSong(ctx, dbService, minioService, jobQueue, song)
+		if err != nil {
+			return err
+		}
+		if len(fieldErrors) > 0 {
+			claimed = false
+			discardPresignedUpload(c, dbService, minioService, upload)
+			return rejectUpload(c, fieldErrors)
+		}
+
+		if err := completeUpload(ctx, dbService, minioService, upload, songID); err != nil {
+			return err
+		}
+		claimed = false
+		cleanup.RemoveObjects(ctx, dbService, minioService, "music", "staging of upload "+upload.UploadID.String(), upload.ObjectName, upload.ThumbnailObject)
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Music uploaded successfully",
+			"song_id": songID.String(),
+		})
+	}
+}
+
+// stagedThumbnail reads and validates the thumbnail the client uploaded.
+// Thumbnails are small enough to hold in memory.
+func stagedThumbnail(c echo.Context, minioService database.MinIOService, upload *models.Upload, limit int64) ([]byte, media.Format, *media.FieldError, error) {
+	ctx := c.Request().Context()
+	info, err := minioService.StatObject(ctx, "music", upload.ThumbnailObject)
+	if database.IsObjectNotFound(err) {
+		return nil, media.Format{}, nil, echo.NewHTTPError(http.StatusConflict, "Thumbnail file has not been uploaded yet")
+	} else if err != nil {
+		return nil, media.Format{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check uploaded thumbnail")
+	}
+	if fe := media.CheckSize("thumbnail", info.Size, limit); fe != nil {
+		return nil, media.Format{}, fe, nil
+	}
+
+	body, err := minioService.GetObjectRange(ctx, "music", upload.ThumbnailObject, 0, info.Size-1)
+	if err != nil {
+		return nil, media.Format{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read uploaded thumbnail")
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, media.Format{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read uploaded thumbnail")
+	}
+
+	format, fe := media.ValidateImage("thumbnail", bytes.NewReader(data), int64(len(data)), limit)
+	return data, format, fe, nil
+}
+
+// discardPresignedUpload removes the staged files of a rejected upload so the
+// client has to start over with a fresh one.
+func discardPresignedUpload(c echo.Context, dbService database.ScyllaService, minioService database.MinIOService, upload *models.Upload) {
+	cleanup.RemoveObjects(c.Request().Context(), dbService, minioService, "music", "rejected upload "+upload.UploadID.String(), upload.ObjectName, upload.ThumbnailObject)
+	if err := dbService.RemoveUpload(upload.UploadID); err != nil {
+		log.Printf("Failed to remove upload %s: %v", upload.UploadID, err)
+	}
+}
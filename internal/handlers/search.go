@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"rr-backend/internal/search"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxSearchResults = 50
+	// maxSearchOffset stops clients from paging arbitrarily deep, which
+	// gets slower with every page.
+	maxSearchOffset    = 1000
+	defaultSuggestions = 8
+)
+
+// searchPage is the page envelope with the total match count and facet
+// counts of the whole search.
+type searchPage struct {
+	page
+	Total  uint64                    `json:"total"`
+	Facets map[string][]search.Facet `json:"facets"`
+}
+
+// SearchHandler searches songs, artists and playlists.
+//
+// Query parameters: q, limit, cursor, and the optional filters type
+// (song, artist or playlist), genre and year. The response carries facet
+// counts for genre and year alongside the hits.
+func SearchHandler(searchIndex *search.Index, dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		req := search.Request{
+			Query: c.QueryParam("q"),
+			Type:  c.QueryParam("type"),
+			Genre: c.QueryParam("genre"),
+			Limit: min(limit, maxSearchResults),
+		}
+		// Search cursors carry an offset into the ranked results instead of
+		// a driver paging state; the index, unlike Scylla, can skip cheaply.
+		if pageState != nil {
+			offset, err := strconv.Atoi(string(pageState))
+			if err != nil || offset < 0 || offset > maxSearchOffset {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+			}
+			req.Offset = offset
+		}
+		switch req.Type {
+		case "", search.TypeSong, search.TypeArtist, search.TypePlaylist:
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid type")
+		}
+		if s := c.QueryParam("year"); s != "" {
+			year, err := strconv.Atoi(s)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid year")
+			}
+			req.Year = year
+		}
+
+		results, err := searchIndex.Search(req)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to search")
+		}
+
+		// The index only knows song IDs; load the songs themselves and drop
+		// any that went away or became unavailable since they were indexed.
+		var songIDs []gocql.UUID
+		for _, hit := range results.Hits {
+			if hit.Type != search.TypeSong {
+				continue
+			}
+			if songID, err := gocql.ParseUUID(hit.ID); err == nil {
+				songIDs = append(songIDs, songID)
+			}
+		}
+		songs, err := dbService.GetSongsByIDs(songIDs)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to search")
+		}
+		byID := make(map[string]*models.Song, len(songs))
+		for i := range songs {
+			byID[songs[i].SongID] = &songs[i]
+		}
+		hits := results.Hits[:0]
+		for _, hit := range results.Hits {
+			if hit.Type == search.TypeSong {
+				song := byID[hit.ID]
+				if song == nil || song.Status != "" {
+					continue
+				}
+				hit.Song = song
+			}
+			hits = append(hits, hit)
+		}
+
+		var next []byte
+		if results.More && req.Offset+req.Limit <= maxSearchOffset {
+			next = []byte(strconv.Itoa(req.Offset + req.Limit))
+		}
+		return c.JSON(http.StatusOK, searchPage{page: newPage(hits, next), Total: results.Total, Facets: results.Facets})
+	}
+}
+
+// SuggestHandler completes what the user has typed so far into songs,
+// artists, albums and genres. It never touches the database.
+func SuggestHandler(suggester *search.Suggester) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit := defaultSuggestions
+		if s := c.QueryParam("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid limit")
+			}
+			limit = min(n, search.MaxSuggestions)
+		}
+		return c.JSON(http.StatusOK, echo.Map{"suggestions": suggester.Suggest(c.QueryParam("q"), limit)})
+	}
+}
@@ -1,124 +1,50 @@
 package handlers
 
 import (
-	"fmt"
+	"log"
 	"net/http"
-	"strconv"
 	"time"
 
+	"rr-backend/internal/audit"
+	"rr-backend/internal/cleanup"
 	"rr-backend/internal/database"
-	"rr-backend/internal/helper"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+	"rr-backend/internal/streaming"
 
 	"github.com/gocql/gocql"
 	"github.com/labstack/echo/v4"
 )
 
-func UploadMusicHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// Verify the JWT and get the user ID
-		userID := c.Get("userID").(string)
-
-		// Read form fields
-		title := c.FormValue("title")
-		album := c.FormValue("album")
-		releaseDate := c.FormValue("releaseDate")
-		genre := c.FormValue("genre")
-
-		// Read form files
-		songFile, err := c.FormFile("song")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "Song file is required")
-		}
-		thumbnailFile, err := c.FormFile("thumbnail")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "Thumbnail file is required")
-		}
-
-		// Generate UUID for song
-		songID := gocql.TimeUUID()
-
-		// Upload song file to MinIO
-		songSrc, err := songFile.Open()
-		if err != nil {
-			return err
-		}
-		defer songSrc.Close()
-
-		songObjectName := fmt.Sprintf("songs/%s/%s", userID, songFile.Filename)
-		_, err = minioService.UploadObject("music", songObjectName, songSrc, songFile.Size, songFile.Header.Get("Content-Type"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload song")
-		}
-
-		// Upload thumbnail file to MinIO
-		thumbnailSrc, err := thumbnailFile.Open()
-		if err != nil {
-			return err
-		}
-		defer thumbnailSrc.Close()
-
-		thumbnailObjectName := fmt.Sprintf("thumbnails/%s/%s", userID, thumbnailFile.Filename)
-		_, err = minioService.UploadObject("music", thumbnailObjectName, thumbnailSrc, thumbnailFile.Size, thumbnailFile.Header.Get("Content-Type"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload thumbnail")
-		}
-
-		parsedReleaseDate, err := time.Parse("2006-01-02", releaseDate)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid release date format")
-		}
-
-		err = dbService.InsertSong(songID, title, userID, album, parsedReleaseDate, genre, songObjectName, thumbnailObjectName)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save song metadata")
-		}
-
-		return c.JSON(http.StatusOK, echo.Map{
-			"message": "Music uploaded successfully",
-		})
-	}
-}
-
+// RemoveSongHandler deletes a song and its objects. Only the artist who
+// uploaded it or an admin may; the route enforces that with
+// middleware.Authorize.
 func RemoveSongHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		songID := c.Param("song_id")
-		userID := c.Get("userID").(string)
-
-		objectName, err := dbService.GetObjectNameBySongID(songID)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
-		}
 
 		songUUID, err := gocql.ParseUUID(songID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
 		}
 
-		user, err := dbService.GetUserByID(userID)
+		// Collect the song's objects while the row still points at them
+		song, err := dbService.GetSongByID(songUUID)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
-		}
-		if user == nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
-		}
-
-		songOwnerID, err := dbService.GetSongUserID(songUUID)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song owner")
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
 		}
-		if user.Role != "admin" && userID != songOwnerID {
-			return echo.NewHTTPError(http.StatusForbidden, "Unauthorized to delete this song")
+		if song == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Song not found")
 		}
 
 		err = dbService.RemoveSong(songUUID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove song")
 		}
+		audit.Record(c, dbService, audit.Entry{Action: models.ActionRemoveSong, TargetType: models.TargetSong, TargetID: songID, Before: song})
 
-		err = minioService.RemoveObject("music", objectName)
-		if err != nil {
-			echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove song from storage")
-		}
+		// The song is gone either way; objects that cannot be removed now are retried later
+		cleanup.RemoveObjects(c.Request().Context(), dbService, minioService, "music", "removal of song "+songID, songObjects(song)...)
 
 		return c.JSON(http.StatusOK, echo.Map{
 			"message": "Song removed successfully",
@@ -126,89 +52,201 @@ func RemoveSongHandler(dbService database.ScyllaService, minioService database.M
 	}
 }
 
+// songObjects names everything a song keeps in storage.
+func songObjects(song *models.Song) []string {
+	objectNames := []string{song.SongURL, media.HLSPrefix(song.SongID)}
+	if song.ThumbnailURL != "" {
+		objectNames = append(objectNames, song.ThumbnailURL)
+		objectNames = append(objectNames, media.ThumbnailVariants(song.ThumbnailURL)...)
+	}
+	return objectNames
+}
+
 func GetSongsByUser(dbService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID := c.Get("userID").(string) // Get the userID from JWT middleware
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
 
-		songs, err := dbService.GetSongsByUserID(userID)
+		songs, next, err := dbService.GetSongsByUserID(userID, limit, pageState)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get songs")
 		}
 
-		return c.JSON(http.StatusOK, songs)
+		return c.JSON(http.StatusOK, newPage(availableSongs(songs), next))
 	}
 }
 
 func GetAllSongs(dbService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		songs, err := dbService.GetAllSongs()
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		songs, next, err := dbService.GetAllSongs(limit, pageState)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get songs")
 		}
 
-		return c.JSON(http.StatusOK, songs)
+		return c.JSON(http.StatusOK, newPage(availableSongs(songs), next))
 	}
 }
 
-func GetSongThumbnail(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+// availableSongs leaves out the songs that were taken down or whose audio
+// went missing. Pages may come back short; the cursor still points past the
+// songs left out.
+func availableSongs(songs []models.Song) []models.Song {
+	available := songs[:0]
+	for _, song := range songs {
+		if song.Status == "" {
+			available = append(available, song)
+		}
+	}
+	return available
+}
+
+const streamRedirectExpiry = 15 * time.Minute
+
+func StreamMusic(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		songID := c.Param("song_id")
-		thumbnailName, err := dbService.GetSongThumbnailBySongID(songID)
+		if err := checkStreamable(dbService, songID); err != nil {
+			return err
+		}
+		objectName, err := dbService.GetObjectNameBySongID(songID)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get thumbnail")
+			if err == gocql.ErrNotFound {
+				return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+		}
+
+		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+		err = streaming.Serve(c.Response(), c.Request(), minioService, "music", objectName)
+		if err == streaming.ErrNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "Song file not found in storage")
 		}
-		object, err := minioService.GetObject("music", thumbnailName)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get thumbnail from storage")
+			if c.Response().Committed {
+				log.Printf("Failed to stream song %s: %v", songID, err)
+				return nil
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song from storage")
 		}
-		defer object.Close()
-		c.Stream(http.StatusOK, "image/jpeg", object)
 		return nil
 	}
 }
 
-func StreamMusic(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+// RedirectMusic sends the client to a short-lived presigned MinIO URL for the
+// song, so the audio itself never passes through the backend.
+func RedirectMusic(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		songID := c.Param("song_id")
+		if err := checkStreamable(dbService, songID); err != nil {
+			return err
+		}
 		objectName, err := dbService.GetObjectNameBySongID(songID)
 		if err != nil {
+			if err == gocql.ErrNotFound {
+				return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+			}
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
 		}
 
-		object, err := minioService.GetObject("music", objectName)
+		url, err := minioService.PresignedGetObject(c.Request().Context(), "music", objectName, streamRedirectExpiry, nil)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song from storage")
 		}
-		defer object.Close()
-
-		helper.ServeContent(c.Response().Writer, c.Request(), objectName, time.Now(), object)
-		return nil
+		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+		// The URL expires, so neither the browser nor a proxy may reuse it.
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Redirect(http.StatusTemporaryRedirect, url.String())
 	}
 }
 
-func SearchSongs(dbService database.ScyllaService) echo.HandlerFunc {
+func GetSongManifest(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		searchQuery := c.QueryParam("q")
-		pageStr := c.QueryParam("page")
-		limitStr := c.QueryParam("limit")
+		manifest, err := songManifest(dbService, c.Param("song_id"))
+		if err != nil {
+			return err
+		}
+		return serveHLSObject(c, minioService, manifest)
+	}
+}
 
-		page, _ := strconv.Atoi(pageStr)
-		if page < 1 {
-			page = 1
+func StreamHLSFile(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		manifest, err := songManifest(dbService, c.Param("song_id"))
+		if err != nil {
+			return err
 		}
-		limit, _ := strconv.Atoi(limitStr)
-		if limit <= 0 {
-			limit = 10 // 10 items/page
+		objectName, ok := media.HLSObjectName(manifest, c.Param("variant"), c.Param("file"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "Unknown HLS file")
 		}
-		offset := (page - 1) * limit
+		return serveHLSObject(c, minioService, objectName)
+	}
+}
 
-		songs, err := dbService.SearchSongs(searchQuery, limit, offset)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to search songs")
+func songManifest(dbService database.ScyllaService, songID string) (string, error) {
+	if err := checkStreamable(dbService, songID); err != nil {
+		return "", err
+	}
+	manifest, err := dbService.GetSongManifestBySongID(songID)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return "", echo.NewHTTPError(http.StatusNotFound, "Song not found")
 		}
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+	}
+	if manifest == "" {
+		return "", echo.NewHTTPError(http.StatusNotFound, "HLS stream is not ready yet")
+	}
+	return manifest, nil
+}
 
-		return c.JSON(http.StatusOK, songs)
+// checkStreamable keeps songs a moderator took down, and songs whose audio
+// went missing from storage, from being streamed.
+func checkStreamable(dbService database.ScyllaService, songID string) error {
+	if _, err := gocql.ParseUUID(songID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
 	}
+	status, err := dbService.GetSongStatus(songID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song")
+	}
+	return songStatusError(status)
+}
+
+// songStatusError answers a request for a song that is not available, or
+// returns nil if it is.
+func songStatusError(status string) error {
+	switch status {
+	case models.SongStatusTakenDown:
+		return echo.NewHTTPError(http.StatusGone, "Song has been taken down")
+	case models.SongStatusUnavailable:
+		return echo.NewHTTPError(http.StatusNotFound, "Song is unavailable")
+	}
+	return nil
+}
+
+func serveHLSObject(c echo.Context, minioService database.MinIOService, objectName string) error {
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	err := streaming.Serve(c.Response(), c.Request(), minioService, "music", objectName)
+	if err == streaming.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "HLS file not found in storage")
+	}
+	if err != nil {
+		if c.Response().Committed {
+			log.Printf("Failed to stream %s: %v", objectName, err)
+			return nil
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get HLS file from storage")
+	}
+	return nil
 }
 
 func LikeSongHandler(dbService database.ScyllaService) echo.HandlerFunc {
@@ -256,11 +294,16 @@ func UnlikeSongHandler(dbService database.ScyllaService) echo.HandlerFunc {
 func GetLikedSongsHandler(dbService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID := c.Get("userID").(string)
-		likedSongs, err := dbService.GetLikedSongsByUser(userID)
+		limit, pageState, err := pageParams(c)
+		if err != nil {
+			return err
+		}
+
+		likedSongs, next, err := dbService.GetLikedSongsByUser(userID, limit, pageState)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get liked songs")
 		}
 
-		return c.JSON(http.StatusOK, likedSongs)
+		return c.JSON(http.StatusOK, newPage(availableSongs(likedSongs), next))
 	}
 }
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+	"rr-backend/internal/streaming"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// Thumbnail objects are named after their content, so a response only goes
+// stale when the song gets new artwork; a week keeps that window acceptable.
+const thumbnailCacheControl = "public, max-age=604800, stale-while-revalidate=86400"
+
+// thumbnailFallbackCacheControl applies when the original stands in for a
+// derivative that has not been generated yet, so clients ask again soon.
+const thumbnailFallbackCacheControl = "public, max-age=60"
+
+// GetSongThumbnail serves the song's artwork. With ?size= it serves the
+// closest derivative, as WebP when the client accepts it and JPEG otherwise,
+// falling back to the original until the derivatives have been generated.
+func GetSongThumbnail(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		songID := c.Param("song_id")
+		thumbnailName, err := dbService.GetSongThumbnailBySongID(songID)
+		if err != nil {
+			if err == gocql.ErrNotFound {
+				return echo.NewHTTPError(http.StatusNotFound, "Song not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get thumbnail")
+		}
+		if thumbnailName == "" {
+			// Resumable uploads may create songs without artwork
+			return echo.NewHTTPError(http.StatusNotFound, "Song has no thumbnail")
+		}
+
+		objectNames := []string{thumbnailName}
+		if sizeParam := c.QueryParam("size"); sizeParam != "" {
+			size, err := strconv.Atoi(sizeParam)
+			if err != nil || size <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid size")
+			}
+			format := media.FormatJPEG
+			if prefersWebP(c.Request().Header.Get("Accept")) {
+				format = media.FormatWebP
+			}
+			variant := media.ThumbnailVariantName(thumbnailName, media.ThumbnailSizeFor(size), format)
+			objectNames = []string{variant, thumbnailName}
+		}
+
+		h := c.Response().Header()
+		h.Set("Cache-Control", thumbnailCacheControl)
+		h.Set("Vary", "Accept")
+		for i, objectName := range objectNames {
+			if i > 0 {
+				h.Set("Cache-Control", thumbnailFallbackCacheControl)
+			}
+			err = streaming.Serve(c.Response(), c.Request(), minioService, "music", objectName)
+			if err != streaming.ErrNotFound {
+				break
+			}
+		}
+		if err == streaming.ErrNotFound {
+			h.Del("Cache-Control")
+			return echo.NewHTTPError(http.StatusNotFound, "Thumbnail not found in storage")
+		}
+		if err != nil {
+			if c.Response().Committed {
+				log.Printf("Failed to serve thumbnail of %s: %v", songID, err)
+				return nil
+			}
+			h.Del("Cache-Control")
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get thumbnail from storage")
+		}
+		return nil
+	}
+}
+
+// prefersWebP reports whether an Accept header ranks image/webp at least as
+// high as JPEG. Wildcards alone do not count, since clients that send only
+// */* cannot be assumed to decode WebP.
+func prefersWebP(accept string) bool {
+	webp, jpeg := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, "q") {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "image/webp":
+			webp = q
+		case "image/jpeg", "image/*", "*/*":
+			if q > jpeg {
+				jpeg = q
+			}
+		}
+	}
+	return webp > 0 && webp >= jpeg
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/database"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// Resumable uploads following the tus 1.0 protocol (core, creation and
+// termination extensions). Chunks are written straight into a MinIO multipart
+// upload; chunks smaller than MinIO's minimum part size are buffered in a
+// pending object until enough bytes have arrived.
+
+const (
+	tusVersion     = "1.0.0"
+	tusMinPartSize = 5 << 20
+	tusContentType = "application/offset+octet-stream"
+)
+
+var tusMetadataKeys = []string{"filename", "title", "album", "genre", "releaseDate"}
+
+func TusOptionsHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h := c.Response().Header()
+		h.Set("Tus-Resumable", tusVersion)
+		h.Set("Tus-Version", tusVersion)
+		h.Set("Tus-Extension", "creation,termination")
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func TusCreateHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := checkTusResumable(c); err != nil {
+			return err
+		}
+		userID := c.Get("userID").(string)
+
+		limits, err := uploadLimits(c, dbService)
+		if err != nil {
+			return err
+		}
+
+		length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Upload-Length header is required")
+		}
+		if length == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Upload is empty")
+		}
+		if length > limits.Audio {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Upload exceeds your size limit")
+		}
+
+		metadata, err := parseTusMetadata(c.Request().Header.Get("Upload-Metadata"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid Upload-Metadata header")
+		}
+
+		uploadID := gocql.TimeUUID()
+		objectName := media.StagingObjectName(uploadID.String())
+		multipartID, err := minioService.NewMultipartUpload(c.Request().Context(), "music", objectName, "application/octet-stream")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start upload")
+		}
+
+		err = dbService.CreateUpload(models.Upload{
+			UploadID:    uploadID,
+			UserID:      userID,
+			ObjectName:  objectName,
+			MultipartID: multipartID,
+			Length:      length,
+			Metadata:    metadata,
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			minioService.AbortMultipartUpload(c.Request().Context(), "music", objectName, multipartID)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start upload")
+		}
+
+		c.Response().Header().Set("Location", "/music/tus/"+uploadID.String())
+		return c.NoContent(http.StatusCreated)
+	}
+}
+
+func TusHeadHandler(dbService database.ScyllaService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		upload, err := loadUpload(c, dbService, true)
+		if err != nil {
+			return err
+		}
+
+		h := c.Response().Header()
+		h.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		h.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		h.Set("Cache-Control", "no-store")
+		if upload.SongID != (gocql.UUID{}) {
+			h.Set("X-Song-ID", upload.SongID.String())
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func TusPatchHandler(dbService database.ScyllaService, minioService database.MinIOService, jobQueue *jobs.Queue) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := checkTusResumable(c); err != nil {
+			return err
+		}
+		upload, err := loadUpload(c, dbService, true)
+		if err != nil {
+			return err
+		}
+
+		if c.Request().Header.Get("Content-Type") != tusContentType {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+		}
+		offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Upload-Offset header is required")
+		}
+		if offset != upload.Offset {
+			return echo.NewHTTPError(http.StatusConflict, "Upload-Offset does not match the current offset")
+		}
+		if upload.Offset == upload.Length {
+			// Every byte is already here; only the finalize step may be left.
+			return finishTusUpload(c, dbService, minioService, jobQueue, upload)
+		}
+
+		// Claim a part number before writing anything. Numbers are never
+		// handed out twice, so the part or pending object this request writes
+		// is its own even if a concurrent request at the same offset wins.
+		partNumber, claimed, err := dbService.ClaimUploadPart(*upload)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record upload progress")
+		}
+		if !claimed {
+			return echo.NewHTTPError(http.StatusConflict, "Upload was modified by another request")
+		}
+
+		ctx := c.Request().Context()
+		chunk, err := os.CreateTemp("", "tus-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(chunk.Name())
+		defer chunk.Close()
+
+		// Prepend whatever is still buffered from earlier small chunks.
+		pendingName := pendingObject(upload)
+		if upload.PendingSize > 0 {
+			if err := media.DownloadObject(ctx, minioService, "music", pendingName, chunk.Name()); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to resume upload")
+			}
+			if _, err := chunk.Seek(0, io.SeekEnd); err != nil {
+				return err
+			}
+		}
+
+		// A broken connection still keeps whatever bytes made it through.
+		received, copyErr := io.Copy(chunk, io.LimitReader(c.Request().Body, upload.Length-offset))
+		if received == 0 && copyErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read upload chunk")
+		}
+		if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		advanced := *upload
+		advanced.Parts = map[int]string{}
+		for n, etag := range upload.Parts {
+			advanced.Parts[n] = etag
+		}
+		total := upload.PendingSize + received
+		advanced.Offset = offset + received
+		written := ""
+		switch {
+		case total >= tusMinPartSize || (advanced.Offset == upload.Length && total > 0):
+			etag, err := minioService.PutObjectPart(ctx, "music", upload.ObjectName, upload.MultipartID, partNumber, chunk, total)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store upload chunk")
+			}
+			advanced.Parts[partNumber] = etag
+			advanced.PendingObject, advanced.PendingSize = "", 0
+		case received > 0:
+			written = upload.ObjectName + ".pending-" + strconv.Itoa(partNumber)
+			if _, err := minioService.UploadObject("music", written, chunk, total, "application/octet-stream"); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store upload chunk")
+			}
+			advanced.PendingObject, advanced.PendingSize = written, total
+		}
+
+		// Only the request whose progress is recorded keeps what it wrote. A
+		// part that is never recorded is dropped when the upload is completed
+		// or aborted; a pending object has to be removed.
+		applied, err := dbService.AdvanceUpload(advanced, offset)
+		if err != nil || !applied {
+			if written != "" {
+				cleanup.RemoveObjects(ctx, dbService, minioService, "music", "unrecorded chunk of upload "+upload.UploadID.String(), written)
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record upload progress")
+			}
+			return echo.NewHTTPError(http.StatusConflict, "Upload was modified by another request")
+		}
+		if upload.PendingSize > 0 && pendingName != advanced.PendingObject {
+			cleanup.RemoveObjects(ctx, dbService, minioService, "music", "flushed chunk of upload "+upload.UploadID.String(), pendingName)
+		}
+
+		upload = &advanced
+		if upload.Offset == upload.Length {
+			return finishTusUpload(c, dbService, minioService, jobQueue, upload)
+		}
+
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func TusDeleteHandler(dbService database.ScyllaService, minioService database.MinIOService) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := checkTusResumable(c); err != nil {
+			return err
+		}
+		upload, err := loadUpload(c, dbService, true)
+		if err != nil {
+			return err
+		}
+		if upload.SongID != (gocql.UUID{}) {
+			return echo.NewHTTPError(http.StatusConflict, "Upload is already finished")
+		}
+
+		discardTusUpload(c, dbService, minioService, upload)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// finishTusUpload assembles the multipart upload, validates it like a regular
+// upload and creates the song row. Only one request at a time gets to; after
+// a failure it is safe to call again.
+func finishTusUpload(c echo.Context, dbService database.ScyllaService, minioService database.MinIOService, jobQueue *jobs.Queue, upload *models.Upload) error {
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.SongID != (gocql.UUID{}) {
+		h.Set("X-Song-ID", upload.SongID.String())
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err := claimUploadFinish(dbService, upload); err != nil {
+		return err
+	}
+	// Hand the upload back on failure; a rejected one is discarded instead.
+	claimed := true
+	defer func() {
+		if claimed {
+			releaseUploadFinish(dbService, upload)
+		}
+	}()
+
+	ctx := c.Request().Context()
+	info, err := minioService.StatObject(ctx, "music", upload.ObjectName)
+	if database.IsObjectNotFound(err) {
+		if err := minioService.CompleteMultipartUpload(ctx, "music", upload.ObjectName, upload.MultipartID, upload.Parts); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assemble upload")
+		}
+		info, err = minioService.StatObject(ctx, "music", upload.ObjectName)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assemble upload")
+	}
+
+	limits, err := uploadLimits(c, dbService)
+	if err != nil {
+		return err
+	}
+	format, fe := media.ValidateAudio("song", media.ObjectReaderAt(ctx, minioService, "music", upload.ObjectName, info.Size), info.Size, limits.Audio)
+	if fe != nil {
+		claimed = false
+		discardTusUpload(c, dbService, minioService, upload)
+		return rejectUpload(c, []*media.FieldError{fe})
+	}
+
+	songID, fieldErrors, err := storeSong(ctx, dbService, minioService, jobQueue, newSong{
+		userID:          upload.UserID,
+		fields:          upload.Metadata,
+		fileName:        upload.Metadata["filename"],
+		audioFormat:     format,
+		stagedAudio:     upload.ObjectName,
+		stagedAudioSize: info.Size,
+		imageLimit:      limits.Image,
+	})
+	if err != nil {
+		return err
+	}
+	if len(fieldErrors) > 0 {
+		claimed = false
+		discardTusUpload(c, dbService, minioService, upload)
+		return rejectUpload(c, fieldErrors)
+	}
+
+	if err := completeUpload(ctx, dbService, minioService, upload, songID); err != nil {
+		return err
+	}
+	claimed = false
+	cleanup.RemoveObjects(ctx, dbService, minioService, "music", "staging of upload "+upload.UploadID.String(), upload.ObjectName)
+
+	h.Set("X-Song-ID", songID.String())
+	return c.NoContent(http.StatusNoContent)
+}
+
+// discardTusUpload releases everything an unfinished upload holds in storage
+// and forgets about it.
+func discardTusUpload(c echo.Context, dbService database.ScyllaService, minioService database.MinIOService, upload *models.Upload) {
+	ctx := c.Request().Context()
+	if err := minioService.AbortMultipartUpload(ctx, "music", upload.ObjectName, upload.MultipartID); err != nil {
+		log.Printf("Failed to abort multipart upload %s: %v", upload.UploadID, err)
+	}
+	cleanup.RemoveObjects(ctx, dbService, minioService, "music", "discarded upload "+upload.UploadID.String(), upload.ObjectName, pendingObject(upload))
+	if err := dbService.RemoveUpload(upload.UploadID); err != nil {
+		log.Printf("Failed to remove upload %s: %v", upload.UploadID, err)
+	}
+}
+
+// pendingObject names the object buffering the chunks of upload that are too
+// small for a part. Uploads started before pending objects got their own name
+// per chunk all used the same one.
+func pendingObject(upload *models.Upload) string {
+	if upload.PendingObject != "" {
+		return upload.PendingObject
+	}
+	return upload.ObjectName + ".pending"
+}
+
+// loadUpload returns the upload named in the URL if it belongs to the
+// signed-in user. Uploads of other users, and presigned uploads asked for
+// through tus (or the other way round), are reported as missing.
+func loadUpload(c echo.Context, dbService database.ScyllaService, tus bool) (*models.Upload, error) {
+	uploadID, err := gocql.ParseUUID(c.Param("upload_id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Upload not found")
+	}
+	upload, err := dbService.GetUpload(uploadID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get upload")
+	}
+	if upload == nil || upload.UserID != c.Get("userID").(string) || (upload.MultipartID != "") != tus {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Upload not found")
+	}
+	return upload, nil
+}
+
+func checkTusResumable(c echo.Context) error {
+	c.Response().Header().Set("Tus-Resumable", tusVersion)
+	if c.Request().Header.Get("Tus-Resumable") != tusVersion {
+		c.Response().Header().Set("Tus-Version", tusVersion)
+		return echo.NewHTTPError(http.StatusPreconditionFailed, "Unsupported tus version")
+	}
+	return nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated pairs
+// of a key and a base64 encoded value. Unknown keys are ignored.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		for _, known := range tusMetadataKeys {
+			if key == known {
+				metadata[key] = string(value)
+			}
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/database"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/media"
+	mdw "rr-backend/internal/middleware"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+func UploadMusicHandler(dbService database.ScyllaService, minioService database.MinIOService, jobQueue *jobs.Queue) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Verify the JWT and get the user ID
+		userID := c.Get("userID").(string)
+
+		limits, err := uploadLimits(c, dbService)
+		if err != nil {
+			return err
+		}
+
+		// Read and validate form files; the thumbnail may come from the song's embedded artwork instead
+		var fieldErrors []*media.FieldError
+		songFile, err := c.FormFile("song")
+		if err != nil {
+			fieldErrors = append(fieldErrors, &media.FieldError{Field: "song", Code: "required", Message: "Song file is required", Status: http.StatusBadRequest})
+		}
+		thumbnailFile, err := c.FormFile("thumbnail")
+		if err != nil && err != http.ErrMissingFile {
+			fieldErrors = append(fieldErrors, &media.FieldError{Field: "thumbnail", Code: "invalid", Message: "Invalid thumbnail file", Status: http.StatusBadRequest})
+		}
+
+		var songFormat, thumbnailFormat media.Format
+		if songFile != nil {
+			songFormat, err = validateFormFile(songFile, func(f multipart.File) (media.Format, *media.FieldError) {
+				return media.ValidateAudio("song", f, songFile.Size, limits.Audio)
+			})
+			if fe, ok := err.(*media.FieldError); ok {
+				fieldErrors = append(fieldErrors, fe)
+			} else if err != nil {
+				return err
+			}
+		}
+		if thumbnailFile != nil {
+			thumbnailFormat, err = validateFormFile(thumbnailFile, func(f multipart.File) (media.Format, *media.FieldError) {
+				return media.ValidateImage("thumbnail", f, thumbnailFile.Size, limits.Image)
+			})
+			if fe, ok := err.(*media.FieldError); ok {
+				fieldErrors = append(fieldErrors, fe)
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(fieldErrors) > 0 {
+			return rejectUpload(c, fieldErrors)
+		}
+
+		// Spool the song to disk so its tags and stream properties can be read
+		songSrc, err := songFile.Open()
+		if err != nil {
+			return err
+		}
+		songPath, err := media.SpoolTemp(songSrc, "upload-*"+songFormat.Ext)
+		songSrc.Close()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read song file")
+		}
+		defer os.Remove(songPath)
+
+		song := newSong{
+			userID: userID,
+			fields: map[string]string{
+				"title":       c.FormValue("title"),
+				"album":       c.FormValue("album"),
+				"releaseDate": c.FormValue("releaseDate"),
+				"genre":       c.FormValue("genre"),
+			},
+			fileName:         songFile.Filename,
+			audioPath:        songPath,
+			audioFormat:      songFormat,
+			requireThumbnail: true,
+			imageLimit:       limits.Image,
+		}
+		if thumbnailFile != nil {
+			thumbnailSrc, err := thumbnailFile.Open()
+			if err != nil {
+				return err
+			}
+			defer thumbnailSrc.Close()
+			song.thumbnail = thumbnailSrc
+			song.thumbnailSize = thumbnailFile.Size
+			song.thumbnailFormat = thumbnailFormat
+		}
+
+		songID, fieldErrors, err := storeSong(c.Request().Context(), dbService, minioService, jobQueue, song)
+		if err != nil {
+			return err
+		}
+		if len(fieldErrors) > 0 {
+			return rejectUpload(c, fieldErrors)
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Music uploaded successfully",
+			"song_id": songID.String(),
+		})
+	}
+}
+
+// newSong is an upload whose files already passed content validation.
+type newSong struct {
+	userID string
+	// fields holds title, album, genre and releaseDate as sent by the client.
+	fields map[string]string
+
+	fileName    string
+	audioPath   string
+	audioFormat media.Format
+	// stagedAudio is set instead of audioPath when the client uploaded the song
+	// straight to MinIO; the object is read once to hash it and copied into
+	// place by MinIO.
+	stagedAudio     string
+	stagedAudioSize int64
+
+	// thumbnail is nil when the client did not upload one.
+	thumbnail        io.ReadSeeker
+	thumbnailSize    int64
+	thumbnailFormat  media.Format
+	requireThumbnail bool
+	imageLimit       int64
+}
+
+// storeSong reads the embedded metadata of a validated upload, fills in the
+// fields the client left empty, stores audio and thumbnail under song-scoped
+// keys, inserts the song row and schedules HLS packaging. Problems with the
+// client's input are returned as field errors before anything is stored;
+// anything else as an error, after removing whatever was already stored.
+func storeSong(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, jobQueue *jobs.Queue, song newSong) (gocql.UUID, []*media.FieldError, error) {
+	var songHash, probeSource string
+	if song.stagedAudio != "" {
+		// The client wrote the object straight to MinIO, so read it back to
+		// hash it; its ETag is not a content hash for multipart uploads.
+		var err error
+		songHash, err = media.HashObject(ctx, minioService, "music", song.stagedAudio, song.stagedAudioSize)
+		if err != nil {
+			return gocql.UUID{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read song file")
+		}
+		probeURL, err := minioService.PresignedGetObject(ctx, "music", song.stagedAudio, 10*time.Minute, nil)
+		if err != nil {
+			return gocql.UUID{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read song file")
+		}
+		probeSource = probeURL.String()
+	} else {
+		var err error
+		songHash, err = media.HashFile(song.audioPath)
+		if err != nil {
+			return gocql.UUID{}, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to read song file")
+		}
+		probeSource = song.audioPath
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	meta, err := media.Probe(probeCtx, probeSource)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to read metadata from %s: %v", song.fileName, err)
+		meta = &media.Metadata{}
+	}
+	if meta.CoverArt != nil {
+		if _, fe := media.ValidateImage("cover_art", bytes.NewReader(meta.CoverArt), int64(len(meta.CoverArt)), song.imageLimit); fe != nil {
+			log.Printf("Ignoring embedded cover art of %s: %v", song.fileName, fe)
+			meta.CoverArt = nil
+		}
+	}
+
+	// Form values win; embedded tags fill whatever was left empty
+	title := firstNonEmpty(song.fields["title"], meta.Title, strings.TrimSuffix(song.fileName, path.Ext(song.fileName)))
+	album := firstNonEmpty(song.fields["album"], meta.Album)
+	genre := firstNonEmpty(song.fields["genre"], meta.Genre)
+
+	var fieldErrors []*media.FieldError
+	var releaseDate time.Time
+	switch {
+	case song.fields["releaseDate"] != "":
+		releaseDate, err = time.Parse("2006-01-02", song.fields["releaseDate"])
+		if err != nil {
+			fieldErrors = append(fieldErrors, &media.FieldError{Field: "releaseDate", Code: "invalid", Message: "Release date must be formatted as YYYY-MM-DD", Status: http.StatusBadRequest})
+		}
+	case !meta.ReleaseDate.IsZero():
+		releaseDate = meta.ReleaseDate
+	default:
+		fieldErrors = append(fieldErrors, &media.FieldError{Field: "releaseDate", Code: "required", Message: "Release date is required when the song has no date tag", Status: http.StatusBadRequest})
+	}
+	if song.requireThumbnail && song.thumbnail == nil && meta.CoverArt == nil {
+		fieldErrors = append(fieldErrors, &media.FieldError{Field: "thumbnail", Code: "required", Message: "Thumbnail file is required when the song has no embedded cover art", Status: http.StatusBadRequest})
+	}
+	if len(fieldErrors) > 0 {
+		return gocql.UUID{}, fieldErrors, nil
+	}
+
+	// Generate UUID for song
+	songID := gocql.TimeUUID()
+
+	// From here on every object we write to is rolled back if a step fails;
+	// removing an object that was never written is harmless.
+	var stored []string
+	fail := func(err error) (gocql.UUID, []*media.FieldError, error) {
+		cleanup.RemoveObjects(context.WithoutCancel(ctx), dbService, minioService, "music", "rollback of upload "+songID.String(), stored...)
+		return gocql.UUID{}, nil, err
+	}
+
+	// Upload song file to MinIO
+	songObjectName := media.SongObjectName(songID.String(), songHash, song.audioFormat.Ext)
+	stored = append(stored, songObjectName)
+	if song.stagedAudio != "" {
+		if err := minioService.CopyObject(ctx, "music", song.stagedAudio, songObjectName, song.audioFormat.ContentType); err != nil {
+			return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload song"))
+		}
+	} else {
+		songData, err := os.Open(song.audioPath)
+		if err != nil {
+			return fail(err)
+		}
+		defer songData.Close()
+		songInfo, err := songData.Stat()
+		if err != nil {
+			return fail(err)
+		}
+		_, err = minioService.UploadObject("music", songObjectName, songData, songInfo.Size(), song.audioFormat.ContentType)
+		if err != nil {
+			return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload song"))
+		}
+	}
+
+	// Upload thumbnail file to MinIO
+	var thumbnailObjectName string
+	switch {
+	case song.thumbnail != nil:
+		thumbnailHash, err := media.ContentHash(song.thumbnail)
+		if err != nil {
+			return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to read thumbnail file"))
+		}
+		if _, err := song.thumbnail.Seek(0, io.SeekStart); err != nil {
+			return fail(err)
+		}
+
+		thumbnailObjectName = media.ThumbnailObjectName(songID.String(), thumbnailHash, song.thumbnailFormat.Ext)
+		stored = append(stored, thumbnailObjectName)
+		_, err = minioService.UploadObject("music", thumbnailObjectName, song.thumbnail, song.thumbnailSize, song.thumbnailFormat.ContentType)
+		if err != nil {
+			return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload thumbnail"))
+		}
+	case meta.CoverArt != nil:
+		ext := ".jpg"
+		if meta.CoverArtType == "image/png" {
+			ext = ".png"
+		}
+		thumbnailHash, _ := media.ContentHash(bytes.NewReader(meta.CoverArt))
+		thumbnailObjectName = media.ThumbnailObjectName(songID.String(), thumbnailHash, ext)
+		stored = append(stored, thumbnailObjectName)
+		_, err = minioService.UploadObject("music", thumbnailObjectName, bytes.NewReader(meta.CoverArt), int64(len(meta.CoverArt)), meta.CoverArtType)
+		if err != nil {
+			return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload thumbnail"))
+		}
+	}
+
+	err = dbService.InsertSong(songID, title, song.userID, album, releaseDate, genre, songObjectName, thumbnailObjectName, meta.Audio)
+	if err != nil {
+		return fail(echo.NewHTTPError(http.StatusInternalServerError, "Failed to save song metadata"))
+	}
+
+	// The song is stored either way; work the queue has no room for is
+	// picked up by the media backfill. Both jobs need ffmpeg.
+	if !media.FFmpegAvailable() {
+		return songID, nil, nil
+	}
+	if !jobQueue.Enqueue("hls "+songID.String(), func(ctx context.Context) error {
+		return media.PackageHLS(ctx, dbService, minioService, songID, songObjectName)
+	}) {
+		log.Printf("Deferred HLS packaging of song %s to the media backfill", songID)
+	}
+	if thumbnailObjectName != "" && !jobQueue.Enqueue("thumbnails "+songID.String(), func(ctx context.Context) error {
+		return media.GenerateThumbnails(ctx, dbService, minioService, thumbnailObjectName)
+	}) {
+		log.Printf("Deferred thumbnails of song %s to the media backfill", songID)
+	}
+
+	return songID, nil, nil
+}
+
+// uploadFinishLease is how long a request that claimed the finish of an
+// upload has before another one may take over, in case it died midway.
+const uploadFinishLease = 10 * time.Minute
+
+// claimUploadFinish makes the calling request the only one turning upload
+// into a song, recording the claim in upload.FinalizingAt. Without it, a
+// retried or concurrent finish would store the song twice.
+func claimUploadFinish(dbService database.ScyllaService, upload *models.Upload) error {
+	claimedAt := time.Now()
+	claimed, err := dbService.ClaimUploadFinish(*upload, claimedAt, uploadFinishLease)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to finish upload")
+	}
+	if !claimed {
+		return echo.NewHTTPError(http.StatusConflict, "Upload is already being finished")
+	}
+	upload.FinalizingAt = claimedAt
+	return nil
+}
+
+// releaseUploadFinish gives up the claim on an upload that could not be
+// finished, so the client can retry right away.
+func releaseUploadFinish(dbService database.ScyllaService, upload *models.Upload) {
+	if err := dbService.ReleaseUploadFinish(*upload); err != nil {
+		log.Printf("Failed to release upload %s: %v", upload.UploadID, err)
+	}
+}
+
+// completeUpload records that upload became songID. When that fails the song
+// is removed again, since a retry of the upload would store it once more.
+func completeUpload(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, upload *models.Upload, songID gocql.UUID) error {
+	completed, err := dbService.CompleteUpload(*upload, songID)
+	if err == nil && completed {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to mark upload %s complete: %v", upload.UploadID, err)
+	}
+
+	song, lookupErr := dbService.GetSongByID(songID)
+	if lookupErr != nil || song == nil {
+		log.Printf("Failed to get song %s of upload %s: %v", songID, upload.UploadID, lookupErr)
+	} else if removeErr := dbService.RemoveSong(songID); removeErr != nil {
+		log.Printf("Failed to remove song %s of upload %s: %v", songID, upload.UploadID, removeErr)
+	} else {
+		cleanup.RemoveObjects(context.WithoutCancel(ctx), dbService, minioService, "music", "rollback of upload "+songID.String(), songObjects(song)...)
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to finish upload")
+	}
+	return echo.NewHTTPError(http.StatusConflict, "Upload was finished by another request")
+}
+
+// uploadLimits returns the size limits that apply to the signed-in user.
+func uploadLimits(c echo.Context, dbService database.ScyllaService) (media.UploadLimits, error) {
+	user, err := mdw.CurrentUser(c, dbService)
+	if err != nil {
+		return media.UploadLimits{}, err
+	}
+	if user == nil {
+		return media.UploadLimits{}, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+	return media.UploadLimitsFor(user.Role), nil
+}
+
+// validateFormFile opens an uploaded file and runs check against its content.
+// Validation failures are returned as *media.FieldError.
+func validateFormFile(fh *multipart.FileHeader, check func(multipart.File) (media.Format, *media.FieldError)) (media.Format, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return media.Format{}, err
+	}
+	defer f.Close()
+
+	format, fe := check(f)
+	if fe != nil {
+		return media.Format{}, fe
+	}
+	return format, nil
+}
+
+// rejectUpload answers with every field that failed validation, using the
+// status of the first failure.
+func rejectUpload(c echo.Context, fieldErrors []*media.FieldError) error {
+	return c.JSON(fieldErrors[0].Status, echo.Map{
+		"message": "Upload rejected",
+		"errors":  fieldErrors,
+	})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
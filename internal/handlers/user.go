@@ -2,47 +2,38 @@ package handlers
 
 import (
 	"net/http"
+	"rr-backend/internal/auth"
 	"rr-backend/internal/database"
+	mdw "rr-backend/internal/middleware"
 	"rr-backend/internal/models"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+// UpsertUserHandler records a sign-in. Everything it stores comes from the
+// claims of the verified ID token; the request body is ignored, so clients
+// cannot choose their role. New users start with models.DefaultRole.
 func UpsertUserHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Get the verified UID from the JWT middleware
-		userID := c.Get("userID").(string)
-
-		// Bind the rest of the user data from the request body
-		user := new(models.User)
-		if err := c.Bind(user); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		identity, ok := c.Get(mdw.IdentityKey).(*auth.Identity)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "No ID token provided")
 		}
-		user.UserID = userID // Set the UID from the verified token
 
-		// Upsert the user in the database
-		err := scyllaService.UpsertUser(user.UserID, user.Username, user.Email, user.Role)
-		if err != nil {
+		user, err := scyllaService.SignInUser(models.User{
+			UserID:   identity.UserID,
+			Username: identity.Name,
+			Email:    identity.Email,
+			PhotoURL: identity.PhotoURL,
+		}, time.Now())
+		if err != nil || user == nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upsert user")
 		}
 
 		return c.JSON(http.StatusOK, echo.Map{
 			"message": "User upserted successfully",
-		})
-	}
-}
-
-func PromoteListenerToArtistHandler(scyllaService database.ScyllaService) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		listenerID := c.Get("userID").(string)
-
-		err := scyllaService.UpdateUserRole(listenerID, "artist")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to promote listener to artist")
-		}
-
-		return c.JSON(http.StatusOK, echo.Map{
-			"message": "Listener promoted to artist successfully",
+			"user":    user,
 		})
 	}
 }
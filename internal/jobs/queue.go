@@ -0,0 +1,127 @@
+// Package jobs runs background work off the request path.
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Queue is a bounded in-process work queue drained by a fixed pool of workers.
+type Queue struct {
+	jobs   chan job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// tickers tracks the goroutines started by Every.
+	tickers sync.WaitGroup
+
+	// mu guards closed, so Enqueue never sends on the closed jobs channel.
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewQueue(workers, capacity int) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		jobs:   make(chan job, capacity),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue schedules fn to run on a worker. It returns false without blocking
+// when the queue is full or closed so callers can decide how to degrade.
+func (q *Queue) Enqueue(name string, fn func(ctx context.Context) error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		log.Printf("Job queue closed, dropping %s", name)
+		return false
+	}
+	select {
+	case q.jobs <- job{name: name, run: fn}:
+		return true
+	default:
+		log.Printf("Job queue full, dropping %s", name)
+		return false
+	}
+}
+
+// Every enqueues fn once per interval until the queue is closed. A run that
+// is still queued or running when the next tick comes is not doubled up.
+func (q *Queue) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	var mu sync.Mutex
+	busy := false
+	run := func(ctx context.Context) error {
+		defer func() {
+			mu.Lock()
+			busy = false
+			mu.Unlock()
+		}()
+		return fn(ctx)
+	}
+
+	q.tickers.Add(1)
+	go func() {
+		defer q.tickers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				if busy {
+					mu.Unlock()
+					continue
+				}
+				busy = true
+				mu.Unlock()
+				if !q.Enqueue(name, run) {
+					mu.Lock()
+					busy = false
+					mu.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+// Close stops accepting work, cancels running jobs and waits for workers.
+// Calling it again is a no-op.
+func (q *Queue) Close() {
+	q.cancel()
+	q.tickers.Wait()
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for j := range q.jobs {
+		if q.ctx.Err() != nil {
+			continue
+		}
+		if err := j.run(q.ctx); err != nil {
+			log.Printf("Job %s failed: %v", j.name, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// A media job that keeps failing for a song is retried after
+// firstMediaRetryDelay, then ever more rarely up to maxMediaRetryDelay, and
+// given up on after maxMediaAttempts.
+const (
+	firstMediaRetryDelay = time.Hour
+	maxMediaRetryDelay   = 24 * time.Hour
+	maxMediaAttempts     = 5
+)
+
+// MediaBackfillReport summarises a BackfillMedia run. Deferred counts jobs
+// skipped because they failed before and are not due again yet, or ever.
+type MediaBackfillReport struct {
+	Songs      int      `json:"songs"`
+	Packaged   int      `json:"packaged"`
+	Thumbnails int      `json:"thumbnails"`
+	Deferred   int      `json:"deferred"`
+	Failed     []string `json:"failed"`
+}
+
+// BackfillMedia packages the available songs that have no HLS stream yet and
+// renders the missing thumbnail derivatives. Uploads queue that work
+// themselves; this catches what was dropped because the queue was full or
+// lost in a restart. Songs uploaded within minAge are left alone, as their
+// jobs may still be waiting in the queue. Failures are recorded, so a song
+// whose media cannot be processed is not retried on every run.
+func BackfillMedia(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, minAge time.Duration) (*MediaBackfillReport, error) {
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, fmt.Errorf("list songs: %w", err)
+	}
+	failures := map[string]map[string]models.MediaFailure{}
+	for _, job := range []string{models.MediaJobHLS, models.MediaJobThumbnails} {
+		recorded, err := dbService.GetMediaFailures(job)
+		if err != nil {
+			return nil, fmt.Errorf("list %s failures: %w", job, err)
+		}
+		failures[job] = map[string]models.MediaFailure{}
+		for _, f := range recorded {
+			failures[job][f.SongID] = f
+		}
+	}
+
+	report := &MediaBackfillReport{Songs: len(songs), Failed: []string{}}
+	now := time.Now()
+	cutoff := now.Add(-minAge)
+	// run runs one job for song unless an earlier failure holds it back, and
+	// records how it went.
+	run := func(job, songID string, fn func() error) bool {
+		previous, failedBefore := failures[job][songID]
+		if failedBefore && (previous.Attempts >= maxMediaAttempts || previous.NextAttemptAt.After(now)) {
+			report.Deferred++
+			return false
+		}
+		err := fn()
+		if err == nil {
+			if failedBefore {
+				if err := dbService.RemoveMediaFailure(job, songID); err != nil {
+					log.Printf("Failed to clear the %s failure of song %s: %v", job, songID, err)
+				}
+			}
+			return true
+		}
+
+		log.Printf("Failed to run %s for song %s: %v", job, songID, err)
+		report.Failed = append(report.Failed, songID)
+		failure := models.MediaFailure{Job: job, SongID: songID, Attempts: previous.Attempts + 1, LastError: err.Error()}
+		failure.NextAttemptAt = now.Add(mediaRetryDelay(failure.Attempts))
+		if failure.Attempts >= maxMediaAttempts {
+			log.Printf("Giving up on %s for song %s after %d attempts", job, songID, failure.Attempts)
+		}
+		if err := dbService.RecordMediaFailure(failure); err != nil {
+			log.Printf("Failed to record the %s failure of song %s: %v", job, songID, err)
+		}
+		return false
+	}
+
+	for _, song := range songs {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		songID, err := gocql.ParseUUID(song.SongID)
+		if err != nil || song.Status != "" {
+			continue
+		}
+		// Songs are keyed by time UUIDs; older rows may not be, and are old.
+		if songID.Version() == 1 && songID.Time().After(cutoff) {
+			continue
+		}
+
+		if song.HLSManifest == "" && run(models.MediaJobHLS, song.SongID, func() error {
+			return media.PackageHLS(ctx, dbService, minioService, songID, song.SongURL)
+		}) {
+			report.Packaged++
+		}
+
+		// Derivatives are stored smallest first and removed again when one
+		// fails, so the last one stands for all of them.
+		if song.ThumbnailURL == "" {
+			continue
+		}
+		variants := media.ThumbnailVariants(song.ThumbnailURL)
+		if _, err := minioService.StatObject(ctx, "music", variants[len(variants)-1]); !database.IsObjectNotFound(err) {
+			continue
+		}
+		if run(models.MediaJobThumbnails, song.SongID, func() error {
+			return media.GenerateThumbnails(ctx, dbService, minioService, song.ThumbnailURL)
+		}) {
+			report.Thumbnails++
+		}
+	}
+	return report, nil
+}
+
+func mediaRetryDelay(attempts int) time.Duration {
+	delay := firstMediaRetryDelay
+	for i := 1; i < attempts && delay < maxMediaRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxMediaRetryDelay {
+		return maxMediaRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,148 @@
+// Package maintenance holds one-off and periodic jobs that keep ScyllaDB and
+// MinIO consistent with each other.
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+
+	"github.com/gocql/gocql"
+)
+
+// KeyMigrationReport summarises a MigrateObjectKeys run.
+type KeyMigrationReport struct {
+	Songs         int      `json:"songs"`
+	Migrated      int      `json:"migrated"`
+	AlreadyScoped int      `json:"already_scoped"`
+	Removed       int      `json:"removed"`
+	Failed        []string `json:"failed"`
+}
+
+// MigrateObjectKeys moves every song's audio and thumbnail from the legacy
+// songs/<user>/<file> layout to keys derived from the song ID and content
+// hash, then rewrites the song row. Legacy objects are only removed once no
+// remaining row references them, since colliding uploads may have shared one.
+func MigrateObjectKeys(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, dryRun bool) (*KeyMigrationReport, error) {
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, fmt.Errorf("list songs: %w", err)
+	}
+
+	report := &KeyMigrationReport{Songs: len(songs)}
+	stillReferenced := map[string]bool{}
+	var legacy []string
+
+	for _, song := range songs {
+		if media.IsSongScopedKey(song.SongURL, song.SongID) && (song.ThumbnailURL == "" || media.IsSongScopedKey(song.ThumbnailURL, song.SongID)) {
+			report.AlreadyScoped++
+			continue
+		}
+
+		songURL, err := migrateObject(ctx, minioService, song.SongID, song.SongURL, media.SongObjectName, dryRun)
+		var thumbnailURL string
+		if err == nil {
+			thumbnailURL, err = migrateObject(ctx, minioService, song.SongID, song.ThumbnailURL, media.ThumbnailObjectName, dryRun)
+			if err != nil && !dryRun {
+				removeCopies(minioService, song.SongID, song.SongURL, songURL)
+			}
+		}
+		if err == nil && !dryRun {
+			songUUID, perr := gocql.ParseUUID(song.SongID)
+			if perr == nil {
+				perr = dbService.UpdateSongObjects(songUUID, songURL, thumbnailURL)
+			}
+			if perr != nil {
+				// The row still points at the legacy objects, so the copies
+				// would only be left behind as orphans
+				removeCopies(minioService, song.SongID, song.SongURL, songURL, song.ThumbnailURL, thumbnailURL)
+				err = fmt.Errorf("update row: %w", perr)
+			}
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", song.SongID, err))
+			stillReferenced[song.SongURL] = true
+			stillReferenced[song.ThumbnailURL] = true
+			continue
+		}
+
+		report.Migrated++
+		for _, old := range []string{song.SongURL, song.ThumbnailURL} {
+			if old != "" && old != songURL && old != thumbnailURL {
+				legacy = append(legacy, old)
+			}
+		}
+		log.Printf("Migrated song %s: %s -> %s, %s -> %s", song.SongID, song.SongURL, songURL, song.ThumbnailURL, thumbnailURL)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	removed := map[string]bool{}
+	for _, objectName := range legacy {
+		if stillReferenced[objectName] || removed[objectName] {
+			continue
+		}
+		if err := minioService.RemoveObject("music", objectName); err != nil {
+			log.Printf("Failed to remove legacy object %s: %v", objectName, err)
+			continue
+		}
+		removed[objectName] = true
+		report.Removed++
+	}
+
+	return report, nil
+}
+
+// migrateObject copies objectName to its song-scoped key and returns the new
+// key. Objects that already use the new layout are returned unchanged.
+func migrateObject(ctx context.Context, minioService database.MinIOService, songID, objectName string, keyFor func(songID, contentHash, ext string) string, dryRun bool) (string, error) {
+	if objectName == "" || media.IsSongScopedKey(objectName, songID) {
+		return objectName, nil
+	}
+
+	info, err := minioService.StatObject(ctx, "music", objectName)
+	if err != nil {
+		return "", fmt.Errorf("stat %s: %w", objectName, err)
+	}
+	hash, err := media.HashObject(ctx, minioService, "music", objectName, info.Size)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", objectName, err)
+	}
+
+	ext := path.Ext(objectName)
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(info.ContentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	newName := keyFor(songID, hash, ext)
+	if dryRun {
+		return newName, nil
+	}
+	if err := minioService.CopyObject(ctx, "music", objectName, newName, ""); err != nil {
+		return "", fmt.Errorf("copy %s: %w", objectName, err)
+	}
+	return newName, nil
+}
+
+// removeCopies removes the objects migrateObject copied for a song whose
+// migration did not complete. It takes pairs of legacy and new keys and skips
+// keys that were not copied.
+func removeCopies(minioService database.MinIOService, songID string, pairs ...string) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" || pairs[i+1] == pairs[i] {
+			continue
+		}
+		if err := minioService.RemoveObject("music", pairs[i+1]); err != nil {
+			log.Printf("Failed to remove copy %s of song %s: %v", pairs[i+1], songID, err)
+		}
+	}
+}
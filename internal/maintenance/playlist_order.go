@@ -0,0 +1,86 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"rr-backend/internal/playlists"
+
+	"github.com/gocql/gocql"
+)
+
+// PlaylistOrderReport summarises a MigratePlaylistOrder run.
+type PlaylistOrderReport struct {
+	Playlists       int      `json:"playlists"`
+	Migrated        int      `json:"migrated"`
+	Tracks          int      `json:"tracks"`
+	AlreadyMigrated int      `json:"already_migrated"`
+	Failed          []string `json:"failed"`
+}
+
+// MigratePlaylistOrder copies every playlist from the unordered
+// playlist_songs table into playlist_tracks, ordered by when each song was
+// added. The legacy table did not record who added a song, so the owner is
+// credited. Songs already in playlist_tracks, for instance added through the
+// API before the migration ran, are not copied again; the rest are appended
+// after them. Once copied, the legacy rows of a playlist are deleted, so a
+// rerun neither repeats the copy nor brings back songs removed since.
+func MigratePlaylistOrder(dbService database.ScyllaService, dryRun bool) (*PlaylistOrderReport, error) {
+	all, err := database.All(dbService.GetAllPlaylists)
+	if err != nil {
+		return nil, fmt.Errorf("list playlists: %w", err)
+	}
+
+	report := &PlaylistOrderReport{Playlists: len(all)}
+	for _, playlist := range all {
+		legacy, err := dbService.GetLegacyPlaylistSongs(playlist.PlaylistID)
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", playlist.PlaylistID, err))
+			continue
+		}
+		existing, err := playlists.Tracks(dbService, playlist.PlaylistID)
+		if err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", playlist.PlaylistID, err))
+			continue
+		}
+		present := make(map[gocql.UUID]bool, len(existing))
+		for _, track := range existing {
+			present[track.SongID] = true
+		}
+
+		sort.SliceStable(legacy, func(i, j int) bool { return legacy[i].AddedAt.Before(legacy[j].AddedAt) })
+		var tracks []models.PlaylistTrack
+		for _, track := range legacy {
+			if present[track.SongID] {
+				continue
+			}
+			track.EntryID = gocql.UUIDFromTime(track.AddedAt)
+			track.AddedBy = playlist.UserID
+			tracks = append(tracks, track)
+		}
+
+		if !dryRun {
+			if len(tracks) > 0 {
+				if err := playlists.Append(dbService, playlist.PlaylistID, tracks); err != nil {
+					report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", playlist.PlaylistID, err))
+					continue
+				}
+			}
+			if len(legacy) > 0 {
+				if err := dbService.RemoveLegacyPlaylistSongs(playlist.PlaylistID); err != nil {
+					report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", playlist.PlaylistID, err))
+					continue
+				}
+			}
+		}
+		if len(tracks) == 0 {
+			report.AlreadyMigrated++
+			continue
+		}
+		report.Migrated++
+		report.Tracks += len(tracks)
+	}
+	return report, nil
+}
@@ -0,0 +1,181 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// songPrefixes are the parts of the music bucket owned by song rows.
+var songPrefixes = []string{"songs/", "thumbnails/", "hls/"}
+
+// reconciledPrefixes adds the staging objects owned by upload rows. A staging
+// object is an orphan once its upload row has expired or been removed;
+// SweepUploads removes those on a schedule, along with the multipart uploads
+// a listing does not show.
+var reconciledPrefixes = []string{"songs/", "thumbnails/", "hls/", media.StagingPrefix}
+
+// OrphanObject is a stored object that no song or upload row references.
+type OrphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// BrokenSong is a song row pointing at objects that do not exist.
+type BrokenSong struct {
+	SongID           string `json:"song_id"`
+	MissingSong      string `json:"missing_song,omitempty"`
+	MissingThumbnail string `json:"missing_thumbnail,omitempty"`
+}
+
+// ReconcileReport summarises a Reconcile run.
+type ReconcileReport struct {
+	Songs         int            `json:"songs"`
+	Objects       int            `json:"objects"`
+	OrphanObjects []OrphanObject `json:"orphan_objects"`
+	OrphanBytes   int64          `json:"orphan_bytes"`
+	BrokenSongs   []BrokenSong   `json:"broken_songs"`
+
+	// Filled in only when fixing.
+	Removed           int      `json:"removed"`
+	MarkedUnavailable int      `json:"marked_unavailable"`
+	Failed            []string `json:"failed"`
+}
+
+// ReconcileOptions controls what Reconcile does about the drift it finds.
+type ReconcileOptions struct {
+	// Fix removes orphan objects and marks songs without audio unavailable.
+	Fix bool
+	// MinAge leaves recently written objects alone, since an upload stores
+	// its objects before it inserts the song row.
+	MinAge time.Duration
+}
+
+// Reconcile compares the music bucket with the songs and uploads tables and
+// reports objects no song or upload references and songs whose objects are
+// missing.
+func Reconcile(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, opts ReconcileOptions) (*ReconcileReport, error) {
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, fmt.Errorf("list songs: %w", err)
+	}
+
+	referenced := map[string]bool{}
+	songIDs := map[string]bool{}
+	for _, song := range songs {
+		songIDs[song.SongID] = true
+		referenced[song.SongURL] = true
+		if song.ThumbnailURL != "" {
+			referenced[song.ThumbnailURL] = true
+			for _, variant := range media.ThumbnailVariants(song.ThumbnailURL) {
+				referenced[variant] = true
+			}
+		}
+	}
+
+	report := &ReconcileReport{Songs: len(songs), OrphanObjects: []OrphanObject{}, BrokenSongs: []BrokenSong{}, Failed: []string{}}
+	uploads := map[string]bool{}
+	stored := map[string]bool{}
+	cutoff := time.Now().Add(-opts.MinAge)
+	for _, prefix := range reconciledPrefixes {
+		objects, err := minioService.ListObjects(ctx, "music", prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, object := range objects {
+			report.Objects++
+			stored[object.Key] = true
+			if referenced[object.Key] || (prefix == "hls/" && songIDs[keySongID(object.Key, prefix)]) {
+				continue
+			}
+			if object.LastModified.After(cutoff) {
+				continue
+			}
+			if prefix == media.StagingPrefix {
+				uploadID := media.StagingUploadID(object.Key)
+				if _, ok := uploads[uploadID]; !ok {
+					exists, err := uploadExists(dbService, uploadID)
+					if err != nil {
+						return nil, fmt.Errorf("get upload of %s: %w", object.Key, err)
+					}
+					uploads[uploadID] = exists
+				}
+				if uploads[uploadID] {
+					continue
+				}
+			}
+			report.OrphanObjects = append(report.OrphanObjects, OrphanObject{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+			report.OrphanBytes += object.Size
+		}
+	}
+
+	for _, song := range songs {
+		broken := BrokenSong{SongID: song.SongID}
+		if !stored[song.SongURL] && !objectExists(ctx, minioService, song.SongURL) {
+			broken.MissingSong = song.SongURL
+		}
+		if song.ThumbnailURL != "" && !stored[song.ThumbnailURL] && !objectExists(ctx, minioService, song.ThumbnailURL) {
+			broken.MissingThumbnail = song.ThumbnailURL
+		}
+		if broken.MissingSong != "" || broken.MissingThumbnail != "" {
+			report.BrokenSongs = append(report.BrokenSongs, broken)
+		}
+	}
+
+	if !opts.Fix {
+		return report, nil
+	}
+
+	for _, orphan := range report.OrphanObjects {
+		if err := minioService.RemoveObject("music", orphan.Key); err != nil {
+			log.Printf("Failed to remove orphan %s: %v", orphan.Key, err)
+			report.Failed = append(report.Failed, orphan.Key)
+			continue
+		}
+		report.Removed++
+	}
+	statuses := map[string]string{}
+	for _, song := range songs {
+		statuses[song.SongID] = song.Status
+	}
+	for _, broken := range report.BrokenSongs {
+		if broken.MissingSong == "" || statuses[broken.SongID] == models.SongStatusUnavailable {
+			continue
+		}
+		songID, err := gocql.ParseUUID(broken.SongID)
+		if err == nil {
+			err = dbService.SetSongStatus(songID, models.SongStatusUnavailable)
+		}
+		if err != nil {
+			log.Printf("Failed to mark song %s unavailable: %v", broken.SongID, err)
+			report.Failed = append(report.Failed, broken.SongID)
+			continue
+		}
+		report.MarkedUnavailable++
+	}
+	return report, nil
+}
+
+// objectExists checks objects outside the listed prefixes one at a time. Only
+// a definite "not found" counts as missing.
+func objectExists(ctx context.Context, minioService database.MinIOService, objectName string) bool {
+	if objectName == "" {
+		return false
+	}
+	for _, prefix := range reconciledPrefixes {
+		if strings.HasPrefix(objectName, prefix) {
+			return false // already covered by the listing
+		}
+	}
+	_, err := minioService.StatObject(ctx, "music", objectName)
+	return !database.IsObjectNotFound(err)
+}
@@ -0,0 +1,116 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+)
+
+// ArtistStorage is how much of the music bucket the songs of one artist take.
+type ArtistStorage struct {
+	ArtistID       string `json:"artist_id"`
+	Artist         string `json:"artist"`
+	Songs          int    `json:"songs"`
+	Objects        int    `json:"objects"`
+	Bytes          int64  `json:"bytes"`
+	AudioBytes     int64  `json:"audio_bytes"`
+	ThumbnailBytes int64  `json:"thumbnail_bytes"`
+	HLSBytes       int64  `json:"hls_bytes"`
+}
+
+// StorageReport breaks the music bucket down by artist, largest first.
+// Objects no song accounts for, such as orphans Reconcile would report, are
+// only counted in UnattributedBytes.
+type StorageReport struct {
+	Artists           []ArtistStorage `json:"artists"`
+	TotalBytes        int64           `json:"total_bytes"`
+	UnattributedBytes int64           `json:"unattributed_bytes"`
+}
+
+// StorageUsage lists the objects under the prefixes songs own and adds each
+// one up under the artist of its song.
+func StorageUsage(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService) (*StorageReport, error) {
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, fmt.Errorf("list songs: %w", err)
+	}
+	artists, err := database.All(dbService.GetAllArtists)
+	if err != nil {
+		return nil, fmt.Errorf("list artists: %w", err)
+	}
+	names := map[string]string{}
+	for _, artist := range artists {
+		names[artist.UserID] = artist.Username
+	}
+
+	// Objects are found through the keys the song rows hold, which covers
+	// legacy keys, and otherwise through the song ID in the key.
+	artistOf := map[string]string{}
+	objectArtist := map[string]string{}
+	usage := map[string]*ArtistStorage{}
+	for _, song := range songs {
+		artistOf[song.SongID] = song.UserID
+		objectArtist[song.SongURL] = song.UserID
+		if song.ThumbnailURL != "" {
+			objectArtist[song.ThumbnailURL] = song.UserID
+			for _, variant := range media.ThumbnailVariants(song.ThumbnailURL) {
+				objectArtist[variant] = song.UserID
+			}
+		}
+		if usage[song.UserID] == nil {
+			usage[song.UserID] = &ArtistStorage{ArtistID: song.UserID, Artist: names[song.UserID]}
+		}
+		usage[song.UserID].Songs++
+	}
+
+	report := &StorageReport{Artists: []ArtistStorage{}}
+	for _, prefix := range songPrefixes {
+		objects, err := minioService.ListObjects(ctx, "music", prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, object := range objects {
+			report.TotalBytes += object.Size
+			artistID, ok := objectArtist[object.Key]
+			if !ok {
+				artistID, ok = artistOf[keySongID(object.Key, prefix)]
+			}
+			if !ok {
+				report.UnattributedBytes += object.Size
+				continue
+			}
+			artist := usage[artistID]
+			artist.Objects++
+			artist.Bytes += object.Size
+			switch prefix {
+			case "songs/":
+				artist.AudioBytes += object.Size
+			case "thumbnails/":
+				artist.ThumbnailBytes += object.Size
+			case "hls/":
+				artist.HLSBytes += object.Size
+			}
+		}
+	}
+
+	for _, artist := range usage {
+		report.Artists = append(report.Artists, *artist)
+	}
+	sort.Slice(report.Artists, func(i, j int) bool {
+		if report.Artists[i].Bytes != report.Artists[j].Bytes {
+			return report.Artists[i].Bytes > report.Artists[j].Bytes
+		}
+		return report.Artists[i].ArtistID < report.Artists[j].ArtistID
+	})
+	return report, nil
+}
+
+// keySongID extracts the song ID from <prefix><song_id>/...
+func keySongID(key, prefix string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+	return id
+}
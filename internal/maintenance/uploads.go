@@ -0,0 +1,104 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+
+	"github.com/gocql/gocql"
+)
+
+// UploadSweepReport summarises a SweepUploads run.
+type UploadSweepReport struct {
+	Aborted int      `json:"aborted"`
+	Removed int      `json:"removed"`
+	Failed  []string `json:"failed"`
+}
+
+// SweepUploads releases the storage of abandoned uploads: multipart uploads
+// and staging objects under uploads/ whose upload row has expired or been
+// removed. Upload rows expire a week after their last write, but nothing else
+// ever aborts the multipart upload or removes what was staged. Storage
+// written within minAge is left alone, as an upload starts its multipart
+// upload before it creates the row.
+func SweepUploads(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, minAge time.Duration) (*UploadSweepReport, error) {
+	report := &UploadSweepReport{Failed: []string{}}
+	cutoff := time.Now().Add(-minAge)
+	live := map[string]bool{}
+	abandoned := func(objectName string) (bool, error) {
+		uploadID := media.StagingUploadID(objectName)
+		if _, ok := live[uploadID]; !ok {
+			exists, err := uploadExists(dbService, uploadID)
+			if err != nil {
+				return false, err
+			}
+			live[uploadID] = exists
+		}
+		return !live[uploadID], nil
+	}
+
+	multiparts, err := minioService.ListIncompleteUploads(ctx, "music", media.StagingPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list multipart uploads: %w", err)
+	}
+	for _, multipart := range multiparts {
+		if multipart.Initiated.After(cutoff) {
+			continue
+		}
+		gone, err := abandoned(multipart.Key)
+		if err != nil {
+			return report, fmt.Errorf("get upload of %s: %w", multipart.Key, err)
+		}
+		if !gone {
+			continue
+		}
+		if err := minioService.AbortMultipartUpload(ctx, "music", multipart.Key, multipart.UploadID); err != nil {
+			log.Printf("Failed to abort multipart upload of %s: %v", multipart.Key, err)
+			report.Failed = append(report.Failed, multipart.Key)
+			continue
+		}
+		report.Aborted++
+	}
+
+	objects, err := minioService.ListObjects(ctx, "music", media.StagingPrefix)
+	if err != nil {
+		return report, fmt.Errorf("list %s: %w", media.StagingPrefix, err)
+	}
+	for _, object := range objects {
+		if object.LastModified.After(cutoff) {
+			continue
+		}
+		gone, err := abandoned(object.Key)
+		if err != nil {
+			return report, fmt.Errorf("get upload of %s: %w", object.Key, err)
+		}
+		if !gone {
+			continue
+		}
+		if err := minioService.RemoveObject("music", object.Key); err != nil {
+			log.Printf("Failed to remove staging object %s: %v", object.Key, err)
+			report.Failed = append(report.Failed, object.Key)
+			continue
+		}
+		report.Removed++
+	}
+	return report, nil
+}
+
+// uploadExists reports whether the upload a staging object is named after
+// still has a row. Objects not named after an upload belong to none.
+func uploadExists(dbService database.ScyllaService, uploadID string) (bool, error) {
+	id, err := gocql.ParseUUID(uploadID)
+	if err != nil {
+		return false, nil
+	}
+	upload, err := dbService.GetUpload(id)
+	if err != nil {
+		return false, err
+	}
+	return upload != nil, nil
+}
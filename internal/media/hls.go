@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/database"
+
+	"github.com/gocql/gocql"
+)
+
+// HLSVariant is one rendition of the adaptive stream.
+type HLSVariant struct {
+	Name    string // directory under the song's HLS prefix
+	Bitrate int    // AAC bitrate in kbit/s
+}
+
+var HLSVariants = []HLSVariant{
+	{Name: "64k", Bitrate: 64},
+	{Name: "128k", Bitrate: 128},
+	{Name: "256k", Bitrate: 256},
+}
+
+const (
+	hlsSegmentSeconds = 6
+	hlsPlaylistType   = "application/vnd.apple.mpegurl"
+	hlsSegmentType    = "video/mp2t"
+)
+
+var hlsFileName = regexp.MustCompile(`^[a-z0-9_]+\.(m3u8|ts)$`)
+
+// HLSPrefix is the object prefix holding every HLS file of a song.
+func HLSPrefix(songID string) string {
+	return fmt.Sprintf("hls/%s/", songID)
+}
+
+// HLSObjectName maps a variant playlist or segment requested by a client to
+// its object name, rejecting anything that is not a file we produced.
+func HLSObjectName(manifestObject, variant, file string) (string, bool) {
+	if !hlsFileName.MatchString(file) {
+		return "", false
+	}
+	for _, v := range HLSVariants {
+		if v.Name == variant {
+			return path.Join(path.Dir(manifestObject), variant, file), true
+		}
+	}
+	return "", false
+}
+
+func ffmpegPath() string {
+	if p := os.Getenv("FFMPEG_PATH"); p != "" {
+		return p
+	}
+	return "ffmpeg"
+}
+
+// FFmpegAvailable reports whether ffmpeg can be run, so background work
+// that needs it can be skipped instead of failing for every song.
+func FFmpegAvailable() bool {
+	_, err := exec.LookPath(ffmpegPath())
+	return err == nil
+}
+
+// PackageHLS transcodes the original upload into every HLS variant, stores the
+// playlists and segments in the music bucket and records the master playlist
+// on the song row once everything is in place. If uploading fails, or the song
+// was removed in the meantime, the stored files are removed again.
+func PackageHLS(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, songID gocql.UUID, objectName string) error {
+	workDir, err := os.MkdirTemp("", "hls-"+songID.String())
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	source := filepath.Join(workDir, "source")
+	if err := DownloadObject(ctx, minioService, "music", objectName, source); err != nil {
+		return fmt.Errorf("download %s: %w", objectName, err)
+	}
+
+	for _, v := range HLSVariants {
+		if err := transcodeVariant(ctx, source, filepath.Join(workDir, v.Name), v); err != nil {
+			return fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "master.m3u8"), masterPlaylist(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil {
+		return err
+	}
+
+	prefix := HLSPrefix(songID.String())
+	err = filepath.WalkDir(workDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, p)
+		if err != nil {
+			return err
+		}
+		contentType := hlsSegmentType
+		if filepath.Ext(p) == ".m3u8" {
+			contentType = hlsPlaylistType
+		}
+		return uploadFile(minioService, "music", prefix+filepath.ToSlash(rel), p, contentType)
+	})
+	if err != nil {
+		cleanup.RemoveObjects(ctx, dbService, minioService, "music", "failed HLS packaging of "+songID.String(), prefix)
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	if err := dbService.UpdateSongManifest(songID, prefix+"master.m3u8"); err != nil {
+		if err == gocql.ErrNotFound {
+			cleanup.RemoveObjects(ctx, dbService, minioService, "music", "HLS packaging of removed song "+songID.String(), prefix)
+		}
+		return err
+	}
+	return nil
+}
+
+func transcodeVariant(ctx context.Context, source, dir string, v HLSVariant) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, ffmpegPath(),
+		"-v", "error", "-y",
+		"-i", source,
+		"-map", "0:a:0", "-vn",
+		"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", v.Bitrate), "-ac", "2",
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%03d.ts"),
+		filepath.Join(dir, "index.m3u8"),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+func masterPlaylist() []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range HLSVariants {
+		// Advertise a little above the audio bitrate to cover container overhead.
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"mp4a.40.2\"\n", v.Bitrate*1100)
+		fmt.Fprintf(&b, "%s/index.m3u8\n", v.Name)
+	}
+	return b.Bytes()
+}
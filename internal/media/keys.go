@@ -0,0 +1,88 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"rr-backend/internal/database"
+)
+
+// Object keys are derived from the song ID and a hash of the content, never
+// from client supplied names, so an object is written once and never reused
+// by another song.
+
+// contentHashLength is how many hex characters of the SHA-256 digest end up
+// in the key; the song ID already makes the key unique.
+const contentHashLength = 16
+
+func SongObjectName(songID, contentHash, ext string) string {
+	return "songs/" + songID + "/" + shortHash(contentHash) + ext
+}
+
+func ThumbnailObjectName(songID, contentHash, ext string) string {
+	return "thumbnails/" + songID + "/" + shortHash(contentHash) + ext
+}
+
+// IsSongScopedKey reports whether objectName already follows the per-song key
+// layout for songID.
+func IsSongScopedKey(objectName, songID string) bool {
+	return strings.HasPrefix(objectName, "songs/"+songID+"/") || strings.HasPrefix(objectName, "thumbnails/"+songID+"/")
+}
+
+// StagingPrefix holds the objects of uploads that have not become a song yet.
+// Each is named after its upload: uploads/<upload_id>, possibly followed by a
+// suffix such as ".thumbnail" or ".pending-3".
+const StagingPrefix = "uploads/"
+
+func StagingObjectName(uploadID string) string {
+	return StagingPrefix + uploadID
+}
+
+// StagingUploadID returns the ID of the upload a staging object belongs to.
+func StagingUploadID(objectName string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(objectName, StagingPrefix), ".")
+	return id
+}
+
+// ContentHash returns the hex SHA-256 digest of everything read from r.
+func ContentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return ContentHash(f)
+}
+
+// HashObject returns the hex SHA-256 digest of a stored object of the given
+// size, reading it once from start to end.
+func HashObject(ctx context.Context, minioService database.MinIOService, bucketName, objectName string, size int64) (string, error) {
+	if size == 0 {
+		return ContentHash(strings.NewReader(""))
+	}
+	body, err := minioService.GetObjectRange(ctx, bucketName, objectName, 0, size-1)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return ContentHash(body)
+}
+
+func shortHash(contentHash string) string {
+	if len(contentHash) > contentHashLength {
+		return contentHash[:contentHashLength]
+	}
+	return contentHash
+}
@@ -0,0 +1,208 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"rr-backend/internal/models"
+)
+
+// Metadata is what we could learn from the file itself: embedded tags
+// (ID3v2, Vorbis comments, FLAC, MP4 atoms), stream properties and cover art.
+type Metadata struct {
+	Title       string
+	Artist      string
+	Album       string
+	Genre       string
+	ReleaseDate time.Time
+	Audio       models.AudioInfo
+
+	CoverArt     []byte
+	CoverArtType string
+}
+
+type probeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		BitRate  string            `json:"bit_rate"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index       int               `json:"index"`
+		CodecType   string            `json:"codec_type"`
+		CodecName   string            `json:"codec_name"`
+		SampleRate  string            `json:"sample_rate"`
+		Channels    int               `json:"channels"`
+		BitRate     string            `json:"bit_rate"`
+		Tags        map[string]string `json:"tags"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func ffprobePath() string {
+	if p := os.Getenv("FFPROBE_PATH"); p != "" {
+		return p
+	}
+	return "ffprobe"
+}
+
+// Probe reads tags, technical properties and embedded artwork from the audio
+// at path using ffprobe. path may also be an HTTP URL, such as a presigned
+// MinIO URL, in which case ffprobe only fetches what it needs.
+func Probe(ctx context.Context, path string) (*Metadata, error) {
+	cmd := exec.CommandContext(ctx, ffprobePath(),
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	meta, cover, err := ParseProbe(stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if cover.ContentType != "" {
+		art, err := extractCoverArt(ctx, path, cover.Index)
+		if err == nil && len(art) > 0 {
+			meta.CoverArt = art
+			meta.CoverArtType = cover.ContentType
+		}
+	}
+	return meta, nil
+}
+
+// CoverArtStream is the embedded picture ParseProbe found. ContentType is
+// empty when there is none in a format we accept.
+type CoverArtStream struct {
+	Index       int
+	ContentType string
+}
+
+// ParseProbe reads the JSON ffprobe prints for -show_format -show_streams.
+// Tags found on the container win over those on the audio stream.
+func ParseProbe(output []byte) (*Metadata, CoverArtStream, error) {
+	var out probeOutput
+	if err := json.Unmarshal(output, &out); err != nil {
+		return nil, CoverArtStream{}, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	tags := map[string]string{}
+	mergeTags(tags, out.Format.Tags)
+
+	meta := &Metadata{}
+	meta.Audio.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	meta.Audio.Bitrate, _ = strconv.Atoi(out.Format.BitRate)
+
+	var cover CoverArtStream
+	for _, st := range out.Streams {
+		switch {
+		case st.CodecType == "audio" && meta.Audio.SampleRate == 0:
+			// Ogg and Opus keep their Vorbis comments on the stream.
+			mergeTags(tags, st.Tags)
+			meta.Audio.SampleRate, _ = strconv.Atoi(st.SampleRate)
+			meta.Audio.Channels = st.Channels
+			if meta.Audio.Bitrate == 0 {
+				meta.Audio.Bitrate, _ = strconv.Atoi(st.BitRate)
+			}
+		case st.CodecType == "video" && st.Disposition.AttachedPic == 1 && cover.ContentType == "":
+			if contentType, ok := coverArtTypes[st.CodecName]; ok {
+				cover = CoverArtStream{Index: st.Index, ContentType: contentType}
+			}
+		}
+	}
+
+	meta.Title = firstTag(tags, "title")
+	meta.Artist = firstTag(tags, "artist", "album_artist")
+	meta.Album = firstTag(tags, "album")
+	meta.Genre = firstTag(tags, "genre")
+	meta.ReleaseDate = parseTagDate(firstTag(tags, "date", "originaldate", "year", "tdrc", "tyer"))
+
+	return meta, cover, nil
+}
+
+var coverArtTypes = map[string]string{
+	"mjpeg": "image/jpeg",
+	"png":   "image/png",
+}
+
+func extractCoverArt(ctx context.Context, path string, streamIndex int) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath(),
+		"-v", "error",
+		"-i", path,
+		"-map", fmt.Sprintf("0:%d", streamIndex),
+		"-c", "copy",
+		"-f", "image2pipe",
+		"-",
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// mergeTags adds src to dst with lower-cased keys, keeping values already set.
+func mergeTags(dst, src map[string]string) {
+	for k, v := range src {
+		k = strings.ToLower(k)
+		if _, ok := dst[k]; !ok && strings.TrimSpace(v) != "" {
+			dst[k] = strings.TrimSpace(v)
+		}
+	}
+}
+
+func firstTag(tags map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := tags[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseTagDate(s string) time.Time {
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if len(s) >= len(layout) {
+			if t, err := time.Parse(layout, s[:len(layout)]); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// SpoolTemp copies r into a temporary file and returns its path. The caller
+// removes the file when done.
+func SpoolTemp(r io.Reader, pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
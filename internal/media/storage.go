@@ -0,0 +1,92 @@
+// Package media processes uploaded audio and artwork.
+package media
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"rr-backend/internal/database"
+)
+
+// DownloadObject copies an object into a local file so external tools can
+// seek in it freely.
+func DownloadObject(ctx context.Context, minioService database.MinIOService, bucketName, objectName, dst string) error {
+	info, err := minioService.StatObject(ctx, bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if info.Size == 0 {
+		return nil
+	}
+	body, err := minioService.GetObjectRange(ctx, bucketName, objectName, 0, info.Size-1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func uploadFile(minioService database.MinIOService, bucketName, objectName, src, contentType string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = minioService.UploadObject(bucketName, objectName, f, st.Size(), contentType)
+	return err
+}
+
+// ObjectReaderAt reads an object of the given size through ranged requests,
+// so headers can be validated without downloading the whole object.
+func ObjectReaderAt(ctx context.Context, minioService database.MinIOService, bucketName, objectName string, size int64) io.ReaderAt {
+	return &objectReaderAt{ctx: ctx, minio: minioService, bucket: bucketName, object: objectName, size: size}
+}
+
+type objectReaderAt struct {
+	ctx    context.Context
+	minio  database.MinIOService
+	bucket string
+	object string
+	size   int64
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p)) - 1
+	if end >= r.size {
+		end = r.size - 1
+	}
+	body, err := r.minio.GetObjectRange(r.ctx, r.bucket, r.object, off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,109 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/database"
+)
+
+// ThumbnailSizes are the edge lengths, in pixels, of the derivatives made for
+// every thumbnail. Images are scaled to fit, never cropped or enlarged.
+var ThumbnailSizes = []int{64, 300, 640}
+
+// ThumbnailFormats are the formats every derivative is stored in.
+var ThumbnailFormats = []Format{FormatJPEG, FormatWebP}
+
+// ThumbnailVariantName is where the derivative of the thumbnail stored at
+// original lives: next to it, e.g. thumbnails/<song_id>/<hash>_300.webp.
+func ThumbnailVariantName(original string, size int, format Format) string {
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(original, path.Ext(original)), size, format.Ext)
+}
+
+// ThumbnailVariants lists every derivative name of original.
+func ThumbnailVariants(original string) []string {
+	var names []string
+	for _, size := range ThumbnailSizes {
+		for _, format := range ThumbnailFormats {
+			names = append(names, ThumbnailVariantName(original, size, format))
+		}
+	}
+	return names
+}
+
+// ThumbnailSizeFor picks the smallest derivative at least as large as
+// requested, or the largest one.
+func ThumbnailSizeFor(requested int) int {
+	for _, size := range ThumbnailSizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+// GenerateThumbnails renders every derivative of the thumbnail stored at
+// objectName and stores it next to the original. Derivatives that were
+// already stored are removed again if a later one fails.
+func GenerateThumbnails(ctx context.Context, dbService database.ScyllaService, minioService database.MinIOService, objectName string) error {
+	workDir, err := os.MkdirTemp("", "thumbnails-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	source := filepath.Join(workDir, "source")
+	if err := DownloadObject(ctx, minioService, "music", objectName, source); err != nil {
+		return fmt.Errorf("download %s: %w", objectName, err)
+	}
+
+	var stored []string
+	for _, size := range ThumbnailSizes {
+		for _, format := range ThumbnailFormats {
+			variant := ThumbnailVariantName(objectName, size, format)
+			dst := filepath.Join(workDir, path.Base(variant))
+			err := resizeImage(ctx, source, dst, size, format)
+			if err == nil {
+				stored = append(stored, variant)
+				err = uploadFile(minioService, "music", variant, dst, format.ContentType)
+			}
+			if err != nil {
+				cleanup.RemoveObjects(ctx, dbService, minioService, "music", "failed thumbnail generation for "+objectName, stored...)
+				return fmt.Errorf("%s: %w", path.Base(variant), err)
+			}
+		}
+	}
+	return nil
+}
+
+func resizeImage(ctx context.Context, source, dst string, size int, format Format) error {
+	args := []string{
+		"-v", "error", "-y",
+		"-i", source,
+		"-frames:v", "1",
+		// Fit inside size x size without upscaling small originals.
+		"-vf", fmt.Sprintf("scale=w='min(%d,iw)':h='min(%d,ih)':force_original_aspect_ratio=decrease", size, size),
+	}
+	switch format {
+	case FormatWebP:
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	case FormatJPEG:
+		args = append(args, "-q:v", "3")
+	}
+	args = append(args, dst)
+
+	cmd := exec.CommandContext(ctx, ffmpegPath(), args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
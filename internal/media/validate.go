@@ -0,0 +1,399 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"rr-backend/internal/models"
+)
+
+// FieldError describes why one upload field was rejected. Status is the HTTP
+// status the handler should answer with when this is the first error.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"-"`
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Format is a file type we accept, identified from its content.
+type Format struct {
+	Name        string
+	ContentType string
+	Ext         string
+}
+
+var (
+	FormatMP3  = Format{"mp3", "audio/mpeg", ".mp3"}
+	FormatAAC  = Format{"aac", "audio/aac", ".aac"}
+	FormatFLAC = Format{"flac", "audio/flac", ".flac"}
+	FormatOgg  = Format{"ogg", "audio/ogg", ".ogg"}
+	FormatWAV  = Format{"wav", "audio/wav", ".wav"}
+	FormatM4A  = Format{"m4a", "audio/mp4", ".m4a"}
+
+	FormatJPEG = Format{"jpeg", "image/jpeg", ".jpg"}
+	FormatPNG  = Format{"png", "image/png", ".png"}
+	FormatWebP = Format{"webp", "image/webp", ".webp"}
+)
+
+// UploadLimits caps upload sizes in bytes.
+type UploadLimits struct {
+	Audio int64
+	Image int64
+}
+
+// uploadLimits lists every role. Moderators do not publish music of their
+// own, so they get no more room than listeners.
+var uploadLimits = map[string]UploadLimits{
+	models.RoleListener:  {Audio: 50 << 20, Image: 5 << 20},
+	models.RoleArtist:    {Audio: 300 << 20, Image: 10 << 20},
+	models.RoleModerator: {Audio: 50 << 20, Image: 5 << 20},
+	models.RoleAdmin:     {Audio: 1 << 30, Image: 20 << 20},
+}
+
+// UploadLimitsFor returns the limits of a role, falling back to the most
+// restrictive ones for unknown roles.
+func UploadLimitsFor(role string) UploadLimits {
+	if l, ok := uploadLimits[role]; ok {
+		return l
+	}
+	return uploadLimits[models.RoleListener]
+}
+
+const maxImageDimension = 8000
+
+// ValidateAudio identifies the audio format from its magic bytes and decodes
+// enough of its headers to reject corrupt or truncated files.
+func ValidateAudio(field string, r io.ReaderAt, size, limit int64) (Format, *FieldError) {
+	if ferr := CheckSize(field, size, limit); ferr != nil {
+		return Format{}, ferr
+	}
+
+	head := make([]byte, 64)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	var (
+		format Format
+		err    error
+	)
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		format, err = FormatFLAC, checkFLAC(r)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		format, err = FormatOgg, checkOgg(r)
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		format, err = FormatWAV, checkWAV(r, size)
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		format, err = FormatM4A, checkMP4(r, size)
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		format, err = FormatAAC, checkADTS(head)
+	case bytes.HasPrefix(head, []byte("ID3")) || (len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0):
+		format, err = FormatMP3, checkMP3(r, size)
+	default:
+		return Format{}, &FieldError{Field: field, Code: "unsupported_format", Message: "File is not a supported audio format (MP3, AAC, FLAC, Ogg, WAV, M4A)", Status: http.StatusUnsupportedMediaType}
+	}
+	if err != nil {
+		return Format{}, &FieldError{Field: field, Code: "corrupt_file", Message: fmt.Sprintf("Invalid %s file: %v", format.Name, err), Status: http.StatusUnprocessableEntity}
+	}
+	return format, nil
+}
+
+// ValidateImage identifies a JPEG, PNG or WebP image and decodes its header.
+func ValidateImage(field string, r io.ReaderAt, size, limit int64) (Format, *FieldError) {
+	if ferr := CheckSize(field, size, limit); ferr != nil {
+		return Format{}, ferr
+	}
+
+	head := make([]byte, 16)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	var (
+		format        Format
+		width, height int
+		err           error
+	)
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		format = FormatJPEG
+		width, height, err = decodeImageConfig(r, size)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		format = FormatPNG
+		width, height, err = decodeImageConfig(r, size)
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		format = FormatWebP
+		width, height, err = webpSize(r)
+	default:
+		return Format{}, &FieldError{Field: field, Code: "unsupported_format", Message: "File is not a supported image format (JPEG, PNG, WebP)", Status: http.StatusUnsupportedMediaType}
+	}
+	if err == nil && (width <= 0 || height <= 0 || width > maxImageDimension || height > maxImageDimension) {
+		err = fmt.Errorf("dimensions %dx%d out of range", width, height)
+	}
+	if err != nil {
+		return Format{}, &FieldError{Field: field, Code: "corrupt_file", Message: fmt.Sprintf("Invalid %s image: %v", format.Name, err), Status: http.StatusUnprocessableEntity}
+	}
+	return format, nil
+}
+
+// CheckSize rejects empty files and files above limit. A limit of zero means
+// no limit.
+func CheckSize(field string, size, limit int64) *FieldError {
+	if size <= 0 {
+		return &FieldError{Field: field, Code: "empty_file", Message: "File is empty", Status: http.StatusBadRequest}
+	}
+	if limit > 0 && size > limit {
+		return &FieldError{Field: field, Code: "too_large", Message: fmt.Sprintf("File exceeds the %d MB limit", limit>>20), Status: http.StatusRequestEntityTooLarge}
+	}
+	return nil
+}
+
+func decodeImageConfig(r io.ReaderAt, size int64) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+func webpSize(r io.ReaderAt) (int, int, error) {
+	b := make([]byte, 30)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return 0, 0, fmt.Errorf("truncated header")
+	}
+	switch string(b[12:16]) {
+	case "VP8 ":
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			return 0, 0, fmt.Errorf("bad VP8 start code")
+		}
+		return int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff), int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff), nil
+	case "VP8L":
+		if b[20] != 0x2f {
+			return 0, 0, fmt.Errorf("bad VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		w := int(b[24]) | int(b[25])<<8 | int(b[26])<<16
+		h := int(b[27]) | int(b[28])<<8 | int(b[29])<<16
+		return w + 1, h + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown chunk %q", b[12:16])
+}
+
+func checkFLAC(r io.ReaderAt) error {
+	b := make([]byte, 4+4+34)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return fmt.Errorf("truncated STREAMINFO")
+	}
+	if b[4]&0x7f != 0 {
+		return fmt.Errorf("first metadata block is not STREAMINFO")
+	}
+	if length := int(b[5])<<16 | int(b[6])<<8 | int(b[7]); length != 34 {
+		return fmt.Errorf("STREAMINFO has length %d", length)
+	}
+	info := b[8:]
+	sampleRate := int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+	if sampleRate == 0 {
+		return fmt.Errorf("sample rate is zero")
+	}
+	return nil
+}
+
+func checkOgg(r io.ReaderAt) error {
+	hdr := make([]byte, 27)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return fmt.Errorf("truncated page header")
+	}
+	if hdr[4] != 0 {
+		return fmt.Errorf("unsupported Ogg version %d", hdr[4])
+	}
+	segments := int(hdr[26])
+	if segments == 0 {
+		return fmt.Errorf("first page has no segments")
+	}
+	packet := make([]byte, 8)
+	if _, err := r.ReadAt(packet, int64(27+segments)); err != nil {
+		return fmt.Errorf("truncated first packet")
+	}
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")),
+		bytes.HasPrefix(packet, []byte("OpusHead")),
+		bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		return nil
+	}
+	return fmt.Errorf("stream does not contain Vorbis, Opus or FLAC audio")
+}
+
+func checkWAV(r io.ReaderAt, size int64) error {
+	offset := int64(12)
+	chunk := make([]byte, 8)
+	for offset+8 <= size {
+		if _, err := r.ReadAt(chunk, offset); err != nil {
+			return fmt.Errorf("truncated chunk header")
+		}
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if string(chunk[:4]) == "fmt " {
+			if length < 16 {
+				return fmt.Errorf("fmt chunk too short")
+			}
+			fmtChunk := make([]byte, 16)
+			if _, err := r.ReadAt(fmtChunk, offset+8); err != nil {
+				return fmt.Errorf("truncated fmt chunk")
+			}
+			channels := binary.LittleEndian.Uint16(fmtChunk[2:4])
+			sampleRate := binary.LittleEndian.Uint32(fmtChunk[4:8])
+			if channels == 0 || sampleRate == 0 {
+				return fmt.Errorf("fmt chunk has %d channels at %d Hz", channels, sampleRate)
+			}
+			return nil
+		}
+		offset += 8 + length + length%2
+	}
+	return fmt.Errorf("missing fmt chunk")
+}
+
+// checkMP4 walks the top-level boxes and requires a moov box, without which
+// the file cannot be played.
+func checkMP4(r io.ReaderAt, size int64) error {
+	offset := int64(0)
+	box := make([]byte, 16)
+	for offset+8 <= size {
+		if _, err := r.ReadAt(box[:8], offset); err != nil {
+			return fmt.Errorf("truncated box header")
+		}
+		length := int64(binary.BigEndian.Uint32(box[:4]))
+		name := string(box[4:8])
+		switch length {
+		case 0:
+			length = size - offset
+		case 1:
+			if _, err := r.ReadAt(box[8:16], offset+8); err != nil {
+				return fmt.Errorf("truncated box header")
+			}
+			length = int64(binary.BigEndian.Uint64(box[8:16]))
+		}
+		if length < 8 || offset+length > size {
+			return fmt.Errorf("box %q has invalid size %d", name, length)
+		}
+		if name == "moov" {
+			return nil
+		}
+		offset += length
+	}
+	return fmt.Errorf("missing moov box")
+}
+
+func checkADTS(head []byte) error {
+	if len(head) < 7 {
+		return fmt.Errorf("truncated ADTS header")
+	}
+	if head[2]>>2&0x0f > 12 {
+		return fmt.Errorf("invalid sampling frequency index")
+	}
+	if frameLen := int(head[3]&0x03)<<11 | int(head[4])<<3 | int(head[5])>>5; frameLen < 7 {
+		return fmt.Errorf("invalid frame length %d", frameLen)
+	}
+	return nil
+}
+
+var (
+	mp3Bitrates = [2][3][16]int{
+		{ // MPEG-1 layers I, II, III
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+		{ // MPEG-2 and 2.5 layers I, II, III
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// mp3FrameLength decodes an MPEG audio frame header and returns the length of
+// the frame in bytes, or 0 if the header is invalid.
+func mp3FrameLength(h []byte) int {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return 0
+	}
+	version := h[1] >> 3 & 0x03
+	layer := h[1] >> 1 & 0x03
+	bitrateIndex := h[2] >> 4
+	rateIndex := h[2] >> 2 & 0x03
+	padding := int(h[2] >> 1 & 0x01)
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return 0
+	}
+
+	table := 0
+	if version != 3 {
+		table = 1
+	}
+	layerIndex := 3 - int(layer) // layer bits: 3 = I, 2 = II, 1 = III
+	bitrate := mp3Bitrates[table][layerIndex][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[version][rateIndex]
+
+	switch {
+	case layerIndex == 0:
+		return (12*bitrate/sampleRate + padding) * 4
+	case layerIndex == 2 && version != 3:
+		return 72*bitrate/sampleRate + padding
+	default:
+		return 144*bitrate/sampleRate + padding
+	}
+}
+
+// checkMP3 skips an ID3v2 tag and requires two consecutive valid MPEG frame
+// headers near the start of the audio data.
+func checkMP3(r io.ReaderAt, size int64) error {
+	start := int64(0)
+	id3 := make([]byte, 10)
+	if n, _ := r.ReadAt(id3, 0); n == 10 && bytes.HasPrefix(id3, []byte("ID3")) {
+		tagSize := int64(id3[6]&0x7f)<<21 | int64(id3[7]&0x7f)<<14 | int64(id3[8]&0x7f)<<7 | int64(id3[9]&0x7f)
+		start = 10 + tagSize
+		if id3[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	if start >= size {
+		return fmt.Errorf("no audio after ID3 tag")
+	}
+
+	const window = 64 << 10
+	buf := make([]byte, window)
+	n, _ := r.ReadAt(buf, start)
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		length := mp3FrameLength(buf[i:])
+		if length == 0 {
+			continue
+		}
+		next := int64(i + length)
+		if start+next == size {
+			return nil // a single-frame file
+		}
+		h := make([]byte, 4)
+		if _, err := r.ReadAt(h, start+next); err == nil && mp3FrameLength(h) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid MPEG audio frames found")
+}
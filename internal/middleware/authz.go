@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"rr-backend/internal/authz"
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+// Loader fetches the resource a request addresses. It returns an HTTP error
+// for malformed IDs and resources that do not exist.
+type Loader func(c echo.Context) (*authz.Resource, error)
+
+// Authorize loads the resource a request addresses and lets the request
+// through only if policy allows the action. It runs after JWTMiddleware or
+// OptionalJWTMiddleware; requests without a user are judged as anonymous.
+// Resources the user may not even view are reported as not found, so their
+// existence does not leak.
+func Authorize(dbService database.ScyllaService, load Loader, policy authz.Policy, action authz.Action) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			resource, err := load(c)
+			if err != nil {
+				return err
+			}
+
+			user, err := CurrentUser(c, dbService)
+			if err != nil {
+				return err
+			}
+
+			if !policy(user, *resource, action) {
+				if !policy(user, *resource, authz.View) {
+					return echo.NewHTTPError(http.StatusNotFound, strings.ToUpper(resource.Kind[:1])+resource.Kind[1:]+" not found")
+				}
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Unauthorized to %s this %s", action, resource.Kind))
+			}
+			return next(c)
+		}
+	}
+}
+
+// PlaylistResource loads the playlist named by :playlist_id. Members count
+// once they accepted their invitation: editors as collaborators, the rest
+// as viewers. A ?share= token checked against shareLinks marks the request
+// as coming through a share link.
+func PlaylistResource(dbService database.ScyllaService, shareLinks *authz.ShareLinks) Loader {
+	return func(c echo.Context) (*authz.Resource, error) {
+		playlistID, err := gocql.ParseUUID(c.Param("playlist_id"))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid playlist ID")
+		}
+		playlist, err := dbService.GetPlaylist(playlistID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist")
+		}
+		if playlist == nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Playlist not found")
+		}
+		members, err := dbService.GetPlaylistMembers(playlistID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get playlist members")
+		}
+		resource := &authz.Resource{
+			Kind:       authz.KindPlaylist,
+			ID:         playlistID.String(),
+			OwnerID:    playlist.UserID,
+			Visibility: playlist.Visibility,
+		}
+		for _, member := range members {
+			switch {
+			case member.Status != models.MemberAccepted:
+			case member.Role == models.PlaylistEditor:
+				resource.Collaborators = append(resource.Collaborators, member.UserID)
+			default:
+				resource.Viewers = append(resource.Viewers, member.UserID)
+			}
+		}
+		if token := c.QueryParam("share"); token != "" && shareLinks != nil {
+			resource.LinkShared = shareLinks.Valid(token, playlistID, time.Now())
+		}
+		return resource, nil
+	}
+}
+
+// SongResource loads the song named by :song_id.
+func SongResource(dbService database.ScyllaService) Loader {
+	return func(c echo.Context) (*authz.Resource, error) {
+		songID, err := gocql.ParseUUID(c.Param("song_id"))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid song ID")
+		}
+		ownerID, err := dbService.GetSongUserID(songID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get song owner")
+		}
+		if ownerID == "" {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Song not found")
+		}
+		return &authz.Resource{Kind: authz.KindSong, ID: songID.String(), OwnerID: ownerID}, nil
+	}
+}
+
+// ArtistResource loads the artist named by :artist_id. An artist owns
+// their own profile.
+func ArtistResource(dbService database.ScyllaService) Loader {
+	return func(c echo.Context) (*authz.Resource, error) {
+		artistID := c.Param("artist_id")
+		user, err := dbService.GetUserByID(artistID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get artist")
+		}
+		if user == nil || (user.Role != models.RoleArtist && user.Role != models.RoleAdmin) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Artist not found")
+		}
+		return &authz.Resource{Kind: authz.KindArtist, ID: artistID, OwnerID: artistID}, nil
+	}
+}
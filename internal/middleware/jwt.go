@@ -2,28 +2,56 @@ package middleware
 
 import (
 	"net/http"
-	firebase "rr-backend/internal/auth"
+	"rr-backend/internal/auth"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
-func JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "No ID token provided")
-		}
+// IdentityKey is where the JWT middlewares keep the *auth.Identity of the
+// verified token, for handlers that need more of its claims than the UID.
+const IdentityKey = "identity"
 
-		idToken := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := firebase.AuthClient.VerifyIDToken(c.Request().Context(), idToken)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired ID token")
+func JWTMiddleware(verifier auth.TokenVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "No ID token provided")
+			}
+			if err := verifyIDToken(c, verifier, authHeader); err != nil {
+				return err
+			}
+			return next(c)
 		}
+	}
+}
 
-		// Store UID for handler
-		c.Set("userID", token.UID)
+// OptionalJWTMiddleware identifies the user when the request carries an ID
+// token and lets anonymous requests through. A token that fails
+// verification is still rejected rather than treated as anonymous.
+func OptionalJWTMiddleware(verifier auth.TokenVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if authHeader := c.Request().Header.Get("Authorization"); authHeader != "" {
+				if err := verifyIDToken(c, verifier, authHeader); err != nil {
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
 
-		return next(c)
+func verifyIDToken(c echo.Context, verifier auth.TokenVerifier, authHeader string) error {
+	idToken := strings.TrimPrefix(authHeader, "Bearer ")
+	identity, err := verifier.VerifyIDToken(c.Request().Context(), idToken)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired ID token")
 	}
+
+	// Store UID for handler
+	c.Set("userID", identity.UserID)
+	c.Set(IdentityKey, identity)
+	return nil
 }
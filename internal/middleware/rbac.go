@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"rr-backend/internal/authz"
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// userKey is where CurrentUser caches the signed-in user of a request.
+const userKey = "user"
+
+// CurrentUser returns the signed-in user, or nil for anonymous requests and
+// users who have no profile yet. The user is loaded once per request and
+// shared by every middleware and handler that asks.
+func CurrentUser(c echo.Context, dbService database.ScyllaService) (*models.User, error) {
+	if user, ok := c.Get(userKey).(*models.User); ok {
+		return user, nil
+	}
+	userID, _ := c.Get("userID").(string)
+	if userID == "" {
+		return nil, nil
+	}
+	user, err := dbService.GetUserByID(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user")
+	}
+	c.Set(userKey, user)
+	return user, nil
+}
+
+// RejectBlocked turns away suspended and banned users. It runs after
+// JWTMiddleware or OptionalJWTMiddleware and lets anonymous requests and
+// users without a profile through.
+func RejectBlocked(dbService database.ScyllaService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := CurrentUser(c, dbService)
+			if err != nil {
+				return err
+			}
+			if user != nil && user.Blocked(time.Now()) {
+				if user.Status == models.UserBanned {
+					return echo.NewHTTPError(http.StatusForbidden, "Account banned")
+				}
+				return echo.NewHTTPError(http.StatusForbidden, "Account suspended")
+			}
+			return next(c)
+		}
+	}
+}
+
+// Require lets a request through only if the role of the signed-in user
+// grants permission. It runs after JWTMiddleware.
+func Require(dbService database.ScyllaService, permission authz.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := CurrentUser(c, dbService)
+			if err != nil {
+				return err
+			}
+			if !authz.UserCan(user, permission) {
+				return echo.NewHTTPError(http.StatusForbidden, "Unauthorized")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"rr-backend/internal/database"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	echomw "github.com/labstack/echo/v4/middleware"
+)
+
+// uploadOverhead is room for the form fields and multipart boundaries sent
+// along with the files.
+const uploadOverhead = 1 << 20
+
+// UploadBodyLimit caps the request body at the largest upload the role of the
+// signed-in user allows, a song and its artwork, so oversized requests are
+// cut off while they are read rather than after they were spooled to disk.
+// The handlers still check each file against its own limit. It runs after
+// JWTMiddleware.
+func UploadBodyLimit(dbService database.ScyllaService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		limited := map[string]echo.HandlerFunc{}
+		for _, role := range []string{models.RoleListener, models.RoleArtist, models.RoleModerator, models.RoleAdmin} {
+			limits := media.UploadLimitsFor(role)
+			limit := strconv.FormatInt(limits.Audio+limits.Image+uploadOverhead, 10)
+			limited[role] = echomw.BodyLimit(limit)(next)
+		}
+		return func(c echo.Context) error {
+			user, err := CurrentUser(c, dbService)
+			if err != nil {
+				return err
+			}
+			if user != nil && limited[user.Role] != nil {
+				return limited[user.Role](c)
+			}
+			return limited[models.DefaultRole](c)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// AuditEvent is one entry of the audit log: who did what to which target,
+// from where, and how the target looked before and after. Events are only
+// ever appended.
+type AuditEvent struct {
+	EventID    gocql.UUID `json:"event_id"` // time based
+	At         time.Time  `json:"at"`
+	ActorID    string     `json:"actor_id"`
+	Action     string     `json:"action"`
+	TargetType string     `json:"target_type"`
+	TargetID   string     `json:"target_id"`
+	// Before and After are JSON snapshots of the target, empty when it did
+	// not exist on that side of the action.
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+// AuditFilter narrows a listing of the audit log. Empty fields match
+// everything; Before continues a listing after its last event.
+type AuditFilter struct {
+	ActorID    string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+	Before     *gocql.UUID
+}
+
+// Audited actions.
+const (
+	ActionChangeRole       = "user.change_role"
+	ActionSuspendUser      = "user.suspend"
+	ActionBanUser          = "user.ban"
+	ActionReinstateUser    = "user.reinstate"
+	ActionApproveArtist    = "artist_application.approve"
+	ActionRejectArtist     = "artist_application.reject"
+	ActionFollowArtist     = "artist.follow"
+	ActionUnfollowArtist   = "artist.unfollow"
+	ActionRemoveSong       = "song.remove"
+	ActionTakeDownSong     = "song.take_down"
+	ActionRestoreSong      = "song.restore"
+	ActionRemovePlaylist   = "playlist.remove"
+	ActionRefreshFollowers = "artists.refresh_followers"
+)
+
+// Kinds of audit targets.
+const (
+	TargetUser     = "user"
+	TargetArtist   = "artist"
+	TargetSong     = "song"
+	TargetPlaylist = "playlist"
+	TargetArtists  = "artists"
+)
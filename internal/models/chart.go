@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Chart is a precomputed ranking, stored as a snapshot by the charts job.
+type Chart struct {
+	Name       string       `json:"chart"`
+	ComputedAt time.Time    `json:"computed_at"`
+	Entries    []ChartEntry `json:"entries"`
+}
+
+// ChartEntry is one place in a chart. Song charts fill the song fields and
+// artist charts the artist fields.
+type ChartEntry struct {
+	Rank      int     `json:"rank"`
+	SongID    string  `json:"song_id,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Genre     string  `json:"genre,omitempty"`
+	ArtistID  string  `json:"artist_id"`
+	Artist    string  `json:"artist,omitempty"`
+	Plays     int64   `json:"plays"`
+	Followers int     `json:"followers,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+}
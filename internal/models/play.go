@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Play is one qualified play in a user's listening history.
+type Play struct {
+	SongID          string    `json:"song_id"`
+	PlayedAt        time.Time `json:"played_at"`
+	ListenedSeconds float64   `json:"listened_seconds"`
+	Song            *Song     `json:"song,omitempty"` // nil once the song has been removed
+}
@@ -1,10 +1,74 @@
 package models
 
-import "github.com/gocql/gocql"
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
 
 type Playlist struct {
 	PlaylistID  gocql.UUID `json:"playlist_id"`
 	UserID      string     `json:"user_id"`
 	Name        string     `json:"name"`
 	Description string     `json:"description"`
+	Visibility  string     `json:"visibility"`
+	// MemberRole is set on playlists listed because they are shared with
+	// the user: the role they were given.
+	MemberRole string `json:"member_role,omitempty"`
+}
+
+// Playlist visibilities. Public playlists are listed on their owner's
+// profile and searchable; unlisted ones are reachable through a share link;
+// private ones only by their owner and members.
+const (
+	PlaylistPublic   = "public"
+	PlaylistPrivate  = "private"
+	PlaylistUnlisted = "unlisted"
+)
+
+// ValidVisibility reports whether v is one of the playlist visibilities.
+func ValidVisibility(v string) bool {
+	return v == PlaylistPublic || v == PlaylistPrivate || v == PlaylistUnlisted
+}
+
+// Playlist member roles and invitation states.
+const (
+	PlaylistEditor = "editor"
+	PlaylistViewer = "viewer"
+
+	MemberInvited  = "invited"
+	MemberAccepted = "accepted"
+)
+
+// PlaylistMember is a user a playlist is shared with.
+type PlaylistMember struct {
+	PlaylistID gocql.UUID `json:"playlist_id"`
+	UserID     string     `json:"user_id"`
+	Role       string     `json:"role"`
+	Status     string     `json:"status"`
+	InvitedBy  string     `json:"invited_by"`
+	InvitedAt  time.Time  `json:"invited_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// PlaylistInvitation is a pending invitation to a playlist, as listed to the
+// invited user.
+type PlaylistInvitation struct {
+	Playlist  Playlist  `json:"playlist"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invited_by"`
+	InvitedAt time.Time `json:"invited_at"`
+}
+
+// PlaylistTrack is one entry of a playlist. The same song may appear more
+// than once; EntryID tells the entries apart. Position only orders entries
+// and has gaps; clients address entries by their index in the playlist.
+type PlaylistTrack struct {
+	PlaylistID gocql.UUID `json:"-"`
+	Position   int64      `json:"-"`
+	EntryID    gocql.UUID `json:"entry_id"`
+	SongID     gocql.UUID `json:"song_id"`
+	AddedAt    time.Time  `json:"added_at"`
+	AddedBy    string     `json:"added_by"`
+	Song       *Song      `json:"song"` // nil once the song was removed or, in listings, is not available
 }
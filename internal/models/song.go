@@ -12,7 +12,30 @@ type Song struct {
 	SongURL      string    `json:"song_url"`
 	ThumbnailURL string    `json:"thumbnail_url"`
 	PlayCount    int       `json:"play_count"`
+	Status       string    `json:"status,omitempty"` // empty while the song is available
+	// HLSManifest is the master playlist object, empty until the song has
+	// been packaged. Clients reach it through /music/stream/:song_id.
+	HLSManifest string `json:"-"`
+	AudioInfo
 }
+
+// Song statuses.
+const (
+	// SongStatusUnavailable marks a song whose audio is missing from storage.
+	SongStatusUnavailable = "unavailable"
+	// SongStatusTakenDown marks a song a moderator took down. It cannot be
+	// streamed until it is restored.
+	SongStatusTakenDown = "taken_down"
+)
+
+// AudioInfo holds the technical properties read from the audio stream.
+type AudioInfo struct {
+	Duration   float64 `json:"duration"`    // seconds
+	Bitrate    int     `json:"bitrate"`     // bits per second
+	SampleRate int     `json:"sample_rate"` // Hz
+	Channels   int     `json:"channels"`
+}
+
 type SongUpload struct {
 	SongID       string    `json:"song_id"`
 	Title        string    `json:"title"`
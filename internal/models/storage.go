@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// PendingDeletion is a storage object whose removal failed and is retried by
+// the cleanup worker. An ObjectName ending in "/" stands for every object
+// under that prefix.
+type PendingDeletion struct {
+	Bucket        string    `json:"bucket"`
+	ObjectName    string    `json:"object_name"`
+	Reason        string    `json:"reason"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	CreatedAt     time.Time `json:"created_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// Media jobs the backfill retries; see MediaFailure.
+const (
+	MediaJobHLS        = "hls"
+	MediaJobThumbnails = "thumbnails"
+)
+
+// MediaFailure is a media job the backfill could not finish for a song. It
+// is retried with backoff until it succeeds or runs out of attempts.
+type MediaFailure struct {
+	Job           string    `json:"job"`
+	SongID        string    `json:"song_id"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
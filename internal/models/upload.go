@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Upload tracks a resumable (tus) upload while its chunks arrive, or a
+// presigned upload until the client reports it complete. Presigned uploads
+// have no MultipartID.
+type Upload struct {
+	UploadID        gocql.UUID        `json:"upload_id"`
+	UserID          string            `json:"user_id"`
+	ObjectName      string            `json:"-"` // staging object receiving the upload
+	MultipartID     string            `json:"-"` // MinIO multipart upload ID
+	ThumbnailObject string            `json:"-"` // staging object of a presigned thumbnail, if any
+	Length          int64             `json:"upload_length"`
+	Offset          int64             `json:"upload_offset"`
+	Parts           map[int]string    `json:"-"` // part number -> ETag
+	NextPart        int               `json:"-"` // part number the next chunk claims
+	PendingObject   string            `json:"-"` // object buffering chunks below the minimum part size
+	PendingSize     int64             `json:"-"` // bytes buffered below the minimum part size
+	Metadata        map[string]string `json:"metadata"`
+	SongID          gocql.UUID        `json:"song_id"`
+	FinalizingAt    time.Time         `json:"-"` // when a request claimed turning the upload into a song
+	CreatedAt       time.Time         `json:"created_at"`
+}
@@ -1,8 +1,74 @@
 package models
 
+import "time"
+
 type User struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
+	UserID    string     `json:"user_id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	PhotoURL  string     `json:"photo_url,omitempty"`
+	Role      string     `json:"role"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// Status is empty for accounts in good standing. A suspension without
+	// SuspendedUntil lasts until an admin reinstates the user.
+	Status         string     `json:"status,omitempty"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+}
+
+// Account statuses.
+const (
+	UserSuspended = "suspended"
+	UserBanned    = "banned"
+)
+
+// Blocked reports whether the user is banned or suspended at now.
+func (u *User) Blocked(now time.Time) bool {
+	switch u.Status {
+	case UserBanned:
+		return true
+	case UserSuspended:
+		return u.SuspendedUntil == nil || now.Before(*u.SuspendedUntil)
+	}
+	return false
 }
+
+// User roles, from least to most privileged. What each may do is decided by
+// authz.Can.
+const (
+	RoleListener  = "listener"
+	RoleArtist    = "artist"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// DefaultRole is the role of a user who signs in for the first time.
+const DefaultRole = RoleListener
+
+func ValidRole(role string) bool {
+	switch role {
+	case RoleListener, RoleArtist, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// ArtistApplication is a listener's request to become an artist. Each user
+// has at most one; applying again after a rejection replaces it.
+type ArtistApplication struct {
+	UserID      string     `json:"user_id"`
+	ArtistName  string     `json:"artist_name"`
+	Message     string     `json:"message"`
+	Status      string     `json:"status"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	ReviewedBy  string     `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+}
+
+// Artist application statuses.
+const (
+	ApplicationPending  = "pending"
+	ApplicationApproved = "approved"
+	ApplicationRejected = "rejected"
+)
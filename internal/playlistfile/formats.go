@@ -0,0 +1,363 @@
+// Package playlistfile reads and writes playlists as M3U8, XSPF and JSPF
+// files and matches their entries against the catalogue, so users can move
+// playlists in from and out to other players.
+package playlistfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type Format string
+
+const (
+	M3U8 Format = "m3u8"
+	XSPF Format = "xspf"
+	JSPF Format = "jspf"
+)
+
+var ErrUnknownFormat = errors.New("unknown playlist format")
+
+// ParseFormat returns the format named name, as used in ?format=.
+func ParseFormat(name string) (Format, bool) {
+	switch f := Format(strings.ToLower(name)); f {
+	case M3U8, XSPF, JSPF:
+		return f, true
+	}
+	return "", false
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case XSPF:
+		return "application/xspf+xml"
+	case JSPF:
+		return "application/jspf+json"
+	default:
+		return "audio/x-mpegurl"
+	}
+}
+
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// Detect guesses the format of a file from its name, then from its first
+// bytes. Anything that is neither XML nor JSON is read as M3U, which may be
+// no more than a list of paths.
+func Detect(filename string, data []byte) Format {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".m3u", ".m3u8":
+		return M3U8
+	case ".xspf":
+		return XSPF
+	case ".jspf", ".json":
+		return JSPF
+	}
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, utf8BOM))
+	switch {
+	case bytes.HasPrefix(data, []byte("<")):
+		return XSPF
+	case bytes.HasPrefix(data, []byte("{")):
+		return JSPF
+	default:
+		return M3U8
+	}
+}
+
+var utf8BOM = []byte("\ufeff")
+
+// File is a playlist independent of its format.
+type File struct {
+	Title       string
+	Description string
+	Entries     []Entry
+}
+
+// Entry is one track as another player describes it. Any field may be
+// missing.
+type Entry struct {
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Location string  `json:"location,omitempty"`
+}
+
+// Write encodes file in format f.
+func Write(w io.Writer, f Format, file File) error {
+	switch f {
+	case M3U8:
+		return writeM3U8(w, file)
+	case XSPF:
+		return writeXSPF(w, file)
+	case JSPF:
+		return writeJSPF(w, file)
+	}
+	return ErrUnknownFormat
+}
+
+// Read decodes a file in format f.
+func Read(r io.Reader, f Format) (*File, error) {
+	switch f {
+	case M3U8:
+		return readM3U8(r)
+	case XSPF:
+		return readXSPF(r)
+	case JSPF:
+		return readJSPF(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// M3U8 is extended M3U in UTF-8: #EXTINF carries the duration and
+// "Artist - Title", #EXTALB the album, and the line after it the location.
+
+func writeM3U8(w io.Writer, file File) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U\n")
+	if file.Title != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", oneLine(file.Title))
+	}
+	for _, e := range file.Entries {
+		duration := -1
+		if e.Duration > 0 {
+			duration = int(math.Round(e.Duration))
+		}
+		name := e.Title
+		if e.Artist != "" {
+			name = e.Artist + " - " + e.Title
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", duration, oneLine(name))
+		if e.Album != "" {
+			fmt.Fprintf(bw, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		fmt.Fprintf(bw, "%s\n", oneLine(e.Location))
+	}
+	return bw.Flush()
+}
+
+// oneLine keeps user-provided text from starting a new M3U line.
+func oneLine(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '\r' }), " ")
+}
+
+func readM3U8(r io.Reader) (*File, error) {
+	file := &File{}
+	var pending Entry
+	var described bool // pending carries an #EXTINF
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, string(utf8BOM))
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			file.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending, described = parseExtInf(strings.TrimPrefix(line, "#EXTINF:")), true
+		case strings.HasPrefix(line, "#EXTALB:"):
+			pending.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#EXTART:"):
+			pending.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			if !described {
+				pending.Artist, pending.Title = splitName(fileTitle(line))
+			}
+			file.Entries = append(file.Entries, pending)
+			pending, described = Entry{}, false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// parseExtInf reads "<duration>[ attributes],<artist> - <title>".
+func parseExtInf(value string) Entry {
+	head, name, _ := strings.Cut(value, ",")
+	head, _, _ = strings.Cut(strings.TrimSpace(head), " ")
+	var e Entry
+	if d, err := strconv.ParseFloat(head, 64); err == nil && d > 0 {
+		e.Duration = d
+	}
+	e.Artist, e.Title = splitName(name)
+	return e
+}
+
+func splitName(name string) (artist, title string) {
+	if artist, title, ok := strings.Cut(name, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", strings.TrimSpace(name)
+}
+
+// fileTitle turns a path or URL into a title: "music/A%20-%20B.mp3" gives
+// "A - B".
+func fileTitle(location string) string {
+	name := location
+	if u, err := url.Parse(location); err == nil && u.Path != "" {
+		name = u.Path
+	}
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// XSPF is version 1 of the XML Shareable Playlist Format. Durations are in
+// milliseconds.
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName    xml.Name `xml:"playlist"`
+	Xmlns      string   `xml:"xmlns,attr,omitempty"`
+	Version    string   `xml:"version,attr"`
+	Title      string   `xml:"title,omitempty"`
+	Annotation string   `xml:"annotation,omitempty"`
+	TrackList  struct {
+		Tracks []xspfTrack `xml:"track"`
+	} `xml:"trackList"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location,omitempty"`
+	Title    string   `xml:"title,omitempty"`
+	Creator  string   `xml:"creator,omitempty"`
+	Album    string   `xml:"album,omitempty"`
+	Duration string   `xml:"duration,omitempty"`
+}
+
+func writeXSPF(w io.Writer, file File) error {
+	doc := xspfPlaylist{Xmlns: xspfNamespace, Version: "1", Title: file.Title, Annotation: file.Description}
+	for _, e := range file.Entries {
+		track := xspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: milliseconds(e.Duration)}
+		if e.Location != "" {
+			track.Location = []string{e.Location}
+		}
+		doc.TrackList.Tracks = append(doc.TrackList.Tracks, track)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func readXSPF(r io.Reader) (*File, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	file := &File{Title: strings.TrimSpace(doc.Title), Description: strings.TrimSpace(doc.Annotation)}
+	for _, t := range doc.TrackList.Tracks {
+		file.Entries = append(file.Entries, Entry{
+			Title:    strings.TrimSpace(t.Title),
+			Artist:   strings.TrimSpace(t.Creator),
+			Album:    strings.TrimSpace(t.Album),
+			Duration: seconds(t.Duration),
+			Location: firstLocation(t.Location),
+		})
+	}
+	return file, nil
+}
+
+// JSPF is XSPF written as JSON.
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title      string      `json:"title,omitempty"`
+	Annotation string      `json:"annotation,omitempty"`
+	Track      []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Location []string `json:"location,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Creator  string   `json:"creator,omitempty"`
+	Album    string   `json:"album,omitempty"`
+	Duration float64  `json:"duration,omitempty"`
+}
+
+func writeJSPF(w io.Writer, file File) error {
+	doc := jspfDocument{Playlist: jspfPlaylist{Title: file.Title, Annotation: file.Description, Track: []jspfTrack{}}}
+	for _, e := range file.Entries {
+		track := jspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: math.Round(e.Duration * 1000)}
+		if e.Location != "" {
+			track.Location = []string{e.Location}
+		}
+		doc.Playlist.Track = append(doc.Playlist.Track, track)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func readJSPF(r io.Reader) (*File, error) {
+	var doc jspfDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	file := &File{Title: strings.TrimSpace(doc.Playlist.Title), Description: strings.TrimSpace(doc.Playlist.Annotation)}
+	for _, t := range doc.Playlist.Track {
+		entry := Entry{
+			Title:    strings.TrimSpace(t.Title),
+			Artist:   strings.TrimSpace(t.Creator),
+			Album:    strings.TrimSpace(t.Album),
+			Location: firstLocation(t.Location),
+		}
+		if t.Duration > 0 {
+			entry.Duration = t.Duration / 1000
+		}
+		file.Entries = append(file.Entries, entry)
+	}
+	return file, nil
+}
+
+func milliseconds(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(math.Round(seconds*1000)), 10)
+}
+
+func seconds(milliseconds string) float64 {
+	ms, err := strconv.ParseFloat(strings.TrimSpace(milliseconds), 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return ms / 1000
+}
+
+func firstLocation(locations []string) string {
+	for _, l := range locations {
+		if l = strings.TrimSpace(l); l != "" {
+			return l
+		}
+	}
+	return ""
+}
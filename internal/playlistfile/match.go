@@ -0,0 +1,301 @@
+package playlistfile
+
+import (
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+	"rr-backend/internal/search"
+
+	"github.com/gocql/gocql"
+)
+
+// StreamPath is where a song is streamed from; exported files point there
+// and imported entries that point there are matched by song ID.
+const StreamPath = "/music/stream/"
+
+// StreamURL is the location of a song on the server at baseURL.
+func StreamURL(baseURL, songID string) string {
+	return strings.TrimSuffix(baseURL, "/") + StreamPath + songID
+}
+
+const (
+	// maxCandidates is how many search hits are scored for one entry.
+	maxCandidates = 10
+	// MinScore is the score a candidate needs to count as a match.
+	MinScore = 0.75
+	// minTitleScore stops a perfect artist and album from carrying a
+	// different song from the same record.
+	minTitleScore = 0.7
+)
+
+// Score weights. Fields missing on either side are left out and the rest
+// scaled up, so an entry with only a title can still match.
+const (
+	titleWeight    = 0.55
+	artistWeight   = 0.25
+	albumWeight    = 0.1
+	durationWeight = 0.1
+)
+
+// Matcher finds the songs in the catalogue that imported entries describe.
+// It caches artist names and is not safe for concurrent use.
+type Matcher struct {
+	db      database.ScyllaService
+	index   *search.Index
+	artists *ArtistNames
+}
+
+func NewMatcher(dbService database.ScyllaService, searchIndex *search.Index) *Matcher {
+	return &Matcher{db: dbService, index: searchIndex, artists: NewArtistNames(dbService)}
+}
+
+// MatchAll returns the best available song for each of entries, or nil where
+// nothing scores MinScore. An entry pointing at one of our stream URLs is
+// that song. Every candidate is searched for first and then loaded in a few
+// batched queries, with their artists, rather than one entry at a time.
+func (m *Matcher) MatchAll(entries []Entry) ([]*models.Song, error) {
+	streamed := make([]*gocql.UUID, len(entries))
+	candidates := make([][]gocql.UUID, len(entries))
+	var songIDs []gocql.UUID
+	for i, e := range entries {
+		if songID, ok := streamedSongID(e.Location); ok {
+			streamed[i] = &songID
+			songIDs = append(songIDs, songID)
+		}
+		title := cleanTitle(e.Title)
+		if title == "" {
+			continue
+		}
+		results, err := m.index.Search(search.Request{Query: title, Type: search.TypeSong, Limit: maxCandidates})
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range results.Hits {
+			if songID, err := gocql.ParseUUID(hit.ID); err == nil {
+				candidates[i] = append(candidates[i], songID)
+				songIDs = append(songIDs, songID)
+			}
+		}
+	}
+
+	songs, err := m.db.GetSongsByIDs(unique(songIDs))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[gocql.UUID]*models.Song, len(songs))
+	userIDs := make([]string, 0, len(songs))
+	for i := range songs {
+		// Taken down songs are never matched.
+		if songs[i].Status != "" {
+			continue
+		}
+		id, _ := gocql.ParseUUID(songs[i].SongID)
+		byID[id] = &songs[i]
+		userIDs = append(userIDs, songs[i].UserID)
+	}
+	if err := m.artists.Load(userIDs); err != nil {
+		return nil, err
+	}
+
+	matches := make([]*models.Song, len(entries))
+	for i, e := range entries {
+		if streamed[i] != nil && byID[*streamed[i]] != nil {
+			matches[i] = byID[*streamed[i]]
+			continue
+		}
+		var bestScore float64
+		for _, songID := range candidates[i] {
+			song := byID[songID]
+			if song == nil {
+				continue
+			}
+			artist, err := m.artists.Name(song.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if score := Score(e, *song, artist); score >= MinScore && score > bestScore {
+				matches[i], bestScore = song, score
+			}
+		}
+	}
+	return matches, nil
+}
+
+func unique(ids []gocql.UUID) []gocql.UUID {
+	seen := make(map[gocql.UUID]bool, len(ids))
+	var kept []gocql.UUID
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// Score rates from 0 to 1 how well song, by artist, fits e.
+func Score(e Entry, song models.Song, artist string) float64 {
+	titleScore := similarity(cleanTitle(e.Title), cleanTitle(song.Title))
+	if titleScore < minTitleScore {
+		return 0
+	}
+	total, weights := titleScore*titleWeight, titleWeight
+	if e.Artist != "" && artist != "" {
+		total += artistScore(e.Artist, artist) * artistWeight
+		weights += artistWeight
+	}
+	if e.Album != "" && song.Album != "" {
+		total += similarity(cleanTitle(e.Album), cleanTitle(song.Album)) * albumWeight
+		weights += albumWeight
+	}
+	if e.Duration > 0 && song.Duration > 0 {
+		total += durationScore(e.Duration, song.Duration) * durationWeight
+		weights += durationWeight
+	}
+	return total / weights
+}
+
+// artistScore compares artist names, also against each credited artist of
+// "A feat. B" or "A & B", since players credit collaborations differently.
+func artistScore(entryArtist, artist string) float64 {
+	best := similarity(search.Fold(entryArtist), search.Fold(artist))
+	for _, name := range artistSeparator.Split(entryArtist, -1) {
+		best = math.Max(best, similarity(search.Fold(name), search.Fold(artist)))
+	}
+	return best
+}
+
+// durationScore is 1 within a couple of seconds, as encoders and players
+// round differently, and falls to 0 at half a minute apart.
+func durationScore(a, b float64) float64 {
+	const exact, none = 2.0, 30.0
+	diff := math.Abs(a - b)
+	switch {
+	case diff <= exact:
+		return 1
+	case diff >= none:
+		return 0
+	default:
+		return 1 - (diff-exact)/(none-exact)
+	}
+}
+
+var (
+	// decoration matches bracketed parts such as "(Remastered 2011)" or
+	// "[Live]" and trailing credits such as " feat. B".
+	decoration      = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]|\s+(?i:feat\.?|ft\.?|featuring)\s.*$`)
+	artistSeparator = regexp.MustCompile(`\s*(?:,|&|(?i:\b(?:and|feat|ft|featuring)\b\.?))\s*`)
+)
+
+// cleanTitle folds a title and drops its decoration, unless that would leave
+// nothing.
+func cleanTitle(title string) string {
+	if cleaned := search.Fold(decoration.ReplaceAllString(title, "")); cleaned != "" {
+		return cleaned
+	}
+	return search.Fold(title)
+}
+
+// similarity is 1 minus the edit distance between a and b relative to the
+// longer of the two.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// streamedSongID extracts the song ID from one of our stream URLs, on any
+// host, so playlists exported from another deployment still resolve.
+func streamedSongID(location string) (gocql.UUID, bool) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return gocql.UUID{}, false
+	}
+	rest, ok := strings.CutPrefix(u.Path, StreamPath)
+	if !ok {
+		return gocql.UUID{}, false
+	}
+	id, err := gocql.ParseUUID(rest)
+	return id, err == nil
+}
+
+// ArtistNames looks up and caches the names of artists by user ID.
+type ArtistNames struct {
+	db    database.ScyllaService
+	names map[string]string
+}
+
+func NewArtistNames(dbService database.ScyllaService) *ArtistNames {
+	return &ArtistNames{db: dbService, names: map[string]string{}}
+}
+
+// Load looks up, in batches, the names of those of userIDs not cached yet.
+func (a *ArtistNames) Load(userIDs []string) error {
+	var missing []string
+	for _, userID := range userIDs {
+		if _, ok := a.names[userID]; !ok {
+			a.names[userID] = ""
+			missing = append(missing, userID)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	users, err := a.db.GetUsersByIDs(missing)
+	if err != nil {
+		for _, userID := range missing {
+			delete(a.names, userID)
+		}
+		return err
+	}
+	for _, user := range users {
+		a.names[user.UserID] = user.Username
+	}
+	return nil
+}
+
+func (a *ArtistNames) Name(userID string) (string, error) {
+	if name, ok := a.names[userID]; ok {
+		return name, nil
+	}
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	var name string
+	if user != nil {
+		name = user.Username
+	}
+	a.names[userID] = name
+	return name, nil
+}
@@ -0,0 +1,320 @@
+// Package playlists keeps playlist tracks in user-defined order.
+//
+// Every track has a position; tracks are listed by position. Positions are
+// spaced apart so that inserting or moving a track only writes that track:
+// it takes the midpoint of its new neighbours. When two neighbours are
+// adjacent the whole playlist is renumbered. Clients never see positions;
+// they address places in a playlist by index.
+//
+// Every change is written against the version of the playlist, so of two
+// concurrent changes one fails and is worked out again on a fresh copy.
+// Changes to many tracks are written in batches of at most batchSize rows.
+// A renumbering lays the playlist out below its first track, so the order
+// holds while only some of the batches have been written.
+package playlists
+
+import (
+	"errors"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// Gap is the distance between the positions of neighbouring tracks after a
+// renumbering.
+const Gap int64 = 1 << 20
+
+const (
+	// batchSize is how many rows one write deletes and inserts, which keeps
+	// batches far below batch_size_fail_threshold_in_kb.
+	batchSize = 200
+	// attempts is how often a change is worked out again after losing to a
+	// concurrent one.
+	attempts = 5
+)
+
+var (
+	ErrTrackNotFound = errors.New("track not in playlist")
+	ErrOrderMismatch = errors.New("order does not list every track of the playlist exactly once")
+	ErrConflict      = errors.New("playlist kept changing concurrently")
+)
+
+// Tracks returns the whole playlist in order.
+func Tracks(dbService database.ScyllaService, playlistID gocql.UUID) ([]models.PlaylistTrack, error) {
+	return database.All(func(limit int, pageState []byte) ([]models.PlaylistTrack, []byte, error) {
+		return dbService.GetPlaylistTracks(playlistID, limit, pageState)
+	})
+}
+
+// Insert adds songID on behalf of addedBy so that it ends up at index. An
+// index that is negative or past the end appends.
+func Insert(dbService database.ScyllaService, playlistID, songID gocql.UUID, addedBy string, index int, addedAt time.Time) (*models.PlaylistTrack, error) {
+	track := models.PlaylistTrack{
+		PlaylistID: playlistID,
+		EntryID:    gocql.UUIDFromTime(addedAt),
+		SongID:     songID,
+		AddedAt:    addedAt,
+		AddedBy:    addedBy,
+	}
+	err := change(dbService, playlistID, func(version int, tracks []models.PlaylistTrack) (bool, error) {
+		at := index
+		if at < 0 || at > len(tracks) {
+			at = len(tracks)
+		}
+		if position, ok := between(tracks, at); ok {
+			track.Position = position
+			return dbService.UpdatePlaylistTracks(playlistID, version, nil, []models.PlaylistTrack{track})
+		}
+		reordered := insertAt(tracks, at, track)
+		renumber(reordered, tracks)
+		track.Position = reordered[at].Position
+		return rewrite(dbService, playlistID, version, tracks, reordered)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// Append adds tracks to the end of the playlist in order. The tracks bring
+// their own entry IDs and those already in the playlist are skipped, so an
+// append that failed part way can simply be repeated.
+func Append(dbService database.ScyllaService, playlistID gocql.UUID, tracks []models.PlaylistTrack) error {
+	return change(dbService, playlistID, func(version int, current []models.PlaylistTrack) (bool, error) {
+		present := make(map[gocql.UUID]bool, len(current))
+		var last int64
+		for _, track := range current {
+			present[track.EntryID] = true
+			last = track.Position
+		}
+		appended := append([]models.PlaylistTrack{}, current...)
+		for _, track := range tracks {
+			if present[track.EntryID] {
+				continue
+			}
+			last += Gap
+			track.PlaylistID, track.Position, track.Song = playlistID, last, nil
+			appended = append(appended, track)
+		}
+		return rewrite(dbService, playlistID, version, current, appended)
+	})
+}
+
+// Move puts the track with entryID at index, counted as if the track had
+// already been taken out. An index that is negative or past the end moves
+// the track to the end.
+func Move(dbService database.ScyllaService, playlistID, entryID gocql.UUID, index int) (*models.PlaylistTrack, error) {
+	var moved models.PlaylistTrack
+	err := change(dbService, playlistID, func(version int, tracks []models.PlaylistTrack) (bool, error) {
+		from := indexOf(tracks, entryID)
+		if from < 0 {
+			return false, ErrTrackNotFound
+		}
+		moved = tracks[from]
+		rest := append(append([]models.PlaylistTrack{}, tracks[:from]...), tracks[from+1:]...)
+		at := index
+		if at < 0 || at > len(rest) {
+			at = len(rest)
+		}
+		if at == from {
+			return true, nil
+		}
+
+		if position, ok := between(rest, at); ok {
+			moved.Position = position
+			return dbService.UpdatePlaylistTracks(playlistID, version, tracks[from:from+1], []models.PlaylistTrack{moved})
+		}
+		reordered := insertAt(rest, at, moved)
+		renumber(reordered, tracks)
+		moved = reordered[at]
+		return rewrite(dbService, playlistID, version, tracks, reordered)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &moved, nil
+}
+
+// Remove takes a single track out of the playlist.
+func Remove(dbService database.ScyllaService, playlistID, entryID gocql.UUID) error {
+	return change(dbService, playlistID, func(version int, tracks []models.PlaylistTrack) (bool, error) {
+		i := indexOf(tracks, entryID)
+		if i < 0 {
+			return false, ErrTrackNotFound
+		}
+		return dbService.UpdatePlaylistTracks(playlistID, version, tracks[i:i+1], nil)
+	})
+}
+
+// RemoveSong takes every occurrence of songID out of the playlist and
+// returns how many there were.
+func RemoveSong(dbService database.ScyllaService, playlistID, songID gocql.UUID) (int, error) {
+	removed := 0
+	err := change(dbService, playlistID, func(version int, tracks []models.PlaylistTrack) (bool, error) {
+		var kept []models.PlaylistTrack
+		for _, track := range tracks {
+			if track.SongID != songID {
+				kept = append(kept, track)
+			}
+		}
+		removed = len(tracks) - len(kept)
+		if removed == 0 {
+			return false, ErrTrackNotFound
+		}
+		return rewrite(dbService, playlistID, version, tracks, kept)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// Reorder rewrites the playlist in the order of entryIDs, which must list
+// every track exactly once. Anything else means the client's copy is stale.
+func Reorder(dbService database.ScyllaService, playlistID gocql.UUID, entryIDs []gocql.UUID) ([]models.PlaylistTrack, error) {
+	var reordered []models.PlaylistTrack
+	err := change(dbService, playlistID, func(version int, tracks []models.PlaylistTrack) (bool, error) {
+		if len(entryIDs) != len(tracks) {
+			return false, ErrOrderMismatch
+		}
+		byEntry := make(map[gocql.UUID]models.PlaylistTrack, len(tracks))
+		for _, track := range tracks {
+			byEntry[track.EntryID] = track
+		}
+		reordered = make([]models.PlaylistTrack, 0, len(tracks))
+		for _, id := range entryIDs {
+			track, ok := byEntry[id]
+			if !ok {
+				return false, ErrOrderMismatch
+			}
+			delete(byEntry, id)
+			reordered = append(reordered, track)
+		}
+		renumber(reordered, tracks)
+		return rewrite(dbService, playlistID, version, tracks, reordered)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reordered, nil
+}
+
+// change works out a change from the current version and tracks of the
+// playlist and writes it, starting over on a fresh copy while a concurrent
+// change gets in first. apply reports whether its writes were applied.
+func change(dbService database.ScyllaService, playlistID gocql.UUID, apply func(version int, tracks []models.PlaylistTrack) (bool, error)) error {
+	for i := 0; i < attempts; i++ {
+		// The version is read first: if the tracks change in between, the
+		// write is refused rather than based on a copy newer than it claims.
+		version, err := dbService.GetPlaylistTracksVersion(playlistID)
+		if err != nil {
+			return err
+		}
+		tracks, err := Tracks(dbService, playlistID)
+		if err != nil {
+			return err
+		}
+		applied, err := apply(version, tracks)
+		if err != nil || applied {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// rewrite turns the playlist from current into tracks. Only tracks that are
+// added, removed or moved are written, a moved track's old and new row in
+// the same batch, so every batch leaves each track in the playlist exactly
+// once. It stops at the first batch that is not applied.
+func rewrite(dbService database.ScyllaService, playlistID gocql.UUID, version int, current, tracks []models.PlaylistTrack) (bool, error) {
+	before := make(map[gocql.UUID]models.PlaylistTrack, len(current))
+	for _, track := range current {
+		before[track.EntryID] = track
+	}
+	var remove, add []models.PlaylistTrack
+	// flush writes the rows collected so far once the next moved track, two
+	// rows, might not fit in the batch, or at the end.
+	flush := func(force bool) (bool, error) {
+		if len(remove)+len(add) == 0 || (!force && len(remove)+len(add)+2 <= batchSize) {
+			return true, nil
+		}
+		applied, err := dbService.UpdatePlaylistTracks(playlistID, version, remove, add)
+		if err != nil || !applied {
+			return false, err
+		}
+		version++
+		remove, add = nil, nil
+		return true, nil
+	}
+
+	for _, track := range tracks {
+		old, ok := before[track.EntryID]
+		delete(before, track.EntryID)
+		if ok && old.Position == track.Position {
+			continue
+		}
+		if ok {
+			remove = append(remove, old)
+		}
+		add = append(add, track)
+		if applied, err := flush(false); !applied {
+			return false, err
+		}
+	}
+	for _, track := range current {
+		if _, gone := before[track.EntryID]; !gone {
+			continue
+		}
+		remove = append(remove, track)
+		if applied, err := flush(false); !applied {
+			return false, err
+		}
+	}
+	return flush(true)
+}
+
+// renumber lays tracks out from scratch, ending one gap below the first of
+// the current tracks so that no new position falls among the old ones.
+func renumber(tracks, current []models.PlaylistTrack) {
+	var start int64
+	if len(current) > 0 {
+		start = current[0].Position - int64(len(tracks))*Gap
+	}
+	for i := range tracks {
+		tracks[i].Position = start + int64(i)*Gap
+	}
+}
+
+func insertAt(tracks []models.PlaylistTrack, index int, track models.PlaylistTrack) []models.PlaylistTrack {
+	return append(append(append([]models.PlaylistTrack{}, tracks[:index]...), track), tracks[index:]...)
+}
+
+// between returns a free position for a track placed at index of tracks, or
+// false when its neighbours leave no room.
+func between(tracks []models.PlaylistTrack, index int) (int64, bool) {
+	switch {
+	case len(tracks) == 0:
+		return Gap, true
+	case index == 0:
+		return tracks[0].Position - Gap, true
+	case index == len(tracks):
+		return tracks[index-1].Position + Gap, true
+	}
+	prev, next := tracks[index-1].Position, tracks[index].Position
+	if next-prev < 2 {
+		return 0, false
+	}
+	return prev + (next-prev)/2, true
+}
+
+func indexOf(tracks []models.PlaylistTrack, entryID gocql.UUID) int {
+	for i, track := range tracks {
+		if track.EntryID == entryID {
+			return i
+		}
+	}
+	return -1
+}
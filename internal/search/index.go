@@ -0,0 +1,320 @@
+// Package search keeps an embedded Bleve index of songs, artists and
+// playlists. ScyllaDB stays the source of truth: the index only holds what is
+// needed to match and rank, and can be rebuilt from the database at any time.
+package search
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"rr-backend/internal/models"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/char/asciifolding"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/gocql/gocql"
+)
+
+// Document types.
+const (
+	TypeSong     = "song"
+	TypeArtist   = "artist"
+	TypePlaylist = "playlist"
+)
+
+// foldedAnalyzer tokenizes on word boundaries and drops case and accents, so
+// "Beyoncé" matches "beyonce".
+const foldedAnalyzer = "folded"
+
+const maxFacetTerms = 20
+
+// DefaultPath is where the index lives unless SEARCH_INDEX_PATH says otherwise.
+func DefaultPath() string {
+	if p := os.Getenv("SEARCH_INDEX_PATH"); p != "" {
+		return p
+	}
+	return "search.bleve"
+}
+
+type Index struct {
+	index bleve.Index
+
+	// played holds the songs whose play count changed since the last
+	// FlushPlays.
+	mu     sync.Mutex
+	played map[gocql.UUID]bool
+}
+
+// Open opens the index at path, creating an empty one if there is none. The
+// second result reports whether it was created and still needs a rebuild.
+func Open(path string) (*Index, bool, error) {
+	idx, err := bleve.Open(path)
+	if err == nil {
+		return &Index{index: idx}, false, nil
+	}
+	if err != bleve.ErrorIndexPathDoesNotExist {
+		return nil, false, err
+	}
+	m, err := newMapping()
+	if err != nil {
+		return nil, false, err
+	}
+	idx, err = bleve.New(path, m)
+	if err != nil {
+		return nil, false, err
+	}
+	return &Index{index: idx}, true, nil
+}
+
+// NewMemory returns an index that lives in memory only.
+func NewMemory() (*Index, error) {
+	m, err := newMapping()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := bleve.NewMemOnly(m)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{index: idx}, nil
+}
+
+func (i *Index) Close() error {
+	return i.index.Close()
+}
+
+func newMapping() (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(foldedAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"char_filters":  []string{asciifolding.Name},
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.DefaultAnalyzer = foldedAnalyzer
+
+	doc := bleve.NewDocumentStaticMapping()
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = foldedAnalyzer
+	for _, field := range []string{"title", "album", "genre", "artist", "name"} {
+		doc.AddFieldMappingsAt(field, text)
+	}
+	// Exact fields used for filters, facets and hit metadata; they are not
+	// part of the free-text match.
+	keyword := bleve.NewKeywordFieldMapping()
+	keyword.IncludeInAll = false
+	for _, field := range []string{"type", "genre_facet", "year", "user_id", "status"} {
+		doc.AddFieldMappingsAt(field, keyword)
+	}
+	plays := bleve.NewNumericFieldMapping()
+	plays.IncludeInAll = false
+	doc.AddFieldMappingsAt("play_count", plays)
+
+	m.DefaultMapping = doc
+	return m, nil
+}
+
+func docID(docType, id string) string {
+	return docType + ":" + id
+}
+
+func songDocument(song models.Song, artist string) map[string]interface{} {
+	doc := map[string]interface{}{
+		"type":        TypeSong,
+		"title":       song.Title,
+		"album":       song.Album,
+		"genre":       song.Genre,
+		"genre_facet": strings.ToLower(song.Genre),
+		"artist":      artist,
+		"user_id":     song.UserID,
+		"play_count":  float64(song.PlayCount),
+	}
+	if !song.ReleaseDate.IsZero() {
+		doc["year"] = strconv.Itoa(song.ReleaseDate.Year())
+	}
+	if song.Status != "" {
+		doc["status"] = song.Status
+	}
+	return doc
+}
+
+func artistDocument(userID, name string) map[string]interface{} {
+	return map[string]interface{}{"type": TypeArtist, "name": name, "user_id": userID}
+}
+
+func playlistDocument(playlist models.Playlist) map[string]interface{} {
+	return map[string]interface{}{"type": TypePlaylist, "name": playlist.Name, "user_id": playlist.UserID}
+}
+
+func (i *Index) IndexSong(song models.Song, artist string) error {
+	return i.index.Index(docID(TypeSong, song.SongID), songDocument(song, artist))
+}
+
+func (i *Index) RemoveSong(songID string) error {
+	return i.index.Delete(docID(TypeSong, songID))
+}
+
+func (i *Index) IndexArtist(userID, name string) error {
+	return i.index.Index(docID(TypeArtist, userID), artistDocument(userID, name))
+}
+
+func (i *Index) RemoveArtist(userID string) error {
+	return i.index.Delete(docID(TypeArtist, userID))
+}
+
+// IndexPlaylist indexes a public playlist. Private and unlisted playlists
+// must not be found, so they are dropped from the index instead.
+func (i *Index) IndexPlaylist(playlist models.Playlist) error {
+	if playlist.Visibility != models.PlaylistPublic {
+		return i.RemovePlaylist(playlist.PlaylistID.String())
+	}
+	return i.index.Index(docID(TypePlaylist, playlist.PlaylistID.String()), playlistDocument(playlist))
+}
+
+func (i *Index) RemovePlaylist(playlistID string) error {
+	return i.index.Delete(docID(TypePlaylist, playlistID))
+}
+
+// Request describes a search. Type, Genre and Year narrow the results when
+// set.
+type Request struct {
+	Query  string
+	Type   string
+	Genre  string
+	Year   int
+	Limit  int
+	Offset int
+}
+
+type Results struct {
+	Total  uint64             `json:"total"`
+	Hits   []Hit              `json:"hits"`
+	Facets map[string][]Facet `json:"facets"`
+	// More reports whether hits may follow this page.
+	More bool `json:"-"`
+}
+
+// Hit is one match. Songs carry only their ID here; artists and playlists
+// also carry their name and, for playlists, the owner.
+type Hit struct {
+	Type   string       `json:"type"`
+	ID     string       `json:"id"`
+	Score  float64      `json:"score"`
+	Name   string       `json:"name,omitempty"`
+	UserID string       `json:"user_id,omitempty"`
+	Song   *models.Song `json:"song,omitempty"`
+}
+
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Search runs a free-text query over titles, albums, genres, artist names and
+// playlist names. Exact matches rank above matches that needed a typo to be
+// forgiven; ties go to the more played song. Songs that cannot be played are
+// left out, so they count towards neither the total nor the facets.
+func (i *Index) Search(r Request) (*Results, error) {
+	filters := []query.Query{textQuery(r.Query)}
+	if r.Type != "" {
+		filters = append(filters, termQuery("type", r.Type))
+	}
+	if r.Genre != "" {
+		filters = append(filters, termQuery("genre_facet", strings.ToLower(r.Genre)))
+	}
+	if r.Year != 0 {
+		filters = append(filters, termQuery("year", strconv.Itoa(r.Year)))
+	}
+
+	q := bleve.NewBooleanQuery()
+	q.AddMust(filters...)
+	q.AddMustNot(termQuery("status", models.SongStatusTakenDown), termQuery("status", models.SongStatusUnavailable))
+
+	req := bleve.NewSearchRequestOptions(q, r.Limit, r.Offset, false)
+	req.Fields = []string{"type", "name", "user_id"}
+	req.SortBy([]string{"-_score", "-play_count", "_id"})
+	req.AddFacet("genre", bleve.NewFacetRequest("genre_facet", maxFacetTerms))
+	req.AddFacet("year", bleve.NewFacetRequest("year", maxFacetTerms))
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	results := &Results{Total: res.Total, Hits: []Hit{}, Facets: map[string][]Facet{}}
+	for _, h := range res.Hits {
+		docType, id, _ := strings.Cut(h.ID, ":")
+		hit := Hit{Type: docType, ID: id, Score: h.Score}
+		if docType != TypeSong {
+			hit.Name, _ = h.Fields["name"].(string)
+			hit.UserID, _ = h.Fields["user_id"].(string)
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	results.More = uint64(r.Offset+len(res.Hits)) < res.Total
+	for name, facet := range res.Facets {
+		facets := []Facet{}
+		if facet.Terms != nil {
+			for _, t := range facet.Terms.Terms() {
+				facets = append(facets, Facet{Value: t.Term, Count: t.Count})
+			}
+		}
+		results.Facets[name] = facets
+	}
+	return results, nil
+}
+
+func textQuery(text string) query.Query {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return bleve.NewMatchAllQuery()
+	}
+	exact := bleve.NewMatchQuery(text)
+	exact.SetOperator(query.MatchQueryOperatorAnd)
+	exact.SetBoost(3)
+
+	fuzziness := fuzzinessFor(text)
+	if fuzziness == 0 {
+		return exact
+	}
+	fuzzy := bleve.NewMatchQuery(text)
+	fuzzy.SetOperator(query.MatchQueryOperatorAnd)
+	fuzzy.SetFuzziness(fuzziness)
+	return bleve.NewDisjunctionQuery(exact, fuzzy)
+}
+
+// fuzzinessFor allows one typo per word once the shortest word has four
+// characters, and two from eight; shorter words would match too much.
+func fuzzinessFor(text string) int {
+	shortest := -1
+	for _, word := range strings.Fields(text) {
+		if n := utf8.RuneCountInString(word); shortest < 0 || n < shortest {
+			shortest = n
+		}
+	}
+	switch {
+	case shortest >= 8:
+		return 2
+	case shortest >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func termQuery(field, term string) query.Query {
+	q := bleve.NewTermQuery(term)
+	q.SetField(field)
+	return q
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"context"
+	"os"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+)
+
+const rebuildBatchSize = 500
+
+// RebuildReport counts the documents written by a rebuild.
+type RebuildReport struct {
+	Songs     int `json:"songs"`
+	Artists   int `json:"artists"`
+	Playlists int `json:"playlists"`
+}
+
+// Rebuild indexes every song, artist and playlist in the database into index.
+// Documents already in the index are overwritten, not removed; use
+// RebuildAt for a clean index.
+func Rebuild(ctx context.Context, dbService database.ScyllaService, index *Index) (*RebuildReport, error) {
+	report := &RebuildReport{}
+	batch := index.index.NewBatch()
+	add := func(id string, doc map[string]interface{}) error {
+		if err := batch.Index(id, doc); err != nil {
+			return err
+		}
+		if batch.Size() < rebuildBatchSize {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := index.index.Batch(batch); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+
+	artists, err := database.All(dbService.GetAllArtists)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, artist := range artists {
+		names[artist.UserID] = artist.Username
+		if err := add(docID(TypeArtist, artist.UserID), artistDocument(artist.UserID, artist.Username)); err != nil {
+			return nil, err
+		}
+		report.Artists++
+	}
+
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range songs {
+		if err := add(docID(TypeSong, song.SongID), songDocument(song, names[song.UserID])); err != nil {
+			return nil, err
+		}
+		report.Songs++
+	}
+
+	playlists, err := database.All(dbService.GetAllPlaylists)
+	if err != nil {
+		return nil, err
+	}
+	for _, playlist := range playlists {
+		if playlist.Visibility != models.PlaylistPublic {
+			continue
+		}
+		if err := add(docID(TypePlaylist, playlist.PlaylistID.String()), playlistDocument(playlist)); err != nil {
+			return nil, err
+		}
+		report.Playlists++
+	}
+
+	if err := index.index.Batch(batch); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// RebuildAt builds a fresh index next to path and swaps it in once it is
+// complete, so a failed rebuild leaves the old index untouched. A server
+// with the old index open keeps using it until restarted.
+func RebuildAt(ctx context.Context, dbService database.ScyllaService, path string) (*RebuildReport, error) {
+	tmp := path + ".rebuild"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	index, _, err := Open(tmp)
+	if err != nil {
+		return nil, err
+	}
+	report, err := Rebuild(ctx, dbService, index)
+	if closeErr := index.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,403 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/blevesearch/bleve/v2/analysis/char/asciifolding"
+)
+
+// Suggestion types besides TypeSong and TypeArtist.
+const (
+	TypeAlbum = "album"
+	TypeGenre = "genre"
+)
+
+// MaxSuggestions is the most suggestions a single lookup returns.
+const MaxSuggestions = 20
+
+// maxKeyWords bounds how many words of a name are indexed as a starting
+// point, so a long title does not add dozens of keys.
+const maxKeyWords = 8
+
+type Suggestion struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"` // song or artist ID
+	Text     string `json:"text"`
+	ArtistID string `json:"artist_id,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+}
+
+// Suggester answers search-as-you-type lookups from memory. Names are
+// folded to lowercase ASCII and indexed in a trie from the start of every
+// word, so "vu" finds "Déjà Vu". Every trie node caches its best entries,
+// which keeps a lookup proportional to the length of the prefix rather than
+// to the size of the catalogue. Changes keep those caches exact along the
+// keys they touch, so lookups only read and run in parallel.
+//
+// Entries rank by weight: the play count of a song, the followers and plays
+// of an artist, and the plays of every song on an album or in a genre.
+type Suggester struct {
+	mu      sync.RWMutex
+	root    *trieNode
+	entries map[string]*suggestEntry // by entryKey
+	songs   map[string]songRef
+	artists map[string]string // artist ID -> name, also for non-artists with songs
+}
+
+type suggestEntry struct {
+	kind     string
+	id       string
+	text     string
+	artistID string
+	weight   int64
+	songs    int // songs contributing to an album or genre
+}
+
+// songRef remembers what a song contributed, so removing it can take that
+// back.
+type songRef struct {
+	artistID string
+	album    string // entry key, empty without album
+	genre    string // entry key, empty without genre
+	weight   int64
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	entries  []*suggestEntry // entries with a key ending here
+	top      []*suggestEntry // best entries of the whole subtree
+}
+
+func NewSuggester() *Suggester {
+	return &Suggester{
+		root:    &trieNode{},
+		entries: map[string]*suggestEntry{},
+		songs:   map[string]songRef{},
+		artists: map[string]string{},
+	}
+}
+
+// LoadSuggester builds a suggester from every song and artist in the
+// database.
+func LoadSuggester(dbService database.ScyllaService) (*Suggester, error) {
+	s := NewSuggester()
+	artists, err := database.All(dbService.GetAllArtists)
+	if err != nil {
+		return nil, err
+	}
+	for _, artist := range artists {
+		s.SetArtist(artist.UserID, artist.Username, artist.Followers)
+	}
+	songs, err := database.All(dbService.GetAllSongs)
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range songs {
+		if song.Status == "" {
+			s.AddSong(song)
+		}
+	}
+	return s, nil
+}
+
+// Replace swaps in the contents of other, typically a freshly loaded
+// suggester. Changes made to s while other was being loaded are lost until
+// the next reload.
+func (s *Suggester) Replace(other *Suggester) {
+	other.mu.Lock()
+	root, entries, songs, artists := other.root, other.entries, other.songs, other.artists
+	other.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root, s.entries, s.songs, s.artists = root, entries, songs, artists
+}
+
+func entryKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+// AddSong adds a song, its album and its genre, or updates them if the song
+// is already known.
+func (s *Suggester) AddSong(song models.Song) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSong(song.SongID)
+
+	ref := songRef{artistID: song.UserID, weight: int64(song.PlayCount) + 1}
+	s.insert(&suggestEntry{kind: TypeSong, id: song.SongID, text: song.Title, artistID: song.UserID, weight: ref.weight})
+	if Fold(song.Album) != "" {
+		ref.album = entryKey(TypeAlbum, song.UserID+"/"+Fold(song.Album))
+		s.addToGroup(ref.album, TypeAlbum, song.Album, song.UserID, ref.weight)
+	}
+	if Fold(song.Genre) != "" {
+		ref.genre = entryKey(TypeGenre, Fold(song.Genre))
+		s.addToGroup(ref.genre, TypeGenre, song.Genre, "", ref.weight)
+	}
+	if artist, ok := s.entries[entryKey(TypeArtist, song.UserID)]; ok {
+		s.reweigh(artist, ref.weight)
+	}
+	s.songs[song.SongID] = ref
+}
+
+func (s *Suggester) RemoveSong(songID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSong(songID)
+}
+
+func (s *Suggester) removeSong(songID string) {
+	ref, ok := s.songs[songID]
+	if !ok {
+		return
+	}
+	delete(s.songs, songID)
+	s.remove(s.entries[entryKey(TypeSong, songID)])
+	for _, key := range []string{ref.album, ref.genre} {
+		group, ok := s.entries[key]
+		if !ok {
+			continue
+		}
+		if group.songs--; group.songs == 0 {
+			s.remove(group)
+		} else {
+			s.reweigh(group, -ref.weight)
+		}
+	}
+	if artist, ok := s.entries[entryKey(TypeArtist, ref.artistID)]; ok {
+		s.reweigh(artist, -ref.weight)
+	}
+}
+
+// SetArtist adds or renames an artist. followers only counts when the
+// artist is new; afterwards its weight follows its songs.
+func (s *Suggester) SetArtist(userID, name string, followers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.artists[userID] = name
+
+	key := entryKey(TypeArtist, userID)
+	if existing, ok := s.entries[key]; ok {
+		if existing.text == name {
+			return
+		}
+		s.remove(existing)
+		existing.text = name
+		s.insert(existing)
+		return
+	}
+	weight := int64(followers) + 1
+	for _, ref := range s.songs {
+		if ref.artistID == userID {
+			weight += ref.weight
+		}
+	}
+	s.insert(&suggestEntry{kind: TypeArtist, id: userID, text: name, weight: weight})
+}
+
+// RemoveArtist stops suggesting an artist; their songs stay.
+func (s *Suggester) RemoveArtist(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(s.entries[entryKey(TypeArtist, userID)])
+}
+
+// Suggest returns up to limit entries with a word starting with prefix.
+func (s *Suggester) Suggest(prefix string, limit int) []Suggestion {
+	suggestions := []Suggestion{}
+	prefix = Fold(prefix)
+	if prefix == "" || limit <= 0 {
+		return suggestions
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := s.root
+	for _, r := range prefix {
+		if n = n.children[r]; n == nil {
+			return suggestions
+		}
+	}
+	for _, e := range n.top[:min(limit, len(n.top))] {
+		suggestion := Suggestion{Type: e.kind, Text: e.text, ArtistID: e.artistID, Artist: s.artists[e.artistID]}
+		if e.kind == TypeSong || e.kind == TypeArtist {
+			suggestion.ID = e.id
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions
+}
+
+func (s *Suggester) addToGroup(key, kind, text, artistID string, weight int64) {
+	if group, ok := s.entries[key]; ok {
+		group.songs++
+		s.reweigh(group, weight)
+		return
+	}
+	id := strings.TrimPrefix(key, kind+":")
+	s.insert(&suggestEntry{kind: kind, id: id, text: text, artistID: artistID, weight: weight, songs: 1})
+}
+
+func (s *Suggester) insert(e *suggestEntry) {
+	s.entries[entryKey(e.kind, e.id)] = e
+	for _, key := range keysFor(e.text) {
+		n := s.root
+		for _, r := range key {
+			child := n.children[r]
+			if child == nil {
+				child = &trieNode{}
+				if n.children == nil {
+					n.children = map[rune]*trieNode{}
+				}
+				n.children[r] = child
+			}
+			n = child
+			n.offer(e)
+		}
+		n.entries = append(n.entries, e)
+	}
+}
+
+func (s *Suggester) remove(e *suggestEntry) {
+	if e == nil {
+		return
+	}
+	delete(s.entries, entryKey(e.kind, e.id))
+	for _, key := range keysFor(e.text) {
+		s.root.removeKey([]rune(key), e)
+	}
+}
+
+// reweigh changes the weight of an entry. Gains can be applied to the
+// cached rankings in place; losses could let an entry below the cut
+// overtake, so the nodes on the keys of e that rank it are rebuilt, deepest
+// first.
+func (s *Suggester) reweigh(e *suggestEntry, delta int64) {
+	e.weight += delta
+	for _, key := range keysFor(e.text) {
+		var path []*trieNode
+		n := s.root
+		for _, r := range key {
+			if n = n.children[r]; n == nil {
+				break
+			}
+			if delta >= 0 {
+				n.offer(e)
+			} else {
+				path = append(path, n)
+			}
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			if path[i].ranks(e) {
+				path[i].rebuild()
+			}
+		}
+	}
+}
+
+// offer puts e into the cached ranking of n if it belongs there.
+func (n *trieNode) offer(e *suggestEntry) {
+	if !n.ranks(e) {
+		if len(n.top) == MaxSuggestions && !ranksBefore(e, n.top[len(n.top)-1]) {
+			return
+		}
+		n.top = append(n.top, e)
+	}
+	sort.SliceStable(n.top, func(i, j int) bool { return ranksBefore(n.top[i], n.top[j]) })
+	if len(n.top) > MaxSuggestions {
+		n.top = n.top[:MaxSuggestions]
+	}
+}
+
+// removeKey drops e from the end of key, rebuilds the rankings that held it
+// along the way back up and prunes nodes left empty. It reports whether n itself is
+// now empty.
+func (n *trieNode) removeKey(key []rune, e *suggestEntry) bool {
+	if len(key) == 0 {
+		for i, t := range n.entries {
+			if t == e {
+				n.entries = append(n.entries[:i], n.entries[i+1:]...)
+				break
+			}
+		}
+	} else if child := n.children[key[0]]; child != nil {
+		if child.removeKey(key[1:], e) {
+			delete(n.children, key[0])
+		} else if child.ranks(e) {
+			child.rebuild()
+		}
+	}
+	return len(n.entries) == 0 && len(n.children) == 0
+}
+
+// ranks reports whether e is in the cached ranking of n. Removing or
+// lowering an entry that is not leaves the ranking as it is.
+func (n *trieNode) ranks(e *suggestEntry) bool {
+	for _, t := range n.top {
+		if t == e {
+			return true
+		}
+	}
+	return false
+}
+
+// rebuild ranks the entries ending at n together with the cached rankings
+// of its children. Whatever ranks in the subtree's best also ranks in the
+// best of the child it lives under, so that is exact, and costs no more
+// than the children times MaxSuggestions.
+func (n *trieNode) rebuild() {
+	all := append([]*suggestEntry(nil), n.entries...)
+	for _, child := range n.children {
+		all = append(all, child.top...)
+	}
+	sort.Slice(all, func(i, j int) bool { return ranksBefore(all[i], all[j]) })
+	// An entry with several keys can come from more than one child; the
+	// ranking is total, so its copies end up next to each other.
+	top := make([]*suggestEntry, 0, MaxSuggestions)
+	for i, e := range all {
+		if len(top) == MaxSuggestions {
+			break
+		}
+		if i == 0 || e != all[i-1] {
+			top = append(top, e)
+		}
+	}
+	n.top = top
+}
+
+func ranksBefore(a, b *suggestEntry) bool {
+	if a.weight != b.weight {
+		return a.weight > b.weight
+	}
+	if a.text != b.text {
+		return a.text < b.text
+	}
+	return entryKey(a.kind, a.id) < entryKey(b.kind, b.id)
+}
+
+var folder = asciifolding.New()
+
+// Fold lowercases text, strips accents and collapses everything that is not
+// a letter or digit into single spaces.
+func Fold(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(string(folder.Filter([]byte(text)))), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// keysFor returns the folded text starting from each of its first words.
+func keysFor(text string) []string {
+	words := strings.Fields(Fold(text))
+	var keys []string
+	for i := 0; i < len(words) && i < maxKeyWords; i++ {
+		keys = append(keys, strings.Join(words[i:], " "))
+	}
+	return keys
+}
@@ -0,0 +1,284 @@
+package search
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// Sync wraps dbService so every write that changes what search can find is
+// mirrored into index and suggester. The database write decides success; a
+// failed index update is only logged and gets fixed by the next rebuild.
+func Sync(dbService database.ScyllaService, index *Index, suggester *Suggester) database.ScyllaService {
+	return &syncedService{ScyllaService: dbService, index: index, suggester: suggester}
+}
+
+type syncedService struct {
+	database.ScyllaService
+	index     *Index
+	suggester *Suggester
+}
+
+func (s *syncedService) InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string, audio models.AudioInfo) error {
+	if err := s.ScyllaService.InsertSong(songID, title, userID, album, releaseDate, genre, songURL, thumbnailURL, audio); err != nil {
+		return err
+	}
+	song := models.Song{SongID: songID.String(), Title: title, UserID: userID, Album: album, ReleaseDate: releaseDate, Genre: genre}
+	s.suggester.AddSong(song)
+	if err := s.index.IndexSong(song, s.username(userID)); err != nil {
+		log.Printf("Failed to index song %s: %v", songID, err)
+	}
+	return nil
+}
+
+func (s *syncedService) RemoveSong(songID gocql.UUID) error {
+	if err := s.ScyllaService.RemoveSong(songID); err != nil {
+		return err
+	}
+	s.suggester.RemoveSong(songID.String())
+	if err := s.index.RemoveSong(songID.String()); err != nil {
+		log.Printf("Failed to remove song %s from the search index: %v", songID, err)
+	}
+	return nil
+}
+
+// SetSongStatus hides songs that become unavailable from search and
+// suggestions, and shows them again once they are restored.
+func (s *syncedService) SetSongStatus(songID gocql.UUID, status string) error {
+	if err := s.ScyllaService.SetSongStatus(songID, status); err != nil {
+		return err
+	}
+	song := s.reindexSong(songID)
+	if status != "" || song == nil {
+		s.suggester.RemoveSong(songID.String())
+	} else {
+		s.suggester.AddSong(*song)
+	}
+	return nil
+}
+
+// RecordPlay notes the song for FlushPlays, which brings the play count that
+// breaks ranking ties up to date.
+func (s *syncedService) RecordPlay(userID string, songID gocql.UUID, playedAt time.Time, listenedSeconds float64) error {
+	if err := s.ScyllaService.RecordPlay(userID, songID, playedAt, listenedSeconds); err != nil {
+		return err
+	}
+	s.index.notePlayed(songID)
+	return nil
+}
+
+// UpsertUser only re-indexes the artist's songs when their name actually
+// changed.
+func (s *syncedService) UpsertUser(userID, username, email, role string) error {
+	previous, _ := s.ScyllaService.GetUserByID(userID)
+	if err := s.ScyllaService.UpsertUser(userID, username, email, role); err != nil {
+		return err
+	}
+	s.syncArtist(userID, username, role)
+	if role == models.RoleArtist && previous != nil && previous.Username != username {
+		s.reindexSongsOf(userID, username)
+	}
+	return nil
+}
+
+// SignInUser runs on every sign-in but only changes what search finds when
+// it fills in the missing username of an artist.
+func (s *syncedService) SignInUser(identity models.User, seenAt time.Time) (*models.User, error) {
+	previous, _ := s.ScyllaService.GetUserByID(identity.UserID)
+	user, err := s.ScyllaService.SignInUser(identity, seenAt)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.Role == models.RoleArtist && previous != nil && previous.Username != user.Username {
+		s.syncArtist(user.UserID, user.Username, user.Role)
+		s.reindexSongsOf(user.UserID, user.Username)
+	}
+	return user, nil
+}
+
+func (s *syncedService) UpdateUserRole(userID, role string) error {
+	if err := s.ScyllaService.UpdateUserRole(userID, role); err != nil {
+		return err
+	}
+	s.syncArtist(userID, s.username(userID), role)
+	return nil
+}
+
+// UpdateUsername re-indexes the songs of an artist whose name changed.
+func (s *syncedService) UpdateUsername(userID, username string) error {
+	previous, _ := s.ScyllaService.GetUserByID(userID)
+	if err := s.ScyllaService.UpdateUsername(userID, username); err != nil {
+		return err
+	}
+	if previous != nil && previous.Role == models.RoleArtist && previous.Username != username {
+		s.syncArtist(userID, username, previous.Role)
+		s.reindexSongsOf(userID, username)
+	}
+	return nil
+}
+
+func (s *syncedService) AddPlaylist(playlistID gocql.UUID, userID, name, description, visibility string) error {
+	if err := s.ScyllaService.AddPlaylist(playlistID, userID, name, description, visibility); err != nil {
+		return err
+	}
+	playlist := models.Playlist{PlaylistID: playlistID, UserID: userID, Name: name, Description: description, Visibility: visibility}
+	if err := s.index.IndexPlaylist(playlist); err != nil {
+		log.Printf("Failed to index playlist %s: %v", playlistID, err)
+	}
+	return nil
+}
+
+func (s *syncedService) UpdatePlaylist(playlistID gocql.UUID, name, description, visibility string) error {
+	if err := s.ScyllaService.UpdatePlaylist(playlistID, name, description, visibility); err != nil {
+		return err
+	}
+	playlist, err := s.ScyllaService.GetPlaylist(playlistID)
+	if err == nil && playlist != nil {
+		err = s.index.IndexPlaylist(*playlist)
+	}
+	if err != nil {
+		log.Printf("Failed to index playlist %s: %v", playlistID, err)
+	}
+	return nil
+}
+
+func (s *syncedService) RemovePlaylist(playlistID gocql.UUID) error {
+	if err := s.ScyllaService.RemovePlaylist(playlistID); err != nil {
+		return err
+	}
+	if err := s.index.RemovePlaylist(playlistID.String()); err != nil {
+		log.Printf("Failed to remove playlist %s from the search index: %v", playlistID, err)
+	}
+	return nil
+}
+
+// syncArtist indexes artists and drops everyone else.
+func (s *syncedService) syncArtist(userID, username, role string) {
+	var err error
+	if role == models.RoleArtist {
+		s.suggester.SetArtist(userID, username, 0)
+		err = s.index.IndexArtist(userID, username)
+	} else {
+		s.suggester.RemoveArtist(userID)
+		err = s.index.RemoveArtist(userID)
+	}
+	if err != nil {
+		log.Printf("Failed to index artist %s: %v", userID, err)
+	}
+}
+
+func (s *syncedService) reindexSongsOf(userID, username string) {
+	songs, err := database.All(func(limit int, pageState []byte) ([]models.Song, []byte, error) {
+		return s.ScyllaService.GetSongsByUserID(userID, limit, pageState)
+	})
+	if err != nil {
+		log.Printf("Failed to re-index songs of %s: %v", userID, err)
+		return
+	}
+	for _, song := range songs {
+		if err := s.index.IndexSong(song, username); err != nil {
+			log.Printf("Failed to index song %s: %v", song.SongID, err)
+		}
+	}
+}
+
+// reindexSong indexes the song as it is now stored and returns it, or nil
+// when it could not be read.
+func (s *syncedService) reindexSong(songID gocql.UUID) *models.Song {
+	song, err := s.ScyllaService.GetSongByID(songID)
+	if err == nil && song != nil {
+		err = s.index.IndexSong(*song, s.username(song.UserID))
+	}
+	if err != nil {
+		log.Printf("Failed to index song %s: %v", songID, err)
+		return nil
+	}
+	return song
+}
+
+// FlushPlays re-indexes the songs played since the last flush, so their play
+// counts reach the index in batches rather than with one write per play. It
+// returns how many songs were re-indexed.
+func FlushPlays(ctx context.Context, dbService database.ScyllaService, index *Index) (int, error) {
+	songIDs := index.takePlayed()
+	if len(songIDs) == 0 {
+		return 0, nil
+	}
+	flushed, err := reindexSongs(ctx, dbService, index, songIDs)
+	if err != nil {
+		// Try again with the next flush.
+		for _, songID := range songIDs {
+			index.notePlayed(songID)
+		}
+	}
+	return flushed, err
+}
+
+func reindexSongs(ctx context.Context, dbService database.ScyllaService, index *Index, songIDs []gocql.UUID) (int, error) {
+	songs, err := dbService.GetSongsByIDs(songIDs)
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []string
+	names := map[string]string{}
+	for _, song := range songs {
+		if _, ok := names[song.UserID]; !ok {
+			names[song.UserID] = ""
+			userIDs = append(userIDs, song.UserID)
+		}
+	}
+	users, err := dbService.GetUsersByIDs(userIDs)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		names[user.UserID] = user.Username
+	}
+
+	batch := index.index.NewBatch()
+	for _, song := range songs {
+		if err := batch.Index(docID(TypeSong, song.SongID), songDocument(song, names[song.UserID])); err != nil {
+			return 0, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := index.index.Batch(batch); err != nil {
+		return 0, err
+	}
+	return len(songs), nil
+}
+
+func (i *Index) notePlayed(songID gocql.UUID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.played == nil {
+		i.played = map[gocql.UUID]bool{}
+	}
+	i.played[songID] = true
+}
+
+func (i *Index) takePlayed() []gocql.UUID {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	songIDs := make([]gocql.UUID, 0, len(i.played))
+	for songID := range i.played {
+		songIDs = append(songIDs, songID)
+	}
+	i.played = nil
+	return songIDs
+}
+
+func (s *syncedService) username(userID string) string {
+	user, err := s.ScyllaService.GetUserByID(userID)
+	if err != nil || user == nil {
+		return ""
+	}
+	return user.Username
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 
+	"rr-backend/internal/authz"
 	"rr-backend/internal/handlers"
 	mdw "rr-backend/internal/middleware"
 
@@ -13,53 +14,163 @@ import (
 func (s *Server) RegisterRoutes() http.Handler {
 
 	e := echo.New()
+	e.IPExtractor = s.ipExtractor()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(middleware.RequestID())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:5173", "http://localhost:3001"},
-		AllowMethods: []string{echo.GET, echo.PUT, echo.POST, echo.DELETE, echo.OPTIONS},
-		AllowHeaders: []string{"Authorization", "Content-Type", "X-Requested-With"},
+		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE, echo.OPTIONS},
+		AllowHeaders: []string{"Authorization", "Content-Type", "X-Requested-With", "Range", "If-Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"},
+		ExposeHeaders: []string{"Accept-Ranges", "Content-Range", "Content-Length", "ETag",
+			"Location", "X-Request-Id", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Upload-Offset", "Upload-Length", "X-Song-ID"},
 	}))
 
+	// Suspended and banned users are turned away once their token checks out
+	verifyToken, verifyTokenIfSent := mdw.JWTMiddleware(s.verifier), mdw.OptionalJWTMiddleware(s.verifier)
+	rejectBlocked := mdw.RejectBlocked(s.db)
+	requireAuth := func(next echo.HandlerFunc) echo.HandlerFunc { return verifyToken(rejectBlocked(next)) }
+	optionalAuth := func(next echo.HandlerFunc) echo.HandlerFunc { return verifyTokenIfSent(rejectBlocked(next)) }
+
+	// Ownership checks, run after requireAuth or optionalAuth
+	playlistResource := mdw.PlaylistResource(s.db, s.shareLinks)
+	viewPlaylist := mdw.Authorize(s.db, playlistResource, authz.Playlist, authz.View)
+	editPlaylist := mdw.Authorize(s.db, playlistResource, authz.Playlist, authz.Edit)
+	managePlaylist := mdw.Authorize(s.db, playlistResource, authz.Playlist, authz.Manage)
+	listPlaylistMembers := mdw.Authorize(s.db, playlistResource, authz.Playlist, authz.ListMembers)
+	manageSong := mdw.Authorize(s.db, mdw.SongResource(s.db), authz.Song, authz.Manage)
+	editArtist := mdw.Authorize(s.db, mdw.ArtistResource(s.db), authz.ArtistProfile, authz.Edit)
+
+	// Role checks, run after requireAuth
+	canUpload := mdw.Require(s.db, authz.UploadMusic)
+	canApply := mdw.Require(s.db, authz.ApplyForArtist)
+	uploadLimit := mdw.UploadBodyLimit(s.db)
+
 	e.GET("/", s.HelloWorldHandler)
 	// TODO: Reformat/structure and group endpoints
 	e.GET("/health", s.healthHandler)
 	// e.GET("/auth/:provider", s.getHandleAuth)
-	e.POST("/auth/google", handlers.UpsertUserHandler(s.db), mdw.JWTMiddleware)
+	e.POST("/auth/google", handlers.UpsertUserHandler(s.db), requireAuth)
 
 	// TODO: Add endpoint for user profile
-	e.PUT("/user/promote", handlers.PromoteListenerToArtistHandler(s.db), mdw.JWTMiddleware)
-	e.GET("/user/info", handlers.GetUserInfoHandler(s.db), mdw.JWTMiddleware)
-
-	e.POST("/music/upload", handlers.UploadMusicHandler(s.db, s.musicService), mdw.JWTMiddleware)
-	e.GET("/music", handlers.GetSongsByUser(s.db), mdw.JWTMiddleware)
-	e.DELETE("/music/:song_id/remove", handlers.RemoveSongHandler(s.db, s.musicService), mdw.JWTMiddleware)
-	e.GET("/music/stream/:song_id", handlers.StreamMusic(s.db, s.musicService))
-	e.GET("/music/search", handlers.SearchSongs(s.db))
+	e.GET("/user/info", handlers.GetUserInfoHandler(s.db), requireAuth)
+
+	// Listeners become artists once an admin approves their application
+	e.POST("/artist-applications", handlers.ApplyForArtistHandler(s.db), requireAuth, canApply)
+	e.GET("/artist-applications/me", handlers.GetMyArtistApplicationHandler(s.db), requireAuth)
+
+	e.POST("/music/upload", handlers.UploadMusicHandler(s.db, s.musicService, s.jobs), requireAuth, canUpload, uploadLimit)
+
+	// Resumable uploads (tus 1.0)
+	e.OPTIONS("/music/tus", handlers.TusOptionsHandler())
+	e.POST("/music/tus", handlers.TusCreateHandler(s.db, s.musicService), requireAuth, canUpload)
+	e.HEAD("/music/tus/:upload_id", handlers.TusHeadHandler(s.db), requireAuth, canUpload)
+	e.PATCH("/music/tus/:upload_id", handlers.TusPatchHandler(s.db, s.musicService, s.jobs), requireAuth, canUpload, uploadLimit)
+	e.DELETE("/music/tus/:upload_id", handlers.TusDeleteHandler(s.db, s.musicService), requireAuth, canUpload)
+
+	// Two-phase uploads straight to MinIO
+	e.POST("/music/uploads", handlers.CreateUploadHandler(s.db, s.musicService), requireAuth, canUpload)
+	e.POST("/music/uploads/:upload_id/complete", handlers.CompleteUploadHandler(s.db, s.musicService, s.jobs), requireAuth, canUpload)
+
+	e.GET("/music", handlers.GetSongsByUser(s.db), requireAuth)
+	e.DELETE("/music/:song_id/remove", handlers.RemoveSongHandler(s.db, s.musicService), requireAuth, manageSong)
+	streamMusic := handlers.StreamMusic(s.db, s.musicService)
+	if s.streamRedirect {
+		streamMusic = handlers.RedirectMusic(s.db, s.musicService)
+	}
+	e.GET("/music/stream/:song_id", streamMusic)
+	e.HEAD("/music/stream/:song_id", streamMusic)
+	e.GET("/music/stream/:song_id/master.m3u8", handlers.GetSongManifest(s.db, s.musicService))
+	e.GET("/music/stream/:song_id/:variant/:file", handlers.StreamHLSFile(s.db, s.musicService))
+	e.GET("/search", handlers.SearchHandler(s.search, s.db))
+	e.GET("/search/suggest", handlers.SuggestHandler(s.suggester))
+	e.GET("/music/search", handlers.SearchHandler(s.search, s.db))
 	e.GET("/music/thumbnail/:song_id", handlers.GetSongThumbnail(s.db, s.musicService))
 	e.GET("/music/all", handlers.GetAllSongs(s.db))
-	e.POST("/music/:song_id/like", handlers.LikeSongHandler(s.db), mdw.JWTMiddleware)
-	e.DELETE("/music/:song_id/like", handlers.UnlikeSongHandler(s.db), mdw.JWTMiddleware)
-	e.GET("/music/likes", handlers.GetLikedSongsHandler(s.db), mdw.JWTMiddleware)
-
-	e.GET("/:user_id/playlists", handlers.FetchPlaylistsHandler(s.db))
-	e.POST("/playlists", handlers.AddPlaylistHandler(s.db), mdw.JWTMiddleware)
-	e.PUT("/playlists/:playlist_id", handlers.UpdatePlaylistHandler(s.db), mdw.JWTMiddleware)
-	e.DELETE("/playlists/:playlist_id", handlers.RemovePlaylistHandler(s.db), mdw.JWTMiddleware)
-	e.POST("/playlists/:playlist_id/songs/:song_id", handlers.AddSongToPlaylistHandler(s.db), mdw.JWTMiddleware)
-	e.DELETE("/playlists/:playlist_id/songs/:song_id", handlers.RemoveSongFromPlaylistHandler(s.db), mdw.JWTMiddleware)
-	e.GET("/playlists/:playlist_id/songs", handlers.GetSongsInPlaylistHandler(s.db), mdw.JWTMiddleware)
+	e.POST("/music/:song_id/like", handlers.LikeSongHandler(s.db), requireAuth)
+	e.DELETE("/music/:song_id/like", handlers.UnlikeSongHandler(s.db), requireAuth)
+	e.GET("/music/likes", handlers.GetLikedSongsHandler(s.db), requireAuth)
+	e.POST("/music/:song_id/plays", handlers.RecordPlayHandler(s.db), requireAuth)
+	e.GET("/me/history", handlers.GetListeningHistoryHandler(s.db), requireAuth)
+	e.GET("/me/playlist-invitations", handlers.GetPlaylistInvitationsHandler(s.db), requireAuth)
+
+	e.GET("/:user_id/playlists", handlers.FetchPlaylistsHandler(s.db), optionalAuth)
+	e.POST("/playlists", handlers.AddPlaylistHandler(s.db), requireAuth)
+	e.POST("/playlists/import", handlers.ImportPlaylistHandler(s.db, s.search), requireAuth)
+	e.GET("/playlists/:playlist_id", handlers.GetPlaylistHandler(s.db), optionalAuth, viewPlaylist)
+	e.PUT("/playlists/:playlist_id", handlers.UpdatePlaylistHandler(s.db), requireAuth, managePlaylist)
+	e.DELETE("/playlists/:playlist_id", handlers.RemovePlaylistHandler(s.db), requireAuth, managePlaylist)
+	e.POST("/playlists/:playlist_id/songs/:song_id", handlers.AddSongToPlaylistHandler(s.db), requireAuth, editPlaylist)
+	e.DELETE("/playlists/:playlist_id/songs/:song_id", handlers.RemoveSongFromPlaylistHandler(s.db), requireAuth, editPlaylist)
+	e.GET("/playlists/:playlist_id/songs", handlers.GetSongsInPlaylistHandler(s.db), optionalAuth, viewPlaylist)
+	e.GET("/playlists/:playlist_id/export", handlers.ExportPlaylistHandler(s.db), optionalAuth, viewPlaylist)
+	e.POST("/playlists/:playlist_id/share", handlers.SharePlaylistHandler(s.shareLinks), requireAuth, managePlaylist)
+	e.PATCH("/playlists/:playlist_id/order", handlers.ReorderPlaylistHandler(s.db), requireAuth, editPlaylist)
+	e.PATCH("/playlists/:playlist_id/tracks/:entry_id", handlers.MovePlaylistTrackHandler(s.db), requireAuth, editPlaylist)
+	e.DELETE("/playlists/:playlist_id/tracks/:entry_id", handlers.RemovePlaylistTrackHandler(s.db), requireAuth, editPlaylist)
+	e.GET("/playlists/:playlist_id/members", handlers.GetPlaylistMembersHandler(s.db), requireAuth, listPlaylistMembers)
+	e.POST("/playlists/:playlist_id/members", handlers.InvitePlaylistMemberHandler(s.db), requireAuth, managePlaylist)
+	e.POST("/playlists/:playlist_id/members/accept", handlers.AcceptPlaylistInviteHandler(s.db), requireAuth)
+	e.DELETE("/playlists/:playlist_id/members/me", handlers.LeavePlaylistHandler(s.db), requireAuth)
+	e.DELETE("/playlists/:playlist_id/members/:user_id", handlers.RevokePlaylistMemberHandler(s.db), requireAuth, managePlaylist)
+
+	// Charts, precomputed by a background job
+	e.GET("/charts/top-songs", handlers.GetTopSongsHandler(s.db))
+	e.GET("/charts/top-songs/:genre", handlers.GetTopSongsByGenreHandler(s.db))
+	e.GET("/charts/top-artists", handlers.GetTopArtistsHandler(s.db))
+	e.GET("/charts/trending", handlers.GetTrendingHandler(s.db))
 
 	// Artist routes
 	e.GET("/artists", handlers.GetAllArtistsHandler(s.db))
 	e.GET("/artists/:artist_id", handlers.GetArtistWithSongsHandler(s.db))
-	e.POST("/artists/:artist_id/follow", handlers.FollowArtistHandler(s.db), mdw.JWTMiddleware)
-	e.DELETE("/artists/:artist_id/follow", handlers.UnfollowArtistHandler(s.db), mdw.JWTMiddleware)
-	e.GET("/artists/followed", handlers.GetFollowedArtistsHandler(s.db), mdw.JWTMiddleware)
+	e.PUT("/artists/:artist_id", handlers.UpdateArtistProfileHandler(s.db), requireAuth, editArtist)
+	e.POST("/artists/:artist_id/follow", handlers.FollowArtistHandler(s.db), requireAuth)
+	e.DELETE("/artists/:artist_id/follow", handlers.UnfollowArtistHandler(s.db), requireAuth)
+	e.GET("/artists/followed", handlers.GetFollowedArtistsHandler(s.db), requireAuth)
+
+	// Admin API, every group behind its own permission
+	admin := e.Group("/admin", requireAuth)
+	reviews := admin.Group("/artist-applications", mdw.Require(s.db, authz.ReviewArtists))
+	reviews.GET("", handlers.GetArtistApplicationsHandler(s.db))
+	reviews.POST("/:user_id/approve", handlers.ApproveArtistApplicationHandler(s.db))
+	reviews.POST("/:user_id/reject", handlers.RejectArtistApplicationHandler(s.db))
+
+	users := admin.Group("/users", mdw.Require(s.db, authz.ManageUsers))
+	users.GET("", handlers.ListUsersHandler(s.db))
+	users.PUT("/:user_id/role", handlers.ChangeUserRoleHandler(s.db))
+	users.POST("/:user_id/suspend", handlers.SuspendUserHandler(s.db))
+	users.POST("/:user_id/ban", handlers.BanUserHandler(s.db))
+	users.POST("/:user_id/reinstate", handlers.ReinstateUserHandler(s.db))
+
+	moderate := mdw.Require(s.db, authz.ModerateContent)
+	admin.POST("/songs/:song_id/takedown", handlers.TakeDownSongHandler(s.db), moderate)
+	admin.POST("/songs/:song_id/restore", handlers.RestoreSongHandler(s.db), moderate)
+	admin.DELETE("/playlists/:playlist_id", handlers.AdminRemovePlaylistHandler(s.db), moderate)
+
+	catalog := admin.Group("/artists", mdw.Require(s.db, authz.ManageCatalog))
+	catalog.GET("/storage", handlers.GetStorageUsageHandler(s.db, s.musicService))
+	catalog.POST("/followers/refresh", handlers.RefreshFollowerCountsHandler(s.db, s.jobs, s.refreshFollowerCounts))
+
+	admin.GET("/audit", handlers.GetAuditLogHandler(s.db), mdw.Require(s.db, authz.ReadAuditLog))
 
 	return e
 }
 
+// ipExtractor works out client addresses, which end up in the audit log.
+// Forwarding headers are only believed when they come from a trusted proxy;
+// without any, the address of the connection is used.
+func (s *Server) ipExtractor() echo.IPExtractor {
+	if len(s.trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range s.trustedProxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (s *Server) HelloWorldHandler(c echo.Context) error {
 	resp := map[string]string{
 		"message": "Hello World",
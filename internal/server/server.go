@@ -1,31 +1,139 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"rr-backend/internal/auth"
+	"rr-backend/internal/authz"
+	"rr-backend/internal/charts"
+	"rr-backend/internal/cleanup"
 	"rr-backend/internal/database"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/media"
+	"rr-backend/internal/search"
+)
+
+// chartsRefreshInterval is how often the /charts snapshots are recomputed.
+const chartsRefreshInterval = 10 * time.Minute
+
+// suggestionsReloadInterval is how often the autocomplete trie is rebuilt
+// from ScyllaDB to pick up play counts; edits are applied as they happen.
+const suggestionsReloadInterval = 30 * time.Minute
+
+// playCountsFlushInterval is how often the play counts of recently played
+// songs are written to the search index.
+const playCountsFlushInterval = time.Minute
+
+// mediaBackfillInterval is how often songs are checked for HLS packaging and
+// thumbnail derivatives their upload did not get; mediaBackfillMinAge leaves
+// recent uploads to the jobs they queued themselves.
+const (
+	mediaBackfillInterval = time.Hour
+	mediaBackfillMinAge   = 30 * time.Minute
+)
+
+// uploadSweepInterval is how often the storage of abandoned uploads is
+// released; uploadSweepMinAge spares uploads still creating their row.
+const (
+	uploadSweepInterval = time.Hour
+	uploadSweepMinAge   = time.Hour
 )
 
 type Server struct {
 	port         int
 	db           database.ScyllaService
 	musicService database.MinIOService
+	jobs         *jobs.Queue
+	search       *search.Index
+	suggester    *search.Suggester
+	shareLinks   *authz.ShareLinks
+	verifier     auth.TokenVerifier
+
+	// trustedProxies are the addresses whose X-Forwarded-For is believed
+	// when working out the client address (TRUSTED_PROXIES).
+	trustedProxies []*net.IPNet
+
+	// streamRedirect sends clients straight to MinIO for audio instead of
+	// proxying it (STREAM_MODE=redirect).
+	streamRedirect bool
+}
+
+// Services are what a Server is built on. NewServer wires up the real ones;
+// tests pass fakes to New.
+type Services struct {
+	DB         database.ScyllaService
+	Storage    database.MinIOService
+	Jobs       *jobs.Queue
+	Search     *search.Index
+	Suggester  *search.Suggester
+	ShareLinks *authz.ShareLinks
+	Verifier   auth.TokenVerifier
+
+	TrustedProxies []*net.IPNet
+	StreamRedirect bool
+}
+
+func New(port int, services Services) *Server {
+	return &Server{
+		port:           port,
+		db:             services.DB,
+		musicService:   services.Storage,
+		jobs:           services.Jobs,
+		search:         services.Search,
+		suggester:      services.Suggester,
+		shareLinks:     services.ShareLinks,
+		verifier:       services.Verifier,
+		trustedProxies: services.TrustedProxies,
+		streamRedirect: services.StreamRedirect,
+	}
 }
 
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port: port,
+	searchIndex, created, err := search.Open(search.DefaultPath())
+	if err != nil {
+		log.Fatalf("Failed to open search index: %v", err)
+	}
+	verifier, err := auth.NewVerifierFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up token verification: %v", err)
+	}
+	suggester := search.NewSuggester()
+	NewServer := New(port, Services{
+		DB:         search.Sync(database.NewScylla(), searchIndex, suggester),
+		Storage:    database.NewMinIO(),
+		Jobs:       jobs.NewQueue(2, 64),
+		Search:     searchIndex,
+		Suggester:  suggester,
+		ShareLinks: authz.NewShareLinks(shareLinkSecret()),
+		Verifier:   verifier,
 
-		db:           database.NewScylla(),
-		musicService: database.NewMinIO(),
+		TrustedProxies: trustedProxies(),
+		StreamRedirect: os.Getenv("STREAM_MODE") == "redirect",
+	})
+	NewServer.scheduleJobs()
+	if created {
+		NewServer.jobs.Enqueue("build search index", func(ctx context.Context) error {
+			report, err := search.Rebuild(ctx, NewServer.db, searchIndex)
+			if err == nil {
+				log.Printf("Indexed %d songs, %d artists and %d playlists for search", report.Songs, report.Artists, report.Playlists)
+			}
+			return err
+		})
 	}
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -37,3 +145,97 @@ func NewServer() *http.Server {
 
 	return server
 }
+
+// shareLinkSecret returns the key share links are signed with
+// (SHARE_LINK_SECRET). Without one a random key is used, and links stop
+// working when the server restarts.
+func shareLinkSecret() []byte {
+	if secret := os.Getenv("SHARE_LINK_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Printf("SHARE_LINK_SECRET is not set; share links will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate a share link secret: %v", err)
+	}
+	return secret
+}
+
+// trustedProxies parses TRUSTED_PROXIES, a comma separated list of the CIDR
+// ranges of the reverse proxies in front of the server.
+func trustedProxies() []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, cidr := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES entry %q: %v", cidr, err)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// scheduleJobs starts the periodic background work.
+func (s *Server) scheduleJobs() {
+	s.jobs.Every("retry object removals", time.Minute, func(ctx context.Context) error {
+		removed, err := cleanup.RetryPending(ctx, s.db, s.musicService, "music", time.Now())
+		if removed > 0 {
+			log.Printf("Removed %d objects left over from earlier failures", removed)
+		}
+		return err
+	})
+
+	s.jobs.Every("sweep abandoned uploads", uploadSweepInterval, func(ctx context.Context) error {
+		report, err := maintenance.SweepUploads(ctx, s.db, s.musicService, uploadSweepMinAge)
+		if report != nil && report.Aborted+report.Removed > 0 {
+			log.Printf("Aborted %d multipart uploads and removed %d staging objects of abandoned uploads", report.Aborted, report.Removed)
+		}
+		return err
+	})
+
+	if media.FFmpegAvailable() {
+		s.jobs.Every("backfill media", mediaBackfillInterval, func(ctx context.Context) error {
+			report, err := maintenance.BackfillMedia(ctx, s.db, s.musicService, mediaBackfillMinAge)
+			if report != nil && report.Packaged+report.Thumbnails > 0 {
+				log.Printf("Packaged %d songs and generated thumbnails for %d left over from earlier uploads", report.Packaged, report.Thumbnails)
+			}
+			return err
+		})
+	}
+
+	s.jobs.Every("index play counts", playCountsFlushInterval, func(ctx context.Context) error {
+		_, err := search.FlushPlays(ctx, s.db, s.search)
+		return err
+	})
+
+	s.jobs.Enqueue("refresh charts", s.refreshCharts)
+	s.jobs.Every("refresh charts", chartsRefreshInterval, s.refreshCharts)
+
+	s.jobs.Enqueue("load suggestions", s.reloadSuggestions)
+	s.jobs.Every("load suggestions", suggestionsReloadInterval, s.reloadSuggestions)
+}
+
+func (s *Server) refreshCharts(ctx context.Context) error {
+	return charts.Refresh(ctx, s.db, time.Now())
+}
+
+func (s *Server) reloadSuggestions(ctx context.Context) error {
+	loaded, err := search.LoadSuggester(s.db)
+	if err != nil {
+		return err
+	}
+	s.suggester.Replace(loaded)
+	return nil
+}
+
+// refreshFollowerCounts recomputes what is derived from follower counts: the
+// artist charts and the weight of artist suggestions.
+func (s *Server) refreshFollowerCounts(ctx context.Context) error {
+	if err := s.refreshCharts(ctx); err != nil {
+		return err
+	}
+	return s.reloadSuggestions(ctx)
+}
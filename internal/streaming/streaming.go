@@ -0,0 +1,344 @@
+// Package streaming serves objects stored in MinIO over HTTP with support for
+// byte ranges and conditional requests. Every range is fetched with its own
+// ranged GetObject call, so seeking in a long track only pulls the bytes the
+// client asked for.
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"rr-backend/internal/database"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Serve writes the object to w, honouring Range, If-Range, If-Match,
+// If-None-Match, If-Modified-Since and If-Unmodified-Since. It returns
+// ErrNotFound when the object does not exist; any other error returned after
+// the response has been committed can only be logged by the caller.
+func Serve(w http.ResponseWriter, r *http.Request, store database.MinIOService, bucketName, objectName string) error {
+	info, err := store.StatObject(r.Context(), bucketName, objectName)
+	if err != nil {
+		if database.IsObjectNotFound(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	etag := quoteETag(info.ETag)
+	modTime := info.LastModified.UTC().Truncate(time.Second)
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	}
+
+	if status := checkPreconditions(r, etag, modTime); status != 0 {
+		if status == http.StatusNotModified {
+			w.WriteHeader(status)
+		} else {
+			http.Error(w, http.StatusText(status), status)
+		}
+		return nil
+	}
+
+	contentType := ContentType(info)
+	size := info.Size
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" && !ifRangeMatches(r, etag, modTime) {
+		rangeHeader = ""
+	}
+
+	ranges, err := parseRange(rangeHeader, size)
+	if err != nil {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return nil
+	}
+	if sumRangesSize(ranges) > size {
+		// The client asked for more than the whole object; serve it once
+		// instead of amplifying the response.
+		ranges = nil
+	}
+
+	switch {
+	case len(ranges) == 0:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead || size == 0 {
+			return nil
+		}
+		return copyRange(w, r, store, bucketName, objectName, httpRange{start: 0, length: size})
+
+	case len(ranges) == 1:
+		ra := ranges[0]
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return nil
+		}
+		return copyRange(w, r, store, bucketName, objectName, ra)
+
+	default:
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		h.Set("Content-Length", strconv.FormatInt(multipartSize(ranges, contentType, size, boundary), 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return nil
+		}
+
+		mw := multipart.NewWriter(w)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return err
+		}
+		for _, ra := range ranges {
+			part, err := mw.CreatePart(ra.mimeHeader(contentType, size))
+			if err != nil {
+				return err
+			}
+			if err := copyRange(part, r, store, bucketName, objectName, ra); err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	}
+}
+
+// ContentType returns the MIME type recorded for the object, falling back to
+// its extension when the uploader did not provide a useful one.
+func ContentType(info minio.ObjectInfo) string {
+	if info.ContentType != "" && info.ContentType != "application/octet-stream" {
+		return info.ContentType
+	}
+	if ctype := mime.TypeByExtension(path.Ext(info.Key)); ctype != "" {
+		return ctype
+	}
+	return "application/octet-stream"
+}
+
+func copyRange(w io.Writer, r *http.Request, store database.MinIOService, bucketName, objectName string, ra httpRange) error {
+	body, err := store.GetObjectRange(r.Context(), bucketName, objectName, ra.start, ra.start+ra.length-1)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = io.CopyN(w, body, ra.length)
+	return err
+}
+
+// checkPreconditions evaluates the conditional request headers in the order
+// given by RFC 9110 section 13.2.2 and returns the status code to reply with,
+// or 0 when the request should proceed.
+func checkPreconditions(r *http.Request, etag string, modTime time.Time) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !modTime.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && modTime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag, false) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if t, err := http.ParseTime(ims); err == nil && !modTime.After(t) {
+				return http.StatusNotModified
+			}
+		}
+	}
+	return 0
+}
+
+// ifRangeMatches reports whether a Range header should be honoured. If-Range
+// requires a strong ETag match or an exact Last-Modified match.
+func ifRangeMatches(r *http.Request, etag string, modTime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return !strings.HasPrefix(ir, "W/") && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !modTime.IsZero() && t.Equal(modTime)
+}
+
+func etagListMatches(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+type httpRange struct {
+	start, length int64
+}
+
+func (ra httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.start+ra.length-1, size)
+}
+
+func (ra httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {ra.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+var errUnsatisfiable = errors.New("invalid range: failed to overlap")
+
+// parseRange parses a Range header as described by RFC 9110 section 14.1.2.
+// Ranges that start beyond the end of the object are dropped; if none remain
+// the range is unsatisfiable.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errors.New("invalid range")
+	}
+
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errors.New("invalid range")
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+
+		var r httpRange
+		if start == "" {
+			// Suffix range: the last N bytes.
+			if end == "" || end[0] == '-' {
+				return nil, errors.New("invalid range")
+			}
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = size - r.start
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errors.New("invalid range")
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - r.start
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.start > j {
+					return nil, errors.New("invalid range")
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if noOverlap && len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
+
+// multipartSize computes the exact length of a multipart/byteranges body so
+// the response can carry a Content-Length without buffering it.
+func multipartSize(ranges []httpRange, contentType string, size int64, boundary string) int64 {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		mw.CreatePart(ra.mimeHeader(contentType, size))
+		w += countingWriter(ra.length)
+	}
+	mw.Close()
+	return int64(w)
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,302 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"rr-backend/internal/charts"
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+// newAdminDB is newAuthzDB with a moderator.
+func newAdminDB() *fakeScylla {
+	db := newAuthzDB()
+	db.users["mod"] = &models.User{UserID: "mod", Role: models.RoleModerator}
+	db.users["stranger"].Email = "stranger@example.com"
+	return db
+}
+
+// lastAudit returns the newest audit event and its before and after
+// snapshots, failing when it is not of action.
+func lastAudit(t *testing.T, db *fakeScylla, action string) (models.AuditEvent, map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	if len(db.audit) == 0 {
+		t.Fatalf("no audit event, want %s", action)
+	}
+	event := db.audit[len(db.audit)-1]
+	if event.Action != action {
+		t.Fatalf("last audit event is %s, want %s", event.Action, action)
+	}
+	snapshot := func(encoded string) map[string]interface{} {
+		decoded := map[string]interface{}{}
+		if encoded != "" {
+			if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return decoded
+	}
+	return event, snapshot(event.Before), snapshot(event.After)
+}
+
+func TestAdminRoutePermissions(t *testing.T) {
+	routes := []struct {
+		method, target string
+		moderator      bool
+	}{
+		{http.MethodGet, "/admin/users", false},
+		{http.MethodPut, "/admin/users/stranger/role", false},
+		{http.MethodPost, "/admin/users/stranger/suspend", false},
+		{http.MethodPost, "/admin/users/stranger/ban", false},
+		{http.MethodPost, "/admin/users/stranger/reinstate", false},
+		{http.MethodGet, "/admin/artist-applications", false},
+		{http.MethodGet, "/admin/artists/storage", false},
+		{http.MethodPost, "/admin/artists/followers/refresh", false},
+		{http.MethodGet, "/admin/audit", false},
+		{http.MethodPost, "/admin/songs/" + authzSongID + "/takedown", true},
+		{http.MethodPost, "/admin/songs/" + authzSongID + "/restore", true},
+		{http.MethodDelete, "/admin/playlists/" + authzPlaylistID, true},
+	}
+	for _, route := range routes {
+		// A fresh database each time, since the allowed calls change it.
+		for userID, allowed := range map[string]bool{"stranger": false, "artist-1": false, "mod": route.moderator} {
+			client := newTestClient(t, server.Services{DB: newAdminDB()})
+			if code := client.do(userID, route.method, route.target, "{}").Code; (code != http.StatusForbidden) != allowed {
+				t.Errorf("%s %s as %s: status = %d", route.method, route.target, userID, code)
+			}
+		}
+	}
+}
+
+func TestAdminManagesUsers(t *testing.T) {
+	db := newAdminDB()
+	client := newTestClient(t, server.Services{DB: db})
+	list := func(query string) []string {
+		t.Helper()
+		rec := client.do("root", http.MethodGet, "/admin/users"+query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list %s: status = %d, body = %s", query, rec.Code, rec.Body.String())
+		}
+		var page struct {
+			Items []models.User `json:"items"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, u := range page.Items {
+			ids = append(ids, u.UserID)
+		}
+		return ids
+	}
+
+	if ids := list("?q=STRANGER@example"); len(ids) != 1 || ids[0] != "stranger" {
+		t.Errorf("search by email = %v", ids)
+	}
+	if ids := list("?role=artist"); len(ids) != 2 {
+		t.Errorf("artists = %v", ids)
+	}
+	if ids := list("?role=artist&limit=1"); len(ids) != 1 {
+		t.Errorf("first page of artists = %v", ids)
+	}
+	if code := client.do("root", http.MethodGet, "/admin/users?role=superuser", "").Code; code != http.StatusBadRequest {
+		t.Errorf("unknown role filter: status = %d", code)
+	}
+
+	// Roles
+	if rec := client.do("root", http.MethodPut, "/admin/users/stranger/role", `{"role":"moderator"}`); rec.Code != http.StatusOK {
+		t.Fatalf("change role: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if db.users["stranger"].Role != models.RoleModerator {
+		t.Errorf("role = %q", db.users["stranger"].Role)
+	}
+	event, before, after := lastAudit(t, db, models.ActionChangeRole)
+	if event.ActorID != "root" || event.TargetType != models.TargetUser || event.TargetID != "stranger" || before["role"] != "listener" || after["role"] != "moderator" {
+		t.Errorf("audit event = %+v", event)
+	}
+	for body, want := range map[string]int{`{"role":"superuser"}`: http.StatusBadRequest, `{"role":"admin"}`: http.StatusNotFound} {
+		if code := client.do("root", http.MethodPut, "/admin/users/nobody/role", body).Code; code != want {
+			t.Errorf("role %s of unknown user: status = %d, want %d", body, code, want)
+		}
+	}
+	if code := client.do("root", http.MethodPut, "/admin/users/root/role", `{"role":"listener"}`).Code; code != http.StatusBadRequest {
+		t.Errorf("demoting yourself: status = %d", code)
+	}
+
+	// Suspensions lock the user out of every authenticated route until they
+	// end or the user is reinstated.
+	userInfo := func() int { return client.do("owner", http.MethodGet, "/user/info", "").Code }
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	if code := client.do("root", http.MethodPost, "/admin/users/owner/suspend", `{"until":"`+past+`"}`).Code; code != http.StatusBadRequest {
+		t.Errorf("suspension in the past: status = %d", code)
+	}
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if code := client.do("root", http.MethodPost, "/admin/users/owner/suspend", `{"until":"`+future+`","reason":"spam"}`).Code; code != http.StatusOK {
+		t.Fatalf("suspend: status = %d", code)
+	}
+	if code := userInfo(); code != http.StatusForbidden {
+		t.Errorf("suspended user: status = %d, want 403", code)
+	}
+	if code := client.do("owner", http.MethodGet, "/playlists/"+authzPlaylistID, "").Code; code != http.StatusForbidden {
+		t.Errorf("suspended user with optional auth: status = %d, want 403", code)
+	}
+	if event, _, after := lastAudit(t, db, models.ActionSuspendUser); event.Reason != "spam" || after["status"] != "suspended" || after["suspended_until"] == nil {
+		t.Errorf("suspension audit event = %+v", event)
+	}
+	expired := time.Now().Add(-time.Minute)
+	db.users["owner"].SuspendedUntil = &expired
+	if code := userInfo(); code != http.StatusOK {
+		t.Errorf("user whose suspension ended: status = %d", code)
+	}
+	if ids := list("?status=suspended"); len(ids) != 0 {
+		t.Errorf("suspended users after the suspension ended = %v", ids)
+	}
+
+	if code := client.do("root", http.MethodPost, "/admin/users/owner/ban", `{"reason":"abuse"}`).Code; code != http.StatusOK {
+		t.Fatalf("ban: status = %d", code)
+	}
+	if code := userInfo(); code != http.StatusForbidden {
+		t.Errorf("banned user: status = %d, want 403", code)
+	}
+	if ids := list("?status=banned"); len(ids) != 1 || ids[0] != "owner" {
+		t.Errorf("banned users = %v", ids)
+	}
+	if code := client.do("root", http.MethodPost, "/admin/users/owner/reinstate", "{}").Code; code != http.StatusOK {
+		t.Fatalf("reinstate: status = %d", code)
+	}
+	if code := userInfo(); code != http.StatusOK {
+		t.Errorf("reinstated user: status = %d", code)
+	}
+	if event, before, after := lastAudit(t, db, models.ActionReinstateUser); before["status"] != "banned" || after["status"] != nil {
+		t.Errorf("reinstatement audit event = %+v", event)
+	}
+}
+
+func TestModeratorTakesDownSongs(t *testing.T) {
+	db := newAdminDB()
+	client := newTestClient(t, server.Services{DB: db})
+	songPath := "/admin/songs/" + authzSongID
+
+	if code := client.do("mod", http.MethodPost, songPath+"/restore", "{}").Code; code != http.StatusConflict {
+		t.Errorf("restoring an available song: status = %d", code)
+	}
+	if code := client.do("mod", http.MethodPost, "/admin/songs/"+gocql.TimeUUID().String()+"/takedown", "{}").Code; code != http.StatusNotFound {
+		t.Errorf("taking down an unknown song: status = %d", code)
+	}
+	if code := client.do("mod", http.MethodPost, songPath+"/takedown", `{"reason":"copyright"}`).Code; code != http.StatusOK {
+		t.Fatalf("take down: status = %d", code)
+	}
+	if code := client.do("mod", http.MethodPost, songPath+"/takedown", "{}").Code; code != http.StatusConflict {
+		t.Errorf("taking down twice: status = %d", code)
+	}
+	if db.songs[authzSongID].Status != models.SongStatusTakenDown {
+		t.Errorf("status = %q", db.songs[authzSongID].Status)
+	}
+	if event, _, after := lastAudit(t, db, models.ActionTakeDownSong); event.ActorID != "mod" || event.TargetID != authzSongID || event.Reason != "copyright" || after["status"] != models.SongStatusTakenDown {
+		t.Errorf("audit event = %+v", event)
+	}
+
+	for _, target := range []string{"/music/stream/" + authzSongID, "/music/stream/" + authzSongID + "/master.m3u8"} {
+		if code := client.do("stranger", http.MethodGet, target, "").Code; code != http.StatusGone {
+			t.Errorf("GET %s of a song taken down: status = %d, want 410", target, code)
+		}
+	}
+	if code := client.do("stranger", http.MethodPost, "/music/"+authzSongID+"/plays", `{"listened_seconds":60}`).Code; code != http.StatusNotFound {
+		t.Errorf("play of a song taken down: status = %d, want 404", code)
+	}
+
+	if code := client.do("root", http.MethodPost, songPath+"/restore", "{}").Code; code != http.StatusOK {
+		t.Fatalf("restore: status = %d", code)
+	}
+	if db.songs[authzSongID].Status != "" {
+		t.Errorf("status after restore = %q", db.songs[authzSongID].Status)
+	}
+	if code := client.do("stranger", http.MethodGet, "/music/stream/"+authzSongID, "").Code; code == http.StatusGone {
+		t.Error("restored song is still gone")
+	}
+}
+
+func TestModeratorDeletesAnyPlaylist(t *testing.T) {
+	db := newAdminDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	if code := client.do("mod", http.MethodDelete, "/admin/playlists/"+authzPlaylistID+"?reason=hate", "").Code; code != http.StatusOK {
+		t.Fatalf("delete: status = %d", code)
+	}
+	if code := client.do("mod", http.MethodDelete, "/admin/playlists/"+authzPlaylistID, "").Code; code != http.StatusNotFound {
+		t.Errorf("deleting twice: status = %d", code)
+	}
+	if len(db.playlists) != 0 {
+		t.Error("playlist was not removed")
+	}
+	if event, before, _ := lastAudit(t, db, models.ActionRemovePlaylist); event.TargetID != authzPlaylistID || before["user_id"] != "owner" || event.Reason != "hate" {
+		t.Errorf("audit event = %+v", event)
+	}
+}
+
+func TestStorageUsage(t *testing.T) {
+	db, store := newDriftedStorage()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Username: "One", Role: models.RoleArtist}
+	db.users["artist-2"] = &models.User{UserID: "artist-2", Username: "Two", Role: models.RoleArtist}
+	db.songs[healthySongID].UserID = "artist-1"
+	db.songs[brokenSongID].UserID = "artist-2"
+	// A legacy key outside the per-song layout still counts for its song.
+	db.songs[brokenSongID].SongURL = "songs/artist-2/legacy.mp3"
+	store.put("songs/artist-2/legacy.mp3", make([]byte, 5000), "audio/mpeg")
+
+	report, err := maintenance.StorageUsage(context.Background(), db, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := func(key string) int64 { return int64(len(store.objects[key].data)) }
+	if len(report.Artists) != 2 || report.Artists[0].ArtistID != "artist-2" || report.Artists[1].ArtistID != "artist-1" {
+		t.Fatalf("artists = %+v", report.Artists)
+	}
+	two, one := report.Artists[0], report.Artists[1]
+	if two.Artist != "Two" || two.Songs != 1 || two.Objects != 2 ||
+		two.AudioBytes != 5000 || two.ThumbnailBytes != size("thumbnails/"+brokenSongID+"/b.png") || two.HLSBytes != 0 {
+		t.Errorf("artist-2 = %+v", two)
+	}
+	if one.Objects != 3 || one.HLSBytes != size("hls/"+healthySongID+"/master.m3u8") ||
+		one.Bytes != one.AudioBytes+one.ThumbnailBytes+one.HLSBytes {
+		t.Errorf("artist-1 = %+v", one)
+	}
+
+	// Leftovers of the deleted song and the upload in flight belong to no
+	// artist.
+	orphans := size("songs/"+deletedSongID+"/c.mp3") + size("hls/"+deletedSongID+"/64k/seg_000.ts") +
+		size("songs/77777777-7777-7777-7777-777777777777/d.mp3")
+	if report.UnattributedBytes != orphans || report.TotalBytes != one.Bytes+two.Bytes+orphans {
+		t.Errorf("total = %d, unattributed = %d, want orphans of %d", report.TotalBytes, report.UnattributedBytes, orphans)
+	}
+}
+
+func TestRefreshFollowerCounts(t *testing.T) {
+	db := newAdminDB()
+	queue := jobs.NewQueue(1, 1)
+	t.Cleanup(queue.Close)
+	client := newTestClient(t, server.Services{DB: db, Jobs: queue})
+
+	if rec := client.do("root", http.MethodPost, "/admin/artists/followers/refresh", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if chart, _ := db.GetChart(charts.TopArtistsFollowed); chart != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("follower counts were not refreshed")
+		}
+	}
+	if event, _, _ := lastAudit(t, db, models.ActionRefreshFollowers); event.ActorID != "root" {
+		t.Errorf("audit event = %+v", event)
+	}
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"rr-backend/internal/auth"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSecurityActionsAreAudited(t *testing.T) {
+	db := newAdminDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	rec := client.do("stranger", http.MethodPost, "/artists/artist-1/follow", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("follow: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	event, _, _ := lastAudit(t, db, models.ActionFollowArtist)
+	if event.ActorID != "stranger" || event.TargetType != models.TargetArtist || event.TargetID != "artist-1" {
+		t.Errorf("follow audit event = %+v", event)
+	}
+	if event.RequestID == "" || event.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Errorf("request ID = %q, response has %q", event.RequestID, rec.Header().Get(echo.HeaderXRequestID))
+	}
+	if event.IP == "" || event.At.IsZero() || event.EventID.Time().IsZero() {
+		t.Errorf("follow audit event = %+v", event)
+	}
+
+	if code := client.do("stranger", http.MethodDelete, "/artists/artist-1/follow", "").Code; code != http.StatusOK {
+		t.Fatalf("unfollow: status = %d", code)
+	}
+	lastAudit(t, db, models.ActionUnfollowArtist)
+
+	if code := client.do("artist-1", http.MethodDelete, "/music/"+authzSongID+"/remove", "").Code; code != http.StatusOK {
+		t.Fatalf("remove song: status = %d", code)
+	}
+	event, before, after := lastAudit(t, db, models.ActionRemoveSong)
+	if event.ActorID != "artist-1" || event.TargetID != authzSongID || before["title"] != "Song" || len(after) != 0 {
+		t.Errorf("song removal audit event = %+v", event)
+	}
+
+	if code := client.do("owner", http.MethodDelete, "/playlists/"+authzPlaylistID, "").Code; code != http.StatusOK {
+		t.Fatalf("remove playlist: status = %d", code)
+	}
+	event, before, _ = lastAudit(t, db, models.ActionRemovePlaylist)
+	if event.ActorID != "owner" || event.TargetID != authzPlaylistID || before["name"] != "Mix" {
+		t.Errorf("playlist removal audit event = %+v", event)
+	}
+
+	// Requests that change nothing leave no trace.
+	recorded := len(db.audit)
+	client.do("stranger", http.MethodDelete, "/playlists/"+authzPlaylistID, "")
+	client.do("stranger", http.MethodGet, "/artists/followed", "")
+	if len(db.audit) != recorded {
+		t.Errorf("audit events = %+v", db.audit[recorded:])
+	}
+}
+
+func TestAuditIgnoresForgedForwardingHeaders(t *testing.T) {
+	issuer := auth.NewHMACIssuer([]byte("test secret"))
+	token, err := issuer.Mint(auth.Identity{UserID: "stranger"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	follow := func(e *echo.Echo, remoteAddr string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/artists/artist-1/follow", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9")
+		req.Header.Set(echo.HeaderXRealIP, "203.0.113.9")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("follow: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// Without trusted proxies the connection's address is recorded.
+	db := newAdminDB()
+	follow(newRouter(t, server.Services{DB: db, Verifier: issuer}), "198.51.100.7:4321")
+	if event, _, _ := lastAudit(t, db, models.ActionFollowArtist); event.IP != "198.51.100.7" {
+		t.Errorf("IP = %q, want the remote address", event.IP)
+	}
+
+	// Behind a trusted proxy its X-Forwarded-For is believed, but not from
+	// anyone else.
+	_, proxy, _ := net.ParseCIDR("10.1.0.0/16")
+	db = newAdminDB()
+	e := newRouter(t, server.Services{DB: db, Verifier: issuer, TrustedProxies: []*net.IPNet{proxy}})
+	follow(e, "10.1.2.3:4321")
+	if event, _, _ := lastAudit(t, db, models.ActionFollowArtist); event.IP != "203.0.113.9" {
+		t.Errorf("IP behind a trusted proxy = %q, want the forwarded address", event.IP)
+	}
+	follow(e, "198.51.100.7:4321")
+	if event, _, _ := lastAudit(t, db, models.ActionFollowArtist); event.IP != "198.51.100.7" {
+		t.Errorf("IP from an untrusted peer = %q, want the remote address", event.IP)
+	}
+}
+
+func TestAuditLogListing(t *testing.T) {
+	db := newAdminDB()
+	client := newTestClient(t, server.Services{DB: db})
+	now := time.Now()
+	add := func(ago time.Duration, actorID, action, targetType, targetID string) {
+		at := now.Add(-ago)
+		db.audit = append(db.audit, models.AuditEvent{
+			EventID: gocql.UUIDFromTime(at), At: at, ActorID: actorID, Action: action, TargetType: targetType, TargetID: targetID,
+		})
+	}
+	add(60*24*time.Hour, "root", models.ActionChangeRole, models.TargetUser, "owner")
+	add(3*time.Hour, "root", models.ActionBanUser, models.TargetUser, "stranger")
+	add(2*time.Hour, "mod", models.ActionTakeDownSong, models.TargetSong, authzSongID)
+	add(time.Hour, "stranger", models.ActionFollowArtist, models.TargetArtist, "artist-1")
+
+	list := func(query url.Values) ([]string, string) {
+		t.Helper()
+		rec := client.do("root", http.MethodGet, "/admin/audit?"+query.Encode(), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list %s: status = %d, body = %s", query.Encode(), rec.Code, rec.Body.String())
+		}
+		var page struct {
+			Items      []models.AuditEvent `json:"items"`
+			NextCursor string              `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, event := range page.Items {
+			actions = append(actions, event.Action)
+		}
+		return actions, page.NextCursor
+	}
+
+	// The last 30 days by default, newest first.
+	actions, _ := list(url.Values{})
+	if len(actions) != 3 || actions[0] != models.ActionFollowArtist || actions[2] != models.ActionBanUser {
+		t.Errorf("default listing = %v", actions)
+	}
+	actions, _ = list(url.Values{"actor": {"root"}, "from": {now.Add(-90 * 24 * time.Hour).Format(time.RFC3339)}})
+	if len(actions) != 2 || actions[0] != models.ActionBanUser || actions[1] != models.ActionChangeRole {
+		t.Errorf("by actor = %v", actions)
+	}
+	actions, _ = list(url.Values{"target_type": {models.TargetSong}, "target_id": {authzSongID}})
+	if len(actions) != 1 || actions[0] != models.ActionTakeDownSong {
+		t.Errorf("by target = %v", actions)
+	}
+	actions, _ = list(url.Values{"to": {now.Add(-150 * time.Minute).Format(time.RFC3339)}})
+	if len(actions) != 1 || actions[0] != models.ActionBanUser {
+		t.Errorf("by time = %v", actions)
+	}
+
+	first, cursor := list(url.Values{"limit": {"2"}})
+	if len(first) != 2 || cursor == "" {
+		t.Fatalf("first page = %v, cursor %q", first, cursor)
+	}
+	second, _ := list(url.Values{"limit": {"2"}, "cursor": {cursor}})
+	if len(second) != 1 || second[0] != models.ActionBanUser {
+		t.Errorf("second page = %v", second)
+	}
+
+	for _, query := range []string{
+		"target_type=song",
+		"from=yesterday",
+		"from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+		"from=2020-01-01T00:00:00Z&to=2024-01-01T00:00:00Z",
+		"cursor=AAAA",
+	} {
+		if code := client.do("root", http.MethodGet, "/admin/audit?"+query, "").Code; code != http.StatusBadRequest {
+			t.Errorf("?%s: status = %d, want 400", query, code)
+		}
+	}
+	if code := client.do("mod", http.MethodGet, "/admin/audit", "").Code; code != http.StatusForbidden {
+		t.Errorf("moderator reading the audit log: status = %d", code)
+	}
+}
@@ -0,0 +1,315 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"rr-backend/internal/auth"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestLocalIssuer(t *testing.T) {
+	hmacIssuer := auth.NewHMACIssuer([]byte("dev secret"))
+	rsaIssuer := auth.NewRSAIssuer(mustRSAKey(t))
+	identity := auth.Identity{UserID: "user-1", Email: "me@example.com", Name: "Me"}
+
+	mint := func(issuer *auth.LocalIssuer, ttl time.Duration) string {
+		token, err := issuer.Mint(identity, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := mint(hmacIssuer, time.Hour)
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": auth.LocalIssuerName, "aud": auth.LocalAudience, "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name   string
+		issuer *auth.LocalIssuer
+		token  string
+		ok     bool
+	}{
+		{"hs256", hmacIssuer, valid, true},
+		{"rs256", rsaIssuer, mint(rsaIssuer, time.Hour), true},
+		{"expired", hmacIssuer, mint(hmacIssuer, -time.Minute), false},
+		{"other secret", auth.NewHMACIssuer([]byte("other")), valid, false},
+		{"other algorithm", rsaIssuer, valid, false},
+		{"tampered", hmacIssuer, valid[:len(valid)-2] + "xx", false},
+		{"unsigned", hmacIssuer, unsigned, false},
+		{"garbage", hmacIssuer, "not.a.token", false},
+	}
+	for _, tt := range tests {
+		got, err := tt.issuer.VerifyIDToken(context.Background(), tt.token)
+		if tt.ok && (err != nil || *got != identity) {
+			t.Errorf("%s: identity = %+v, err = %v", tt.name, got, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: token was accepted", tt.name)
+		}
+	}
+}
+
+// fakeOIDCProvider serves a discovery document and a key set holding the
+// public half of whichever key is current.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	mu      sync.Mutex
+	kid     string
+	key     *rsa.PrivateKey
+	fetches int
+	// down makes key set requests fail; delay holds them up.
+	down  bool
+	delay time.Duration
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	p := &fakeOIDCProvider{kid: "key-1", key: mustRSAKey(t)}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Write([]byte(`{"issuer":"` + p.URL + `","jwks_uri":"` + p.URL + `/keys"}`))
+		case "/keys":
+			p.fetches++
+			time.Sleep(p.delay)
+			if p.down {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			n := base64.RawURLEncoding.EncodeToString(p.key.N.Bytes())
+			e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes())
+			w.Write([]byte(`{"keys":[{"kty":"RSA","use":"sig","alg":"RS256","kid":"` + p.kid + `","n":"` + n + `","e":"` + e + `"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) rotate(t *testing.T, kid string) {
+	key := mustRSAKey(t)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kid, p.key = kid, key
+}
+
+func (p *fakeOIDCProvider) token(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	verifier, err := auth.NewOIDCVerifier(provider.URL, "rr-client", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":   provider.URL,
+			"aud":   []string{"other-client", "rr-client"},
+			"sub":   "oidc-user",
+			"email": "oidc@example.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	identity, err := verifier.VerifyIDToken(context.Background(), provider.token(t, claims(nil)))
+	if err != nil || identity.UserID != "oidc-user" || identity.Email != "oidc@example.com" {
+		t.Fatalf("identity = %+v, err = %v", identity, err)
+	}
+	for name, change := range map[string]func(jwt.MapClaims){
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "other-client" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	} {
+		if _, err := verifier.VerifyIDToken(context.Background(), provider.token(t, claims(change))); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+	if provider.fetches != 1 {
+		t.Errorf("key set fetched %d times, want once", provider.fetches)
+	}
+
+	// A token signed with a key we have not seen makes us look again, but
+	// only once a minute has passed since the last fetch.
+	provider.rotate(t, "key-2")
+	if _, err := verifier.VerifyIDToken(context.Background(), provider.token(t, claims(nil))); err == nil {
+		t.Error("token of a rotated key was accepted before the key set could be fetched again")
+	}
+	if provider.fetches != 1 {
+		t.Errorf("key set fetched %d times, want once", provider.fetches)
+	}
+}
+
+func TestOIDCVerifierFetchesKeysOnce(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	provider.delay = 50 * time.Millisecond
+	verifier, err := auth.NewOIDCVerifier(provider.URL, "rr-client", provider.URL+"/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := provider.token(t, jwt.MapClaims{"iss": provider.URL, "aud": "rr-client", "sub": "oidc-user", "exp": time.Now().Add(time.Hour).Unix()})
+
+	// Requests arriving while the keys are being fetched wait for that
+	// fetch instead of starting their own.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.VerifyIDToken(context.Background(), token); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if provider.fetches != 1 {
+		t.Errorf("key set fetched %d times, want once", provider.fetches)
+	}
+
+	// A provider that is down is not asked again on every request.
+	down := newFakeOIDCProvider(t)
+	down.down = true
+	verifier, err = auth.NewOIDCVerifier(down.URL, "rr-client", down.URL+"/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = down.token(t, jwt.MapClaims{"iss": down.URL, "aud": "rr-client", "sub": "oidc-user", "exp": time.Now().Add(time.Hour).Unix()})
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.VerifyIDToken(context.Background(), token); err == nil {
+			t.Fatal("token was accepted without keys")
+		}
+	}
+	if down.fetches != 1 {
+		t.Errorf("failing key set fetched %d times, want once", down.fetches)
+	}
+}
+
+// Routes anyone may call, and routes that identify the user when a token is
+// sent. Every other route must require a token.
+var (
+	publicRoutes = map[string]bool{
+		"GET /":                                     true,
+		"GET /health":                               true,
+		"OPTIONS /music/tus":                        true,
+		"GET /music/stream/:song_id":                true,
+		"HEAD /music/stream/:song_id":               true,
+		"GET /music/stream/:song_id/master.m3u8":    true,
+		"GET /music/stream/:song_id/:variant/:file": true,
+		"GET /search":                               true,
+		"GET /search/suggest":                       true,
+		"GET /music/search":                         true,
+		"GET /music/thumbnail/:song_id":             true,
+		"GET /music/all":                            true,
+		"GET /charts/top-songs":                     true,
+		"GET /charts/top-songs/:genre":              true,
+		"GET /charts/top-artists":                   true,
+		"GET /charts/trending":                      true,
+		"GET /artists":                              true,
+		"GET /artists/:artist_id":                   true,
+	}
+	optionalAuthRoutes = map[string]bool{
+		"GET /:user_id/playlists":            true,
+		"GET /playlists/:playlist_id":        true,
+		"GET /playlists/:playlist_id/songs":  true,
+		"GET /playlists/:playlist_id/export": true,
+	}
+)
+
+var routeParam = regexp.MustCompile(`:[a-z_]+`)
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	issuer := auth.NewHMACIssuer([]byte("test secret"))
+	e := newRouter(t, server.Services{DB: newAuthzDB(), Verifier: issuer})
+
+	valid, err := issuer.Mint(auth.Identity{UserID: "stranger"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := issuer.Mint(auth.Identity{UserID: "stranger"}, -time.Minute)
+	forged, _ := auth.NewHMACIssuer([]byte("guessed secret")).Mint(auth.Identity{UserID: "owner"}, time.Hour)
+
+	protected := 0
+	for _, route := range e.Routes() {
+		name := route.Method + " " + route.Path
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		target := routeParam.ReplaceAllStringFunc(route.Path, func(string) string { return gocql.TimeUUID().String() })
+		call := func(token string) int {
+			req := httptest.NewRequest(route.Method, target, nil)
+			if token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		if publicRoutes[name] {
+			if code := call(""); code == http.StatusUnauthorized {
+				t.Errorf("%s: public route answered 401", name)
+			}
+			continue
+		}
+		if code := call(""); (code == http.StatusUnauthorized) == optionalAuthRoutes[name] {
+			t.Errorf("%s without a token: status = %d", name, code)
+		}
+		for label, token := range map[string]string{"expired": expired, "forged": forged, "malformed": "abc"} {
+			if code := call(token); code != http.StatusUnauthorized {
+				t.Errorf("%s with %s token: status = %d, want 401", name, label, code)
+			}
+		}
+		if code := call(valid); code == http.StatusUnauthorized {
+			t.Errorf("%s with a valid token: status = 401", name)
+		}
+		if !optionalAuthRoutes[name] {
+			protected++
+		}
+	}
+	if protected < 30 {
+		t.Errorf("only %d protected routes were checked", protected)
+	}
+}
@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"rr-backend/internal/authz"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+func TestPolicies(t *testing.T) {
+	owner := &models.User{UserID: "owner", Role: "listener"}
+	collaborator := &models.User{UserID: "collab", Role: "listener"}
+	stranger := &models.User{UserID: "stranger", Role: "artist"}
+	admin := &models.User{UserID: "root", Role: "admin"}
+	viewer := &models.User{UserID: "viewer", Role: "listener"}
+	resource := authz.Resource{ID: "r", OwnerID: "owner", Collaborators: []string{"collab"}, Viewers: []string{"viewer"}, Visibility: models.PlaylistPublic}
+	private, unlisted, linked := resource, resource, resource
+	private.Visibility = models.PlaylistPrivate
+	unlisted.Visibility = models.PlaylistUnlisted
+	linked.Visibility, linked.LinkShared = models.PlaylistUnlisted, true
+
+	cases := []struct {
+		name     string
+		policy   authz.Policy
+		user     *models.User
+		action   authz.Action
+		want     bool
+		resource *authz.Resource // defaults to the public resource
+	}{
+		{"playlist/anonymous/view", authz.Playlist, nil, authz.View, true, nil},
+		{"playlist/anonymous/edit", authz.Playlist, nil, authz.Edit, false, nil},
+		{"playlist/stranger/view", authz.Playlist, stranger, authz.View, true, nil},
+		{"playlist/stranger/edit", authz.Playlist, stranger, authz.Edit, false, nil},
+		{"playlist/stranger/manage", authz.Playlist, stranger, authz.Manage, false, nil},
+		{"playlist/collaborator/edit", authz.Playlist, collaborator, authz.Edit, true, nil},
+		{"playlist/collaborator/manage", authz.Playlist, collaborator, authz.Manage, false, nil},
+		{"playlist/owner/edit", authz.Playlist, owner, authz.Edit, true, nil},
+		{"playlist/owner/manage", authz.Playlist, owner, authz.Manage, true, nil},
+		{"playlist/admin/manage", authz.Playlist, admin, authz.Manage, true, nil},
+		{"playlist/viewer/view", authz.Playlist, viewer, authz.View, true, nil},
+		{"playlist/viewer/edit", authz.Playlist, viewer, authz.Edit, false, nil},
+
+		{"private/anonymous/view", authz.Playlist, nil, authz.View, false, &private},
+		{"private/stranger/view", authz.Playlist, stranger, authz.View, false, &private},
+		{"private/viewer/view", authz.Playlist, viewer, authz.View, true, &private},
+		{"private/collaborator/view", authz.Playlist, collaborator, authz.View, true, &private},
+		{"private/owner/view", authz.Playlist, owner, authz.View, true, &private},
+		{"private/admin/view", authz.Playlist, admin, authz.View, true, &private},
+		{"unlisted/anonymous/view", authz.Playlist, nil, authz.View, false, &unlisted},
+		{"unlisted/stranger/view", authz.Playlist, stranger, authz.View, false, &unlisted},
+		{"unlisted/link/view", authz.Playlist, nil, authz.View, true, &linked},
+		{"unlisted/link/edit", authz.Playlist, stranger, authz.Edit, false, &linked},
+		{"private/link/view", authz.Playlist, nil, authz.View, false, func() *authz.Resource { r := private; r.LinkShared = true; return &r }()},
+
+		{"song/anonymous/view", authz.Song, nil, authz.View, true, nil},
+		{"song/stranger/manage", authz.Song, stranger, authz.Manage, false, nil},
+		{"song/collaborator/edit", authz.Song, collaborator, authz.Edit, false, nil},
+		{"song/owner/manage", authz.Song, owner, authz.Manage, true, nil},
+		{"song/admin/manage", authz.Song, admin, authz.Manage, true, nil},
+
+		{"artist/anonymous/edit", authz.ArtistProfile, nil, authz.Edit, false, nil},
+		{"artist/stranger/edit", authz.ArtistProfile, stranger, authz.Edit, false, nil},
+		{"artist/owner/edit", authz.ArtistProfile, owner, authz.Edit, true, nil},
+		{"artist/admin/edit", authz.ArtistProfile, admin, authz.Edit, true, nil},
+	}
+	for _, tc := range cases {
+		r := resource
+		if tc.resource != nil {
+			r = *tc.resource
+		}
+		if got := tc.policy(tc.user, r, tc.action); got != tc.want {
+			t.Errorf("%s: allowed = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// A resource without an owner belongs to nobody, not to every user
+	// without an ID.
+	if authz.Song(&models.User{}, authz.Resource{}, authz.Manage) {
+		t.Error("empty user manages an ownerless song")
+	}
+}
+
+const (
+	authzPlaylistID = "44444444-4444-4444-4444-444444444444"
+	authzSongID     = "55555555-5555-5555-5555-555555555555"
+	authzEntryID    = "66666666-6666-6666-6666-666666666666"
+	authzMissingID  = "77777777-7777-7777-7777-777777777777"
+)
+
+// testShareLinks signs the share links of every test server.
+var testShareLinks = authz.NewShareLinks([]byte("test secret"))
+
+// newAuthzDB holds a public playlist of "owner" with one track, an accepted
+// editor "collab", an accepted viewer "viewer" and an invited editor
+// "pending", a song of "artist-1", a second listener and an admin.
+func newAuthzDB() *fakeScylla {
+	db := newFakeScylla()
+	for _, u := range []models.User{
+		{UserID: "owner", Role: "listener"},
+		{UserID: "stranger", Role: "listener"},
+		{UserID: "collab", Role: "listener"},
+		{UserID: "pending", Role: "listener"},
+		{UserID: "viewer", Role: "listener"},
+		{UserID: "artist-1", Username: "Artist", Role: "artist"},
+		{UserID: "artist-2", Username: "Other", Role: "artist"},
+		{UserID: "root", Role: "admin"},
+	} {
+		u := u
+		db.users[u.UserID] = &u
+	}
+	db.addSong(models.Song{SongID: authzSongID, Title: "Song", UserID: "artist-1", SongURL: "songs/" + authzSongID + "/a.mp3"})
+	playlistID, _ := gocql.ParseUUID(authzPlaylistID)
+	songID, _ := gocql.ParseUUID(authzSongID)
+	entryID, _ := gocql.ParseUUID(authzEntryID)
+	db.AddPlaylist(playlistID, "owner", "Mix", "", models.PlaylistPublic)
+	db.seedTrack(models.PlaylistTrack{PlaylistID: playlistID, Position: 1, EntryID: entryID, SongID: songID, AddedAt: time.Now(), AddedBy: "owner"})
+	db.seedMember(models.PlaylistMember{PlaylistID: playlistID, UserID: "collab", Role: models.PlaylistEditor, Status: models.MemberAccepted})
+	db.seedMember(models.PlaylistMember{PlaylistID: playlistID, UserID: "pending", Role: models.PlaylistEditor, Status: models.MemberInvited})
+	db.seedMember(models.PlaylistMember{PlaylistID: playlistID, UserID: "viewer", Role: models.PlaylistViewer, Status: models.MemberAccepted})
+	return db
+}
+
+func TestRoutesEnforceOwnership(t *testing.T) {
+	playlist := "/playlists/" + authzPlaylistID
+	missing := "/playlists/" + authzMissingID
+	routes := []struct {
+		method, path, missing, body string
+		owner                       string
+		ok                          int
+		collaborator                bool // accepted editors may use it
+		member                      bool // accepted viewers may use it too
+		public                      bool // anyone signed in may use it
+	}{
+		{http.MethodDelete, "/music/" + authzSongID + "/remove", "/music/" + authzMissingID + "/remove", "", "artist-1", http.StatusOK, false, false, false},
+		{http.MethodPut, playlist, missing, `{"name":"Renamed"}`, "owner", http.StatusCreated, false, false, false},
+		{http.MethodDelete, playlist, missing, "", "owner", http.StatusOK, false, false, false},
+		{http.MethodPost, playlist + "/songs/" + authzSongID, missing + "/songs/" + authzSongID, "", "owner", http.StatusCreated, true, false, false},
+		{http.MethodDelete, playlist + "/songs/" + authzSongID, missing + "/songs/" + authzSongID, "", "owner", http.StatusOK, true, false, false},
+		{http.MethodGet, playlist, missing, "", "owner", http.StatusOK, true, false, true},
+		{http.MethodGet, playlist + "/songs", missing + "/songs", "", "owner", http.StatusOK, true, false, true},
+		{http.MethodGet, playlist + "/export", missing + "/export", "", "owner", http.StatusOK, true, false, true},
+		{http.MethodPost, playlist + "/share", missing + "/share", "", "owner", http.StatusCreated, false, false, false},
+		{http.MethodPatch, playlist + "/order", missing + "/order", `{"entry_ids":["` + authzEntryID + `"]}`, "owner", http.StatusOK, true, false, false},
+		{http.MethodPatch, playlist + "/tracks/" + authzEntryID, missing + "/tracks/" + authzEntryID, `{"position":0}`, "owner", http.StatusOK, true, false, false},
+		{http.MethodDelete, playlist + "/tracks/" + authzEntryID, missing + "/tracks/" + authzEntryID, "", "owner", http.StatusOK, true, false, false},
+		{http.MethodGet, playlist + "/members", missing + "/members", "", "owner", http.StatusOK, true, true, false},
+		{http.MethodPost, playlist + "/members", missing + "/members", `{"user_id":"stranger","role":"viewer"}`, "owner", http.StatusCreated, false, false, false},
+		{http.MethodDelete, playlist + "/members/pending", missing + "/members/pending", "", "owner", http.StatusOK, false, false, false},
+		{http.MethodPut, "/artists/artist-1", "/artists/nobody", `{"username":"Renamed"}`, "artist-1", http.StatusOK, false, false, false},
+	}
+
+	type authzCase struct {
+		user, path string
+		want       int
+	}
+	for _, r := range routes {
+		cases := []authzCase{
+			{r.owner, r.path, r.ok},
+			{"root", r.path, r.ok},
+			{r.owner, r.missing, http.StatusNotFound},
+		}
+		for _, other := range []string{"stranger", "artist-2", "pending", "viewer", "collab"} {
+			want := http.StatusForbidden
+			if r.public || (other == "collab" && r.collaborator) || (other == "viewer" && r.member) {
+				want = r.ok
+			}
+			cases = append(cases, authzCase{other, r.path, want})
+		}
+
+		for _, tc := range cases {
+			db := newAuthzDB()
+			store := newFakeMinIO()
+			rec := newTestClient(t, server.Services{DB: db, Storage: store}).do(tc.user, r.method, tc.path, r.body)
+			if rec.Code != tc.want {
+				t.Errorf("%s %s as %s: status = %d, want %d (%s)", r.method, tc.path, tc.user, rec.Code, tc.want, rec.Body.String())
+			}
+			if tc.want == http.StatusForbidden && !untouched(db) {
+				t.Errorf("%s %s as %s: forbidden request changed data", r.method, tc.path, tc.user)
+			}
+		}
+	}
+}
+
+func TestAuthorizeRejectsMalformedIDs(t *testing.T) {
+	client := newTestClient(t, server.Services{DB: newAuthzDB()})
+	for _, target := range []struct{ method, path string }{
+		{http.MethodPut, "/playlists/not-a-uuid"},
+		{http.MethodGet, "/playlists/not-a-uuid/songs"},
+		{http.MethodDelete, "/music/not-a-uuid/remove"},
+	} {
+		if rec := client.do("owner", target.method, target.path, `{}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: status = %d, want 400", target.method, target.path, rec.Code)
+		}
+	}
+}
+
+// untouched reports whether the fixture of newAuthzDB is still intact.
+func untouched(db *fakeScylla) bool {
+	playlistID, _ := gocql.ParseUUID(authzPlaylistID)
+	songID, _ := gocql.ParseUUID(authzSongID)
+	playlist, _ := db.GetPlaylist(playlistID)
+	song, _ := db.GetSongByID(songID)
+	artist, _ := db.GetUserByID("artist-1")
+	tracks := db.tracks[playlistID]
+	members, _ := db.GetPlaylistMembers(playlistID)
+	return playlist != nil && playlist.Name == "Mix" && song != nil && artist.Username == "Artist" &&
+		len(tracks) == 1 && tracks[0].EntryID.String() == authzEntryID && tracks[0].Position == 1 &&
+		len(members) == 3
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rr-backend/internal/charts"
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	chartSongA = "aaaaaaaa-0000-0000-0000-000000000001"
+	chartSongB = "aaaaaaaa-0000-0000-0000-000000000002"
+	chartSongC = "aaaaaaaa-0000-0000-0000-000000000003"
+	chartSongX = "aaaaaaaa-0000-0000-0000-000000000004"
+)
+
+func newChartsDB(now time.Time) *fakeScylla {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Username: "One", Role: "artist"}
+	db.users["artist-2"] = &models.User{UserID: "artist-2", Username: "Two", Role: "artist"}
+	db.addSong(models.Song{SongID: chartSongA, Title: "A", UserID: "artist-1", Genre: "rock", PlayCount: 50})
+	db.addSong(models.Song{SongID: chartSongB, Title: "B", UserID: "artist-2", Genre: "pop", PlayCount: 80})
+	db.addSong(models.Song{SongID: chartSongC, Title: "C", UserID: "artist-1", Genre: "rock", PlayCount: 40})
+	db.addSong(models.Song{SongID: chartSongX, Title: "Gone", UserID: "artist-2", Genre: "rock", PlayCount: 1000, Status: models.SongStatusUnavailable})
+
+	songA, _ := gocql.ParseUUID(chartSongA)
+	songB, _ := gocql.ParseUUID(chartSongB)
+	songC, _ := gocql.ParseUUID(chartSongC)
+
+	// B was already popular yesterday, C is picking up fast.
+	for i := 0; i < 10; i++ {
+		db.RecordPlay("listener", songB, now.Add(-30*time.Hour), 60)
+		db.RecordPlay("listener", songB, now.Add(-2*time.Hour), 60)
+	}
+	for i := 0; i < 6; i++ {
+		db.RecordPlay("listener", songC, now.Add(-3*time.Hour), 60)
+	}
+	db.RecordPlay("listener", songA, now.Add(-time.Hour), 60)
+
+	db.followers["artist-1"] = []time.Time{now.Add(-30 * 24 * time.Hour)}
+	db.followers["artist-2"] = []time.Time{now.Add(-time.Hour), now.Add(-2 * time.Hour)}
+	return db
+}
+
+func getChart(t *testing.T, e *echo.Echo, target string) (int, models.Chart) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	var chart models.Chart
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &chart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, chart
+}
+
+func chartIDs(chart models.Chart) []string {
+	var ids []string
+	for _, entry := range chart.Entries {
+		if entry.SongID != "" {
+			ids = append(ids, entry.SongID)
+		} else {
+			ids = append(ids, entry.ArtistID)
+		}
+	}
+	return ids
+}
+
+func TestCharts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	db := newChartsDB(now)
+
+	e := echo.New()
+	e.GET("/charts/top-songs", handlers.GetTopSongsHandler(db))
+	e.GET("/charts/top-songs/:genre", handlers.GetTopSongsByGenreHandler(db))
+	e.GET("/charts/top-artists", handlers.GetTopArtistsHandler(db))
+	e.GET("/charts/trending", handlers.GetTrendingHandler(db))
+
+	if code, _ := getChart(t, e, "/charts/top-songs"); code != http.StatusNotFound {
+		t.Fatalf("before the first refresh: status = %d, want 404", code)
+	}
+	if err := charts.Refresh(context.Background(), db, now); err != nil {
+		t.Fatal(err)
+	}
+	if db.followerScans != 1 {
+		t.Errorf("new followers were counted in %d reads, want one for all artists", db.followerScans)
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		// Song play counts include the plays recorded above.
+		{"/charts/top-songs", []string{chartSongB, chartSongA, chartSongC}},
+		{"/charts/top-songs?limit=1", []string{chartSongB}},
+		{"/charts/top-songs/rock", []string{chartSongA, chartSongC}},
+		{"/charts/top-artists", []string{"artist-2", "artist-1"}},
+		{"/charts/top-artists?by=followers", []string{"artist-2"}},
+		{"/charts/trending", []string{chartSongC, chartSongB}},
+		{"/charts/trending?window=7d", []string{chartSongB, chartSongC}},
+	}
+	for _, tt := range tests {
+		code, chart := getChart(t, e, tt.target)
+		if code != http.StatusOK {
+			t.Errorf("%s: status = %d", tt.target, code)
+			continue
+		}
+		got := chartIDs(chart)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] || chart.Entries[i].Rank != i+1 {
+				t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+				break
+			}
+		}
+		if !chart.ComputedAt.Equal(now) {
+			t.Errorf("%s: computed_at = %v", tt.target, chart.ComputedAt)
+		}
+	}
+
+	for _, target := range []string{"/charts/top-artists?by=likes", "/charts/trending?window=1y", "/charts/top-songs?limit=0"} {
+		if code, _ := getChart(t, e, target); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, code)
+		}
+	}
+	if code, _ := getChart(t, e, "/charts/top-songs/jazz"); code != http.StatusNotFound {
+		t.Errorf("unknown genre: status = %d, want 404", code)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rr-backend/internal/cleanup"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+)
+
+func TestUploadRollsBackWhenInsertFails(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	db.insertErr = errors.New("scylla unavailable")
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	req := uploadRequest(t,
+		map[string]string{"title": "Track", "releaseDate": "2024-01-02"},
+		uploadFile{"song", "track.mp3", fakeMP3(0x66)},
+		uploadFile{"thumbnail", "cover.png", fakePNG(color.Black)},
+	)
+	rec := client.serve("artist-1", req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("failed upload left %d objects behind", len(store.objects))
+	}
+}
+
+func TestUploadValidatesBeforeStoring(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	req := uploadRequest(t,
+		map[string]string{"title": "Track", "releaseDate": "02/01/2024"},
+		uploadFile{"song", "track.mp3", fakeMP3(0x67)},
+		uploadFile{"thumbnail", "cover.png", fakePNG(color.Black)},
+	)
+	rec := client.serve("artist-1", req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("invalid upload stored %d objects", len(store.objects))
+	}
+}
+
+const removedSongID = "33333333-3333-3333-3333-333333333333"
+
+func addRemovableSong(db *fakeScylla, store *fakeMinIO) {
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	db.addSong(models.Song{
+		SongID:       removedSongID,
+		UserID:       "artist-1",
+		SongURL:      "songs/" + removedSongID + "/a.mp3",
+		ThumbnailURL: "thumbnails/" + removedSongID + "/b.png",
+	})
+	store.put("songs/"+removedSongID+"/a.mp3", fakeMP3(0x77), "audio/mpeg")
+	store.put("thumbnails/"+removedSongID+"/b.png", fakePNG(color.White), "image/png")
+	store.put("hls/"+removedSongID+"/master.m3u8", []byte("#EXTM3U\n"), "application/vnd.apple.mpegurl")
+	store.put("hls/"+removedSongID+"/64k/seg_000.ts", []byte{0x47}, "video/mp2t")
+}
+
+func TestRemoveSongDeletesEveryObject(t *testing.T) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	addRemovableSong(db, store)
+	store.put("songs/other/keep.mp3", fakeMP3(0x78), "audio/mpeg")
+
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+	rec := client.serve("artist-1", httptest.NewRequest(http.MethodDelete, "/music/"+removedSongID+"/remove", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("objects left: %v", store.objects)
+	}
+	if len(db.deletions) != 0 {
+		t.Errorf("unexpected pending deletions: %v", db.deletions)
+	}
+}
+
+func TestFailedRemovalIsRetried(t *testing.T) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	addRemovableSong(db, store)
+	thumbnail := "thumbnails/" + removedSongID + "/b.png"
+	store.removeErr[thumbnail] = errors.New("minio unavailable")
+
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+	rec := client.serve("artist-1", httptest.NewRequest(http.MethodDelete, "/music/"+removedSongID+"/remove", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	pending, _ := db.GetPendingDeletions("music")
+	if len(pending) != 1 || pending[0].ObjectName != thumbnail {
+		t.Fatalf("pending deletions = %+v", pending)
+	}
+
+	// Not due yet: nothing happens.
+	ctx := context.Background()
+	if removed, err := cleanup.RetryPending(ctx, db, store, "music", time.Now()); err != nil || removed != 0 {
+		t.Fatalf("early retry removed %d, err %v", removed, err)
+	}
+
+	// Still failing: the attempt is counted and pushed back.
+	later := time.Now().Add(2 * time.Minute)
+	if _, err := cleanup.RetryPending(ctx, db, store, "music", later); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ = db.GetPendingDeletions("music")
+	if len(pending) != 1 || pending[0].Attempts != 2 || !pending[0].NextAttemptAt.After(later) {
+		t.Fatalf("pending deletions after failed retry = %+v", pending)
+	}
+
+	delete(store.removeErr, thumbnail)
+	removed, err := cleanup.RetryPending(ctx, db, store, "music", later.Add(time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("retry removed %d, err %v", removed, err)
+	}
+	if _, ok := store.data(thumbnail); ok {
+		t.Errorf("thumbnail still stored")
+	}
+	if len(db.deletions) != 0 {
+		t.Errorf("pending deletion was not cleared")
+	}
+}
@@ -0,0 +1,1194 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rr-backend/internal/database"
+	"rr-backend/internal/models"
+
+	"github.com/gocql/gocql"
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeScylla embeds the ScyllaService interface so each test only has to
+// implement the methods it exercises; anything else panics loudly.
+type fakeScylla struct {
+	database.ScyllaService
+
+	mu                sync.Mutex
+	objectNames       map[string]string
+	users             map[string]*models.User
+	songs             map[string]*models.Song
+	songOrder         []string
+	uploads           map[string]*models.Upload
+	deletions         map[string]models.PendingDeletion
+	mediaFailures     map[string]models.MediaFailure
+	claims            map[string]time.Time // user/song -> end of the replay window
+	history           map[string][]models.Play
+	hourlyPlays       map[time.Time]map[string]int64
+	charts            map[string]models.Chart
+	followers         map[string][]time.Time // artist -> followed_at of each follower
+	likes             map[string][]string    // user -> liked song IDs
+	playlists         map[gocql.UUID]*models.Playlist
+	tracks            map[gocql.UUID][]models.PlaylistTrack // kept sorted by position
+	trackVersions     map[gocql.UUID]int
+	largestTrackBatch int                                   // most rows one UpdatePlaylistTracks wrote
+	legacySongs       map[gocql.UUID][]models.PlaylistTrack // playlist_songs rows
+	members           map[gocql.UUID]map[string]models.PlaylistMember
+	apps              map[string]models.ArtistApplication
+	audit             []models.AuditEvent
+
+	// userLookups and songLookups count GetUserByID and GetSongByID calls,
+	// followerScans CountNewFollowers calls.
+	userLookups   int
+	songLookups   int
+	followerScans int
+
+	// insertErr, when set, makes InsertSong fail.
+	insertErr error
+	// updateObjectsErr, when set, makes UpdateSongObjects fail.
+	updateObjectsErr error
+	// completeUploadErr, when set, makes CompleteUpload fail.
+	completeUploadErr error
+}
+
+func newFakeScylla() *fakeScylla {
+	return &fakeScylla{
+		objectNames:   map[string]string{},
+		users:         map[string]*models.User{},
+		songs:         map[string]*models.Song{},
+		uploads:       map[string]*models.Upload{},
+		deletions:     map[string]models.PendingDeletion{},
+		mediaFailures: map[string]models.MediaFailure{},
+		claims:        map[string]time.Time{},
+		likes:         map[string][]string{},
+		history:       map[string][]models.Play{},
+		hourlyPlays:   map[time.Time]map[string]int64{},
+		charts:        map[string]models.Chart{},
+		followers:     map[string][]time.Time{},
+		playlists:     map[gocql.UUID]*models.Playlist{},
+		tracks:        map[gocql.UUID][]models.PlaylistTrack{},
+		trackVersions: map[gocql.UUID]int{},
+		legacySongs:   map[gocql.UUID][]models.PlaylistTrack{},
+		members:       map[gocql.UUID]map[string]models.PlaylistMember{},
+		apps:          map[string]models.ArtistApplication{},
+	}
+}
+
+func (f *fakeScylla) addSong(song models.Song) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.songs[song.SongID] = &song
+	f.songOrder = append(f.songOrder, song.SongID)
+	f.objectNames[song.SongID] = song.SongURL
+}
+
+func (f *fakeScylla) GetUserByID(userID string) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.userLookups++
+	if u, ok := f.users[userID]; ok {
+		copied := *u
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScylla) GetUsersByIDs(userIDs []string) ([]models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var users []models.User
+	for _, userID := range userIDs {
+		if u, ok := f.users[userID]; ok {
+			users = append(users, *u)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeScylla) UpsertUser(userID, username, email, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[userID] = &models.User{UserID: userID, Username: username, Email: email, Role: role}
+	return nil
+}
+
+func (f *fakeScylla) SignInUser(identity models.User, seenAt time.Time) (*models.User, error) {
+	f.mu.Lock()
+	u, ok := f.users[identity.UserID]
+	if !ok {
+		u = &models.User{UserID: identity.UserID, Username: identity.Username, Role: models.DefaultRole, FirstSeen: &seenAt}
+		f.users[identity.UserID] = u
+	}
+	u.Email, u.PhotoURL, u.LastSeen = identity.Email, identity.PhotoURL, &seenAt
+	if u.FirstSeen == nil {
+		u.FirstSeen = &seenAt
+	}
+	if u.Username == "" {
+		u.Username = identity.Username
+	}
+	if u.Role == "" {
+		u.Role = models.DefaultRole
+	}
+	f.mu.Unlock()
+	return f.GetUserByID(identity.UserID)
+}
+
+func (f *fakeScylla) UpdateUserRole(userID, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[userID]; ok {
+		u.Role = role
+	}
+	return nil
+}
+
+func (f *fakeScylla) UpdateUsername(userID, username string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[userID]; ok {
+		u.Username = username
+	}
+	return nil
+}
+
+func (f *fakeScylla) GetUsers(limit int, pageState []byte) ([]models.User, []byte, error) {
+	f.mu.Lock()
+	var users []models.User
+	for _, u := range f.users {
+		users = append(users, *u)
+	}
+	f.mu.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return fakePage(users, limit, pageState)
+}
+
+func (f *fakeScylla) SetUserStatus(userID, status string, suspendedUntil *time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[userID]; ok {
+		u.Status, u.SuspendedUntil = status, suspendedUntil
+	}
+	return nil
+}
+
+func (f *fakeScylla) RecordAuditEvent(event models.AuditEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audit = append(f.audit, event)
+	return nil
+}
+
+// GetAuditEvents lists the recorded events newest first; events were
+// recorded in order, so Before is found by position rather than by time.
+func (f *fakeScylla) GetAuditEvents(filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []models.AuditEvent
+	seenBefore := filter.Before == nil
+	for i := len(f.audit) - 1; i >= 0 && len(events) < limit; i-- {
+		event := f.audit[i]
+		if !seenBefore {
+			seenBefore = event.EventID == *filter.Before
+			continue
+		}
+		switch {
+		case filter.ActorID != "" && event.ActorID != filter.ActorID:
+		case filter.TargetID != "" && (event.TargetType != filter.TargetType || event.TargetID != filter.TargetID):
+		case event.At.Before(filter.From) || event.At.After(filter.To):
+		default:
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeScylla) SaveArtistApplication(application models.ArtistApplication) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apps[application.UserID] = application
+	return nil
+}
+
+func (f *fakeScylla) GetArtistApplication(userID string) (*models.ArtistApplication, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a, ok := f.apps[userID]; ok {
+		return &a, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScylla) GetArtistApplications(status string, limit int, pageState []byte) ([]models.ArtistApplication, []byte, error) {
+	f.mu.Lock()
+	var applications []models.ArtistApplication
+	for _, a := range f.apps {
+		if a.Status == status {
+			applications = append(applications, a)
+		}
+	}
+	f.mu.Unlock()
+	sort.Slice(applications, func(i, j int) bool { return applications[i].UserID < applications[j].UserID })
+	return fakePage(applications, limit, pageState)
+}
+
+func (f *fakeScylla) ReviewArtistApplication(userID, status, reviewedBy, note string, reviewedAt time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.apps[userID]
+	if !ok || a.Status != models.ApplicationPending {
+		return false, nil
+	}
+	a.Status, a.ReviewedBy, a.ReviewedAt, a.ReviewNote = status, reviewedBy, &reviewedAt, note
+	f.apps[userID] = a
+	return true, nil
+}
+
+func (f *fakeScylla) GetSongsByUserID(userID string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	var owned []models.Song
+	for _, song := range f.allSongs() {
+		if song.UserID == userID {
+			owned = append(owned, song)
+		}
+	}
+	return fakePage(owned, limit, pageState)
+}
+
+func (f *fakeScylla) GetArtistWithSongs(artistID string, limit int, pageState []byte) (*models.ArtistWithSongs, []byte, error) {
+	f.mu.Lock()
+	u, ok := f.users[artistID]
+	var artist models.Artist
+	if ok {
+		artist = models.Artist{UserID: u.UserID, Username: u.Username, Role: u.Role, Followers: len(f.followers[artistID])}
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil, nil, gocql.ErrNotFound
+	}
+	songs, next, err := f.GetSongsByUserID(artistID, limit, pageState)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.ArtistWithSongs{Artist: artist, Songs: songs}, next, nil
+}
+
+func (f *fakeScylla) LikeSong(userID string, songID gocql.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.likes[userID] = append(f.likes[userID], songID.String())
+	return nil
+}
+
+func (f *fakeScylla) GetLikedSongsByUser(userID string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	f.mu.Lock()
+	var liked []models.Song
+	for _, songID := range f.likes[userID] {
+		if song, ok := f.songs[songID]; ok {
+			liked = append(liked, *song)
+		}
+	}
+	f.mu.Unlock()
+	return fakePage(liked, limit, pageState)
+}
+
+func (f *fakeScylla) AddPlaylist(playlistID gocql.UUID, userID, name, description, visibility string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.playlists[playlistID] = &models.Playlist{PlaylistID: playlistID, UserID: userID, Name: name, Description: description, Visibility: visibility}
+	return nil
+}
+
+func (f *fakeScylla) UpdatePlaylist(playlistID gocql.UUID, name, description, visibility string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.playlists[playlistID]; ok {
+		p.Name, p.Description, p.Visibility = name, description, visibility
+	}
+	return nil
+}
+
+func (f *fakeScylla) RemovePlaylist(playlistID gocql.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.playlists, playlistID)
+	delete(f.tracks, playlistID)
+	delete(f.trackVersions, playlistID)
+	delete(f.legacySongs, playlistID)
+	delete(f.members, playlistID)
+	return nil
+}
+
+func (f *fakeScylla) GetPlaylist(playlistID gocql.UUID) (*models.Playlist, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.playlists[playlistID]; ok {
+		copied := *p
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScylla) GetAllPlaylists(limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	f.mu.Lock()
+	var playlists []models.Playlist
+	for _, p := range f.playlists {
+		playlists = append(playlists, *p)
+	}
+	f.mu.Unlock()
+	sort.Slice(playlists, func(i, j int) bool { return playlists[i].PlaylistID.String() < playlists[j].PlaylistID.String() })
+	return fakePage(playlists, limit, pageState)
+}
+
+func (f *fakeScylla) FetchPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	all, _ := database.All(f.GetAllPlaylists)
+	var owned []models.Playlist
+	for _, p := range all {
+		if p.UserID == userID {
+			owned = append(owned, p)
+		}
+	}
+	return fakePage(owned, limit, pageState)
+}
+
+func (f *fakeScylla) GetSharedPlaylists(userID string, limit int, pageState []byte) ([]models.Playlist, []byte, error) {
+	all, _ := database.All(f.GetAllPlaylists)
+	var shared []models.Playlist
+	f.mu.Lock()
+	for _, p := range all {
+		if m, ok := f.members[p.PlaylistID][userID]; ok && m.Status == models.MemberAccepted {
+			p.MemberRole = m.Role
+			shared = append(shared, p)
+		}
+	}
+	f.mu.Unlock()
+	return fakePage(shared, limit, pageState)
+}
+
+func (f *fakeScylla) GetPlaylistInvitations(userID string, limit int, pageState []byte) ([]models.PlaylistInvitation, []byte, error) {
+	all, _ := database.All(f.GetAllPlaylists)
+	var invitations []models.PlaylistInvitation
+	f.mu.Lock()
+	for _, p := range all {
+		if m, ok := f.members[p.PlaylistID][userID]; ok && m.Status == models.MemberInvited {
+			invitations = append(invitations, models.PlaylistInvitation{Playlist: p, Role: m.Role, InvitedBy: m.InvitedBy, InvitedAt: m.InvitedAt})
+		}
+	}
+	f.mu.Unlock()
+	return fakePage(invitations, limit, pageState)
+}
+
+func (f *fakeScylla) InvitePlaylistMember(member models.PlaylistMember) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.members[member.PlaylistID][member.UserID]; ok {
+		return false, nil
+	}
+	if f.members[member.PlaylistID] == nil {
+		f.members[member.PlaylistID] = map[string]models.PlaylistMember{}
+	}
+	f.members[member.PlaylistID][member.UserID] = member
+	return true, nil
+}
+
+func (f *fakeScylla) SetPlaylistMemberRole(playlistID gocql.UUID, userID, role string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.members[playlistID][userID]
+	if !ok {
+		return false, nil
+	}
+	m.Role = role
+	f.members[playlistID][userID] = m
+	return true, nil
+}
+
+// seedMember stores a membership as it is, whatever its status.
+func (f *fakeScylla) seedMember(member models.PlaylistMember) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.members[member.PlaylistID] == nil {
+		f.members[member.PlaylistID] = map[string]models.PlaylistMember{}
+	}
+	f.members[member.PlaylistID][member.UserID] = member
+}
+
+func (f *fakeScylla) GetPlaylistMember(playlistID gocql.UUID, userID string) (*models.PlaylistMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.members[playlistID][userID]; ok {
+		return &m, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScylla) GetPlaylistMembers(playlistID gocql.UUID) ([]models.PlaylistMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var members []models.PlaylistMember
+	for _, m := range f.members[playlistID] {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members, nil
+}
+
+func (f *fakeScylla) AcceptPlaylistInvite(playlistID gocql.UUID, userID string, acceptedAt time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.members[playlistID][userID]
+	if !ok || m.Status != models.MemberInvited {
+		return false, nil
+	}
+	m.Status, m.AcceptedAt = models.MemberAccepted, &acceptedAt
+	f.members[playlistID][userID] = m
+	return true, nil
+}
+
+func (f *fakeScylla) RemovePlaylistMember(playlistID gocql.UUID, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.members[playlistID], userID)
+	return nil
+}
+
+func (f *fakeScylla) GetPlaylistTracks(playlistID gocql.UUID, limit int, pageState []byte) ([]models.PlaylistTrack, []byte, error) {
+	f.mu.Lock()
+	tracks := append([]models.PlaylistTrack(nil), f.tracks[playlistID]...)
+	f.mu.Unlock()
+	for i := range tracks {
+		tracks[i].Song, _ = f.GetSongByID(tracks[i].SongID)
+	}
+	return fakePage(tracks, limit, pageState)
+}
+
+func (f *fakeScylla) GetPlaylistTracksVersion(playlistID gocql.UUID) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.trackVersions[playlistID], nil
+}
+
+func (f *fakeScylla) UpdatePlaylistTracks(playlistID gocql.UUID, version int, remove, add []models.PlaylistTrack) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.trackVersions[playlistID] != version {
+		return false, nil
+	}
+	f.trackVersions[playlistID] = version + 1
+	if rows := len(remove) + len(add); rows > f.largestTrackBatch {
+		f.largestTrackBatch = rows
+	}
+	var kept []models.PlaylistTrack
+	for _, t := range f.tracks[playlistID] {
+		removed := false
+		for _, r := range remove {
+			removed = removed || (t.EntryID == r.EntryID && t.Position == r.Position)
+		}
+		if !removed {
+			kept = append(kept, t)
+		}
+	}
+	for _, t := range add {
+		t.PlaylistID, t.Song = playlistID, nil
+		kept = append(kept, t)
+	}
+	f.setTracks(playlistID, kept)
+	return true, nil
+}
+
+// seedTrack adds a track without going through the playlists package.
+func (f *fakeScylla) seedTrack(track models.PlaylistTrack) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setTracks(track.PlaylistID, append(f.tracks[track.PlaylistID], track))
+}
+
+// setTracks stores tracks in clustering order, like playlist_tracks.
+func (f *fakeScylla) setTracks(playlistID gocql.UUID, tracks []models.PlaylistTrack) {
+	sort.Slice(tracks, func(i, j int) bool {
+		if tracks[i].Position != tracks[j].Position {
+			return tracks[i].Position < tracks[j].Position
+		}
+		return tracks[i].EntryID.String() < tracks[j].EntryID.String()
+	})
+	f.tracks[playlistID] = tracks
+}
+
+func (f *fakeScylla) GetLegacyPlaylistSongs(playlistID gocql.UUID) ([]models.PlaylistTrack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.PlaylistTrack(nil), f.legacySongs[playlistID]...), nil
+}
+
+func (f *fakeScylla) RemoveLegacyPlaylistSongs(playlistID gocql.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.legacySongs, playlistID)
+	return nil
+}
+
+func (f *fakeScylla) InsertSong(songID gocql.UUID, title, userID, album string, releaseDate time.Time, genre, songURL, thumbnailURL string, audio models.AudioInfo) error {
+	if f.insertErr != nil {
+		return f.insertErr
+	}
+	f.addSong(models.Song{
+		SongID:       songID.String(),
+		Title:        title,
+		UserID:       userID,
+		Album:        album,
+		ReleaseDate:  releaseDate,
+		Genre:        genre,
+		SongURL:      songURL,
+		ThumbnailURL: thumbnailURL,
+		AudioInfo:    audio,
+	})
+	return nil
+}
+
+func (f *fakeScylla) RemoveSong(songID gocql.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.songs, songID.String())
+	delete(f.objectNames, songID.String())
+	return nil
+}
+
+func (f *fakeScylla) GetSongUserID(songID gocql.UUID) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if song, ok := f.songs[songID.String()]; ok {
+		return song.UserID, nil
+	}
+	return "", nil
+}
+
+func (f *fakeScylla) GetSongThumbnailBySongID(songID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	song, ok := f.songs[songID]
+	if !ok {
+		return "", gocql.ErrNotFound
+	}
+	return song.ThumbnailURL, nil
+}
+
+// allSongs returns every song in insertion order.
+func (f *fakeScylla) allSongs() []models.Song {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var songs []models.Song
+	for _, id := range f.songOrder {
+		if song, ok := f.songs[id]; ok {
+			songs = append(songs, *song)
+		}
+	}
+	return songs
+}
+
+func (f *fakeScylla) GetAllSongs(limit int, pageState []byte) ([]models.Song, []byte, error) {
+	return fakePage(f.allSongs(), limit, pageState)
+}
+
+func (f *fakeScylla) UpdateSongObjects(songID gocql.UUID, songURL, thumbnailURL string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.updateObjectsErr != nil {
+		return f.updateObjectsErr
+	}
+	song, ok := f.songs[songID.String()]
+	if !ok {
+		return gocql.ErrNotFound
+	}
+	song.SongURL = songURL
+	song.ThumbnailURL = thumbnailURL
+	f.objectNames[song.SongID] = songURL
+	return nil
+}
+
+func (f *fakeScylla) SetSongStatus(songID gocql.UUID, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	song, ok := f.songs[songID.String()]
+	if !ok {
+		return gocql.ErrNotFound
+	}
+	song.Status = status
+	return nil
+}
+
+func (f *fakeScylla) UpdateSongManifest(songID gocql.UUID, manifestURL string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	song, ok := f.songs[songID.String()]
+	if !ok {
+		return gocql.ErrNotFound
+	}
+	song.HLSManifest = manifestURL
+	return nil
+}
+
+func (f *fakeScylla) GetSongStatus(songID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if song, ok := f.songs[songID]; ok {
+		return song.Status, nil
+	}
+	return "", nil
+}
+
+func (f *fakeScylla) GetSongByID(songID gocql.UUID) (*models.Song, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.songLookups++
+	if song, ok := f.songs[songID.String()]; ok {
+		copied := *song
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScylla) GetSongsByIDs(songIDs []gocql.UUID) ([]models.Song, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var songs []models.Song
+	for _, songID := range songIDs {
+		if song, ok := f.songs[songID.String()]; ok {
+			songs = append(songs, *song)
+		}
+	}
+	return songs, nil
+}
+
+func (f *fakeScylla) ClaimPlay(userID string, songID gocql.UUID, window time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := userID + "/" + songID.String()
+	if until, ok := f.claims[key]; ok && time.Now().Before(until) {
+		return false, nil
+	}
+	f.claims[key] = time.Now().Add(window)
+	return true, nil
+}
+
+func (f *fakeScylla) RecordPlay(userID string, songID gocql.UUID, playedAt time.Time, listenedSeconds float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if song, ok := f.songs[songID.String()]; ok {
+		song.PlayCount++
+	}
+	hour := playedAt.UTC().Truncate(time.Hour)
+	if f.hourlyPlays[hour] == nil {
+		f.hourlyPlays[hour] = map[string]int64{}
+	}
+	f.hourlyPlays[hour][songID.String()]++
+	play := models.Play{SongID: songID.String(), PlayedAt: playedAt, ListenedSeconds: listenedSeconds}
+	f.history[userID] = append([]models.Play{play}, f.history[userID]...)
+	return nil
+}
+
+// GetListeningHistory pages through the history with the offset as page state.
+func (f *fakeScylla) GetListeningHistory(userID string, limit int, pageState []byte) ([]models.Play, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePage(f.history[userID], limit, pageState)
+}
+
+func (f *fakeScylla) GetHourlyPlays(hour time.Time) (map[string]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	plays := map[string]int64{}
+	for songID, n := range f.hourlyPlays[hour.UTC().Truncate(time.Hour)] {
+		plays[songID] = n
+	}
+	return plays, nil
+}
+
+func (f *fakeScylla) GetSongsByGenre(genre string, limit int, pageState []byte) ([]models.Song, []byte, error) {
+	var matched []models.Song
+	for _, song := range f.allSongs() {
+		if song.Genre == genre {
+			matched = append(matched, song)
+		}
+	}
+	return fakePage(matched, limit, pageState)
+}
+
+// GetAllArtists reports every user with the artist role.
+func (f *fakeScylla) GetAllArtists(limit int, pageState []byte) ([]models.Artist, []byte, error) {
+	f.mu.Lock()
+	var artists []models.Artist
+	for _, u := range f.users {
+		if u.Role == "artist" {
+			artists = append(artists, models.Artist{UserID: u.UserID, Username: u.Username, Role: u.Role, Followers: len(f.followers[u.UserID])})
+		}
+	}
+	f.mu.Unlock()
+	sort.Slice(artists, func(i, j int) bool { return artists[i].UserID < artists[j].UserID })
+	return fakePage(artists, limit, pageState)
+}
+
+func (f *fakeScylla) FollowArtist(artistID string, followerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.followers[artistID] = append(f.followers[artistID], time.Now())
+	return nil
+}
+
+// UnfollowArtist forgets the newest follower; followers are not tracked by ID.
+func (f *fakeScylla) UnfollowArtist(artistID string, followerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n := len(f.followers[artistID]); n > 0 {
+		f.followers[artistID] = f.followers[artistID][:n-1]
+	}
+	return nil
+}
+
+func (f *fakeScylla) CountNewFollowers(since time.Time) (map[string]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.followerScans++
+	counts := map[string]int{}
+	for artistID, followed := range f.followers {
+		for _, followedAt := range followed {
+			if followedAt.After(since) {
+				counts[artistID]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (f *fakeScylla) SaveChart(chart models.Chart) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.charts[chart.Name] = chart
+	return nil
+}
+
+func (f *fakeScylla) GetChart(name string) (*models.Chart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chart, ok := f.charts[name]
+	if !ok {
+		return nil, nil
+	}
+	chart.Entries = append([]models.ChartEntry(nil), chart.Entries...)
+	return &chart, nil
+}
+
+func fakePage[T any](items []T, limit int, pageState []byte) ([]T, []byte, error) {
+	offset := 0
+	if len(pageState) > 0 {
+		n, err := strconv.Atoi(string(pageState))
+		if err != nil {
+			return nil, nil, err
+		}
+		offset = n
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := offset + limit
+	if end >= len(items) {
+		return append([]T(nil), items[offset:]...), nil, nil
+	}
+	return append([]T(nil), items[offset:end]...), []byte(strconv.Itoa(end)), nil
+}
+
+func (f *fakeScylla) GetObjectNameBySongID(songID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, ok := f.objectNames[songID]
+	if !ok {
+		return "", gocql.ErrNotFound
+	}
+	return name, nil
+}
+
+func (f *fakeScylla) CreateUpload(upload models.Upload) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload.Parts = map[int]string{}
+	upload.NextPart = 1
+	f.uploads[upload.UploadID.String()] = &upload
+	return nil
+}
+
+func (f *fakeScylla) GetUpload(uploadID gocql.UUID) (*models.Upload, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uploads[uploadID.String()]
+	if !ok {
+		return nil, nil
+	}
+	copied := *u
+	copied.Parts = map[int]string{}
+	for n, etag := range u.Parts {
+		copied.Parts[n] = etag
+	}
+	return &copied, nil
+}
+
+func (f *fakeScylla) ClaimUploadPart(upload models.Upload) (int, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uploads[upload.UploadID.String()]
+	if !ok || u.Offset != upload.Offset || u.NextPart != upload.NextPart {
+		return 0, false, nil
+	}
+	u.NextPart++
+	return upload.NextPart, true, nil
+}
+
+func (f *fakeScylla) AdvanceUpload(upload models.Upload, fromOffset int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uploads[upload.UploadID.String()]
+	if !ok || u.Offset != fromOffset {
+		return false, nil
+	}
+	u.Offset = upload.Offset
+	u.Parts = upload.Parts
+	u.PendingObject, u.PendingSize = upload.PendingObject, upload.PendingSize
+	return true, nil
+}
+
+func (f *fakeScylla) ClaimUploadFinish(upload models.Upload, claimedAt time.Time, lease time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.uploads[upload.UploadID.String()]
+	if !ok || u.SongID != (gocql.UUID{}) || !u.FinalizingAt.Equal(upload.FinalizingAt) {
+		return false, nil
+	}
+	if !u.FinalizingAt.IsZero() && claimedAt.Sub(u.FinalizingAt) < lease {
+		return false, nil
+	}
+	u.FinalizingAt = claimedAt
+	return true, nil
+}
+
+func (f *fakeScylla) ReleaseUploadFinish(upload models.Upload) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.uploads[upload.UploadID.String()]; ok && u.FinalizingAt.Equal(upload.FinalizingAt) {
+		u.FinalizingAt = time.Time{}
+	}
+	return nil
+}
+
+func (f *fakeScylla) CompleteUpload(upload models.Upload, songID gocql.UUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.completeUploadErr != nil {
+		return false, f.completeUploadErr
+	}
+	u, ok := f.uploads[upload.UploadID.String()]
+	if !ok || !u.FinalizingAt.Equal(upload.FinalizingAt) {
+		return false, nil
+	}
+	u.SongID = songID
+	return true, nil
+}
+
+func (f *fakeScylla) RemoveUpload(uploadID gocql.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.uploads, uploadID.String())
+	return nil
+}
+
+func (f *fakeScylla) AddPendingDeletion(deletion models.PendingDeletion) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletions[deletion.Bucket+"/"+deletion.ObjectName] = deletion
+	return nil
+}
+
+func (f *fakeScylla) GetPendingDeletions(bucketName string) ([]models.PendingDeletion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deletions []models.PendingDeletion
+	for _, d := range f.deletions {
+		if d.Bucket == bucketName {
+			deletions = append(deletions, d)
+		}
+	}
+	return deletions, nil
+}
+
+func (f *fakeScylla) RemovePendingDeletion(bucketName, objectName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.deletions, bucketName+"/"+objectName)
+	return nil
+}
+
+func (f *fakeScylla) RecordMediaFailure(failure models.MediaFailure) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mediaFailures[failure.Job+"/"+failure.SongID] = failure
+	return nil
+}
+
+func (f *fakeScylla) GetMediaFailures(job string) ([]models.MediaFailure, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var failures []models.MediaFailure
+	for _, failure := range f.mediaFailures {
+		if failure.Job == job {
+			failures = append(failures, failure)
+		}
+	}
+	return failures, nil
+}
+
+func (f *fakeScylla) RemoveMediaFailure(job, songID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.mediaFailures, job+"/"+songID)
+	return nil
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+}
+
+// fakeMinIO is an in-memory MinIOService that records every ranged read so
+// tests can assert how many bytes were pulled from storage.
+type fakeMinIO struct {
+	database.MinIOService
+
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	reads   []readCall
+	// parts holds the parts of open multipart uploads, keyed by upload ID.
+	parts map[string]map[int][]byte
+	// multiparts describes the open multipart uploads, keyed by upload ID.
+	multiparts map[string]minio.ObjectMultipartInfo
+	// removeErr makes RemoveObject fail for the listed objects.
+	removeErr map[string]error
+}
+
+type readCall struct {
+	objectName string
+	start, end int64
+}
+
+func newFakeMinIO() *fakeMinIO {
+	return &fakeMinIO{objects: map[string]*fakeObject{}, parts: map[string]map[int][]byte{}, multiparts: map[string]minio.ObjectMultipartInfo{}, removeErr: map[string]error{}}
+}
+
+func (f *fakeMinIO) put(objectName string, data []byte, contentType string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj := &fakeObject{
+		data:        data,
+		contentType: contentType,
+		etag:        fmt.Sprintf("%x", len(data)) + "-" + path.Base(objectName),
+		modTime:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.objects[objectName] = obj
+	return obj
+}
+
+func (f *fakeMinIO) bytesRead() (n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.reads {
+		n += r.end - r.start + 1
+	}
+	return n
+}
+
+func (f *fakeMinIO) StatObject(ctx context.Context, bucketName, objectName string) (minio.ObjectInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey", StatusCode: 404}
+	}
+	return minio.ObjectInfo{
+		Key:          objectName,
+		Size:         int64(len(obj.data)),
+		ETag:         obj.etag,
+		ContentType:  obj.contentType,
+		LastModified: obj.modTime,
+	}, nil
+}
+
+func (f *fakeMinIO) GetObjectRange(ctx context.Context, bucketName, objectName string, start, end int64) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return nil, minio.ErrorResponse{Code: "NoSuchKey", StatusCode: 404}
+	}
+	if start < 0 || end >= int64(len(obj.data)) || start > end {
+		return nil, fmt.Errorf("invalid range %d-%d for %d bytes", start, end, len(obj.data))
+	}
+	f.reads = append(f.reads, readCall{objectName: objectName, start: start, end: end})
+	return io.NopCloser(bytes.NewReader(obj.data[start : end+1])), nil
+}
+
+func (f *fakeMinIO) UploadObject(bucketName, objectName string, reader io.Reader, objectSize int64, contentType string) (*minio.UploadInfo, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != objectSize {
+		return nil, fmt.Errorf("read %d bytes, expected %d", len(data), objectSize)
+	}
+	f.put(objectName, data, contentType)
+	return &minio.UploadInfo{Bucket: bucketName, Key: objectName, Size: objectSize}, nil
+}
+
+func (f *fakeMinIO) CopyObject(ctx context.Context, bucketName, srcObjectName, dstObjectName, contentType string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	src, ok := f.objects[srcObjectName]
+	if !ok {
+		return minio.ErrorResponse{Code: "NoSuchKey", StatusCode: 404}
+	}
+	copied := *src
+	if contentType != "" {
+		copied.contentType = contentType
+	}
+	f.objects[dstObjectName] = &copied
+	return nil
+}
+
+func (f *fakeMinIO) RemoveObject(bucketName, objectName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.removeErr[objectName]; err != nil {
+		return err
+	}
+	delete(f.objects, objectName)
+	return nil
+}
+
+func (f *fakeMinIO) ListObjects(ctx context.Context, bucketName, prefix string) ([]minio.ObjectInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var objects []minio.ObjectInfo
+	for name, obj := range f.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, minio.ObjectInfo{Key: name, Size: int64(len(obj.data)), ETag: obj.etag, LastModified: obj.modTime})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (f *fakeMinIO) NewMultipartUpload(ctx context.Context, bucketName, objectName, contentType string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploadID := fmt.Sprintf("multipart-%d", len(f.parts)+1)
+	f.parts[uploadID] = map[int][]byte{}
+	f.multiparts[uploadID] = minio.ObjectMultipartInfo{Key: objectName, UploadID: uploadID, Initiated: time.Now()}
+	return uploadID, nil
+}
+
+func (f *fakeMinIO) PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) != size {
+		return "", fmt.Errorf("read %d bytes, expected %d", len(data), size)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts, ok := f.parts[uploadID]
+	if !ok {
+		return "", minio.ErrorResponse{Code: "NoSuchUpload", StatusCode: 404}
+	}
+	parts[partNumber] = data
+	return fmt.Sprintf("etag-%d", partNumber), nil
+}
+
+func (f *fakeMinIO) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts map[int]string) error {
+	f.mu.Lock()
+	stored, ok := f.parts[uploadID]
+	delete(f.parts, uploadID)
+	delete(f.multiparts, uploadID)
+	f.mu.Unlock()
+	if !ok {
+		return minio.ErrorResponse{Code: "NoSuchUpload", StatusCode: 404}
+	}
+	// Like S3, only the listed parts make up the object, in part order.
+	numbers := make([]int, 0, len(parts))
+	for n := range parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var data []byte
+	for _, n := range numbers {
+		part, ok := stored[n]
+		if !ok || parts[n] != fmt.Sprintf("etag-%d", n) {
+			return fmt.Errorf("part %d missing", n)
+		}
+		data = append(data, part...)
+	}
+	f.put(objectName, data, "application/octet-stream")
+	return nil
+}
+
+func (f *fakeMinIO) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.parts, uploadID)
+	delete(f.multiparts, uploadID)
+	return nil
+}
+
+func (f *fakeMinIO) ListIncompleteUploads(ctx context.Context, bucketName, prefix string) ([]minio.ObjectMultipartInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uploads []minio.ObjectMultipartInfo
+	for _, upload := range f.multiparts {
+		if strings.HasPrefix(upload.Key, prefix) {
+			uploads = append(uploads, upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Key < uploads[j].Key })
+	return uploads, nil
+}
+
+// Presigned URLs point at a fake host; tests play the client by calling put.
+// The form fields carry the key and, in place of a signed policy, the only
+// size the form accepts.
+func (f *fakeMinIO) PresignedPostObject(ctx context.Context, bucketName, objectName string, size int64, expiry time.Duration) (*url.URL, map[string]string, error) {
+	u, err := url.Parse("http://minio.test/" + bucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, map[string]string{"key": objectName, "content-length": fmt.Sprint(size)}, nil
+}
+
+func (f *fakeMinIO) PresignedGetObject(ctx context.Context, bucketName, objectName string, expiry time.Duration, reqParams url.Values) (*url.URL, error) {
+	return url.Parse("http://minio.test/" + bucketName + "/" + objectName + "?X-Amz-Expires=" + fmt.Sprint(int(expiry.Seconds())))
+}
+
+func (f *fakeMinIO) data(objectName string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return nil, false
+	}
+	return obj.data, true
+}
@@ -0,0 +1,319 @@
+package tests
+
+import (
+	"context"
+	"image/color"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/jobs"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+// fakeFFmpeg points FFMPEG_PATH at a script that records its arguments, one
+// call per line, and writes the files ffmpeg would: the output named by the
+// last argument and, for HLS, a segment next to it. With fail set it exits
+// with an error instead. It returns the recorded calls.
+func fakeFFmpeg(t *testing.T, fail bool) func() []string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> "` + calls + `"
+if [ -n "$FAKE_FFMPEG_FAIL" ]; then echo "invalid data found" >&2; exit 1; fi
+prev=""
+for arg; do
+	if [ "$prev" = "-hls_segment_filename" ]; then printf segment > "$(dirname "$arg")/seg_000.ts"; fi
+	prev="$arg"
+done
+printf output > "$prev"
+`
+	bin := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FFMPEG_PATH", bin)
+	if fail {
+		t.Setenv("FAKE_FFMPEG_FAIL", "1")
+	}
+	return func() []string {
+		data, err := os.ReadFile(calls)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+func TestHLSObjectName(t *testing.T) {
+	const manifest = "hls/song-1/master.m3u8"
+	for _, tc := range []struct {
+		variant, file string
+		want          string
+	}{
+		{"64k", "index.m3u8", "hls/song-1/64k/index.m3u8"},
+		{"256k", "seg_012.ts", "hls/song-1/256k/seg_012.ts"},
+		{"96k", "index.m3u8", ""},
+		{"", "master.m3u8", ""},
+		{"64k", "../../songs/a.mp3", ""},
+		{"..", "index.m3u8", ""},
+		{"64k", "seg/../index.m3u8", ""},
+		{"64k", "SEG_000.TS", ""},
+		{"64k", "index.m3u8.bak", ""},
+		{"64k", "cover.jpg", ""},
+	} {
+		got, ok := media.HLSObjectName(manifest, tc.variant, tc.file)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("HLSObjectName(%q, %q) = %q, %v, want %q", tc.variant, tc.file, got, ok, tc.want)
+		}
+	}
+}
+
+func TestPackageHLS(t *testing.T) {
+	songID := gocql.TimeUUID()
+	source := "songs/" + songID.String() + "/a.mp3"
+	prefix := media.HLSPrefix(songID.String())
+	setup := func(exists bool) (*fakeScylla, *fakeMinIO) {
+		db := newFakeScylla()
+		store := newFakeMinIO()
+		if exists {
+			db.addSong(models.Song{SongID: songID.String(), SongURL: source})
+		}
+		store.put(source, fakeMP3(0x01), "audio/mpeg")
+		return db, store
+	}
+	stored := func(store *fakeMinIO) []string {
+		objects, _ := store.ListObjects(context.Background(), "music", prefix)
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+
+	t.Run("packaged", func(t *testing.T) {
+		calls := fakeFFmpeg(t, false)
+		db, store := setup(true)
+		if err := media.PackageHLS(context.Background(), db, store, songID, source); err != nil {
+			t.Fatal(err)
+		}
+
+		ran := calls()
+		if len(ran) != len(media.HLSVariants) {
+			t.Fatalf("ffmpeg ran %d times: %q", len(ran), ran)
+		}
+		for i, v := range media.HLSVariants {
+			for _, want := range []string{
+				"-map 0:a:0 -vn",
+				"-c:a aac -b:a " + v.Name + " -ac 2",
+				"-f hls -hls_time 6 -hls_playlist_type vod",
+				"/" + v.Name + "/seg_%03d.ts",
+			} {
+				if !strings.Contains(ran[i], want) {
+					t.Errorf("ffmpeg call %d = %q, want %q in it", i, ran[i], want)
+				}
+			}
+			if !strings.HasSuffix(ran[i], "/"+v.Name+"/index.m3u8") {
+				t.Errorf("ffmpeg call %d = %q, want the variant playlist last", i, ran[i])
+			}
+
+			for file, contentType := range map[string]string{"index.m3u8": "application/vnd.apple.mpegurl", "seg_000.ts": "video/mp2t"} {
+				obj := store.objects[prefix+v.Name+"/"+file]
+				if obj == nil || obj.contentType != contentType {
+					t.Errorf("%s%s/%s = %+v, want it stored as %s", prefix, v.Name, file, obj, contentType)
+				}
+			}
+		}
+		if _, ok := store.objects[prefix+"source"]; ok {
+			t.Error("the downloaded source was uploaded with the stream")
+		}
+
+		master, ok := store.data(prefix + "master.m3u8")
+		if !ok {
+			t.Fatalf("no master playlist among %v", stored(store))
+		}
+		want := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=70400,CODECS=\"mp4a.40.2\"\n64k/index.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=140800,CODECS=\"mp4a.40.2\"\n128k/index.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=281600,CODECS=\"mp4a.40.2\"\n256k/index.m3u8\n"
+		if string(master) != want {
+			t.Errorf("master playlist = %q, want %q", master, want)
+		}
+		if got := db.songs[songID.String()].HLSManifest; got != prefix+"master.m3u8" {
+			t.Errorf("manifest = %q", got)
+		}
+	})
+
+	t.Run("ffmpeg fails", func(t *testing.T) {
+		fakeFFmpeg(t, true)
+		db, store := setup(true)
+		err := media.PackageHLS(context.Background(), db, store, songID, source)
+		if err == nil || !strings.Contains(err.Error(), "invalid data found") {
+			t.Fatalf("err = %v, want ffmpeg's message", err)
+		}
+		if keys := stored(store); len(keys) != 0 {
+			t.Errorf("stored %v", keys)
+		}
+		if got := db.songs[songID.String()].HLSManifest; got != "" {
+			t.Errorf("manifest = %q", got)
+		}
+	})
+
+	t.Run("song removed meanwhile", func(t *testing.T) {
+		fakeFFmpeg(t, false)
+		db, store := setup(false)
+		if err := media.PackageHLS(context.Background(), db, store, songID, source); err != gocql.ErrNotFound {
+			t.Fatalf("err = %v, want gocql.ErrNotFound", err)
+		}
+		if keys := stored(store); len(keys) != 0 {
+			t.Errorf("the stream of a removed song was kept: %v", keys)
+		}
+	})
+
+	t.Run("source missing", func(t *testing.T) {
+		calls := fakeFFmpeg(t, false)
+		db := newFakeScylla()
+		db.addSong(models.Song{SongID: songID.String(), SongURL: source})
+		if err := media.PackageHLS(context.Background(), db, newFakeMinIO(), songID, source); err == nil {
+			t.Fatal("packaged a song without audio")
+		}
+		if ran := calls(); len(ran) != 0 {
+			t.Errorf("ffmpeg ran: %q", ran)
+		}
+	})
+}
+
+func TestMediaBackfill(t *testing.T) {
+	calls := fakeFFmpeg(t, false)
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	old := time.Now().Add(-2 * time.Hour)
+	song := func(songID gocql.UUID, manifest, thumbnail, status string) string {
+		id := songID.String()
+		db.addSong(models.Song{SongID: id, SongURL: "songs/" + id + "/a.mp3", ThumbnailURL: thumbnail, HLSManifest: manifest, Status: status})
+		store.put("songs/"+id+"/a.mp3", fakeMP3(0x01), "audio/mpeg")
+		if thumbnail != "" {
+			store.put(thumbnail, fakeMP3(0x02), "image/png")
+		}
+		return id
+	}
+	dropped := song(gocql.UUIDFromTime(old), "", "", "")
+	song(gocql.UUIDFromTime(old), "hls/packaged/master.m3u8", "", "")
+	song(gocql.UUIDFromTime(old), "", "", models.SongStatusTakenDown)
+	song(gocql.TimeUUID(), "", "", "") // its own job may still be queued
+	legacy := song(mustUUID(t, "77777777-7777-4777-8777-777777777777"), "", "", "")
+	withThumbnail := song(gocql.UUIDFromTime(old), "hls/thumbnail/master.m3u8", "thumbnails/art/a.png", "")
+	done := song(gocql.UUIDFromTime(old), "hls/done/master.m3u8", "thumbnails/done/a.png", "")
+	for _, variant := range media.ThumbnailVariants("thumbnails/done/a.png") {
+		store.put(variant, []byte("derivative"), "image/jpeg")
+	}
+
+	report, err := maintenance.BackfillMedia(context.Background(), db, store, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Packaged != 2 || report.Thumbnails != 1 || len(report.Failed) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	for _, id := range []string{dropped, legacy} {
+		if got := db.songs[id].HLSManifest; got != media.HLSPrefix(id)+"master.m3u8" {
+			t.Errorf("manifest of %s = %q", id, got)
+		}
+	}
+	for _, variant := range media.ThumbnailVariants("thumbnails/art/a.png") {
+		if _, ok := store.data(variant); !ok {
+			t.Errorf("missing %s of %s", variant, withThumbnail)
+		}
+	}
+	if ran := calls(); len(ran) != 2*len(media.HLSVariants)+len(media.ThumbnailVariants("")) {
+		t.Errorf("ffmpeg ran %d times, want only for the songs missing something (not %s)", len(ran), done)
+	}
+}
+
+func TestMediaBackfillBacksOffFailingSongs(t *testing.T) {
+	calls := fakeFFmpeg(t, true)
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	id := gocql.UUIDFromTime(time.Now().Add(-2 * time.Hour)).String()
+	db.addSong(models.Song{SongID: id, SongURL: "songs/" + id + "/a.mp3"})
+	store.put("songs/"+id+"/a.mp3", fakeMP3(0x01), "audio/mpeg")
+
+	report, err := maintenance.BackfillMedia(context.Background(), db, store, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 1 || len(calls()) != 1 {
+		t.Fatalf("first run: report = %+v, ffmpeg ran %d times", report, len(calls()))
+	}
+	failures, _ := db.GetMediaFailures(models.MediaJobHLS)
+	if len(failures) != 1 || failures[0].Attempts != 1 || !failures[0].NextAttemptAt.After(time.Now()) {
+		t.Fatalf("recorded failures = %+v", failures)
+	}
+
+	report, err = maintenance.BackfillMedia(context.Background(), db, store, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deferred != 1 || len(report.Failed) != 0 || len(calls()) != 1 {
+		t.Errorf("second run retried before the backoff: report = %+v, ffmpeg ran %d times", report, len(calls()))
+	}
+
+	// Once due, the job runs again, and a success clears the failure.
+	failures[0].NextAttemptAt = time.Now().Add(-time.Minute)
+	db.RecordMediaFailure(failures[0])
+	os.Unsetenv("FAKE_FFMPEG_FAIL")
+	report, err = maintenance.BackfillMedia(context.Background(), db, store, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Packaged != 1 {
+		t.Errorf("due retry: report = %+v", report)
+	}
+	if failures, _ := db.GetMediaFailures(models.MediaJobHLS); len(failures) != 0 {
+		t.Errorf("failure kept after success: %+v", failures)
+	}
+
+	// A song that ran out of attempts is left alone for good.
+	db.songs[id].HLSManifest = ""
+	db.RecordMediaFailure(models.MediaFailure{Job: models.MediaJobHLS, SongID: id, Attempts: 5, NextAttemptAt: time.Now().Add(-time.Hour)})
+	before := len(calls())
+	if report, _ = maintenance.BackfillMedia(context.Background(), db, store, 30*time.Minute); report.Deferred != 1 || len(calls()) != before {
+		t.Errorf("retried a song that ran out of attempts: report = %+v", report)
+	}
+}
+
+func TestUploadSkipsMediaJobsWithoutFFmpeg(t *testing.T) {
+	t.Setenv("FFMPEG_PATH", filepath.Join(t.TempDir(), "ffmpeg"))
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: models.RoleArtist}
+	queue := jobs.NewQueue(0, 1)
+	client := newTestClient(t, server.Services{DB: db, Jobs: queue})
+
+	req := uploadRequest(t,
+		map[string]string{"title": "Track", "releaseDate": "2024-01-02"},
+		uploadFile{"song", "track.mp3", fakeMP3(0x11)},
+		uploadFile{"thumbnail", "cover.png", fakePNG(color.White)},
+	)
+	if rec := client.serve("artist-1", req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if !queue.Enqueue("probe", func(ctx context.Context) error { return nil }) {
+		t.Error("upload queued media jobs that need ffmpeg")
+	}
+}
@@ -0,0 +1,31 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"rr-backend/internal/jobs"
+)
+
+func TestEnqueueRacingCloseDoesNotPanic(t *testing.T) {
+	q := jobs.NewQueue(2, 4)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				q.Enqueue("noop", func(ctx context.Context) error { return nil })
+			}
+		}()
+	}
+	q.Close()
+	wg.Wait()
+
+	if q.Enqueue("late", func(ctx context.Context) error { return nil }) {
+		t.Error("Enqueue after Close accepted the job")
+	}
+	q.Close()
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	longSongID  = "99999999-9999-9999-9999-999999999991"
+	shortSongID = "99999999-9999-9999-9999-999999999992"
+)
+
+func newPlayDB() *fakeScylla {
+	db := newFakeScylla()
+	db.addSong(models.Song{SongID: longSongID, Title: "Long", AudioInfo: models.AudioInfo{Duration: 240}})
+	db.addSong(models.Song{SongID: shortSongID, Title: "Short", AudioInfo: models.AudioInfo{Duration: 20}})
+	return db
+}
+
+func postPlay(client *testClient, userID, songID string, listened float64) (int, bool, string) {
+	rec := client.postJSON(userID, "/music/"+songID+"/plays", echo.Map{"listened_seconds": listened})
+	var resp struct {
+		Counted bool   `json:"counted"`
+		Reason  string `json:"reason"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec.Code, resp.Counted, resp.Reason
+}
+
+func TestPlayCounting(t *testing.T) {
+	db := newPlayDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	tests := []struct {
+		name     string
+		songID   string
+		listened float64
+		counted  bool
+		reason   string
+	}{
+		{"skipped after a few seconds", longSongID, 12, false, "too_short"},
+		{"listened past 30 seconds", longSongID, 45, true, ""},
+		{"rapid replay", longSongID, 240, false, "duplicate"},
+		{"short song at half its length", shortSongID, 10, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, counted, reason := postPlay(client, "listener-1", tt.songID, tt.listened)
+			if code != http.StatusOK || counted != tt.counted || reason != tt.reason {
+				t.Errorf("got %d counted=%v reason=%q", code, counted, reason)
+			}
+		})
+	}
+
+	if db.songs[longSongID].PlayCount != 1 || db.songs[shortSongID].PlayCount != 1 {
+		t.Errorf("play counts = %d, %d", db.songs[longSongID].PlayCount, db.songs[shortSongID].PlayCount)
+	}
+
+	// Another listener is not affected by the first one's replay window.
+	if _, counted, _ := postPlay(client, "listener-2", longSongID, 60); !counted {
+		t.Errorf("play by another user was not counted")
+	}
+
+	if code, _, _ := postPlay(client, "listener-1", "not-a-uuid", 60); code != http.StatusBadRequest {
+		t.Errorf("invalid song ID: status = %d", code)
+	}
+	if code, _, _ := postPlay(client, "listener-1", "99999999-9999-9999-9999-000000000000", 60); code != http.StatusNotFound {
+		t.Errorf("unknown song: status = %d", code)
+	}
+}
+
+func TestListeningHistoryPagination(t *testing.T) {
+	db := newPlayDB()
+	client := newTestClient(t, server.Services{DB: db})
+	postPlay(client, "listener-1", longSongID, 60)
+	postPlay(client, "listener-1", shortSongID, 15)
+	db.claims = map[string]time.Time{} // let the replay count
+	postPlay(client, "listener-1", longSongID, 60)
+
+	type historyPage struct {
+		Items []struct {
+			SongID string `json:"song_id"`
+			Song   *struct {
+				Title string `json:"title"`
+			} `json:"song"`
+		} `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+	get := func(query string) historyPage {
+		rec := client.serve("listener-1", httptest.NewRequest(http.MethodGet, "/me/history"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var p historyPage
+		json.Unmarshal(rec.Body.Bytes(), &p)
+		return p
+	}
+
+	lookups := db.songLookups
+	first := get("?limit=2")
+	if len(first.Items) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	if db.songLookups != lookups {
+		t.Errorf("looked up %d songs one by one", db.songLookups-lookups)
+	}
+	if first.Items[0].SongID != longSongID || first.Items[1].Song == nil || first.Items[1].Song.Title != "Short" {
+		t.Errorf("history is not most recent first with songs attached: %+v", first.Items)
+	}
+	second := get("?limit=2&cursor=" + first.NextCursor)
+	if len(second.Items) != 1 || second.NextCursor != "" {
+		t.Errorf("second page = %+v", second)
+	}
+
+	rec := client.serve("listener-1", httptest.NewRequest(http.MethodGet, "/me/history?cursor=***", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "cursor") {
+		t.Errorf("bad cursor: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,329 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/models"
+	"rr-backend/internal/playlistfile"
+	"rr-backend/internal/search"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+func TestPlaylistFilesRoundTrip(t *testing.T) {
+	file := playlistfile.File{
+		Title:       "Road trip",
+		Description: "For the\nlong drive",
+		Entries: []playlistfile.Entry{
+			{Title: "Déjà Vu", Artist: "Beyoncé", Album: "B'Day", Duration: 240, Location: "https://example.com/music/stream/1"},
+			{Title: "Untitled", Location: "https://example.com/music/stream/2"},
+		},
+	}
+	for _, format := range []playlistfile.Format{playlistfile.M3U8, playlistfile.XSPF, playlistfile.JSPF} {
+		var buf bytes.Buffer
+		if err := playlistfile.Write(&buf, format, file); err != nil {
+			t.Fatalf("%s: write: %v", format, err)
+		}
+		if got := playlistfile.Detect("", buf.Bytes()); got != format {
+			t.Errorf("%s: detected as %s", format, got)
+		}
+		got, err := playlistfile.Read(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: read: %v", format, err)
+		}
+		if !reflect.DeepEqual(got.Entries, file.Entries) {
+			t.Errorf("%s: entries = %+v, want %+v", format, got.Entries, file.Entries)
+		}
+		if got.Title != file.Title {
+			t.Errorf("%s: title = %q", format, got.Title)
+		}
+	}
+}
+
+func TestReadPlaylistFiles(t *testing.T) {
+	tests := []struct {
+		name, filename, data string
+		want                 []playlistfile.Entry
+	}{
+		{
+			"bare m3u", "old.m3u",
+			"Music/Beyonc%C3%A9%20-%20Halo.mp3\r\n\r\nC:\\Music\\Yellow.flac\r\n",
+			[]playlistfile.Entry{
+				{Title: "Halo", Artist: "Beyoncé", Location: "Music/Beyonc%C3%A9%20-%20Halo.mp3"},
+				{Title: "Yellow", Location: `C:\Music\Yellow.flac`},
+			},
+		},
+		{
+			"extended m3u with attributes", "",
+			"\ufeff#EXTM3U\n#EXTINF:-1 tvg-id=\"x\",Halo\n#EXTART:Beyoncé\nhalo.mp3\n#EXTINF:266.4,Coldplay - Yellow\nyellow.mp3\n",
+			[]playlistfile.Entry{
+				{Title: "Halo", Artist: "Beyoncé", Location: "halo.mp3"},
+				{Title: "Yellow", Artist: "Coldplay", Duration: 266.4, Location: "yellow.mp3"},
+			},
+		},
+		{
+			"xspf without namespace", "",
+			`<?xml version="1.0"?><playlist version="1"><trackList><track><title>Halo</title><creator>Beyoncé</creator><duration>261500</duration></track></trackList></playlist>`,
+			[]playlistfile.Entry{{Title: "Halo", Artist: "Beyoncé", Duration: 261.5}},
+		},
+		{
+			"jspf", "export.json",
+			`{"playlist":{"track":[{"location":["", "halo.mp3"],"title":"Halo","album":"I Am... Sasha Fierce","duration":261000}]}}`,
+			[]playlistfile.Entry{{Title: "Halo", Album: "I Am... Sasha Fierce", Duration: 261, Location: "halo.mp3"}},
+		},
+	}
+	for _, tt := range tests {
+		format := playlistfile.Detect(tt.filename, []byte(tt.data))
+		got, err := playlistfile.Read(strings.NewReader(tt.data), format)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Entries, tt.want) {
+			t.Errorf("%s: entries = %+v, want %+v", tt.name, got.Entries, tt.want)
+		}
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	halo := models.Song{Title: "Halo", Album: "I Am... Sasha Fierce", AudioInfo: models.AudioInfo{Duration: 261}}
+	tests := []struct {
+		name   string
+		entry  playlistfile.Entry
+		artist string
+		match  bool
+	}{
+		{"exact", playlistfile.Entry{Title: "Halo", Artist: "Beyoncé", Album: "I Am... Sasha Fierce", Duration: 261}, "Beyoncé", true},
+		{"title only", playlistfile.Entry{Title: "halo"}, "Beyoncé", true},
+		{"accents, decoration and rounding", playlistfile.Entry{Title: "Halo (Remastered) [Live]", Artist: "beyonce", Duration: 262}, "Beyoncé", true},
+		{"featured artist", playlistfile.Entry{Title: "Halo feat. Someone", Artist: "Someone & Beyoncé"}, "Beyoncé", true},
+		{"another artist", playlistfile.Entry{Title: "Halo", Artist: "Florence + The Machine", Album: "Lungs", Duration: 200}, "Beyoncé", false},
+		{"another title", playlistfile.Entry{Title: "Hello", Artist: "Beyoncé"}, "Beyoncé", false},
+	}
+	for _, tt := range tests {
+		score := playlistfile.Score(tt.entry, halo, tt.artist)
+		if got := score >= playlistfile.MinScore; got != tt.match {
+			t.Errorf("%s: score = %.2f, match = %v, want %v", tt.name, score, got, tt.match)
+		}
+	}
+}
+
+var (
+	yellowID    = gocql.TimeUUID()
+	otherHaloID = gocql.TimeUUID()
+	goneID      = gocql.TimeUUID()
+)
+
+// newPlaylistFilesFixture adds a second "Halo" by another artist, "Yellow"
+// and an unavailable song to the search fixture, and gives songs durations.
+func newPlaylistFilesFixture(t *testing.T) (*fakeScylla, *search.Index, *testClient) {
+	t.Helper()
+	fake, index := newSearchFixture(t)
+	db := search.Sync(fake, index, search.NewSuggester())
+	db.UpsertUser("artist-2", "Coldplay", "c@example.com", "artist")
+	db.UpsertUser("artist-3", "Halo Tribute Band", "h@example.com", "artist")
+	db.InsertSong(yellowID, "Yellow", "artist-2", "Parachutes", time.Time{}, "Rock", "", "", models.AudioInfo{Duration: 266})
+	db.InsertSong(otherHaloID, "Halo", "artist-3", "Covers", time.Time{}, "Pop", "", "", models.AudioInfo{Duration: 180})
+	db.InsertSong(goneID, "Gone", "artist-2", "Parachutes", time.Time{}, "Rock", "", "", models.AudioInfo{})
+	fake.SetSongStatus(goneID, models.SongStatusUnavailable)
+	fake.songs[haloID.String()].Duration = 261
+
+	return fake, index, newTestClient(t, server.Services{DB: fake, Search: index})
+}
+
+type importReport struct {
+	Playlist  models.Playlist           `json:"playlist"`
+	Matched   int                       `json:"matched"`
+	Unmatched []handlers.UnmatchedEntry `json:"unmatched"`
+}
+
+func importPlaylist(t *testing.T, client *testClient, filename, data string, fields map[string]string) (*httptest.ResponseRecorder, importReport) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(data))
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/playlists/import", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	rec := client.serve("listener-1", req)
+	var report importReport
+	if rec.Code == http.StatusCreated {
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, report
+}
+
+func trackSongIDs(db *fakeScylla, playlistID gocql.UUID) []gocql.UUID {
+	var ids []gocql.UUID
+	for _, track := range db.tracks[playlistID] {
+		ids = append(ids, track.SongID)
+	}
+	return ids
+}
+
+func TestImportPlaylistMatchesCatalogue(t *testing.T) {
+	db, _, client := newPlaylistFilesFixture(t)
+	m3u := strings.Join([]string{
+		"#EXTM3U",
+		"#PLAYLIST:Summer",
+		"#EXTINF:241,Beyonce - Deja Vu (feat. Jay-Z)",
+		"/music/deja-vu.mp3",
+		"#EXTINF:262,Beyoncé - Halo",
+		"#EXTALB:I Am... Sasha Fierce",
+		"/music/halo.mp3",
+		"#EXTINF:200,Nobody - Unknown Song",
+		"/music/unknown.mp3",
+		"#EXTINF:266,Coldplay - Yelow",
+		"/music/yellow.mp3",
+		"#EXTINF:0,Coldplay - Gone",
+		"/music/gone.mp3",
+		"https://elsewhere.example/music/stream/" + otherHaloID.String(),
+	}, "\n")
+
+	db.userLookups, db.songLookups = 0, 0
+	rec, report := importPlaylist(t, client, "summer.m3u8", m3u, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	// The one user lookup is the signed-in user, loaded by the middleware.
+	if db.userLookups != 1 || db.songLookups != 0 {
+		t.Errorf("matching looked up %d users and %d songs one by one", db.userLookups-1, db.songLookups)
+	}
+	if report.Playlist.Name != "Summer" || report.Playlist.UserID != "listener-1" || report.Playlist.Visibility != models.PlaylistPublic {
+		t.Errorf("playlist = %+v", report.Playlist)
+	}
+	if report.Matched != 4 {
+		t.Errorf("matched = %d, want 4", report.Matched)
+	}
+	var unmatched []string
+	for _, u := range report.Unmatched {
+		unmatched = append(unmatched, u.Title)
+	}
+	if want := []string{"Unknown Song", "Gone"}; !reflect.DeepEqual(unmatched, want) || report.Unmatched[0].Index != 2 || report.Unmatched[0].Artist != "Nobody" {
+		t.Errorf("unmatched = %+v, want %v", report.Unmatched, want)
+	}
+
+	want := []gocql.UUID{dejaVuID, haloID, yellowID, otherHaloID}
+	if got := trackSongIDs(db, report.Playlist.PlaylistID); !reflect.DeepEqual(got, want) {
+		t.Errorf("tracks = %v, want %v", got, want)
+	}
+}
+
+func TestImportPlaylistOptions(t *testing.T) {
+	_, _, client := newPlaylistFilesFixture(t)
+	jspf := `{"playlist":{"track":[{"title":"Halo","creator":"Beyoncé"}]}}`
+
+	rec, report := importPlaylist(t, client, "mine.txt", jspf, map[string]string{"format": "jspf", "visibility": models.PlaylistPrivate})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if report.Playlist.Name != "mine" || report.Playlist.Visibility != models.PlaylistPrivate || report.Matched != 1 {
+		t.Errorf("report = %+v", report)
+	}
+
+	for _, tt := range []struct {
+		name, data string
+		fields     map[string]string
+		want       int
+	}{
+		{"bad format", jspf, map[string]string{"format": "pls"}, http.StatusBadRequest},
+		{"bad visibility", jspf, map[string]string{"visibility": "friends"}, http.StatusBadRequest},
+		{"malformed", `{"playlist":`, map[string]string{"format": "jspf"}, http.StatusBadRequest},
+		{"too large", strings.Repeat("a", 1<<20+1), nil, http.StatusRequestEntityTooLarge},
+	} {
+		if rec, _ := importPlaylist(t, client, "mine.jspf", tt.data, tt.fields); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+// failingTracks refuses to write playlist tracks.
+type failingTracks struct{ *fakeScylla }
+
+func (failingTracks) UpdatePlaylistTracks(gocql.UUID, int, []models.PlaylistTrack, []models.PlaylistTrack) (bool, error) {
+	return false, errors.New("scylla unavailable")
+}
+
+func TestFailedImportLeavesNoPlaylist(t *testing.T) {
+	db, index, _ := newPlaylistFilesFixture(t)
+	client := newTestClient(t, server.Services{DB: failingTracks{db}, Search: index})
+	before := len(db.playlists)
+	rec, _ := importPlaylist(t, client, "mine.jspf", `{"playlist":{"track":[{"title":"Halo","creator":"Beyoncé"}]}}`, nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if len(db.playlists) != before {
+		t.Errorf("failed import left a playlist behind")
+	}
+
+	// Files over the entry cap are turned away before anything is matched.
+	entries := strings.Repeat(`{"title":"Halo"},`, 251)
+	rec, _ = importPlaylist(t, client, "big.jspf", `{"playlist":{"track":[`+strings.TrimSuffix(entries, ",")+`]}}`, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("251 entries: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestExportPlaylist(t *testing.T) {
+	db, _, client := newPlaylistFilesFixture(t)
+	playlistID := gocql.TimeUUID()
+	db.AddPlaylist(playlistID, "listener-1", "Road trip: 2024", "", models.PlaylistPublic)
+	for i, songID := range []gocql.UUID{haloID, yellowID, dejaVuID} {
+		db.seedTrack(models.PlaylistTrack{PlaylistID: playlistID, Position: int64(i + 1), EntryID: gocql.TimeUUID(), SongID: songID})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/playlists/"+playlistID.String()+"/export?format=xspf", nil)
+	req.Host = "api.example.com"
+	rec := client.serve("", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "application/xspf+xml" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); cd != `attachment; filename="Road trip 2024.xspf"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	file, err := playlistfile.Read(bytes.NewReader(rec.Body.Bytes()), playlistfile.XSPF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := playlistfile.Entry{Title: "Halo", Artist: "Beyoncé", Album: "I Am... Sasha Fierce", Duration: 261, Location: "http://api.example.com/music/stream/" + haloID.String()}
+	if len(file.Entries) != 3 || file.Entries[0] != want {
+		t.Errorf("entries = %+v, want %+v first", file.Entries, want)
+	}
+
+	// Importing the export gives back the same songs, matched by stream URL.
+	_, report := importPlaylist(t, client, "export.xspf", rec.Body.String(), nil)
+	if got := trackSongIDs(db, report.Playlist.PlaylistID); !reflect.DeepEqual(got, []gocql.UUID{haloID, yellowID, dejaVuID}) {
+		t.Errorf("re-imported tracks = %v", got)
+	}
+
+	rec = client.serve("", httptest.NewRequest(http.MethodGet, "/playlists/"+playlistID.String()+"/export?format=pls", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+func TestPlaylistInvitationFlow(t *testing.T) {
+	db := newAuthzDB()
+	client := newTestClient(t, server.Services{DB: db})
+	playlist := "/playlists/" + authzPlaylistID
+	addSong := playlist + "/songs/" + authzSongID
+
+	invites := []struct {
+		body string
+		want int
+	}{
+		{`{"user_id":"stranger","role":"owner"}`, http.StatusBadRequest},
+		{`{"user_id":"owner"}`, http.StatusBadRequest},
+		{`{"user_id":"nobody"}`, http.StatusNotFound},
+		{`{"user_id":"stranger"}`, http.StatusCreated},
+	}
+	for _, inv := range invites {
+		if rec := client.do("owner", http.MethodPost, playlist+"/members", inv.body); rec.Code != inv.want {
+			t.Fatalf("invite %s: status = %d, want %d (%s)", inv.body, rec.Code, inv.want, rec.Body.String())
+		}
+	}
+
+	// An invitation grants nothing until it is accepted.
+	if rec := client.do("stranger", http.MethodPost, addSong, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("invited editor added a song: status = %d", rec.Code)
+	}
+	if rec := client.do("artist-2", http.MethodPost, playlist+"/members/accept", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("accepting without an invitation: status = %d, want 404", rec.Code)
+	}
+	if rec := client.do("stranger", http.MethodPost, playlist+"/members/accept", ""); rec.Code != http.StatusOK {
+		t.Fatalf("accept: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := client.do("stranger", http.MethodPost, playlist+"/members/accept", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("accepting twice: status = %d, want 404", rec.Code)
+	}
+
+	rec := client.do("stranger", http.MethodPost, addSong, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("editor adding a song: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var track models.PlaylistTrack
+	json.Unmarshal(rec.Body.Bytes(), &track)
+	if track.AddedBy != "stranger" {
+		t.Fatalf("added_by = %q, want the editor", track.AddedBy)
+	}
+
+	// Editors cannot hand out access, and a viewer cannot edit.
+	if rec := client.do("stranger", http.MethodPost, playlist+"/members", `{"user_id":"artist-2"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("editor inviting: status = %d, want 403", rec.Code)
+	}
+	if rec := client.do("owner", http.MethodPost, playlist+"/members", `{"user_id":"stranger","role":"viewer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("changing role: status = %d", rec.Code)
+	}
+	if m, _ := db.GetPlaylistMember(mustUUID(t, authzPlaylistID), "stranger"); m.Status != models.MemberAccepted || m.Role != models.PlaylistViewer {
+		t.Fatalf("after changing role: %+v", m)
+	}
+	if rec := client.do("stranger", http.MethodPost, addSong, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer added a song: status = %d", rec.Code)
+	}
+
+	// Members can leave; owners can revoke.
+	if rec := client.do("stranger", http.MethodDelete, playlist+"/members/me", ""); rec.Code != http.StatusOK {
+		t.Fatalf("leave: status = %d", rec.Code)
+	}
+	if rec := client.do("stranger", http.MethodDelete, playlist+"/members/me", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("leaving twice: status = %d, want 404", rec.Code)
+	}
+	if rec := client.do("owner", http.MethodDelete, playlist+"/members/collab", ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status = %d", rec.Code)
+	}
+	if rec := client.do("collab", http.MethodPost, addSong, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("revoked editor added a song: status = %d", rec.Code)
+	}
+}
+
+func TestPendingInvitationsAreListedToTheInvitee(t *testing.T) {
+	db := newAuthzDB()
+	client := newTestClient(t, server.Services{DB: db})
+	invitations := func(userID string) []models.PlaylistInvitation {
+		t.Helper()
+		rec := client.do(userID, http.MethodGet, "/me/playlist-invitations", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("invitations of %s: status = %d, body = %s", userID, rec.Code, rec.Body.String())
+		}
+		var page struct {
+			Items []models.PlaylistInvitation `json:"items"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Items
+	}
+
+	got := invitations("pending")
+	if len(got) != 1 || got[0].Playlist.PlaylistID.String() != authzPlaylistID || got[0].Playlist.Name != "Mix" || got[0].Role != models.PlaylistEditor {
+		t.Fatalf("invitations of pending = %+v", got)
+	}
+	if got := invitations("collab"); len(got) != 0 {
+		t.Fatalf("accepted membership listed as an invitation: %+v", got)
+	}
+	if rec := client.do("pending", http.MethodPost, "/playlists/"+authzPlaylistID+"/members/accept", ""); rec.Code != http.StatusOK {
+		t.Fatalf("accept: status = %d", rec.Code)
+	}
+	if got := invitations("pending"); len(got) != 0 {
+		t.Fatalf("invitations after accepting = %+v", got)
+	}
+}
+
+// staleMembers answers member lookups from a copy taken before a concurrent
+// change.
+type staleMembers struct {
+	*fakeScylla
+	copy []models.PlaylistMember
+}
+
+func (s staleMembers) GetPlaylistMembers(gocql.UUID) ([]models.PlaylistMember, error) {
+	return s.copy, nil
+}
+
+func TestReinviteKeepsConcurrentAccept(t *testing.T) {
+	db := newAuthzDB()
+	playlistID := mustUUID(t, authzPlaylistID)
+	members, _ := db.GetPlaylistMembers(playlistID)
+	if _, err := db.AcceptPlaylistInvite(playlistID, "pending", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, server.Services{DB: staleMembers{db, members}})
+	rec := client.do("owner", http.MethodPost, "/playlists/"+authzPlaylistID+"/members", `{"user_id":"pending","role":"viewer"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("re-invite: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if m, _ := db.GetPlaylistMember(playlistID, "pending"); m.Status != models.MemberAccepted || m.Role != models.PlaylistViewer {
+		t.Fatalf("after re-invite: %+v, want the accept kept", m)
+	}
+}
+
+func TestFetchPlaylistsIncludesSharedOnes(t *testing.T) {
+	db := newFakeScylla()
+	var want []string
+	for i := 0; i < 3; i++ {
+		id := gocql.TimeUUID()
+		db.AddPlaylist(id, "me", fmt.Sprintf("mine-%d", i), "", models.PlaylistPublic)
+		want = append(want, id.String())
+	}
+	var sharedIDs []string
+	for i, status := range []string{models.MemberAccepted, models.MemberInvited, models.MemberAccepted} {
+		id := gocql.TimeUUID()
+		db.AddPlaylist(id, "friend", fmt.Sprintf("theirs-%d", i), "", models.PlaylistPublic)
+		db.seedMember(models.PlaylistMember{PlaylistID: id, UserID: "me", Role: models.PlaylistEditor, Status: status})
+		if status == models.MemberAccepted {
+			sharedIDs = append(sharedIDs, id.String())
+		}
+	}
+	// The fakes list in playlist ID order; owned ones still come first.
+	ownedSorted := append([]string(nil), want...)
+	sort.Strings(ownedSorted)
+	sort.Strings(sharedIDs)
+	want = append(ownedSorted, sharedIDs...)
+
+	client := newTestClient(t, server.Services{DB: db})
+	for _, limit := range []int{1, 2, 3, 10} {
+		got := pageThrough(t, client.as("me"), fmt.Sprintf("/me/playlists?limit=%d", limit), "playlist_id")
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("limit %d: playlists = %v, want %v", limit, got, want)
+		}
+	}
+
+	rec := client.do("me", http.MethodGet, "/me/playlists?limit=10", "")
+	var resp struct {
+		Items []models.Playlist `json:"items"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	for _, p := range resp.Items {
+		if (p.UserID == "friend") != (p.MemberRole == models.PlaylistEditor) {
+			t.Fatalf("playlist %s of %s has member_role %q", p.Name, p.UserID, p.MemberRole)
+		}
+	}
+
+	if rec := client.do("me", http.MethodGet, "/me/playlists?cursor="+url.QueryEscape("eA"), ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("foreign cursor: status = %d, want 400", rec.Code)
+	}
+}
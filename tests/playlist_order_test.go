@@ -0,0 +1,371 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/playlists"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+// newPlaylistDB returns a store with one empty playlist of "owner" and
+// songs titled "a" to "e".
+func newPlaylistDB() (*fakeScylla, gocql.UUID, map[string]string) {
+	db := newFakeScylla()
+	db.users["owner"] = &models.User{UserID: "owner", Role: models.RoleListener}
+	playlistID := gocql.TimeUUID()
+	db.AddPlaylist(playlistID, "owner", "Mix", "", models.PlaylistPublic)
+	songs := map[string]string{}
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		songID := gocql.TimeUUID().String()
+		db.addSong(models.Song{SongID: songID, Title: title, UserID: "artist"})
+		songs[title] = songID
+	}
+	return db, playlistID, songs
+}
+
+func addTrack(t *testing.T, client *testClient, playlistID gocql.UUID, songID, query string) models.PlaylistTrack {
+	t.Helper()
+	rec := client.do("owner", http.MethodPost, fmt.Sprintf("/playlists/%s/songs/%s%s", playlistID, songID, query), "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("add %s%s: status = %d, body = %s", songID, query, rec.Code, rec.Body.String())
+	}
+	var track models.PlaylistTrack
+	if err := json.Unmarshal(rec.Body.Bytes(), &track); err != nil {
+		t.Fatal(err)
+	}
+	return track
+}
+
+// playlistTitles lists the playlist through the API, a page at a time.
+func playlistTitles(t *testing.T, client *testClient, playlistID gocql.UUID) []string {
+	t.Helper()
+	var titles []string
+	cursor := ""
+	for {
+		rec := client.do("owner", http.MethodGet, fmt.Sprintf("/playlists/%s/songs?limit=2&cursor=%s", playlistID, cursor), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Items      []models.PlaylistTrack `json:"items"`
+			NextCursor string                 `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, track := range resp.Items {
+			if track.Song == nil {
+				t.Fatalf("track %s came back without its song", track.EntryID)
+			}
+			titles = append(titles, track.Song.Title)
+		}
+		if resp.NextCursor == "" {
+			return titles
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+func TestPlaylistInsertAtPositionAndDuplicates(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	addTrack(t, client, playlistID, songs["a"], "")
+	addTrack(t, client, playlistID, songs["c"], "")
+	addTrack(t, client, playlistID, songs["b"], "?position=1")
+	addTrack(t, client, playlistID, songs["d"], "?position=0")
+	addTrack(t, client, playlistID, songs["a"], "?position=99")
+
+	if got, want := playlistTitles(t, client, playlistID), []string{"d", "a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+
+	rec := client.do("owner", http.MethodPost, fmt.Sprintf("/playlists/%s/songs/%s?position=-1", playlistID, songs["e"]), "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("negative position: status = %d, want 400", rec.Code)
+	}
+	rec = client.do("owner", http.MethodPost, fmt.Sprintf("/playlists/%s/songs/%s", playlistID, gocql.TimeUUID()), "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown song: status = %d, want 404", rec.Code)
+	}
+
+	// Removing by song takes out every occurrence.
+	rec = client.do("owner", http.MethodDelete, fmt.Sprintf("/playlists/%s/songs/%s", playlistID, songs["a"]), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove song: status = %d", rec.Code)
+	}
+	if got, want := playlistTitles(t, client, playlistID), []string{"d", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after removing a: %v, want %v", got, want)
+	}
+}
+
+func TestPlaylistMoveAndRemoveTrack(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	client := newTestClient(t, server.Services{DB: db})
+	var entries []models.PlaylistTrack
+	for _, title := range []string{"a", "b", "c", "a"} {
+		entries = append(entries, addTrack(t, client, playlistID, songs[title], ""))
+	}
+
+	moves := []struct {
+		entry models.PlaylistTrack
+		to    int
+		want  []string
+	}{
+		{entries[3], 0, []string{"a", "a", "b", "c"}},
+		{entries[1], 3, []string{"a", "a", "c", "b"}},
+		{entries[0], 2, []string{"a", "c", "a", "b"}},
+		{entries[2], 2, []string{"a", "a", "c", "b"}},
+	}
+	for _, m := range moves {
+		rec := client.do("owner", http.MethodPatch, fmt.Sprintf("/playlists/%s/tracks/%s", playlistID, m.entry.EntryID), fmt.Sprintf(`{"position":%d}`, m.to))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("move: status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		if got := playlistTitles(t, client, playlistID); !reflect.DeepEqual(got, m.want) {
+			t.Fatalf("after moving to %d: %v, want %v", m.to, got, m.want)
+		}
+	}
+
+	// Only the addressed occurrence of a duplicated song goes.
+	rec := client.do("owner", http.MethodDelete, fmt.Sprintf("/playlists/%s/tracks/%s", playlistID, entries[3].EntryID), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove track: status = %d", rec.Code)
+	}
+	if got, want := playlistTitles(t, client, playlistID), []string{"a", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after removing one a: %v, want %v", got, want)
+	}
+	rec = client.do("owner", http.MethodDelete, fmt.Sprintf("/playlists/%s/tracks/%s", playlistID, entries[3].EntryID), "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("removing twice: status = %d, want 404", rec.Code)
+	}
+	rec = client.do("owner", http.MethodPatch, fmt.Sprintf("/playlists/%s/tracks/%s", playlistID, entries[0].EntryID), `{}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("move without position: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPlaylistBulkReorder(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	client := newTestClient(t, server.Services{DB: db})
+	var ids []string
+	for _, title := range []string{"a", "b", "c"} {
+		ids = append(ids, addTrack(t, client, playlistID, songs[title], "").EntryID.String())
+	}
+	order := func(ids ...string) string {
+		body, _ := json.Marshal(map[string][]string{"entry_ids": ids})
+		return string(body)
+	}
+
+	rec := client.do("owner", http.MethodPatch, fmt.Sprintf("/playlists/%s/order", playlistID), order(ids[2], ids[0], ids[1]))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reorder: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got, want := playlistTitles(t, client, playlistID), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after reorder: %v, want %v", got, want)
+	}
+
+	stale := []string{
+		order(ids[0], ids[1]),
+		order(ids[0], ids[1], ids[1]),
+		order(ids[0], ids[1], gocql.TimeUUID().String()),
+		order(ids[0], ids[1], ids[2], gocql.TimeUUID().String()),
+	}
+	for _, body := range stale {
+		rec := client.do("owner", http.MethodPatch, fmt.Sprintf("/playlists/%s/order", playlistID), body)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("reorder with %s: status = %d, want 409", body, rec.Code)
+		}
+	}
+	if got, want := playlistTitles(t, client, playlistID), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rejected reorders changed the playlist: %v", got)
+	}
+}
+
+func TestPlaylistRenumbersWhenNeighboursAreAdjacent(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	first, err := playlists.Insert(db, playlistID, mustUUID(t, songs["a"]), "owner", -1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := playlists.Insert(db, playlistID, mustUUID(t, songs["b"]), "owner", -1, time.Now())
+
+	// Inserting right after the first track halves the gap each time, so
+	// it runs out after about log2(Gap) inserts.
+	for i := 0; i < 30; i++ {
+		if _, err := playlists.Insert(db, playlistID, mustUUID(t, songs["c"]), "owner", 1, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracks, err := playlists.Tracks(db, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 32 || tracks[0].EntryID != first.EntryID || tracks[31].EntryID != last.EntryID {
+		t.Fatalf("renumbering lost or misplaced tracks: %d tracks", len(tracks))
+	}
+	for i := 1; i < len(tracks); i++ {
+		if tracks[i].Position <= tracks[i-1].Position {
+			t.Fatalf("positions not strictly increasing at %d: %d, %d", i, tracks[i-1].Position, tracks[i].Position)
+		}
+	}
+}
+
+func TestLargeReorderIsWrittenInSmallBatches(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	var tracks []models.PlaylistTrack
+	for i := 0; i < 500; i++ {
+		tracks = append(tracks, models.PlaylistTrack{EntryID: gocql.TimeUUID(), SongID: mustUUID(t, songs["a"])})
+	}
+	if err := playlists.Append(db, playlistID, tracks); err != nil {
+		t.Fatal(err)
+	}
+
+	reversed := make([]gocql.UUID, len(tracks))
+	for i, track := range tracks {
+		reversed[len(tracks)-1-i] = track.EntryID
+	}
+	db.largestTrackBatch = 0
+	if _, err := playlists.Reorder(db, playlistID, reversed); err != nil {
+		t.Fatal(err)
+	}
+	if db.largestTrackBatch > 200 {
+		t.Errorf("largest batch wrote %d rows", db.largestTrackBatch)
+	}
+	stored, err := playlists.Tracks(db, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(tracks) {
+		t.Fatalf("%d tracks after reorder, want %d", len(stored), len(tracks))
+	}
+	for i, track := range stored {
+		if track.EntryID != reversed[i] {
+			t.Fatalf("track %d is %s, want %s", i, track.EntryID, reversed[i])
+		}
+	}
+}
+
+func TestConcurrentMovesKeepEveryTrackOnce(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	var entryIDs []gocql.UUID
+	for _, title := range []string{"a", "b", "c", "d", "e"} {
+		track, err := playlists.Insert(db, playlistID, mustUUID(t, songs[title]), "owner", -1, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		entryIDs = append(entryIDs, track.EntryID)
+	}
+
+	// A change that lost the race is refused and worked out again.
+	version, _ := db.GetPlaylistTracksVersion(playlistID)
+	if applied, _ := db.UpdatePlaylistTracks(playlistID, version-1, nil, nil); applied {
+		t.Fatal("write against a stale version was applied")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := playlists.Move(db, playlistID, entryIDs[0], i%5); err != nil && !errors.Is(err, playlists.ErrConflict) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := playlists.Tracks(db, playlistID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[gocql.UUID]int{}
+	for _, track := range stored {
+		seen[track.EntryID]++
+	}
+	if len(stored) != len(entryIDs) || len(seen) != len(entryIDs) {
+		t.Fatalf("after concurrent moves: %d rows for %d entries", len(stored), len(seen))
+	}
+}
+
+func TestMigratePlaylistOrder(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range []string{"c", "a", "b"} {
+		songID := mustUUID(t, songs[title])
+		// Rows come back in song ID order; the migration orders them by
+		// when they were added.
+		db.legacySongs[playlistID] = append(db.legacySongs[playlistID], models.PlaylistTrack{
+			PlaylistID: playlistID,
+			SongID:     songID,
+			AddedAt:    base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	// A playlist someone added a song to through the API before the
+	// migration ran keeps it and gains the legacy songs it lacks.
+	started := gocql.TimeUUID()
+	db.AddPlaylist(started, "owner", "Started early", "", models.PlaylistPublic)
+	if _, err := playlists.Insert(db, started, mustUUID(t, songs["a"]), "owner", -1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	db.legacySongs[started] = db.legacySongs[playlistID]
+
+	report, err := maintenance.MigratePlaylistOrder(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 2 || report.Tracks != 5 || report.AlreadyMigrated != 0 || len(db.tracks[playlistID]) != 0 || len(db.tracks[started]) != 1 {
+		t.Fatalf("dry run: report = %+v, wrote %d tracks", report, len(db.tracks[playlistID]))
+	}
+
+	if _, err := maintenance.MigratePlaylistOrder(db, false); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, server.Services{DB: db})
+	if got, want := playlistTitles(t, client, playlistID), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("migrated order = %v, want %v", got, want)
+	}
+	if got, want := playlistTitles(t, client, started), []string{"a", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged order = %v, want %v", got, want)
+	}
+	if len(db.legacySongs) != 0 {
+		t.Fatalf("legacy rows left behind: %v", db.legacySongs)
+	}
+
+	// Rerunning finds nothing left to do, and does not bring back a song
+	// removed since.
+	if _, err := playlists.RemoveSong(db, playlistID, mustUUID(t, songs["a"])); err != nil {
+		t.Fatal(err)
+	}
+	report, err = maintenance.MigratePlaylistOrder(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 0 || report.AlreadyMigrated != 2 {
+		t.Fatalf("second run: report = %+v", report)
+	}
+	if got, want := playlistTitles(t, client, playlistID), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("order after rerun = %v, want %v", got, want)
+	}
+}
+
+func mustUUID(t *testing.T, s string) gocql.UUID {
+	t.Helper()
+	id, err := gocql.ParseUUID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
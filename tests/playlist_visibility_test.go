@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"rr-backend/internal/authz"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+// newVisibilityDB is newAuthzDB with the playlist set to visibility.
+func newVisibilityDB(t *testing.T, visibility string) *fakeScylla {
+	db := newAuthzDB()
+	db.UpdatePlaylist(mustUUID(t, authzPlaylistID), "Mix", "", visibility)
+	return db
+}
+
+func TestPlaylistVisibility(t *testing.T) {
+	playlistID := mustUUID(t, authzPlaylistID)
+	valid := testShareLinks.Token(playlistID, time.Now().Add(time.Hour))
+	expired := testShareLinks.Token(playlistID, time.Now().Add(-time.Minute))
+	otherPlaylist := testShareLinks.Token(gocql.TimeUUID(), time.Now().Add(time.Hour))
+	forged := authz.NewShareLinks([]byte("another secret")).Token(playlistID, time.Now().Add(time.Hour))
+
+	requesters := []struct {
+		name, user, token string
+		// whether the request may see a public, unlisted and private playlist
+		public, unlisted, private bool
+	}{
+		{"anonymous", "", "", true, false, false},
+		{"stranger", "stranger", "", true, false, false},
+		{"invited", "pending", "", true, false, false},
+		{"viewer", "viewer", "", true, true, true},
+		{"editor", "collab", "", true, true, true},
+		{"owner", "owner", "", true, true, true},
+		{"admin", "root", "", true, true, true},
+		{"share link", "", valid, true, true, false},
+		{"share link, signed in", "stranger", valid, true, true, false},
+		{"expired link", "", expired, true, false, false},
+		{"link of another playlist", "", otherPlaylist, true, false, false},
+		{"forged link", "", forged, true, false, false},
+		{"garbage link", "", "not-a-token", true, false, false},
+	}
+	for _, visibility := range []string{models.PlaylistPublic, models.PlaylistUnlisted, models.PlaylistPrivate} {
+		client := newTestClient(t, server.Services{DB: newVisibilityDB(t, visibility)})
+		for _, r := range requesters {
+			allowed := map[string]bool{
+				models.PlaylistPublic:   r.public,
+				models.PlaylistUnlisted: r.unlisted,
+				models.PlaylistPrivate:  r.private,
+			}[visibility]
+			want := http.StatusNotFound
+			if allowed {
+				want = http.StatusOK
+			}
+			for _, path := range []string{"/playlists/" + authzPlaylistID, "/playlists/" + authzPlaylistID + "/songs", "/playlists/" + authzPlaylistID + "/export"} {
+				if r.token != "" {
+					path += "?share=" + url.QueryEscape(r.token)
+				}
+				if rec := client.do(r.user, http.MethodGet, path, ""); rec.Code != want {
+					t.Errorf("%s playlist, %s: GET %s = %d, want %d", visibility, r.name, path, rec.Code, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPlaylistListingHidesNonPublicPlaylists(t *testing.T) {
+	db := newAuthzDB()
+	ids := map[string]string{models.PlaylistPublic: authzPlaylistID}
+	for _, visibility := range []string{models.PlaylistUnlisted, models.PlaylistPrivate} {
+		id := gocql.TimeUUID()
+		db.AddPlaylist(id, "owner", visibility, "", visibility)
+		ids[visibility] = id.String()
+	}
+	all := []string{ids[models.PlaylistPublic], ids[models.PlaylistUnlisted], ids[models.PlaylistPrivate]}
+	client := newTestClient(t, server.Services{DB: db})
+	sortIDs := func(ids []string) []string {
+		sorted := append([]string(nil), ids...)
+		sort.Strings(sorted)
+		return sorted
+	}
+
+	for _, tc := range []struct {
+		user string
+		want []string
+	}{
+		{"", []string{ids[models.PlaylistPublic]}},
+		{"stranger", []string{ids[models.PlaylistPublic]}},
+		{"collab", []string{ids[models.PlaylistPublic]}},
+		{"owner", sortIDs(all)},
+		{"root", sortIDs(all)},
+	} {
+		for _, limit := range []string{"1", "20"} {
+			got := pageThrough(t, client.as(tc.user), "/owner/playlists?limit="+limit, "playlist_id")
+			if !reflect.DeepEqual(sortIDs(got), tc.want) {
+				t.Errorf("as %q, limit %s: listed %v, want %v", tc.user, limit, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestPlaylistVisibilityIsValidated(t *testing.T) {
+	db := newAuthzDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	rec := client.do("owner", http.MethodPost, "/playlists", `{"name":"New"}`)
+	var created models.Playlist
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != http.StatusCreated || created.Visibility != models.PlaylistPublic {
+		t.Fatalf("create without visibility: status = %d, playlist = %+v", rec.Code, created)
+	}
+	if rec := client.do("owner", http.MethodPost, "/playlists", `{"name":"New","visibility":"secret"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("create with bad visibility: status = %d, want 400", rec.Code)
+	}
+	if rec := client.do("owner", http.MethodPut, "/playlists/"+authzPlaylistID, `{"name":"Mix","visibility":"secret"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("update with bad visibility: status = %d, want 400", rec.Code)
+	}
+
+	// Leaving visibility out of an update keeps it.
+	client.do("owner", http.MethodPut, "/playlists/"+authzPlaylistID, `{"name":"Mix","visibility":"unlisted"}`)
+	client.do("owner", http.MethodPut, "/playlists/"+authzPlaylistID, `{"name":"Renamed"}`)
+	if p, _ := db.GetPlaylist(mustUUID(t, authzPlaylistID)); p.Visibility != models.PlaylistUnlisted || p.Name != "Renamed" {
+		t.Fatalf("after update: %+v", p)
+	}
+}
+
+func TestShareLinkGrantsAccessToUnlistedPlaylist(t *testing.T) {
+	db := newVisibilityDB(t, models.PlaylistUnlisted)
+	client := newTestClient(t, server.Services{DB: db})
+
+	rec := client.do("owner", http.MethodPost, "/playlists/"+authzPlaylistID+"/share?days=7", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("share: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var link struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &link)
+	if d := time.Until(link.ExpiresAt); d < 6*24*time.Hour || d > 7*24*time.Hour {
+		t.Fatalf("link expires in %v, want 7 days", d)
+	}
+
+	target := "/playlists/" + authzPlaylistID + "/songs?share=" + url.QueryEscape(link.Token)
+	if rec := client.do("", http.MethodGet, target, ""); rec.Code != http.StatusOK {
+		t.Fatalf("with link: status = %d", rec.Code)
+	}
+
+	// Making the playlist private retires the link.
+	db.UpdatePlaylist(mustUUID(t, authzPlaylistID), "Mix", "", models.PlaylistPrivate)
+	if rec := client.do("", http.MethodGet, target, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("link to a private playlist: status = %d, want 404", rec.Code)
+	}
+
+	// Only those who may manage the playlist hand out links, and the
+	// private playlist stays hidden from everyone else.
+	if rec := client.do("collab", http.MethodPost, "/playlists/"+authzPlaylistID+"/share", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("editor sharing: status = %d, want 403", rec.Code)
+	}
+	if rec := client.do("stranger", http.MethodPost, "/playlists/"+authzPlaylistID+"/share", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("stranger sharing a private playlist: status = %d, want 404", rec.Code)
+	}
+	for _, days := range []string{"0", "366", "x"} {
+		if rec := client.do("owner", http.MethodPost, "/playlists/"+authzPlaylistID+"/share?days="+days, ""); rec.Code != http.StatusBadRequest {
+			t.Fatalf("days=%s: status = %d, want 400", days, rec.Code)
+		}
+	}
+}
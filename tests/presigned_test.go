@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+type presignedTarget struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+type presignedResponse struct {
+	UploadID  string          `json:"upload_id"`
+	Song      presignedTarget `json:"song"`
+	Thumbnail presignedTarget `json:"thumbnail"`
+}
+
+// postForm plays the client: it sends data to MinIO through a presigned
+// form, which refuses a file of any size other than the one announced.
+func postForm(t *testing.T, store *fakeMinIO, target presignedTarget, data []byte, contentType string) {
+	t.Helper()
+	if target.Method != http.MethodPost || target.URL == "" {
+		t.Fatalf("not a presigned form: %+v", target)
+	}
+	if size := target.Fields["content-length"]; size != strconv.Itoa(len(data)) {
+		t.Fatalf("form accepts %s bytes, sending %d", size, len(data))
+	}
+	store.put(target.Fields["key"], data, contentType)
+}
+
+func TestPresignedUploadCompletes(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := bytes.Repeat(fakeMP3(0x55), 200) // larger than the validation window
+	cover := fakePNG(color.White)
+	rec := client.postJSON("artist-1", "/music/uploads", echo.Map{
+		"song":        echo.Map{"filename": "track.mp3", "size": len(audio)},
+		"thumbnail":   echo.Map{"filename": "cover.png", "size": len(cover)},
+		"title":       "Direct",
+		"releaseDate": "2024-07-08",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var created presignedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Song.Fields["content-length"] != strconv.Itoa(len(audio)) || created.Thumbnail.Fields["content-length"] != strconv.Itoa(len(cover)) {
+		t.Fatalf("upload forms do not hold the files to their announced size: %+v", created)
+	}
+
+	complete := "/music/uploads/" + created.UploadID + "/complete"
+	if rec := client.postJSON("artist-1", complete, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("complete before upload: status = %d, want 409", rec.Code)
+	}
+
+	// Client uploads straight to MinIO, possibly with a bogus content type.
+	postForm(t, store, created.Song, audio, "text/html")
+	postForm(t, store, created.Thumbnail, cover, "image/png")
+
+	rec = client.postJSON("artist-1", complete, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("complete: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	songs := db.allSongs()
+	if len(songs) != 1 || songs[0].Title != "Direct" {
+		t.Fatalf("songs = %+v", songs)
+	}
+	song := songs[0]
+	if !strings.HasPrefix(song.SongURL, "songs/"+song.SongID+"/") {
+		t.Errorf("song object %s is not scoped to the song", song.SongURL)
+	}
+	if data, ok := store.data(song.SongURL); !ok || !bytes.Equal(data, audio) {
+		t.Errorf("song object does not hold the uploaded audio")
+	}
+	if ct := store.objects[song.SongURL].contentType; ct != "audio/mpeg" {
+		t.Errorf("content type = %q, want the sniffed audio/mpeg", ct)
+	}
+	// The key is named after the content, not the ETag MinIO gave the PUT.
+	hash, _ := media.ContentHash(bytes.NewReader(audio))
+	if !strings.HasPrefix(path.Base(song.SongURL), hash[:16]) {
+		t.Errorf("song object %s is not named after the SHA-256 of the audio %s", song.SongURL, hash)
+	}
+	// Validation only needs the frame headers; the song is read in full
+	// once, to hash it.
+	stagedSong := created.Song.Fields["key"]
+	var songBytesRead int64
+	for _, r := range store.reads {
+		if r.objectName == stagedSong {
+			songBytesRead += r.end - r.start + 1
+		}
+	}
+	if songBytesRead >= 2*int64(len(audio)) {
+		t.Errorf("backend read %d bytes of a %d byte song", songBytesRead, len(audio))
+	}
+	for name := range store.objects {
+		if strings.HasPrefix(name, "uploads/") {
+			t.Errorf("staging object %s was left behind", name)
+		}
+	}
+
+	// Completing twice reports the same song instead of creating another.
+	rec = client.postJSON("artist-1", complete, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), song.SongID) {
+		t.Errorf("second complete: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPresignedUploadRejectsMismatchedFile(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	if rec := client.postJSON("artist-1", "/music/uploads", echo.Map{"song": echo.Map{"filename": "big.flac", "size": 1 << 30}}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized: status = %d, want 413", rec.Code)
+	}
+
+	rec := client.postJSON("artist-1", "/music/uploads", echo.Map{"song": echo.Map{"filename": "track.mp3", "size": 100}, "releaseDate": "2024-01-01"})
+	var created presignedResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	store.put(created.Song.Fields["key"], []byte("<html>not a song</html>"), "audio/mpeg")
+
+	rec = client.postJSON("artist-1", "/music/uploads/"+created.UploadID+"/complete", nil)
+	if rec.Code != http.StatusUnprocessableEntity && rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(store.objects) != 0 || len(db.songOrder) != 0 {
+		t.Errorf("rejected upload left %d objects and %d songs", len(store.objects), len(db.songOrder))
+	}
+}
+
+func TestPresignedCompletionIsClaimedOnce(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := fakeMP3(0x56)
+	create := func() presignedResponse {
+		rec := client.postJSON("artist-1", "/music/uploads", echo.Map{
+			"song":        echo.Map{"filename": "track.mp3", "size": len(audio)},
+			"thumbnail":   echo.Map{"filename": "cover.png", "size": len(fakePNG(color.White))},
+			"releaseDate": "2024-07-08",
+		})
+		var created presignedResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		postForm(t, store, created.Song, audio, "audio/mpeg")
+		postForm(t, store, created.Thumbnail, fakePNG(color.White), "image/png")
+		return created
+	}
+	created := create()
+	complete := "/music/uploads/" + created.UploadID + "/complete"
+
+	// A song whose upload cannot be marked complete is taken back.
+	db.completeUploadErr = errors.New("write timeout")
+	if rec := client.postJSON("artist-1", complete, nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failed completion: status = %d, want 500", rec.Code)
+	}
+	if songs := db.allSongs(); len(songs) != 0 {
+		t.Fatalf("songs after failed completion = %+v", songs)
+	}
+	db.completeUploadErr = nil
+
+	db.uploads[created.UploadID].FinalizingAt = time.Now()
+	if rec := client.postJSON("artist-1", complete, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("concurrent completion: status = %d, want 409", rec.Code)
+	}
+	db.uploads[created.UploadID].FinalizingAt = time.Time{}
+	if rec := client.postJSON("artist-1", complete, nil); rec.Code != http.StatusOK {
+		t.Fatalf("retried completion: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if songs := db.allSongs(); len(songs) != 1 {
+		t.Fatalf("songs = %+v", songs)
+	}
+
+	// An upload that is never completed leaves its staged files behind only
+	// until its row expires.
+	abandoned := create()
+	delete(db.uploads, abandoned.UploadID)
+	if _, err := maintenance.SweepUploads(context.Background(), db, store, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for name := range store.objects {
+		if strings.HasPrefix(name, "uploads/") {
+			t.Errorf("staging object %s was left behind", name)
+		}
+	}
+}
+
+func TestRedirectMusic(t *testing.T) {
+	db := newFakeScylla()
+	db.addSong(models.Song{SongID: "66666666-6666-6666-6666-666666666666", SongURL: "songs/66666666-6666-6666-6666-666666666666/abc.mp3"})
+	e := echo.New()
+	e.GET("/music/stream/:song_id", handlers.RedirectMusic(db, newFakeMinIO()))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/music/stream/66666666-6666-6666-6666-666666666666", nil))
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("status = %d, want 307", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); !strings.Contains(loc, "/music/songs/66666666-6666-6666-6666-666666666666/abc.mp3") {
+		t.Errorf("Location = %q", loc)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("redirect to an expiring URL must not be cached")
+	}
+}
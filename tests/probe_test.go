@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+)
+
+// The fixtures under testdata/ffprobe are what ffprobe -print_format json
+// -show_format -show_streams printed for real uploads, trimmed.
+func TestParseProbe(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		fixture string
+		want    media.Metadata
+		cover   media.CoverArtStream
+	}{
+		{
+			// ID3 frames come through on the container; surrounding space is
+			// dropped, artist wins over album_artist and TDRC gives the date.
+			fixture: "mp3_id3.json",
+			want: media.Metadata{
+				Title: "Crazy in Love", Artist: "Beyoncé", Album: "Dangerously in Love", Genre: "R&B",
+				ReleaseDate: date(2003, time.May, 18),
+				Audio:       models.AudioInfo{Duration: 215.954286, Bitrate: 321851, SampleRate: 44100, Channels: 2},
+			},
+			cover: media.CoverArtStream{Index: 1, ContentType: "image/jpeg"},
+		},
+		{
+			// Vorbis comments live on the stream, upper-cased, and the
+			// container has no bit rate of its own.
+			fixture: "ogg_vorbis.json",
+			want: media.Metadata{
+				Title: "Field Recording", Artist: "Sam", Genre: "Ambient",
+				ReleaseDate: date(2021, time.January, 1),
+				Audio:       models.AudioInfo{Duration: 61.5, Bitrate: 112000, SampleRate: 48000, Channels: 1},
+			},
+		},
+		{
+			// The first picture is a BMP we do not accept, so the PNG after it
+			// is used; album_artist stands in for a missing artist.
+			fixture: "m4a_png_cover.json",
+			want: media.Metadata{
+				Title: "Halo", Artist: "Beyoncé", Album: "I Am... Sasha Fierce",
+				ReleaseDate: date(2008, time.November, 1),
+				Audio:       models.AudioInfo{Duration: 180.04644, Bitrate: 263599, SampleRate: 44100, Channels: 2},
+			},
+			cover: media.CoverArtStream{Index: 2, ContentType: "image/png"},
+		},
+		{
+			fixture: "wav_untagged.json",
+			want: media.Metadata{
+				Audio: models.AudioInfo{Duration: 3, Bitrate: 1411200, SampleRate: 44100, Channels: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		output, err := os.ReadFile(filepath.Join("testdata", "ffprobe", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, cover, err := media.ParseProbe(output)
+		if err != nil {
+			t.Errorf("%s: %v", tt.fixture, err)
+			continue
+		}
+		if meta.Title != tt.want.Title || meta.Artist != tt.want.Artist || meta.Album != tt.want.Album || meta.Genre != tt.want.Genre {
+			t.Errorf("%s: tags = %q, %q, %q, %q, want %q, %q, %q, %q", tt.fixture,
+				meta.Title, meta.Artist, meta.Album, meta.Genre, tt.want.Title, tt.want.Artist, tt.want.Album, tt.want.Genre)
+		}
+		if !meta.ReleaseDate.Equal(tt.want.ReleaseDate) {
+			t.Errorf("%s: release date = %v, want %v", tt.fixture, meta.ReleaseDate, tt.want.ReleaseDate)
+		}
+		if meta.Audio != tt.want.Audio {
+			t.Errorf("%s: audio = %+v, want %+v", tt.fixture, meta.Audio, tt.want.Audio)
+		}
+		if cover != tt.cover {
+			t.Errorf("%s: cover art = %+v, want %+v", tt.fixture, cover, tt.cover)
+		}
+		if meta.CoverArt != nil {
+			t.Errorf("%s: cover art was read without ffmpeg", tt.fixture)
+		}
+	}
+
+	if _, _, err := media.ParseProbe([]byte("Invalid data found when processing input")); err == nil {
+		t.Error("ParseProbe accepted output that is not JSON")
+	}
+}
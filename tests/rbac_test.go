@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rr-backend/internal/authz"
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission authz.Permission
+		want       bool
+	}{
+		{models.RoleListener, authz.ApplyForArtist, true},
+		{models.RoleListener, authz.UploadMusic, false},
+		{models.RoleListener, authz.ReviewArtists, false},
+		{models.RoleArtist, authz.UploadMusic, true},
+		{models.RoleArtist, authz.ApplyForArtist, false},
+		{models.RoleArtist, authz.ModerateContent, false},
+		{models.RoleModerator, authz.ModerateContent, true},
+		{models.RoleModerator, authz.UploadMusic, false},
+		{models.RoleModerator, authz.ReviewArtists, false},
+		{models.RoleAdmin, authz.ReviewArtists, true},
+		{models.RoleAdmin, authz.ManageUsers, true},
+		{models.RoleAdmin, authz.UploadMusic, true},
+		{"", authz.ApplyForArtist, false},
+		{"superuser", authz.UploadMusic, false},
+	}
+	for _, tt := range tests {
+		if got := authz.Can(tt.role, tt.permission); got != tt.want {
+			t.Errorf("Can(%q, %s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+	if authz.UserCan(nil, authz.ApplyForArtist) {
+		t.Error("anonymous user was granted a permission")
+	}
+}
+
+func TestUploadRoutesRequireArtist(t *testing.T) {
+	client := newTestClient(t, server.Services{DB: newAuthzDB()})
+	routes := []struct{ method, target string }{
+		{http.MethodPost, "/music/upload"},
+		{http.MethodPost, "/music/tus"},
+		{http.MethodHead, "/music/tus/some-upload"},
+		{http.MethodPatch, "/music/tus/some-upload"},
+		{http.MethodDelete, "/music/tus/some-upload"},
+		{http.MethodPost, "/music/uploads"},
+		{http.MethodPost, "/music/uploads/some-upload/complete"},
+	}
+	for _, route := range routes {
+		for userID, allowed := range map[string]bool{"stranger": false, "artist-1": true, "root": true} {
+			code := client.do(userID, route.method, route.target, "{}").Code
+			if (code != http.StatusForbidden) != allowed {
+				t.Errorf("%s %s as %s: status = %d", route.method, route.target, userID, code)
+			}
+		}
+	}
+
+	if code := client.do("stranger", http.MethodPut, "/user/promote", "").Code; code != http.StatusNotFound && code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT /user/promote: status = %d, want the route gone", code)
+	}
+}
+
+func TestCurrentUserLoadedOncePerRequest(t *testing.T) {
+	db := newAuthzDB()
+	client := newTestClient(t, server.Services{DB: db})
+
+	// The role check and the upload limits both need the user.
+	rec := client.do("artist-1", http.MethodPost, "/music/uploads", "{}")
+	if rec.Code == http.StatusForbidden {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if db.userLookups != 1 {
+		t.Errorf("user loaded %d times, want once", db.userLookups)
+	}
+}
+
+func TestArtistApplicationWorkflow(t *testing.T) {
+	db := newAuthzDB()
+	client := newTestClient(t, server.Services{DB: db})
+	expect := func(rec *httptest.ResponseRecorder, want int, what string) {
+		t.Helper()
+		if rec.Code != want {
+			t.Fatalf("%s: status = %d, want %d, body = %s", what, rec.Code, want, rec.Body.String())
+		}
+	}
+
+	expect(client.do("stranger", http.MethodGet, "/artist-applications/me", ""), http.StatusNotFound, "status before applying")
+	expect(client.do("stranger", http.MethodPost, "/artist-applications", `{"artist_name":" "}`), http.StatusBadRequest, "apply without a name")
+	expect(client.do("stranger", http.MethodPost, "/artist-applications", `{"artist_name":"The Strangers","message":"hi"}`), http.StatusCreated, "apply")
+	expect(client.do("stranger", http.MethodPost, "/artist-applications", `{"artist_name":"Again"}`), http.StatusConflict, "apply twice")
+	expect(client.do("artist-1", http.MethodPost, "/artist-applications", `{"artist_name":"Already"}`), http.StatusForbidden, "apply as an artist")
+
+	// Only admins review.
+	for _, userID := range []string{"stranger", "artist-1"} {
+		expect(client.do(userID, http.MethodGet, "/admin/artist-applications", ""), http.StatusForbidden, "list as "+userID)
+		expect(client.do(userID, http.MethodPost, "/admin/artist-applications/stranger/approve", "{}"), http.StatusForbidden, "approve as "+userID)
+	}
+	if db.users["stranger"].Role != models.RoleListener {
+		t.Fatal("listener was promoted without review")
+	}
+
+	rec := client.do("root", http.MethodGet, "/admin/artist-applications", "")
+	expect(rec, http.StatusOK, "list pending")
+	var pending struct {
+		Items []models.ArtistApplication `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &pending); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending.Items) != 1 || pending.Items[0].UserID != "stranger" || pending.Items[0].ArtistName != "The Strangers" {
+		t.Fatalf("pending = %+v", pending.Items)
+	}
+
+	expect(client.do("root", http.MethodPost, "/admin/artist-applications/nobody/approve", "{}"), http.StatusNotFound, "approve unknown")
+	expect(client.do("root", http.MethodPost, "/admin/artist-applications/stranger/approve", `{"note":"welcome"}`), http.StatusOK, "approve")
+	expect(client.do("root", http.MethodPost, "/admin/artist-applications/stranger/reject", "{}"), http.StatusConflict, "review twice")
+
+	user := db.users["stranger"]
+	if user.Role != models.RoleArtist || user.Username != "The Strangers" {
+		t.Errorf("approved user = %+v", user)
+	}
+	rec = client.do("stranger", http.MethodGet, "/artist-applications/me", "")
+	expect(rec, http.StatusOK, "status after approval")
+	var application models.ArtistApplication
+	if err := json.Unmarshal(rec.Body.Bytes(), &application); err != nil {
+		t.Fatal(err)
+	}
+	if application.Status != models.ApplicationApproved || application.ReviewedBy != "root" || application.ReviewNote != "welcome" || application.ReviewedAt == nil {
+		t.Errorf("application = %+v", application)
+	}
+	if code := client.do("stranger", http.MethodPost, "/music/uploads", "{}").Code; code == http.StatusForbidden {
+		t.Error("approved artist may not upload")
+	}
+
+	// A rejected listener stays a listener and may apply again.
+	expect(client.do("owner", http.MethodPost, "/artist-applications", `{"artist_name":"Owners"}`), http.StatusCreated, "apply")
+	expect(client.do("root", http.MethodPost, "/admin/artist-applications/owner/reject", `{"note":"no songs yet"}`), http.StatusOK, "reject")
+	if db.users["owner"].Role != models.RoleListener {
+		t.Errorf("rejected user has role %q", db.users["owner"].Role)
+	}
+	expect(client.do("owner", http.MethodPost, "/artist-applications", `{"artist_name":"Owners"}`), http.StatusCreated, "apply again")
+}
+
+// staleUsers answers user lookups from a copy taken before a concurrent
+// change, as a handler racing an admin sees them.
+type staleUsers struct {
+	*fakeScylla
+	copies map[string]models.User
+}
+
+func (s staleUsers) GetUserByID(userID string) (*models.User, error) {
+	user, ok := s.copies[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func TestProfileUpdateKeepsConcurrentDemotion(t *testing.T) {
+	db := newAuthzDB()
+	stale := staleUsers{fakeScylla: db, copies: map[string]models.User{"artist-1": *db.users["artist-1"]}}
+	db.UpdateUserRole("artist-1", models.RoleListener)
+
+	e := echo.New()
+	e.PUT("/artists/:artist_id", handlers.UpdateArtistProfileHandler(stale))
+	req := httptest.NewRequest(http.MethodPut, "/artists/artist-1", strings.NewReader(`{"username":"Renamed"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if user := db.users["artist-1"]; user.Username != "Renamed" || user.Role != models.RoleListener {
+		t.Errorf("user after rename = %+v, want the demotion kept", user)
+	}
+}
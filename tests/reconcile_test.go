@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	healthySongID = "44444444-4444-4444-4444-444444444444"
+	brokenSongID  = "55555555-5555-5555-5555-555555555555"
+	deletedSongID = "66666666-6666-6666-6666-666666666666"
+
+	stagedUploadID  = "88888888-8888-8888-8888-888888888888"
+	expiredUploadID = "99999999-9999-9999-9999-999999999999"
+)
+
+func newDriftedStorage() (*fakeScylla, *fakeMinIO) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+
+	db.addSong(models.Song{SongID: healthySongID, SongURL: "songs/" + healthySongID + "/a.mp3", ThumbnailURL: "thumbnails/" + healthySongID + "/a.png"})
+	store.put("songs/"+healthySongID+"/a.mp3", fakeMP3(0x01), "audio/mpeg")
+	store.put("thumbnails/"+healthySongID+"/a.png", fakePNG(color.White), "image/png")
+	store.put("hls/"+healthySongID+"/master.m3u8", []byte("#EXTM3U\n"), "application/vnd.apple.mpegurl")
+
+	// The audio of this song was lost.
+	db.addSong(models.Song{SongID: brokenSongID, SongURL: "songs/" + brokenSongID + "/b.mp3", ThumbnailURL: "thumbnails/" + brokenSongID + "/b.png"})
+	store.put("thumbnails/"+brokenSongID+"/b.png", fakePNG(color.Black), "image/png")
+
+	// Leftovers of a song whose row is gone.
+	store.put("songs/"+deletedSongID+"/c.mp3", fakeMP3(0x02), "audio/mpeg")
+	store.put("hls/"+deletedSongID+"/64k/seg_000.ts", []byte{0x47}, "video/mp2t")
+
+	// An upload that is still in flight.
+	store.put("songs/77777777-7777-7777-7777-777777777777/d.mp3", fakeMP3(0x03), "audio/mpeg").modTime = time.Now()
+
+	// Staged files of an upload still waiting to be completed, and of one
+	// whose row has expired.
+	uploadID, _ := gocql.ParseUUID(stagedUploadID)
+	db.CreateUpload(models.Upload{UploadID: uploadID, ObjectName: "uploads/" + stagedUploadID})
+	store.put("uploads/"+stagedUploadID, fakeMP3(0x04), "audio/mpeg")
+	store.put("uploads/"+expiredUploadID+".thumbnail", fakePNG(color.White), "image/png")
+	return db, store
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	db, store := newDriftedStorage()
+
+	report, err := maintenance.Reconcile(context.Background(), db, store, maintenance.ReconcileOptions{MinAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans := map[string]bool{}
+	for _, o := range report.OrphanObjects {
+		orphans[o.Key] = true
+	}
+	if len(orphans) != 3 || !orphans["songs/"+deletedSongID+"/c.mp3"] || !orphans["hls/"+deletedSongID+"/64k/seg_000.ts"] || !orphans["uploads/"+expiredUploadID+".thumbnail"] {
+		t.Errorf("orphans = %v", report.OrphanObjects)
+	}
+	if len(report.BrokenSongs) != 1 || report.BrokenSongs[0].SongID != brokenSongID || report.BrokenSongs[0].MissingThumbnail != "" {
+		t.Errorf("broken songs = %+v", report.BrokenSongs)
+	}
+
+	// A report without -fix changes nothing.
+	if len(store.objects) != 9 || db.songs[brokenSongID].Status != "" {
+		t.Errorf("dry run modified storage")
+	}
+}
+
+func TestReconcileFix(t *testing.T) {
+	db, store := newDriftedStorage()
+
+	report, err := maintenance.Reconcile(context.Background(), db, store, maintenance.ReconcileOptions{Fix: true, MinAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 3 || report.MarkedUnavailable != 1 || len(report.Failed) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if _, ok := store.data("songs/" + deletedSongID + "/c.mp3"); ok {
+		t.Errorf("orphan was not removed")
+	}
+	if _, ok := store.data("songs/77777777-7777-7777-7777-777777777777/d.mp3"); !ok {
+		t.Errorf("recent upload was removed")
+	}
+	if _, ok := store.data("uploads/" + stagedUploadID); !ok {
+		t.Errorf("staged file of a live upload was removed")
+	}
+	if db.songs[brokenSongID].Status != models.SongStatusUnavailable {
+		t.Errorf("broken song was not marked unavailable")
+	}
+	if db.songs[healthySongID].Status != "" {
+		t.Errorf("healthy song was marked %q", db.songs[healthySongID].Status)
+	}
+
+	again, err := maintenance.Reconcile(context.Background(), db, store, maintenance.ReconcileOptions{Fix: true, MinAge: time.Hour})
+	if err != nil || again.Removed != 0 || again.MarkedUnavailable != 0 {
+		t.Errorf("second run should be a no-op, got %+v, %v", again, err)
+	}
+}
+
+func TestUnavailableSongsAreNotServed(t *testing.T) {
+	db, store := newDriftedStorage()
+	if _, err := maintenance.Reconcile(context.Background(), db, store, maintenance.ReconcileOptions{Fix: true, MinAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.GET("/music/all", handlers.GetAllSongs(db))
+	e.GET("/music/stream/:song_id", handlers.StreamMusic(db, store))
+	e.GET("/music/stream/:song_id/master.m3u8", handlers.GetSongManifest(db, store))
+	e.GET("/music/redirect/:song_id", handlers.RedirectMusic(db, store))
+
+	for _, target := range []string{"/music/stream/" + brokenSongID, "/music/stream/" + brokenSongID + "/master.m3u8", "/music/redirect/" + brokenSongID} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s of an unavailable song: status = %d, want 404", target, rec.Code)
+		}
+	}
+
+	if got := pageThrough(t, e, "/music/all?limit=1", "song_id"); len(got) != 1 || got[0] != healthySongID {
+		t.Errorf("listed %v, want only the healthy song", got)
+	}
+}
+
+func TestListingsLeaveOutUnavailableSongs(t *testing.T) {
+	db, playlistID, songs := newPlaylistDB()
+	db.users["artist"] = &models.User{UserID: "artist", Role: models.RoleArtist}
+	client := newTestClient(t, server.Services{DB: db})
+	addTrack(t, client, playlistID, songs["a"], "")
+	addTrack(t, client, playlistID, songs["b"], "")
+	for _, title := range []string{"a", "b", "c"} {
+		db.LikeSong("owner", mustUUID(t, songs[title]))
+	}
+	db.SetSongStatus(mustUUID(t, songs["b"]), models.SongStatusUnavailable)
+	db.SetSongStatus(mustUUID(t, songs["c"]), models.SongStatusTakenDown)
+	want := []string{songs["a"], songs["d"], songs["e"]}
+
+	if got := pageThrough(t, client.as("artist"), "/music?limit=2", "song_id"); !reflect.DeepEqual(got, want) {
+		t.Errorf("own songs = %v, want %v", got, want)
+	}
+	if got := pageThrough(t, client.as("owner"), "/music/likes?limit=2", "song_id"); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("liked songs = %v, want %v", got, want[:1])
+	}
+
+	rec := client.do("", http.MethodGet, "/artists/artist", "")
+	var artist struct {
+		Songs struct {
+			Items []models.Song `json:"items"`
+		} `json:"songs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &artist); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("artist page: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(artist.Songs.Items) != len(want) {
+		t.Errorf("artist page lists %d songs, want %d", len(artist.Songs.Items), len(want))
+	}
+
+	rec = client.do("owner", http.MethodGet, "/playlists/"+playlistID.String()+"/songs", "")
+	var tracks struct {
+		Items []models.PlaylistTrack `json:"items"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &tracks)
+	if len(tracks.Items) != 2 || tracks.Items[0].Song == nil || tracks.Items[1].Song != nil {
+		t.Errorf("playlist tracks = %s, want the unavailable song's entry without its song", rec.Body.String())
+	}
+
+	if rec := client.do("owner", http.MethodPost, "/playlists/"+playlistID.String()+"/songs/"+songs["c"], ""); rec.Code != http.StatusGone {
+		t.Errorf("adding a taken-down song: status = %d, want 410", rec.Code)
+	}
+}
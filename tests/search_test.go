@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/models"
+	"rr-backend/internal/search"
+
+	"github.com/gocql/gocql"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	dejaVuID   = gocql.TimeUUID()
+	haloID     = gocql.TimeUUID()
+	roadTripID = gocql.TimeUUID()
+)
+
+func newSearchFixture(t *testing.T) (*fakeScylla, *search.Index) {
+	t.Helper()
+	index, err := search.NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+
+	fake := newFakeScylla()
+	db := search.Sync(fake, index, search.NewSuggester())
+	db.UpsertUser("artist-1", "Beyoncé", "b@example.com", "artist")
+	db.UpsertUser("listener-1", "Sam", "s@example.com", "listener")
+	db.InsertSong(dejaVuID, "Déjà Vu", "artist-1", "B'Day", time.Date(2006, 6, 1, 0, 0, 0, 0, time.UTC), "R&B", "", "", models.AudioInfo{})
+	db.InsertSong(haloID, "Halo", "artist-1", "I Am... Sasha Fierce", time.Date(2008, 1, 1, 0, 0, 0, 0, time.UTC), "Pop", "", "", models.AudioInfo{})
+	db.AddPlaylist(roadTripID, "listener-1", "Road trip", "", models.PlaylistPublic)
+	return fake, index
+}
+
+func searchIDs(t *testing.T, index *search.Index, req search.Request) []string {
+	t.Helper()
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	results, err := index.Search(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, hit := range results.Hits {
+		ids = append(ids, hit.Type+":"+hit.ID)
+	}
+	return ids
+}
+
+func TestSearchIndex(t *testing.T) {
+	_, index := newSearchFixture(t)
+
+	song := func(id gocql.UUID) string { return "song:" + id.String() }
+	tests := []struct {
+		name string
+		req  search.Request
+		want []string
+	}{
+		{"accents are ignored", search.Request{Query: "deja vu"}, []string{song(dejaVuID)}},
+		{"accents in the query are ignored", search.Request{Query: "DÉJÀ"}, []string{song(dejaVuID)}},
+		{"typos are forgiven", search.Request{Query: "hallo"}, []string{song(haloID)}},
+		{"short words must match exactly", search.Request{Query: "vo"}, nil},
+		{"album", search.Request{Query: "sasha fierce"}, []string{song(haloID)}},
+		{"genre", search.Request{Query: "pop"}, []string{song(haloID)}},
+		{"playlist name", search.Request{Query: "road"}, []string{"playlist:" + roadTripID.String()}},
+		{"listeners are not artists", search.Request{Query: "sam"}, nil},
+		{"type filter", search.Request{Query: "beyonce", Type: search.TypeArtist}, []string{"artist:artist-1"}},
+		{"genre filter", search.Request{Query: "beyonce", Genre: "pop"}, []string{song(haloID)}},
+		{"year filter", search.Request{Query: "beyonce", Year: 2006}, []string{song(dejaVuID)}},
+	}
+	for _, tt := range tests {
+		got := searchIDs(t, index, tt.req)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	results, err := index.Search(search.Request{Query: "beyonce", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 3 {
+		t.Errorf("artist name should match the artist and both songs, got %d hits", results.Total)
+	}
+	genres := map[string]int{}
+	for _, f := range results.Facets["genre"] {
+		genres[f.Value] = f.Count
+	}
+	if genres["pop"] != 1 || genres["r&b"] != 1 || len(results.Facets["year"]) != 2 {
+		t.Errorf("facets = %+v", results.Facets)
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	fake, index := newSearchFixture(t)
+	db := search.Sync(fake, index, search.NewSuggester())
+
+	db.RemoveSong(haloID)
+	if got := searchIDs(t, index, search.Request{Query: "halo"}); len(got) != 0 {
+		t.Errorf("removed song is still found: %v", got)
+	}
+
+	db.UpsertUser("artist-1", "Queen B", "b@example.com", "artist")
+	if got := searchIDs(t, index, search.Request{Query: "queen", Type: search.TypeSong}); len(got) != 1 {
+		t.Errorf("songs were not re-indexed under the new artist name: %v", got)
+	}
+
+	db.UpdatePlaylist(roadTripID, "Night drive", "", models.PlaylistPublic)
+	results, _ := index.Search(search.Request{Query: "night", Limit: 10})
+	if len(results.Hits) != 1 || results.Hits[0].UserID != "listener-1" {
+		t.Errorf("renamed playlist: %+v", results.Hits)
+	}
+	db.UpdatePlaylist(roadTripID, "Night drive", "", models.PlaylistUnlisted)
+	if got := searchIDs(t, index, search.Request{Query: "night"}); len(got) != 0 {
+		t.Errorf("unlisted playlist is still found: %v", got)
+	}
+	db.UpdatePlaylist(roadTripID, "Night drive", "", models.PlaylistPublic)
+	db.RemovePlaylist(roadTripID)
+	if got := searchIDs(t, index, search.Request{Query: "night"}); len(got) != 0 {
+		t.Errorf("removed playlist is still found: %v", got)
+	}
+
+	// A rebuild from the database finds everything that is left.
+	fresh, err := search.NewMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	report, err := search.Rebuild(context.Background(), fake, fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Songs != 1 || report.Artists != 1 || report.Playlists != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if got := searchIDs(t, fresh, search.Request{Query: "queen deja"}); len(got) != 1 {
+		t.Errorf("rebuilt index: %v", got)
+	}
+}
+
+func TestSearchIndexFollowsSongStatusAndPlays(t *testing.T) {
+	fake, index := newSearchFixture(t)
+	suggester := search.NewSuggester()
+	db := search.Sync(fake, index, suggester)
+	halo, _ := fake.GetSongByID(haloID)
+	suggester.AddSong(*halo)
+
+	db.SetSongStatus(haloID, models.SongStatusTakenDown)
+	results, err := index.Search(search.Request{Query: "beyonce", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Total != 2 || len(results.Facets["year"]) != 1 {
+		t.Errorf("taken down song is counted: total %d, facets %+v", results.Total, results.Facets)
+	}
+	for _, f := range results.Facets["genre"] {
+		if f.Value == "pop" {
+			t.Errorf("taken down song is in the genre facet: %+v", results.Facets["genre"])
+		}
+	}
+	if got := suggester.Suggest("halo", 10); len(got) != 0 {
+		t.Errorf("taken down song is suggested: %v", got)
+	}
+
+	db.SetSongStatus(haloID, "")
+	if got := searchIDs(t, index, search.Request{Query: "halo"}); len(got) != 1 {
+		t.Errorf("restored song is not found: %v", got)
+	}
+	if got := suggester.Suggest("halo", 10); len(got) != 1 || got[0].ID != haloID.String() {
+		t.Errorf("restored song is not suggested: %v", got)
+	}
+
+	// Plays break ties between equally good matches.
+	songs := search.Request{Type: search.TypeSong}
+	first := searchIDs(t, index, songs)[0]
+	second := haloID
+	if first == "song:"+haloID.String() {
+		second = dejaVuID
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.RecordPlay("listener-1", second, time.Now(), 60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := searchIDs(t, index, songs); got[0] != first {
+		t.Errorf("plays reached the index before the flush: %v", got)
+	}
+	if n, err := search.FlushPlays(context.Background(), db, index); err != nil || n != 1 {
+		t.Fatalf("FlushPlays() = %d, %v", n, err)
+	}
+	if got := searchIDs(t, index, songs); got[0] != "song:"+second.String() {
+		t.Errorf("more played song does not rank first: %v", got)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	fake, index := newSearchFixture(t)
+	fake.SetSongStatus(haloID, models.SongStatusUnavailable)
+
+	e := echo.New()
+	e.GET("/search", handlers.SearchHandler(index, fake))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=beyonce&type=song", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var results struct {
+		Items  []search.Hit `json:"items"`
+		Total  int          `json:"total"`
+		Facets map[string][]search.Facet
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Items) != 1 || results.Items[0].Song == nil || results.Items[0].Song.Title != "Déjà Vu" {
+		t.Errorf("hits = %+v", results.Items)
+	}
+	if fake.songLookups != 0 {
+		t.Errorf("looked up %d songs one by one", fake.songLookups)
+	}
+	if results.Total != 2 || len(results.Facets["genre"]) != 2 {
+		t.Errorf("total = %d, facets = %+v", results.Total, results.Facets)
+	}
+
+	for _, target := range []string{"/search?q=x&type=album", "/search?q=x&year=recent", "/search?q=x&cursor=bm90IGpzb24"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/streaming"
+
+	"github.com/labstack/echo/v4"
+)
+
+func testTrack() []byte {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func serve(t *testing.T, store *fakeMinIO, objectName string, method string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/music/stream/x", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	if err := streaming.Serve(rec, req, store, "music", objectName); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	return rec
+}
+
+func TestStreamingRanges(t *testing.T) {
+	data := testTrack()
+
+	tests := []struct {
+		name       string
+		rangeHdr   string
+		wantStatus int
+		wantBody   []byte
+		wantRange  string
+	}{
+		{"full object", "", http.StatusOK, data, ""},
+		{"first bytes", "bytes=0-99", http.StatusPartialContent, data[:100], "bytes 0-99/1000"},
+		{"middle", "bytes=500-509", http.StatusPartialContent, data[500:510], "bytes 500-509/1000"},
+		{"open ended", "bytes=990-", http.StatusPartialContent, data[990:], "bytes 990-999/1000"},
+		{"suffix", "bytes=-10", http.StatusPartialContent, data[990:], "bytes 990-999/1000"},
+		{"end clamped", "bytes=995-5000", http.StatusPartialContent, data[995:], "bytes 995-999/1000"},
+		{"unsatisfiable", "bytes=1000-1001", http.StatusRequestedRangeNotSatisfiable, nil, "bytes */1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeMinIO()
+			store.put("songs/a/track.flac", data, "audio/flac")
+
+			headers := map[string]string{}
+			if tt.rangeHdr != "" {
+				headers["Range"] = tt.rangeHdr
+			}
+			rec := serve(t, store, "songs/a/track.flac", http.MethodGet, headers)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body mismatch: got %d bytes, want %d", rec.Body.Len(), len(tt.wantBody))
+			}
+			if got := rec.Header().Get("Content-Type"); got != "audio/flac" {
+				t.Errorf("Content-Type = %q, want audio/flac", got)
+			}
+			if got := store.bytesRead(); got != int64(len(tt.wantBody)) {
+				t.Errorf("read %d bytes from storage, want %d", got, len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestStreamingMetadataHeaders(t *testing.T) {
+	store := newFakeMinIO()
+	obj := store.put("songs/a/track.mp3", testTrack(), "")
+
+	rec := serve(t, store, "songs/a/track.mp3", http.MethodHead, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "audio/mpeg" {
+		t.Errorf("Content-Type = %q, want audio/mpeg from extension", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "1000" {
+		t.Errorf("Content-Length = %q, want 1000", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `"`+obj.etag+`"` {
+		t.Errorf("ETag = %q, want quoted %q", got, obj.etag)
+	}
+	if got := rec.Header().Get("Last-Modified"); got != "Fri, 01 Mar 2024 12:00:00 GMT" {
+		t.Errorf("Last-Modified = %q", got)
+	}
+	if rec.Body.Len() != 0 || store.bytesRead() != 0 {
+		t.Errorf("HEAD must not read the object")
+	}
+}
+
+func TestStreamingMultiRange(t *testing.T) {
+	data := testTrack()
+	store := newFakeMinIO()
+	store.put("songs/a/track.mp3", data, "audio/mpeg")
+
+	rec := serve(t, store, "songs/a/track.mp3", http.MethodGet, map[string]string{"Range": "bytes=0-9, 100-119, -5"})
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", rec.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", rec.Header().Get("Content-Type"))
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Content-Length = %s, body is %d bytes", got, rec.Body.Len())
+	}
+
+	want := []struct {
+		contentRange string
+		body         []byte
+	}{
+		{"bytes 0-9/1000", data[0:10]},
+		{"bytes 100-119/1000", data[100:120]},
+		{"bytes 995-999/1000", data[995:]},
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	for i, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != w.contentRange {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, w.contentRange)
+		}
+		body, _ := io.ReadAll(part)
+		if !bytes.Equal(body, w.body) {
+			t.Errorf("part %d body mismatch", i)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly %d parts", len(want))
+	}
+	if got := store.bytesRead(); got != 35 {
+		t.Errorf("read %d bytes from storage, want 35", got)
+	}
+}
+
+func TestStreamingConditionals(t *testing.T) {
+	data := testTrack()
+
+	tests := []struct {
+		name       string
+		headers    func(etag string) map[string]string
+		wantStatus int
+	}{
+		{"if-range etag match", func(etag string) map[string]string {
+			return map[string]string{"Range": "bytes=0-9", "If-Range": etag}
+		}, http.StatusPartialContent},
+		{"if-range etag mismatch", func(etag string) map[string]string {
+			return map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`}
+		}, http.StatusOK},
+		{"if-range date match", func(etag string) map[string]string {
+			return map[string]string{"Range": "bytes=0-9", "If-Range": "Fri, 01 Mar 2024 12:00:00 GMT"}
+		}, http.StatusPartialContent},
+		{"if-range date mismatch", func(etag string) map[string]string {
+			return map[string]string{"Range": "bytes=0-9", "If-Range": "Thu, 29 Feb 2024 12:00:00 GMT"}
+		}, http.StatusOK},
+		{"if-none-match", func(etag string) map[string]string {
+			return map[string]string{"If-None-Match": etag}
+		}, http.StatusNotModified},
+		{"if-modified-since", func(etag string) map[string]string {
+			return map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT"}
+		}, http.StatusNotModified},
+		{"if-match mismatch", func(etag string) map[string]string {
+			return map[string]string{"If-Match": `"other"`}
+		}, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeMinIO()
+			obj := store.put("songs/a/track.mp3", data, "audio/mpeg")
+
+			rec := serve(t, store, "songs/a/track.mp3", http.MethodGet, tt.headers(`"`+obj.etag+`"`))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStreamMusicHandler(t *testing.T) {
+	db := newFakeScylla()
+	db.objectNames["11111111-1111-1111-1111-111111111111"] = "songs/a/track.mp3"
+	store := newFakeMinIO()
+	store.put("songs/a/track.mp3", testTrack(), "audio/mpeg")
+
+	e := echo.New()
+	e.GET("/music/stream/:song_id", handlers.StreamMusic(db, store))
+
+	req := httptest.NewRequest(http.MethodGet, "/music/stream/11111111-1111-1111-1111-111111111111", nil)
+	req.Header.Set("Range", "bytes=900-")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", rec.Code)
+	}
+	if rec.Body.Len() != 100 {
+		t.Errorf("body = %d bytes, want 100", rec.Body.Len())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/music/stream/22222222-2222-2222-2222-222222222222", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown song status = %d, want 404", rec.Code)
+	}
+}
+
+func TestStreamHandlersRejectMalformedSongIDs(t *testing.T) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+
+	e := echo.New()
+	e.GET("/music/stream/:song_id", handlers.StreamMusic(db, store))
+	e.GET("/music/stream/:song_id/master.m3u8", handlers.GetSongManifest(db, store))
+	e.GET("/music/redirect/:song_id", handlers.RedirectMusic(db, store))
+
+	for _, target := range []string{"/music/stream/not-a-uuid", "/music/stream/not-a-uuid/master.m3u8", "/music/redirect/not-a-uuid"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/models"
+	"rr-backend/internal/search"
+
+	"github.com/labstack/echo/v4"
+)
+
+func suggestionTexts(suggestions []search.Suggestion) []string {
+	var texts []string
+	for _, s := range suggestions {
+		texts = append(texts, s.Type+":"+s.Text)
+	}
+	return texts
+}
+
+func TestSuggestions(t *testing.T) {
+	s := search.NewSuggester()
+	s.SetArtist("artist-1", "Beyoncé", 10)
+	s.AddSong(models.Song{SongID: "s1", Title: "Déjà Vu", UserID: "artist-1", Album: "B'Day", Genre: "R&B", PlayCount: 5})
+	s.AddSong(models.Song{SongID: "s2", Title: "Halo", UserID: "artist-1", Album: "Sasha Fierce", Genre: "Pop", PlayCount: 50})
+	s.AddSong(models.Song{SongID: "s3", Title: "Be Alright", UserID: "artist-2", Genre: "pop", PlayCount: 1})
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		// The artist outweighs her songs; both pop songs add up in the genre.
+		{"be", []string{"artist:Beyoncé", "song:Be Alright"}},
+		{"BEYO", []string{"artist:Beyoncé"}},
+		{"deja", []string{"song:Déjà Vu"}},
+		{"vu", []string{"song:Déjà Vu"}},
+		{"p", []string{"genre:Pop"}},
+		{"sa", []string{"album:Sasha Fierce"}},
+		{"h", []string{"song:Halo"}},
+		{"x", nil},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got := suggestionTexts(s.Suggest(tt.prefix, 10))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Suggest(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	song := s.Suggest("halo", 1)[0]
+	if song.ID != "s2" || song.ArtistID != "artist-1" || song.Artist != "Beyoncé" {
+		t.Errorf("song suggestion = %+v", song)
+	}
+
+	s.RemoveSong("s2")
+	if got := suggestionTexts(s.Suggest("sa", 10)); got != nil {
+		t.Errorf("album without songs is still suggested: %v", got)
+	}
+	if got := suggestionTexts(s.Suggest("pop", 10)); !reflect.DeepEqual(got, []string{"genre:Pop"}) {
+		t.Errorf("genre with a song left: %v", got)
+	}
+
+	s.SetArtist("artist-1", "Queen B", 0)
+	if got := suggestionTexts(s.Suggest("beyo", 10)); got != nil {
+		t.Errorf("old artist name is still suggested: %v", got)
+	}
+	if got := s.Suggest("deja", 1); len(got) != 1 || got[0].Artist != "Queen B" {
+		t.Errorf("songs should carry the new artist name: %+v", got)
+	}
+}
+
+// TestSuggestionsMatchReload applies random changes incrementally and checks
+// that the cached rankings agree with a suggester loaded from scratch.
+func TestSuggestionsMatchReload(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "alps", "beta", "bet", "gamma", "gala", "delta"}
+	phrase := func() string {
+		return words[rng.Intn(len(words))] + " " + words[rng.Intn(len(words))]
+	}
+
+	db := newFakeScylla()
+	s := search.NewSuggester()
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("artist-%d", i)
+		db.users[id] = &models.User{UserID: id, Username: phrase(), Role: "artist"}
+		s.SetArtist(id, db.users[id].Username, 0)
+	}
+
+	listed := map[string]bool{} // songOrder keeps removed songs
+	for step := 0; step < 1500; step++ {
+		id := fmt.Sprintf("%08d-0000-0000-0000-000000000000", rng.Intn(200))
+		if rng.Intn(3) == 0 {
+			db.mu.Lock()
+			delete(db.songs, id)
+			db.mu.Unlock()
+			s.RemoveSong(id)
+			continue
+		}
+		song := models.Song{
+			SongID:    id,
+			Title:     phrase(),
+			UserID:    fmt.Sprintf("artist-%d", rng.Intn(5)),
+			Album:     words[rng.Intn(len(words))],
+			Genre:     words[rng.Intn(len(words))],
+			PlayCount: rng.Intn(100),
+		}
+		db.mu.Lock()
+		if !listed[id] {
+			listed[id] = true
+			db.songOrder = append(db.songOrder, id)
+		}
+		db.songs[id] = &song
+		db.mu.Unlock()
+		s.AddSong(song)
+
+		if step%5 == 0 {
+			compareSuggesters(t, db, s)
+		}
+	}
+	compareSuggesters(t, db, s)
+}
+
+func TestSuggestWhileSongsChange(t *testing.T) {
+	s := search.NewSuggester()
+	s.SetArtist("artist-1", "Alpha", 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			id := fmt.Sprint(i % 20)
+			s.AddSong(models.Song{SongID: id, Title: "alpha " + id, UserID: "artist-1", PlayCount: i})
+			if i%3 == 0 {
+				s.RemoveSong(id)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			if got := s.Suggest("alpha", 5); len(got) == 0 {
+				t.Fatal("the artist disappeared while songs changed")
+			}
+		}
+	}
+}
+
+func compareSuggesters(t *testing.T, db *fakeScylla, s *search.Suggester) {
+	t.Helper()
+	loaded, err := search.LoadSuggester(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"a", "al", "alp", "b", "be", "bet", "g", "ga", "gal", "d", "alpha b"} {
+		got, want := s.Suggest(prefix, search.MaxSuggestions), loaded.Suggest(prefix, search.MaxSuggestions)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Suggest(%q) drifted from a reload:\n got  %v\n want %v", prefix, got, want)
+		}
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	s := search.NewSuggester()
+	for i := 0; i < 30; i++ {
+		s.AddSong(models.Song{SongID: fmt.Sprint(i), Title: fmt.Sprintf("Song %d", i)})
+	}
+	e := echo.New()
+	e.GET("/search/suggest", handlers.SuggestHandler(s))
+
+	tests := []struct {
+		target string
+		code   int
+		count  int
+	}{
+		{"/search/suggest?q=so", http.StatusOK, 8},
+		{"/search/suggest?q=so&limit=100", http.StatusOK, search.MaxSuggestions},
+		{"/search/suggest?q=", http.StatusOK, 0},
+		{"/search/suggest?q=so&limit=-1", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", tt.target, rec.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var resp struct {
+			Suggestions []search.Suggestion `json:"suggestions"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Suggestions == nil || len(resp.Suggestions) != tt.count {
+			t.Errorf("%s: got %d suggestions, want %d", tt.target, len(resp.Suggestions), tt.count)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rr-backend/internal/handlers"
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const thumbnailSongID = "88888888-8888-8888-8888-888888888888"
+
+func newThumbnailServer(withVariants bool) (*echo.Echo, *fakeMinIO) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	original := "thumbnails/" + thumbnailSongID + "/abc.png"
+	db.addSong(models.Song{SongID: thumbnailSongID, SongURL: "songs/" + thumbnailSongID + "/abc.mp3", ThumbnailURL: original})
+	store.put(original, fakePNG(color.White), "image/png")
+	if withVariants {
+		for _, size := range media.ThumbnailSizes {
+			store.put(media.ThumbnailVariantName(original, size, media.FormatJPEG), []byte("jpeg"), "image/jpeg")
+			store.put(media.ThumbnailVariantName(original, size, media.FormatWebP), []byte("webp"), "image/webp")
+		}
+	}
+
+	e := echo.New()
+	e.GET("/music/thumbnail/:song_id", handlers.GetSongThumbnail(db, store))
+	return e, store
+}
+
+func getThumbnail(e *echo.Echo, query, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/music/thumbnail/"+thumbnailSongID+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestThumbnailNegotiation(t *testing.T) {
+	e, _ := newThumbnailServer(true)
+	const chrome = "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	const safari = "image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"
+
+	tests := []struct {
+		name, query, accept string
+		contentType, body   string
+	}{
+		{"original keeps its own type", "", chrome, "image/png", ""},
+		{"webp when accepted", "?size=300", chrome, "image/webp", "webp"},
+		{"jpeg otherwise", "?size=300", safari, "image/jpeg", "jpeg"},
+		{"webp ranked below jpeg", "?size=64", "image/jpeg,image/webp;q=0.5", "image/jpeg", "jpeg"},
+		{"size rounds up", "?size=100", "image/webp", "image/webp", "webp"},
+		{"size capped at largest", "?size=5000", "", "image/jpeg", "jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getThumbnail(e, tt.query, tt.accept)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if rec.Header().Get("Vary") != "Accept" || rec.Header().Get("Cache-Control") == "" || rec.Header().Get("ETag") == "" {
+				t.Errorf("missing cache headers: %v", rec.Header())
+			}
+		})
+	}
+
+	if rec := getThumbnail(e, "?size=big", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid size: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestThumbnailFallsBackToOriginal(t *testing.T) {
+	e, _ := newThumbnailServer(false)
+
+	rec := getThumbnail(e, "?size=300", "image/webp")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("status = %d, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	// The derivative will replace it soon, so the original is not cached for
+	// as long as the derivative would be.
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	rec = getThumbnail(e, "", "image/webp")
+	if got := rec.Header().Get("Cache-Control"); !strings.Contains(got, "max-age=604800") {
+		t.Errorf("Cache-Control of the original itself = %q", got)
+	}
+}
+
+func TestThumbnailRevalidation(t *testing.T) {
+	e, _ := newThumbnailServer(true)
+
+	first := getThumbnail(e, "?size=640", "image/webp")
+	req := httptest.NewRequest(http.MethodGet, "/music/thumbnail/"+thumbnailSongID+"?size=640", nil)
+	req.Header.Set("Accept", "image/webp")
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("304 carried a body")
+	}
+}
@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/gocql/gocql"
+)
+
+func tusRequest(method, target string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+func tusPatch(client *testClient, userID, location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	return client.serve(userID, tusRequest(http.MethodPatch, location, chunk, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	}))
+}
+
+func TestTusUploadResumesAndCreatesSong(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := fakeMP3(0x44)
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("track.mp3")) +
+		",title " + base64.StdEncoding.EncodeToString([]byte("Resumed")) +
+		",releaseDate " + base64.StdEncoding.EncodeToString([]byte("2024-05-06"))
+
+	rec := client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(audio)),
+		"Upload-Metadata": metadata,
+	}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/music/tus/") {
+		t.Fatalf("Location = %q", location)
+	}
+
+	half := len(audio) / 2
+	if rec := tusPatch(client, "artist-1", location, 0, audio[:half]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("first chunk: status = %d, offset = %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// A client that lost track of the offset is told where to resume.
+	if rec := tusPatch(client, "artist-1", location, 0, audio); rec.Code != http.StatusConflict {
+		t.Fatalf("stale offset: status = %d, want 409", rec.Code)
+	}
+	rec = client.serve("artist-1", tusRequest(http.MethodHead, location, nil, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("head: status = %d, offset = %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	rec = tusPatch(client, "artist-1", location, half, audio[half:])
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("final chunk: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	songID := rec.Header().Get("X-Song-ID")
+	if songID == "" {
+		t.Fatal("finished upload did not report the song ID")
+	}
+
+	songs := db.allSongs()
+	if len(songs) != 1 || songs[0].SongID != songID || songs[0].Title != "Resumed" {
+		t.Fatalf("songs = %+v", songs)
+	}
+	data, ok := store.data(songs[0].SongURL)
+	if !ok || !bytes.Equal(data, audio) {
+		t.Errorf("stored song does not match the uploaded bytes")
+	}
+	for name := range store.objects {
+		if strings.HasPrefix(name, "uploads/") {
+			t.Errorf("staging object %s was left behind", name)
+		}
+	}
+}
+
+func TestTusRejectsOversizedAndForeignUploads(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	db.users["artist-2"] = &models.User{UserID: "artist-2", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	rec := client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{"Upload-Length": "1073741824"}))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized: status = %d, want 413", rec.Code)
+	}
+
+	rec = client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{"Upload-Length": "100"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d", rec.Code)
+	}
+	location := rec.Header().Get("Location")
+
+	rec = client.serve("artist-2", tusRequest(http.MethodHead, location, nil, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("foreign upload: status = %d, want 404", rec.Code)
+	}
+
+	rec = client.serve("artist-1", tusRequest(http.MethodDelete, location, nil, nil))
+	if rec.Code != http.StatusNoContent || len(db.uploads) != 0 {
+		t.Errorf("terminate: status = %d, %d uploads left", rec.Code, len(db.uploads))
+	}
+}
+
+func TestTusConcurrentChunkCannotCorruptUpload(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := fakeMP3(0x45)
+	rec := client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{
+		"Upload-Length": strconv.Itoa(len(audio)),
+		"Upload-Metadata": "title " + base64.StdEncoding.EncodeToString([]byte("Raced")) +
+			",releaseDate " + base64.StdEncoding.EncodeToString([]byte("2024-05-06")),
+	}))
+	location := rec.Header().Get("Location")
+	half := len(audio) / 2
+	if rec := tusPatch(client, "artist-1", location, 0, audio[:half]); rec.Code != http.StatusNoContent {
+		t.Fatalf("first chunk: status = %d", rec.Code)
+	}
+
+	// Another request at the same offset claims a part and writes garbage
+	// into it, then loses the race to record its progress.
+	uploadID, _ := gocql.ParseUUID(strings.TrimPrefix(location, "/music/tus/"))
+	upload, _ := db.GetUpload(uploadID)
+	part, claimed, _ := db.ClaimUploadPart(*upload)
+	if !claimed {
+		t.Fatal("concurrent request could not claim a part")
+	}
+	garbage := bytes.Repeat([]byte{0xff}, len(audio))
+	if _, err := store.PutObjectPart(context.Background(), "music", upload.ObjectName, upload.MultipartID, part, bytes.NewReader(garbage), int64(len(garbage))); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = tusPatch(client, "artist-1", location, half, audio[half:])
+	if rec.Code != http.StatusNoContent || rec.Header().Get("X-Song-ID") == "" {
+		t.Fatalf("final chunk: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	stale := *upload
+	stale.Offset, stale.Parts = int64(len(audio)), map[int]string{part: fmt.Sprintf("etag-%d", part)}
+	if applied, _ := db.AdvanceUpload(stale, upload.Offset); applied {
+		t.Error("the losing request recorded its progress")
+	}
+
+	songs := db.allSongs()
+	if len(songs) != 1 {
+		t.Fatalf("songs = %+v", songs)
+	}
+	if data, _ := store.data(songs[0].SongURL); !bytes.Equal(data, audio) {
+		t.Error("stored song does not match the uploaded bytes")
+	}
+	for name := range store.objects {
+		if strings.HasPrefix(name, "uploads/") {
+			t.Errorf("staging object %s was left behind", name)
+		}
+	}
+}
+
+func TestTusFinishIsClaimedOnce(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := fakeMP3(0x46)
+	rec := client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{
+		"Upload-Length": strconv.Itoa(len(audio)),
+		"Upload-Metadata": "title " + base64.StdEncoding.EncodeToString([]byte("Once")) +
+			",releaseDate " + base64.StdEncoding.EncodeToString([]byte("2024-05-06")),
+	}))
+	location := rec.Header().Get("Location")
+	uploadID := strings.TrimPrefix(location, "/music/tus/")
+
+	// A song whose upload cannot be marked complete is taken back, and the
+	// upload is free to be finished again.
+	db.completeUploadErr = errors.New("write timeout")
+	if rec := tusPatch(client, "artist-1", location, 0, audio); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failed completion: status = %d, want 500", rec.Code)
+	}
+	if songs := db.allSongs(); len(songs) != 0 {
+		t.Fatalf("songs after failed completion = %+v", songs)
+	}
+	if !db.uploads[uploadID].FinalizingAt.IsZero() {
+		t.Fatal("failed finish kept its claim")
+	}
+	db.completeUploadErr = nil
+
+	// While another request is finishing the upload, a retry has to wait.
+	db.uploads[uploadID].FinalizingAt = time.Now()
+	if rec := tusPatch(client, "artist-1", location, len(audio), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("concurrent finish: status = %d, want 409", rec.Code)
+	}
+	if songs := db.allSongs(); len(songs) != 0 {
+		t.Fatalf("songs after concurrent finish = %+v", songs)
+	}
+
+	// A claim left behind by a request that died is taken over.
+	db.uploads[uploadID].FinalizingAt = time.Now().Add(-time.Hour)
+	rec = tusPatch(client, "artist-1", location, len(audio), nil)
+	songID := rec.Header().Get("X-Song-ID")
+	if rec.Code != http.StatusNoContent || songID == "" {
+		t.Fatalf("retried finish: status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	rec = tusPatch(client, "artist-1", location, len(audio), nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("X-Song-ID") != songID {
+		t.Fatalf("repeated finish: status = %d, song = %s", rec.Code, rec.Header().Get("X-Song-ID"))
+	}
+	if songs := db.allSongs(); len(songs) != 1 || songs[0].SongID != songID {
+		t.Fatalf("songs = %+v", songs)
+	}
+}
+
+func TestSweepReleasesAbandonedUploads(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	audio := fakeMP3(0x47)
+	start := func() string {
+		rec := client.serve("artist-1", tusRequest(http.MethodPost, "/music/tus", nil, map[string]string{"Upload-Length": strconv.Itoa(len(audio))}))
+		location := rec.Header().Get("Location")
+		if rec := tusPatch(client, "artist-1", location, 0, audio[:10]); rec.Code != http.StatusNoContent {
+			t.Fatalf("chunk: status = %d", rec.Code)
+		}
+		return strings.TrimPrefix(location, "/music/tus/")
+	}
+	live, expired := start(), start()
+	delete(db.uploads, expired)
+	store.put("uploads/"+gocql.TimeUUID().String(), audio, "audio/mpeg")
+	store.put("uploads/not-an-upload", []byte("junk"), "application/octet-stream")
+
+	// The expired upload's pending chunk goes, besides the staged objects no
+	// upload row claims. Multipart uploads started within minAge may not have
+	// their row yet.
+	report, err := maintenance.SweepUploads(context.Background(), db, store, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Aborted != 0 || report.Removed != 3 || len(store.multiparts) != 2 {
+		t.Fatalf("first sweep: report = %+v, %d multipart uploads left", report, len(store.multiparts))
+	}
+
+	report, err = maintenance.SweepUploads(context.Background(), db, store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Aborted != 1 || report.Removed != 0 || len(report.Failed) != 0 {
+		t.Fatalf("second sweep: report = %+v", report)
+	}
+	for _, multipart := range store.multiparts {
+		if multipart.Key != "uploads/"+live {
+			t.Errorf("multipart upload of %s was not aborted", multipart.Key)
+		}
+	}
+	for name := range store.objects {
+		if !strings.HasPrefix(name, "uploads/"+live) {
+			t.Errorf("staging object %s was left behind", name)
+		}
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("%d staging objects left, want the live upload's pending chunk", len(store.objects))
+	}
+}
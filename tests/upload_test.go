@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rr-backend/internal/maintenance"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeMP3 returns two valid MPEG-1 layer III frames whose payload is filled
+// with fill, so different fills give different content.
+func fakeMP3(fill byte) []byte {
+	frame := bytes.Repeat([]byte{fill}, 417) // 128 kbit/s at 44.1 kHz
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return append(append([]byte{}, frame...), frame...)
+}
+
+func fakePNG(c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+type uploadFile struct {
+	field, name string
+	data        []byte
+}
+
+func uploadRequest(t *testing.T, fields map[string]string, files ...uploadFile) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for _, f := range files {
+		w, err := mw.CreateFormFile(f.field, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/music/upload", &body)
+	req.Header.Set(echo.HeaderContentType, mw.FormDataContentType())
+	return req
+}
+
+func TestUploadsNeverClobberEachOther(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	uploads := [][]byte{fakeMP3(0x11), fakeMP3(0x22), fakeMP3(0x22)}
+	for i, audio := range uploads {
+		req := uploadRequest(t,
+			map[string]string{"title": "Track", "releaseDate": "2024-01-02", "genre": "pop"},
+			uploadFile{"song", "track.mp3", audio},
+			uploadFile{"thumbnail", "cover.png", fakePNG(color.RGBA{uint8(i), 0, 0, 255})},
+		)
+		rec := client.serve("artist-1", req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %d: status = %d, body = %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	songs := db.allSongs()
+	if len(songs) != len(uploads) {
+		t.Fatalf("got %d songs, want %d", len(songs), len(uploads))
+	}
+
+	seen := map[string]bool{}
+	for i, song := range songs {
+		for _, key := range []string{song.SongURL, song.ThumbnailURL} {
+			if seen[key] {
+				t.Errorf("object key %s is shared by more than one song", key)
+			}
+			seen[key] = true
+			if !strings.Contains(key, song.SongID) {
+				t.Errorf("object key %s does not contain song ID %s", key, song.SongID)
+			}
+			if strings.Contains(key, "track.mp3") || strings.Contains(key, "cover.png") {
+				t.Errorf("object key %s still uses the client file name", key)
+			}
+		}
+
+		data, ok := store.data(song.SongURL)
+		if !ok {
+			t.Fatalf("song %d: object %s missing", i, song.SongURL)
+		}
+		if !bytes.Equal(data, uploads[i]) {
+			t.Errorf("song %d: stored audio was overwritten by another upload", i)
+		}
+	}
+}
+
+func TestUploadRejectsInvalidFiles(t *testing.T) {
+	db := newFakeScylla()
+	db.users["artist-1"] = &models.User{UserID: "artist-1", Role: "artist"}
+	store := newFakeMinIO()
+	client := newTestClient(t, server.Services{DB: db, Storage: store})
+
+	req := uploadRequest(t,
+		map[string]string{"title": "Track", "releaseDate": "2024-01-02"},
+		uploadFile{"song", "track.mp3", []byte("definitely not audio")},
+		uploadFile{"thumbnail", "cover.png", []byte{}},
+	)
+	rec := client.serve("artist-1", req)
+
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, want 415, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Errors []struct {
+			Field string `json:"field"`
+			Code  string `json:"code"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 2 || resp.Errors[0].Field != "song" || resp.Errors[1].Code != "empty_file" {
+		t.Errorf("unexpected errors: %+v", resp.Errors)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("rejected upload stored %d objects", len(store.objects))
+	}
+}
+
+func TestMigrateObjectKeys(t *testing.T) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+
+	// Two legacy songs whose uploads collided on the same object.
+	store.put("songs/artist-1/track.mp3", fakeMP3(0x33), "audio/mpeg")
+	store.put("thumbnails/artist-1/cover.png", fakePNG(color.White), "image/png")
+	store.put("thumbnails/artist-1/other.png", fakePNG(color.Black), "image/png")
+	db.addSong(models.Song{SongID: "11111111-1111-1111-1111-111111111111", UserID: "artist-1", SongURL: "songs/artist-1/track.mp3", ThumbnailURL: "thumbnails/artist-1/cover.png"})
+	db.addSong(models.Song{SongID: "22222222-2222-2222-2222-222222222222", UserID: "artist-1", SongURL: "songs/artist-1/track.mp3", ThumbnailURL: "thumbnails/artist-1/other.png"})
+
+	report, err := maintenance.MigrateObjectKeys(context.Background(), db, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 2 || len(report.Failed) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	songs := db.allSongs()
+	if songs[0].SongURL == songs[1].SongURL {
+		t.Errorf("migrated songs still share %s", songs[0].SongURL)
+	}
+	for _, song := range songs {
+		for _, key := range []string{song.SongURL, song.ThumbnailURL} {
+			if !strings.HasPrefix(key, "songs/"+song.SongID+"/") && !strings.HasPrefix(key, "thumbnails/"+song.SongID+"/") {
+				t.Errorf("key %s is not scoped to song %s", key, song.SongID)
+			}
+			if _, ok := store.data(key); !ok {
+				t.Errorf("migrated object %s missing", key)
+			}
+		}
+	}
+	if _, ok := store.data("songs/artist-1/track.mp3"); ok {
+		t.Errorf("legacy object was not removed")
+	}
+
+	again, err := maintenance.MigrateObjectKeys(context.Background(), db, store, false)
+	if err != nil || again.AlreadyScoped != 2 || again.Migrated != 0 {
+		t.Errorf("second run should be a no-op, got %+v, %v", again, err)
+	}
+}
+
+func TestMigrateObjectKeysKeepsLegacyObjectsWhenTheRowIsNotUpdated(t *testing.T) {
+	db := newFakeScylla()
+	store := newFakeMinIO()
+	store.put("songs/artist-1/track.mp3", fakeMP3(0x33), "audio/mpeg")
+	store.put("thumbnails/artist-1/cover.png", fakePNG(color.White), "image/png")
+	song := models.Song{SongID: "11111111-1111-1111-1111-111111111111", UserID: "artist-1", SongURL: "songs/artist-1/track.mp3", ThumbnailURL: "thumbnails/artist-1/cover.png"}
+	db.addSong(song)
+	db.updateObjectsErr = errors.New("scylla unavailable")
+
+	report, err := maintenance.MigrateObjectKeys(context.Background(), db, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 0 || len(report.Failed) != 1 || report.Removed != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(store.objects) != 2 {
+		t.Errorf("stored %d objects, want only the legacy ones", len(store.objects))
+	}
+	for _, key := range []string{song.SongURL, song.ThumbnailURL} {
+		if _, ok := store.data(key); !ok {
+			t.Errorf("legacy object %s was removed", key)
+		}
+	}
+}
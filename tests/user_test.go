@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rr-backend/internal/auth"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSignInTakesIdentityFromToken(t *testing.T) {
+	db := newFakeScylla()
+	issuer := auth.NewHMACIssuer([]byte("test secret"))
+	e := newRouter(t, server.Services{DB: db, Verifier: issuer})
+	signIn := func(identity auth.Identity, body string) models.User {
+		t.Helper()
+		token, err := issuer.Mint(identity, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/google", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			User models.User `json:"user"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.User
+	}
+
+	// Whatever the body claims, the user is who the token says, as a listener.
+	first := signIn(auth.Identity{UserID: "user-1", Email: "me@example.com", Name: "Me", PhotoURL: "https://example.com/me.png"},
+		`{"user_id":"root","username":"Root","email":"root@example.com","role":"admin"}`)
+	if first.UserID != "user-1" || first.Username != "Me" || first.Email != "me@example.com" ||
+		first.PhotoURL != "https://example.com/me.png" || first.Role != models.RoleListener {
+		t.Fatalf("first sign-in = %+v", first)
+	}
+	if _, ok := db.users["root"]; ok {
+		t.Fatal("user from the request body was created")
+	}
+	if first.FirstSeen == nil || first.LastSeen == nil || !first.FirstSeen.Equal(*first.LastSeen) {
+		t.Fatalf("first sign-in seen %v .. %v", first.FirstSeen, first.LastSeen)
+	}
+
+	// Later sign-ins refresh the claims and last_seen but keep the role and
+	// the username, which may have been changed since.
+	db.users["user-1"].Role = models.RoleArtist
+	db.users["user-1"].Username = "Stage Name"
+	time.Sleep(time.Millisecond)
+	again := signIn(auth.Identity{UserID: "user-1", Email: "new@example.com", Name: "Me"}, `{"role":"admin"}`)
+	if again.Role != models.RoleArtist || again.Username != "Stage Name" || again.Email != "new@example.com" || again.PhotoURL != "" {
+		t.Errorf("second sign-in = %+v", again)
+	}
+	if !again.FirstSeen.Equal(*first.FirstSeen) || !again.LastSeen.After(*first.LastSeen) {
+		t.Errorf("second sign-in seen %v .. %v, first %v .. %v", again.FirstSeen, again.LastSeen, first.FirstSeen, first.LastSeen)
+	}
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rr-backend/internal/media"
+	"rr-backend/internal/models"
+	"rr-backend/internal/server"
+
+	"github.com/labstack/echo/v4"
+)
+
+func fakeFLAC() []byte {
+	b := append([]byte("fLaC"), 0x80, 0, 0, 34) // last block, STREAMINFO, 34 bytes
+	info := make([]byte, 34)
+	const sampleRate = 44100
+	info[10], info[11], info[12] = sampleRate>>12, sampleRate>>4&0xff, sampleRate&0x0f<<4
+	return append(b, info...)
+}
+
+func fakeOgg(packet string) []byte {
+	b := append([]byte("OggS"), make([]byte, 22)...) // version 0, rest of the header zeroed
+	b = append(b, 1, byte(len(packet)+8))            // one segment
+	return append(append(b, packet...), make([]byte, 8)...)
+}
+
+func fakeWAV(channels uint16) []byte {
+	b := append([]byte("RIFF"), 0, 0, 0, 0)
+	b = append(b, "WAVEfmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, 1) // PCM
+	b = binary.LittleEndian.AppendUint16(b, channels)
+	b = binary.LittleEndian.AppendUint32(b, 44100)
+	return append(b, make([]byte, 8)...)
+}
+
+func mp4Box(name string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, name...), payload...)
+}
+
+func fakeJPEG() []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	return buf.Bytes()
+}
+
+func fakeWebP(width, height int) []byte {
+	b := append([]byte("RIFF"), 0, 0, 0, 0)
+	b = append(b, "WEBPVP8L"...)
+	b = append(b, 0, 0, 0, 0, 0x2f)
+	b = binary.LittleEndian.AppendUint32(b, uint32(width-1)|uint32(height-1)<<14)
+	return append(b, make([]byte, 8)...)
+}
+
+func TestValidateAudio(t *testing.T) {
+	mp3 := fakeMP3(0x01)
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x0a"), make([]byte, 10)...) // 10 byte tag
+	adts := []byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}
+	m4a := append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4Box("moov", nil)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want media.Format
+		code string
+	}{
+		{"mp3", mp3, media.FormatMP3, ""},
+		{"mp3 after an ID3 tag", append(id3, mp3...), media.FormatMP3, ""},
+		{"mp3 single frame", mp3[:417], media.FormatMP3, ""},
+		{"ID3 tag without audio", id3, media.Format{}, "corrupt_file"},
+		{"mp3 sync word then noise", append([]byte{0xFF, 0xFB, 0x90, 0x00}, bytes.Repeat([]byte{0x55}, 600)...), media.Format{}, "corrupt_file"},
+		{"flac", fakeFLAC(), media.FormatFLAC, ""},
+		{"flac truncated", fakeFLAC()[:20], media.Format{}, "corrupt_file"},
+		{"flac without STREAMINFO", append([]byte("fLaC\x84\x00\x00\x22"), make([]byte, 34)...), media.Format{}, "corrupt_file"},
+		{"vorbis", fakeOgg("\x01vorbis"), media.FormatOgg, ""},
+		{"opus", fakeOgg("OpusHead"), media.FormatOgg, ""},
+		{"ogg video", fakeOgg("\x80theora"), media.Format{}, "corrupt_file"},
+		{"ogg truncated", fakeOgg("\x01vorbis")[:20], media.Format{}, "corrupt_file"},
+		{"wav", fakeWAV(2), media.FormatWAV, ""},
+		{"wav without channels", fakeWAV(0), media.Format{}, "corrupt_file"},
+		{"wav without fmt chunk", []byte("RIFF\x00\x00\x00\x00WAVEdata\x00\x00\x00\x00"), media.Format{}, "corrupt_file"},
+		{"m4a", m4a, media.FormatM4A, ""},
+		{"m4a without moov", mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), media.Format{}, "corrupt_file"},
+		{"m4a box past the end", append(mp4Box("ftyp", []byte("M4A ")), 0, 0, 1, 0, 'm', 'd', 'a', 't'), media.Format{}, "corrupt_file"},
+		{"aac", adts, media.FormatAAC, ""},
+		{"aac truncated", adts[:5], media.Format{}, "corrupt_file"},
+		{"aac bad sampling frequency", []byte{0xFF, 0xF1, 0x7C, 0x80, 0x02, 0x1F, 0xFC}, media.Format{}, "corrupt_file"},
+		{"text named .mp3", []byte("definitely not audio"), media.Format{}, "unsupported_format"},
+		{"image named .mp3", fakePNG(color.White), media.Format{}, "unsupported_format"},
+		{"webp is not audio", fakeWebP(4, 4), media.Format{}, "unsupported_format"},
+		{"empty", nil, media.Format{}, "empty_file"},
+	}
+	for _, tt := range tests {
+		format, fe := media.ValidateAudio("song", bytes.NewReader(tt.data), int64(len(tt.data)), 1<<20)
+		code := ""
+		if fe != nil {
+			code = fe.Code
+			if fe.Field != "song" {
+				t.Errorf("%s: field = %q", tt.name, fe.Field)
+			}
+		}
+		if format != tt.want || code != tt.code {
+			t.Errorf("%s: got %v, %q (%v), want %v, %q", tt.name, format.Name, code, fe, tt.want.Name, tt.code)
+		}
+	}
+
+	if _, fe := media.ValidateAudio("song", bytes.NewReader(mp3), int64(len(mp3)), 100); fe == nil || fe.Code != "too_large" || fe.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("file over the limit: %v", fe)
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	jpg := fakeJPEG()
+	tests := []struct {
+		name string
+		data []byte
+		want media.Format
+		code string
+	}{
+		{"png", fakePNG(color.White), media.FormatPNG, ""},
+		{"jpeg", jpg, media.FormatJPEG, ""},
+		{"webp", fakeWebP(300, 200), media.FormatWebP, ""},
+		{"png truncated", fakePNG(color.White)[:20], media.Format{}, "corrupt_file"},
+		{"jpeg truncated", jpg[:4], media.Format{}, "corrupt_file"},
+		{"png signature then noise", append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0x42}, 40)...), media.Format{}, "corrupt_file"},
+		{"webp too large", fakeWebP(9000, 10), media.Format{}, "corrupt_file"},
+		{"webp truncated", fakeWebP(4, 4)[:16], media.Format{}, "corrupt_file"},
+		{"webp with an unknown chunk", append([]byte("RIFF\x00\x00\x00\x00WEBPVP9 "), make([]byte, 14)...), media.Format{}, "corrupt_file"},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), media.Format{}, "unsupported_format"},
+		{"audio named .png", fakeMP3(0x01), media.Format{}, "unsupported_format"},
+		{"empty", nil, media.Format{}, "empty_file"},
+	}
+	for _, tt := range tests {
+		format, fe := media.ValidateImage("thumbnail", bytes.NewReader(tt.data), int64(len(tt.data)), 1<<20)
+		code := ""
+		if fe != nil {
+			code = fe.Code
+		}
+		if format != tt.want || code != tt.code {
+			t.Errorf("%s: got %v, %q (%v), want %v, %q", tt.name, format.Name, code, fe, tt.want.Name, tt.code)
+		}
+	}
+}
+
+func TestUploadLimitsCoverEveryRole(t *testing.T) {
+	for _, role := range []string{models.RoleListener, models.RoleArtist, models.RoleModerator, models.RoleAdmin} {
+		if limits := media.UploadLimitsFor(role); limits.Audio <= 0 || limits.Image <= 0 {
+			t.Errorf("%s: limits = %+v", role, limits)
+		}
+	}
+	if got, want := media.UploadLimitsFor("someone"), media.UploadLimitsFor(models.RoleListener); got != want {
+		t.Errorf("unknown role gets %+v, want the listener limits %+v", got, want)
+	}
+	if media.UploadLimitsFor(models.RoleAdmin).Audio <= media.UploadLimitsFor(models.RoleArtist).Audio {
+		t.Error("admins may not upload more than artists")
+	}
+}
+
+func TestUploadBodyIsLimitedByRole(t *testing.T) {
+	client := newTestClient(t, server.Services{DB: newAuthzDB()})
+	upload := func(userID, method, target string, size int64) int {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader("--x--"))
+		req.ContentLength = size
+		req.Header.Set(echo.HeaderContentType, "multipart/form-data; boundary=x")
+		return client.serve(userID, req).Code
+	}
+
+	artist := media.UploadLimitsFor(models.RoleArtist)
+	tooLarge := artist.Audio + artist.Image + 2<<20
+	for _, target := range []string{"/music/upload", "/music/tus/some-upload"} {
+		method := http.MethodPost
+		if strings.HasPrefix(target, "/music/tus/") {
+			method = http.MethodPatch
+		}
+		if code := upload("artist-1", method, target, tooLarge); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s of %d bytes by an artist: status = %d, want 413", method, target, tooLarge, code)
+		}
+		if code := upload("root", method, target, tooLarge); code == http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s of %d bytes by an admin was cut off", method, target, tooLarge)
+		}
+	}
+}